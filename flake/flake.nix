@@ -37,21 +37,24 @@
       # Combine static and dynamic inputs
       allInputs = inputs // dynamicInputs;
 
-      pkgs = import nixpkgs {
+      mkPkgs = system: import nixpkgs {
         inherit system;
         config.allowUnfree = true;
         overlays = [
           (final: prev: {
-            electron = unstablePkgs.electron;
+            electron = (mkUnstablePkgs system).electron;
           })
         ];
       };
 
-      unstablePkgs = import nixpkgs-unstable {
+      mkUnstablePkgs = system: import nixpkgs-unstable {
         inherit system;
         config.allowUnfree = true;
       };
 
+      pkgs = mkPkgs system;
+      unstablePkgs = mkUnstablePkgs system;
+
       lib = nixpkgs.lib;
       # userConfigurations = import ./users { inherit lib; };
       userConfigurations = import ./users { inherit lib; config = { inherit username; }; };
@@ -62,7 +65,58 @@
         inherit unstablePkgs self;
       };
 
-      packagesSet = import ./packages { inherit pkgs unstablePkgs self; inputs = allInputs; };
+      packagesSet = import ./packages { inherit pkgs unstablePkgs self baseConfig; inputs = allInputs; };
+
+      # Every directory in ./hosts with a default.nix, except the shared
+      # modules in ./hosts/common, is a host. A host's optional
+      # base-config.json overrides the settings of the top-level one.
+      hostNames = builtins.filter
+        (name: name != "common" && builtins.pathExists ./hosts/${name}/default.nix)
+        (builtins.attrNames (lib.filterAttrs (_: type: type == "directory") (builtins.readDir ./hosts)));
+
+      mkHost = hostName:
+        let
+          overridesFile = ./hosts/${hostName}/base-config.json;
+          hostBaseConfig = lib.recursiveUpdate baseConfig
+            (if builtins.pathExists overridesFile then builtins.fromJSON (builtins.readFile overridesFile) else { });
+          hostConfig = config // hostBaseConfig;
+          hostSystem = lib.attrByPath [ "system" "type" ] "x86_64-linux" hostConfig;
+          hostUsername = let
+            raw = lib.attrByPath [ "system" "username" ] "" hostConfig;
+          in if raw == "" then (builtins.head config.users).username else raw;
+          hostDesktop = lib.attrByPath [ "system" "desktop" ] null hostConfig;
+          hostPkgs = mkPkgs hostSystem;
+          hostUnstablePkgs = mkUnstablePkgs hostSystem;
+          hostUsers = (import ./users { inherit lib; config = { username = hostUsername; }; }).home-manager.users;
+          hostPackagesSet = import ./packages {
+            pkgs = hostPkgs;
+            unstablePkgs = hostUnstablePkgs;
+            inherit self;
+            baseConfig = hostBaseConfig;
+            inputs = allInputs;
+          };
+        in
+        lib.nixosSystem {
+          system = hostSystem;
+          specialArgs = specialArgs // {
+            username = hostUsername;
+            systemPackages = hostPackagesSet.default-list;
+            piloConfig = hostConfig;
+            unstablePkgs = hostUnstablePkgs;
+          };
+          modules = [
+            ./hosts/${hostName}/default.nix
+            home-manager.nixosModules.home-manager
+            {
+              networking.hostName = lib.mkDefault hostName;
+              pilo.ollama.modelsPath = lib.attrByPath [ "system" "ollama" "models" ] "" hostConfig;
+              home-manager.useGlobalPkgs = true;
+              home-manager.useUserPackages = true;
+              home-manager.extraSpecialArgs = { pkgs = hostPkgs; unstablePkgs = hostUnstablePkgs; inherit self; };
+              home-manager.users.${hostUsername} = hostUsers.${hostUsername};
+            }
+          ] ++ (lib.optionals (hostDesktop != null && hostDesktop != "") [ ./desktops/${hostDesktop}.nix ]);
+        };
 
     in
     {
@@ -74,6 +128,8 @@
             modules = [
               userConfig
               { home.username = username; home.homeDirectory = "/home/${username}"; }
+              # Without NixOS there are no system packages, so packages.json is installed per user.
+              { home.packages = packagesSet.default-list; }
             ];
           }
         )
@@ -87,22 +143,6 @@
         inherit pkgs unstablePkgs lib;
       };
 
-      nixosConfigurations = {
-        nixos = lib.nixosSystem {
-          inherit system;
-          specialArgs = { inherit username; systemPackages = packagesSet.default-list; piloConfig = config; } // specialArgs;
-          modules = [
-            ./hosts/nixos/default.nix
-            home-manager.nixosModules.home-manager
-            {
-              pilo.ollama.modelsPath = lib.attrByPath [ "system" "ollama" "models" ] "" config;
-              home-manager.useGlobalPkgs = true;
-              home-manager.useUserPackages = true;
-              home-manager.extraSpecialArgs = { inherit pkgs unstablePkgs self; };
-              home-manager.users.${username} = userConfigurations.home-manager.users.${username};
-            }
-          ] ++ (lib.optionals (desktop != null && desktop != "") [ ./desktops/${desktop}.nix ]);
-        };
-      };
+      nixosConfigurations = lib.genAttrs hostNames mkHost;
     };
 }
@@ -0,0 +1,19 @@
+{ lib, ... }:
+
+{
+  # Modules shared by every host. Each host in ../<hostname>/ imports this
+  # next to its own hardware-configuration.nix.
+  imports = [
+    ./system.nix
+    ./user.nix
+    ./services.nix
+    ./apps.nix
+    ./fonts.nix
+    # The desktop environment preset is imported from the top-level flake.nix
+  ];
+
+  options.pilo.ollama.modelsPath = lib.mkOption {
+    type = lib.types.str;
+    description = "The path to Ollama models.";
+  };
+}
@@ -8,8 +8,7 @@
   # Kernel
   boot.kernelPackages = pkgs.linuxPackages_latest;
 
-  # Networking
-  networking.hostName = "nixos";
+  # Networking. The host name defaults to the name of the host's directory.
   networking.networkmanager.enable = true;
 
   # Time zone
@@ -1,21 +1,11 @@
-{ inputs, username, systemPackages, lib, ... }:
+{ ... }:
 
 {
   imports = [
+    ../common
     ./hardware-configuration.nix
-    ./system.nix
-    ./user.nix
-    ./services.nix
-    ./apps.nix
-    ./fonts.nix
-    # The desktop environment preset is imported from the top-level flake.nix
   ];
 
-  options.pilo.ollama.modelsPath = lib.mkOption {
-    type = lib.types.str;
-    description = "The path to Ollama models.";
-  };
-
   config = {
     # This value determines the NixOS release from which the default
     # settings for stateful data, like file locations and database versions
@@ -25,4 +15,4 @@
     # (e.g. man configuration.nix or on https://nixos.org/nixos/options.html).
     system.stateVersion = "25.05"; # Did you read the comment?
   };
-}
\ No newline at end of file
+}
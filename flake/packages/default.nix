@@ -1,5 +1,5 @@
 # ./packages/default.nix
-{ pkgs, unstablePkgs, self, inputs, ... }:
+{ pkgs, unstablePkgs, self, inputs, baseConfig ? builtins.fromJSON (builtins.readFile ../base-config.json), ... }:
 let
   # Create a wrapper script for a given app name
   mkWrapper = appName: pkgs.writeShellScriptBin "nix-${appName}" ''
@@ -9,13 +9,23 @@ let
   '';
 
   # Get all the app names from the flake's apps output
-  appNames = builtins.attrNames self.apps.${pkgs.system};
+  appNames = builtins.attrNames (self.apps.${pkgs.system} or { });
 
   # Read config from JSON. This makes packages manageable via the pilo API.
   config = builtins.fromJSON (builtins.readFile ../packages.json);
 
-  # Get package names from the JSON, filtering for installed packages.
-  packageNames = map (pkg: pkg.name) (builtins.filter (pkg: pkg.installed) config.packages);
+  # The host picks its package groups with system.groups in its base config;
+  # without that list every enabled group is used.
+  hostGroups = baseConfig.system.groups or [ ];
+  groups = builtins.listToAttrs (map (group: { name = group.name; value = group; }) (config.groups or [ ]));
+  groupUsed = name:
+    let
+      group = groups.${name} or (throw "pilo: unknown package group ${name}");
+    in
+    if hostGroups == [ ] then group.enabled else builtins.elem group.name hostGroups;
+
+  # Get the installed packages from the JSON, leaving out unused groups.
+  installedPackages = builtins.filter (pkg: pkg.installed && (!(pkg ? group) || groupUsed pkg.group)) config.packages;
 
   # Function to resolve a package path from multiple sources (nixpkgs, inputs)
   resolvePackage = pathStr:
@@ -30,8 +40,21 @@ let
     in
     pkgs.lib.getAttrFromPath searchPath base;
 
-  # Convert package names to derivations, allowing for nested attrpaths like "kdePackages.kcalc" or "my-flake.packages.my-package".
-  defaultPackages = map resolvePackage packageNames;
+  # Resolve a package from the source it is configured with: "stable" (the
+  # default), "unstable", or the name of a flake input.
+  resolveSourcedPackage = pkg:
+    let
+      source = pkg.source or "stable";
+      path = pkgs.lib.splitString "." pkg.name;
+      input = inputs.${source} or (throw "pilo: package ${pkg.name} uses unknown source ${source}");
+      inputPackages = input.packages.${pkgs.system} or input.legacyPackages.${pkgs.system} or input;
+    in
+    if source == "stable" then resolvePackage pkg.name
+    else if source == "unstable" then pkgs.lib.getAttrFromPath path unstablePkgs
+    else pkgs.lib.getAttrFromPath path inputPackages;
+
+  # Convert packages to derivations, allowing for nested attrpaths like "kdePackages.kcalc" or "my-flake.packages.my-package".
+  defaultPackages = map resolveSourcedPackage installedPackages;
 in
 (
 let
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"pilo/internal/config"
+	"pilo/internal/importer"
+	"pilo/internal/nix"
+)
+
+// UntrackedPackages returns the packages installed from nixpkgs into the user
+// profile with `nix profile install` that packages.json does not list.
+func UntrackedPackages(ctx context.Context) ([]importer.ProfileElement, error) {
+	out, err := ProfileListJSON(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the nix profile: %w", err)
+	}
+	elements, err := importer.ProfileElements(out)
+	if err != nil {
+		return nil, err
+	}
+	packages, err := config.ReadPackagesConfig()
+	if err != nil {
+		return nil, err
+	}
+	tracked := make(map[string]bool)
+	for _, pkg := range packages {
+		tracked[pkg.Name] = true
+	}
+
+	var untracked []importer.ProfileElement
+	for _, e := range elements {
+		if e.FromNixpkgs() && e.AttrPath != "" && !tracked[e.AttrPath] {
+			untracked = append(untracked, e)
+		}
+	}
+	return untracked, nil
+}
+
+// AdoptPackages moves profile packages into the declarative configuration.
+// They are added to packages.json in one commit, from nixpkgs-unstable if that
+// is where they were installed from, and the configuration is switched to with
+// opts. Packages that cannot be found in nixpkgs stay in the profile and are
+// reported in the result.
+func AdoptPackages(ctx context.Context, elements []importer.ProfileElement, opts RebuildOptions) (*ImportResult, string, error) {
+	names := make([]string, len(elements))
+	sources := make(map[string]string)
+	for i, e := range elements {
+		names[i] = e.AttrPath
+		if e.FromUnstable() {
+			sources[e.AttrPath] = config.SourceUnstable
+		}
+	}
+	result, err := importPackages(names, sources)
+	if err != nil {
+		return result, "", err
+	}
+
+	adopted := make(map[string]bool)
+	for _, name := range append(result.Added, result.Existing...) {
+		adopted[name] = true
+	}
+	var remove, reinstall []string
+	for _, e := range elements {
+		if adopted[e.AttrPath] {
+			remove = append(remove, e.Name)
+			reinstall = append(reinstall, e.OriginalURL+"#"+e.AttrPath)
+		}
+	}
+	if len(remove) == 0 {
+		return result, "", nil
+	}
+
+	// Home Manager installs into the same profile, where the packages would
+	// collide with their copies, so they leave it before the switch and are
+	// reinstalled if the switch fails.
+	args := append([]string{"profile", "remove"}, remove...)
+	out, err := nix.RunCommandContext(ctx, opts.OnOutput, "nix", args...)
+	if err != nil {
+		return result, out, fmt.Errorf("could not remove the adopted packages from your profile: %w", err)
+	}
+
+	opts.Mode = RebuildSwitch
+	rebuildOut, err := RebuildContext(ctx, opts)
+	out += rebuildOut
+	if err != nil {
+		// The switch may have been cancelled through ctx, which must not
+		// keep the packages from coming back.
+		args := append([]string{"profile", "install"}, reinstall...)
+		installOut, installErr := nix.RunCommandContext(context.WithoutCancel(ctx), opts.OnOutput, "nix", args...)
+		out += installOut
+		if installErr != nil {
+			return result, out, fmt.Errorf("rebuild failed and the packages could not be reinstalled into your profile (%v): %w", installErr, err)
+		}
+		return result, out, fmt.Errorf("rebuild failed, so the packages were reinstalled into your profile: %w", err)
+	}
+	return result, out, nil
+}
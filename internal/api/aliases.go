@@ -20,32 +20,12 @@ type Alias struct {
 
 // GetAliases reads the aliases from the JSON file.
 func GetAliases() (map[string]string, error) {
-	data, err := os.ReadFile(getAliasesFile())
-	if err != nil {
-		if os.IsNotExist(err) {
-			return make(map[string]string), nil
-		}
+	aliases, err := config.ReadAliasesConfig()
+	if os.IsNotExist(err) {
+		return make(map[string]string), nil
+	} else if err != nil {
 		return nil, fmt.Errorf("error reading aliases file: %w", err)
 	}
-
-	var aliases map[string]string
-	// Attempt to unmarshal into a nested structure first for backward compatibility
-	var nested struct {
-		Aliases map[string]string `json:"aliases"`
-	}
-	if err := json.Unmarshal(data, &nested); err == nil && nested.Aliases != nil {
-		aliases = nested.Aliases
-	} else {
-		// Fallback to unmarshaling as a flat map
-		if err := json.Unmarshal(data, &aliases); err != nil {
-			return nil, fmt.Errorf("error unmarshaling aliases: %w", err)
-		}
-	}
-
-	if aliases == nil {
-		aliases = make(map[string]string)
-	}
-
 	return aliases, nil
 }
 
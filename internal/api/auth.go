@@ -0,0 +1,333 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"pilo/internal/config"
+	"pilo/internal/nix"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+)
+
+// GitTokenEnv names the environment variable holding an access token for
+// HTTPS remotes. It is sent as the password, with the user name of the
+// remote URL if it has one.
+const GitTokenEnv = "PILO_GIT_TOKEN"
+
+// Prompter asks the user for what connecting to a remote needs. The CLI and
+// the GUI each install one with SetPrompter; without one, passphrase
+// protected keys and hosts missing from known_hosts are refused.
+type Prompter interface {
+	// Passphrase asks for the passphrase of the SSH key at path.
+	Passphrase(path string) (string, error)
+	// ConfirmHostKey asks whether to trust host, which is not in
+	// known_hosts, given the type and SHA256 fingerprint of its key.
+	ConfirmHostKey(host, keyType, fingerprint string) (bool, error)
+}
+
+var (
+	promptMu sync.Mutex
+	prompter Prompter
+	// passphrases remembers the passphrases of the keys unlocked so far, so
+	// that each is asked for once per run.
+	passphrases = map[string]string{}
+)
+
+// SetPrompter installs the Prompter used for passphrases and unknown hosts.
+func SetPrompter(p Prompter) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	prompter = p
+}
+
+func currentPrompter() Prompter {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	return prompter
+}
+
+// getGitAuth returns the authentication for remoteURL, chosen by its
+// scheme. HTTPS remotes use the user and password of the URL, a token from
+// PILO_GIT_TOKEN or git's credential helpers, in that order, and no
+// authentication if none has one. SSH remotes use the configured key, the
+// SSH agent or the default keys in ~/.ssh, and check the host against
+// known_hosts. Local remotes need none.
+func getGitAuth(remoteURL string) (transport.AuthMethod, error) {
+	if remoteURL == "" {
+		return nil, errors.New("no remote URL is configured")
+	}
+	ep, err := transport.NewEndpoint(remoteURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote URL %s: %w", remoteURL, err)
+	}
+	switch ep.Protocol {
+	case "http", "https":
+		return httpAuth(ep), nil
+	case "ssh":
+		return sshAuth(ep)
+	default:
+		return nil, nil
+	}
+}
+
+func httpAuth(ep *transport.Endpoint) transport.AuthMethod {
+	if ep.Password != "" {
+		return &githttp.BasicAuth{Username: ep.User, Password: ep.Password}
+	}
+	if token := os.Getenv(GitTokenEnv); token != "" {
+		user := ep.User
+		if user == "" {
+			user = "pilo"
+		}
+		return &githttp.BasicAuth{Username: user, Password: token}
+	}
+	if user, password, ok := credentialFill(ep); ok {
+		return &githttp.BasicAuth{Username: user, Password: password}
+	}
+	return nil
+}
+
+// credentialFill asks git's credential helpers for the user and password of
+// ep, as "git credential fill" does. It reports false if git is missing or
+// no helper has them; git is never allowed to prompt.
+func credentialFill(ep *transport.Endpoint) (string, string, bool) {
+	host := ep.Host
+	if ep.Port != 0 && ep.Port != 80 && ep.Port != 443 {
+		host = fmt.Sprintf("%s:%d", ep.Host, ep.Port)
+	}
+	var in bytes.Buffer
+	fmt.Fprintf(&in, "protocol=%s\nhost=%s\n", ep.Protocol, host)
+	if path := strings.TrimPrefix(ep.Path, "/"); path != "" {
+		fmt.Fprintf(&in, "path=%s\n", path)
+	}
+	if ep.User != "" {
+		fmt.Fprintf(&in, "username=%s\n", ep.User)
+	}
+	in.WriteString("\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var out bytes.Buffer
+	err := nix.GetExecutor().Run(ctx, nix.Command{
+		Name:   "git",
+		Args:   []string{"credential", "fill"},
+		Env:    []string{"GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS="},
+		Stdin:  &in,
+		Stdout: &out,
+	})
+	if err != nil {
+		return "", "", false
+	}
+	var user, password string
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "username":
+			user = value
+		case "password":
+			password = value
+		}
+	}
+	return user, password, password != ""
+}
+
+func sshAuth(ep *transport.Endpoint) (transport.AuthMethod, error) {
+	user := ep.User
+	if user == "" {
+		user = "git"
+	}
+	port := ep.Port
+	if port == 0 {
+		port = 22
+	}
+	hostKeys, err := hostKeyCallback(net.JoinHostPort(ep.Host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	if path := config.GetSshKeyPath(); path != "" {
+		auth, err := loadSSHKey(user, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load SSH key from %s: %w", path, err)
+		}
+		auth.HostKeyCallbackHelper = hostKeys
+		return auth, nil
+	}
+	// Use SSH agent if no key is specified
+	if os.Getenv("SSH_AUTH_SOCK") != "" {
+		if auth, err := gitssh.NewSSHAgentAuth(user); err == nil {
+			auth.HostKeyCallbackHelper = hostKeys
+			return auth, nil
+		}
+	}
+
+	// Fallback to searching for SSH keys
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	for _, keyType := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		path := filepath.Join(homeDir, ".ssh", keyType)
+		if _, errStat := os.Stat(path); errStat != nil {
+			continue
+		}
+		auth, err := loadSSHKey(user, path)
+		if err != nil {
+			config.AddLogEntry(fmt.Sprintf("Skipping SSH key %s: %v", path, err))
+			continue
+		}
+		auth.HostKeyCallbackHelper = hostKeys
+		return auth, nil
+	}
+	return nil, errors.New("no usable SSH key found and SSH agent is not available")
+}
+
+// loadSSHKey loads the private key at path, asking for its passphrase if it
+// has one. A wrong passphrase is asked for again, up to three times.
+func loadSSHKey(user, path string) (*gitssh.PublicKeys, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = ssh.ParsePrivateKey(pem)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		if err != nil {
+			return nil, err
+		}
+		return gitssh.NewPublicKeys(user, pem, "")
+	}
+
+	promptMu.Lock()
+	passphrase, known := passphrases[path]
+	promptMu.Unlock()
+	if known {
+		return gitssh.NewPublicKeys(user, pem, passphrase)
+	}
+	p := currentPrompter()
+	if p == nil {
+		return nil, errors.New("the key is protected by a passphrase; add it to an SSH agent")
+	}
+	for attempt := 0; attempt < 3; attempt++ {
+		if passphrase, err = p.Passphrase(path); err != nil {
+			return nil, err
+		}
+		auth, err := gitssh.NewPublicKeys(user, pem, passphrase)
+		if err == nil {
+			promptMu.Lock()
+			passphrases[path] = passphrase
+			promptMu.Unlock()
+			return auth, nil
+		}
+	}
+	return nil, errors.New("wrong passphrase")
+}
+
+// knownHostsFiles returns the known_hosts files that host keys are checked
+// against: those listed in $SSH_KNOWN_HOSTS, or else the user's and the
+// system's. New hosts are added to the first of them.
+func knownHostsFiles() []string {
+	if env := os.Getenv("SSH_KNOWN_HOSTS"); env != "" {
+		return filepath.SplitList(env)
+	}
+	files := []string{"/etc/ssh/ssh_known_hosts"}
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append([]string{filepath.Join(home, ".ssh", "known_hosts")}, files...)
+	}
+	return files
+}
+
+// hostKeyCallback checks the keys of hostWithPort against known_hosts. A host
+// that is not listed is trusted only once the user confirms its fingerprint,
+// and is then added to known_hosts. A host whose key does not match is
+// refused. A listed host is asked for the types of key known_hosts has for it,
+// so that a server offering several is not refused for sending another.
+func hostKeyCallback(hostWithPort string) (gitssh.HostKeyCallbackHelper, error) {
+	files := knownHostsFiles()
+	var existing []string
+	for _, f := range files {
+		if _, err := os.Stat(f); err == nil {
+			existing = append(existing, f)
+		}
+	}
+	var known ssh.HostKeyCallback
+	var algorithms []string
+	if len(existing) > 0 {
+		db, err := knownhosts.NewDB(existing...)
+		if err != nil {
+			return gitssh.HostKeyCallbackHelper{}, fmt.Errorf("failed to read known_hosts: %w", err)
+		}
+		known = db.HostKeyCallback()
+		algorithms = db.HostKeyAlgorithms(hostWithPort)
+	}
+
+	callback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if known != nil {
+			err := known(hostname, remote, key)
+			if knownhosts.IsHostKeyChanged(err) {
+				return fmt.Errorf("the host key of %s does not match the one in known_hosts; it may have been changed, or someone may be intercepting the connection", hostname)
+			}
+			if !knownhosts.IsHostUnknown(err) {
+				return err
+			}
+		}
+
+		fingerprint := ssh.FingerprintSHA256(key)
+		p := currentPrompter()
+		if p == nil {
+			return fmt.Errorf("%s is not in known_hosts (%s key %s); connect to it once with ssh to add it", hostname, key.Type(), fingerprint)
+		}
+		trust, err := p.ConfirmHostKey(hostname, key.Type(), fingerprint)
+		if err != nil {
+			return err
+		}
+		if !trust {
+			return fmt.Errorf("the host key of %s was not accepted", hostname)
+		}
+		return addKnownHost(files[0], hostname, key)
+	}
+	return gitssh.HostKeyCallbackHelper{HostKeyCallback: callback, HostKeyAlgorithms: algorithms}, nil
+}
+
+// addKnownHost adds host with key to the known_hosts file at path.
+func addKnownHost(path, host string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(host)}, key)); err != nil {
+		return err
+	}
+	config.AddLogEntry(fmt.Sprintf("Added %s to %s.", host, path))
+	return nil
+}
+
+// originURL returns the URL of the origin remote of repo, or "" if it has
+// none.
+func originURL(repo *git.Repository) string {
+	remote, err := repo.Remote("origin")
+	if err != nil || len(remote.Config().URLs) == 0 {
+		return ""
+	}
+	return remote.Config().URLs[0]
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"pilo/internal/nix"
+	"reflect"
+	"strings"
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"golang.org/x/crypto/ssh"
+)
+
+type fakePrompter struct {
+	passphrase string
+	trust      bool
+	asked      []string
+}
+
+func (p *fakePrompter) Passphrase(path string) (string, error) {
+	p.asked = append(p.asked, "passphrase "+path)
+	return p.passphrase, nil
+}
+
+func (p *fakePrompter) ConfirmHostKey(host, keyType, fingerprint string) (bool, error) {
+	p.asked = append(p.asked, "host "+host)
+	return p.trust, nil
+}
+
+func TestGitAuthHTTPS(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fake := nix.NewFakeExecutor()
+	SetExecutor(fake)
+	t.Cleanup(func() { SetExecutor(nil) })
+
+	// Without a token or a credential helper, no authentication is sent.
+	auth, err := getGitAuth("https://gitea.example.com/me/config.git")
+	if err != nil || auth != nil {
+		t.Errorf("getGitAuth = %v, %v; want no authentication", auth, err)
+	}
+
+	fake.On("git credential fill", nix.FakeResult{Stdout: "protocol=https\nhost=gitea.example.com\nusername=me\npassword=secret\n"})
+	auth, err = getGitAuth("https://gitea.example.com/me/config.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if basic, ok := auth.(*githttp.BasicAuth); !ok || basic.Username != "me" || basic.Password != "secret" {
+		t.Errorf("credential helper auth = %#v", auth)
+	}
+	calls := fake.Calls()
+	if stdin := calls[len(calls)-1].Stdin; !strings.Contains(stdin, "host=gitea.example.com\npath=me/config.git\n") {
+		t.Errorf("git credential fill got %q", stdin)
+	}
+
+	t.Setenv(GitTokenEnv, "token")
+	auth, _ = getGitAuth("https://me@gitea.example.com/me/config.git")
+	if basic, ok := auth.(*githttp.BasicAuth); !ok || basic.Username != "me" || basic.Password != "token" {
+		t.Errorf("token auth = %#v", auth)
+	}
+
+	if auth, err := getGitAuth("/srv/git/config.git"); err != nil || auth != nil {
+		t.Errorf("local remotes need no authentication, got %v, %v", auth, err)
+	}
+}
+
+func TestGitAuthSSH(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("SSH_AUTH_SOCK", "")
+	knownHosts := filepath.Join(home, ".ssh", "known_hosts")
+	t.Setenv("SSH_KNOWN_HOSTS", knownHosts)
+
+	// A passphrase protected key in the default location.
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(home, ".ssh", "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	SetPrompter(nil)
+	if _, err := getGitAuth("git@gitea.example.com:me/config.git"); err == nil {
+		t.Error("an encrypted key should not load without a prompter")
+	}
+
+	prompter := &fakePrompter{passphrase: "hunter2"}
+	SetPrompter(prompter)
+	t.Cleanup(func() { SetPrompter(nil) })
+	auth, err := getGitAuth("git@gitea.example.com:me/config.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, ok := auth.(*gitssh.PublicKeys)
+	if !ok || keys.User != "git" {
+		t.Fatalf("auth = %#v", auth)
+	}
+	if len(prompter.asked) != 1 || prompter.asked[0] != "passphrase "+keyPath {
+		t.Errorf("prompts = %v", prompter.asked)
+	}
+	if _, err := getGitAuth("git@gitea.example.com:me/config.git"); err != nil || len(prompter.asked) != 1 {
+		t.Errorf("the passphrase should be asked for once, prompts = %v, err = %v", prompter.asked, err)
+	}
+
+	// An unknown host is added to known_hosts once trusted.
+	hostPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	hostKey, _ := ssh.NewPublicKey(hostPub)
+	addr := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 22}
+	if err := keys.HostKeyCallback("gitea.example.com:22", addr, hostKey); err == nil {
+		t.Error("an untrusted host should be refused")
+	}
+	prompter.trust = true
+	if err := keys.HostKeyCallback("gitea.example.com:22", addr, hostKey); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(knownHosts)
+	if !strings.HasPrefix(string(data), "gitea.example.com ssh-ed25519 ") {
+		t.Errorf("known_hosts = %q", data)
+	}
+
+	auth, _ = getGitAuth("git@gitea.example.com:me/config.git")
+	keys = auth.(*gitssh.PublicKeys)
+	// The server is asked for the type of key known_hosts has, rather than
+	// whichever it prefers, which would not match.
+	if !reflect.DeepEqual(keys.HostKeyAlgorithms, []string{ssh.KeyAlgoED25519}) {
+		t.Errorf("host key algorithms = %v", keys.HostKeyAlgorithms)
+	}
+	prompter.asked = nil
+	if err := keys.HostKeyCallback("gitea.example.com:22", addr, hostKey); err != nil || len(prompter.asked) != 0 {
+		t.Errorf("a known host should be accepted without asking: %v, prompts = %v", err, prompter.asked)
+	}
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ssh.NewPublicKey(otherPub)
+	if err := keys.HostKeyCallback("gitea.example.com:22", addr, otherKey); err == nil || len(prompter.asked) != 0 {
+		t.Errorf("a changed host key should be refused without asking: %v", err)
+	}
+}
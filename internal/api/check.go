@@ -0,0 +1,274 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"pilo/internal/config"
+	"pilo/internal/nix"
+	"pilo/internal/search"
+	"strconv"
+	"strings"
+)
+
+// PackageCheck is the result of validating a package against its source.
+type PackageCheck struct {
+	Name   string
+	Source string
+	// Found reports whether the attribute path resolves in the source.
+	Found bool
+	Meta  *config.PackageMeta
+	// Warnings lists problems that do not stop the package from being added.
+	Warnings []string
+	// Suggestions holds similar attribute paths when the package was not found.
+	Suggestions []string
+}
+
+// PackageNotFoundError is returned when adding a package whose attribute
+// path does not exist in its source.
+type PackageNotFoundError struct {
+	Name        string
+	Source      string
+	Suggestions []string
+}
+
+func (e *PackageNotFoundError) Error() string {
+	msg := fmt.Sprintf("package '%s' was not found in %s", e.Name, sourceDescription(e.Source))
+	if len(e.Suggestions) > 0 {
+		msg += "; did you mean " + strings.Join(e.Suggestions, ", ") + "?"
+	}
+	return msg
+}
+
+func sourceDescription(source string) string {
+	switch source {
+	case "", config.SourceStable:
+		return "nixpkgs"
+	case config.SourceUnstable:
+		return "nixpkgs-unstable"
+	default:
+		return "the " + source + " input"
+	}
+}
+
+// packageChecker validates packages for one system type. It reuses the
+// search index across packages when one is available.
+type packageChecker struct {
+	system string
+	index  *search.Index
+	inputs map[string]bool
+}
+
+// newPackageChecker prepares a checker. It uses the search index if it is
+// cached, and never builds it, since that takes minutes. Packages the index
+// does not hold are resolved by evaluating nixpkgs.
+func newPackageChecker() (*packageChecker, error) {
+	sys, err := config.GetSystem()
+	if err != nil {
+		return nil, err
+	}
+	system := sys.Type
+	if system == "" {
+		system = "x86_64-linux"
+	}
+	sources, err := PackageSources()
+	if err != nil {
+		return nil, err
+	}
+	c := &packageChecker{system: system, inputs: make(map[string]bool)}
+	for _, s := range sources {
+		if s != config.SourceStable && s != config.SourceUnstable {
+			c.inputs[s] = true
+		}
+	}
+	c.index, _ = search.Cached(config.GetFlakePath())
+	return c, nil
+}
+
+// CheckPackage checks that name resolves in source for the configured system
+// type and warns about unfree, broken, insecure or unsupported packages. An
+// error means the package could not be checked at all.
+func CheckPackage(ctx context.Context, name, source string) (*PackageCheck, error) {
+	c, err := newPackageChecker()
+	if err != nil {
+		return nil, err
+	}
+	return c.check(ctx, name, source)
+}
+
+// CheckPackages checks every package in packages.json.
+func CheckPackages(ctx context.Context) ([]PackageCheck, error) {
+	packages, err := config.ReadPackagesConfig()
+	if err != nil {
+		return nil, err
+	}
+	c, err := newPackageChecker()
+	if err != nil {
+		return nil, err
+	}
+	var checks []PackageCheck
+	for _, pkg := range packages {
+		if strings.HasPrefix(pkg.Name, "github:") {
+			continue
+		}
+		check, err := c.check(ctx, pkg.Name, pkg.Source)
+		if err != nil {
+			return checks, fmt.Errorf("could not check %s: %w", pkg.Name, err)
+		}
+		checks = append(checks, *check)
+	}
+	return checks, nil
+}
+
+func (c *packageChecker) check(ctx context.Context, name, source string) (*PackageCheck, error) {
+	check := &PackageCheck{Name: name, Source: source}
+	path := strings.Split(name, ".")
+
+	var err error
+	switch {
+	case source == "" || source == config.SourceStable:
+		if c.inputs[path[0]] {
+			// Like packages/default.nix, a leading input name selects that input.
+			check.Meta, err = c.eval(ctx, fmt.Sprintf("flake.inputs.%s", nixAttrPath(path[:1])), path[1:])
+		} else if entry, ok := c.indexed(name); ok {
+			check.Meta = packageMeta(entry)
+		} else {
+			// The index lacks nested sets such as python3Packages and packages
+			// that fail to evaluate, so only evaluating can tell a miss apart.
+			check.Meta, err = c.eval(ctx, c.inputPackages("nixpkgs"), path)
+		}
+	case source == config.SourceUnstable:
+		check.Meta, err = c.eval(ctx, c.inputPackages("nixpkgs-unstable"), path)
+	default:
+		check.Meta, err = c.eval(ctx, c.inputPackages(source), path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	check.Found = check.Meta != nil
+	if !check.Found {
+		check.Suggestions = c.suggestions(name)
+		return check, nil
+	}
+	check.Warnings = packageWarnings(check.Meta, c.system)
+	return check, nil
+}
+
+// indexed looks name up in the search index, if there is one.
+func (c *packageChecker) indexed(name string) (search.Entry, bool) {
+	if c.index == nil {
+		return search.Entry{}, false
+	}
+	return c.index.Lookup(name)
+}
+
+// suggestions returns up to five indexed attribute paths similar to name.
+func (c *packageChecker) suggestions(name string) []string {
+	if c.index == nil {
+		return nil
+	}
+	var out []string
+	for _, r := range c.index.Search([]string{name}, search.Options{Limit: 5}) {
+		out = append(out, r.AttrPath)
+	}
+	return out
+}
+
+// inputPackages is the Nix expression for the package set of a flake input.
+func (c *packageChecker) inputPackages(input string) string {
+	i := fmt.Sprintf("flake.inputs.%s", nixAttrPath([]string{input}))
+	sys := strconv.Quote(c.system)
+	return fmt.Sprintf("(%s.legacyPackages.%s or %s.packages.%s or %s)", i, sys, i, sys, i)
+}
+
+// nixAttrPath quotes each element of path for use in a Nix attribute selection.
+func nixAttrPath(path []string) string {
+	quoted := make([]string, len(path))
+	for i, p := range path {
+		quoted[i] = strconv.Quote(p)
+	}
+	return strings.Join(quoted, ".")
+}
+
+// eval looks up path in the attribute set base evaluates to and returns the
+// package's metadata, or nil if there is no such package.
+func (c *packageChecker) eval(ctx context.Context, base string, path []string) (*config.PackageMeta, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	expr := fmt.Sprintf(`let
+  flake = builtins.getFlake %s;
+  pkg = %s.%s or null;
+  meta = pkg.meta or {};
+in
+if pkg == null || !(builtins.isAttrs pkg) then null else {
+  pname = pkg.pname or (pkg.name or "");
+  version = pkg.version or "";
+  homepage = let h = meta.homepage or ""; in if builtins.isList h then builtins.head (h ++ [ "" ]) else h;
+  mainProgram = meta.mainProgram or "";
+  platforms = builtins.filter builtins.isString (meta.platforms or []);
+  unfree = meta.unfree or false;
+  broken = meta.broken or false;
+  insecure = meta.insecure or ((meta.knownVulnerabilities or []) != []);
+}`, strconv.Quote("path:"+config.GetFlakePath()), base, nixAttrPath(path))
+
+	out, err := nix.RunCommandStdout(ctx, nil, "nix", "eval", "--impure", "--json", "--expr", expr)
+	if err != nil {
+		return nil, fmt.Errorf("could not evaluate package: %w", err)
+	}
+	var result *struct {
+		Pname       string   `json:"pname"`
+		Version     string   `json:"version"`
+		Homepage    string   `json:"homepage"`
+		MainProgram string   `json:"mainProgram"`
+		Platforms   []string `json:"platforms"`
+		Unfree      bool     `json:"unfree"`
+		Broken      bool     `json:"broken"`
+		Insecure    bool     `json:"insecure"`
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		return nil, fmt.Errorf("could not parse package metadata: %w", err)
+	}
+	if result == nil {
+		return nil, nil
+	}
+	return &config.PackageMeta{
+		AttrPath:    strings.Join(path, "."),
+		Pname:       result.Pname,
+		Version:     result.Version,
+		Homepage:    result.Homepage,
+		MainProgram: result.MainProgram,
+		Platforms:   result.Platforms,
+		Unfree:      result.Unfree,
+		Broken:      result.Broken,
+		Insecure:    result.Insecure,
+	}, nil
+}
+
+// packageWarnings describes the problems of a package on system.
+func packageWarnings(meta *config.PackageMeta, system string) []string {
+	var warnings []string
+	if meta.Unfree {
+		warnings = append(warnings, "it is unfree")
+	}
+	if meta.Broken {
+		warnings = append(warnings, "it is marked as broken")
+	}
+	if meta.Insecure {
+		warnings = append(warnings, "it has known vulnerabilities")
+	}
+	if len(meta.Platforms) > 0 {
+		supported := false
+		for _, p := range meta.Platforms {
+			if p == system {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			warnings = append(warnings, "it is not supported on "+system)
+		}
+	}
+	return warnings
+}
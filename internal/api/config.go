@@ -0,0 +1,69 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"pilo/internal/config"
+	"pilo/internal/schema"
+)
+
+// MigrateConfig upgrades the configuration files to the current schema
+// version and commits the result. It returns what each migration did, and
+// nothing when the configuration is already up to date or not installed.
+func MigrateConfig() ([]string, error) {
+	if _, err := os.Stat(filepath.Join(config.GetFlakePath(), "base-config.json")); os.IsNotExist(err) {
+		return nil, nil
+	}
+	// Keep the settings file out of repositories in the default install path.
+	if err := CreateGitignore(config.GetInstallPath()); err != nil {
+		return nil, err
+	}
+	done, err := config.Migrate()
+	if err != nil || len(done) == 0 {
+		return done, err
+	}
+	if err := commitChanges(fmt.Sprintf("pilo: migrate configuration to schema version %d", config.SchemaVersion)); err != nil {
+		return done, err
+	}
+	return done, nil
+}
+
+// ValidateConfig checks every JSON file of the configuration against its
+// schema and returns all the problems found.
+func ValidateConfig() ([]schema.Problem, error) {
+	return config.Validate()
+}
+
+// SetSetting changes the setting named key and commits the change when the
+// setting lives in base-config.json.
+func SetSetting(key, value string) (config.Setting, error) {
+	setting, err := config.SetSetting(key, value)
+	if err != nil || setting.Store != config.StoreBaseConfig {
+		return setting, err
+	}
+	return setting, commitChanges(fmt.Sprintf("pilo: set %s", key))
+}
+
+// UnsetSetting resets the setting named key to its default and commits the
+// change when the setting lives in base-config.json.
+func UnsetSetting(key string) (config.Setting, error) {
+	setting, err := config.UnsetSetting(key)
+	if err != nil || setting.Store != config.StoreBaseConfig {
+		return setting, err
+	}
+	return setting, commitChanges(fmt.Sprintf("pilo: unset %s", key))
+}
+
+// SaveBaseConfig replaces base-config.json with data and commits it. Data
+// that does not match the schema is refused with a *schema.Error.
+func SaveBaseConfig(data []byte) error {
+	path := filepath.Join(config.GetFlakePath(), "base-config.json")
+	if err := schema.Check(path, data); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	return commitChanges("pilo: edit base-config.json")
+}
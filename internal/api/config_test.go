@@ -0,0 +1,208 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"pilo/internal/config"
+	"pilo/internal/schema"
+	"strings"
+	"testing"
+)
+
+func TestReadConfigKeepsInvalidFile(t *testing.T) {
+	broken := "{\n  \"commit_triggers\": [],\n  \"push_on_commit\": yes\n}\n"
+	setupFakeEnv(t, broken)
+
+	_, err := config.ReadConfig()
+	var invalid *schema.Error
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected a schema error, got %v", err)
+	}
+	if p := invalid.Problems[0]; p.Line != 3 || p.Column != 21 {
+		t.Errorf("problem at %d:%d, want 3:21: %v", p.Line, p.Column, p)
+	}
+	data, err := os.ReadFile(filepath.Join(config.GetFlakePath(), "base-config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != broken {
+		t.Fatalf("base-config.json was overwritten:\n%s", data)
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	setupFakeEnv(t, "{\n  \"commit_triggers\": []\n}\n")
+	if err := GitInit(config.GetInstallPath()); err != nil {
+		t.Fatal(err)
+	}
+	writeFlakeFiles(t, "{\n  \"commit_triggers\": []\n}\n")
+	aliases := filepath.Join(config.GetFlakePath(), "aliases.json")
+	if err := os.WriteFile(aliases, []byte(`{"aliases": {"gs": "git status"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := ValidateConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 2 {
+		t.Fatalf("problems = %v, want the nested alias and the old schema version", problems)
+	}
+
+	done, err := MigrateConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(done) != config.SchemaVersion {
+		t.Errorf("ran %v, want %d migrations", done, config.SchemaVersion)
+	}
+	if version, err := config.ReadSchemaVersion(); err != nil || version != config.SchemaVersion {
+		t.Errorf("schema version = %d, %v, want %d", version, err, config.SchemaVersion)
+	}
+	data, err := os.ReadFile(filepath.Join(config.GetFlakePath(), "base-config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "{\n  \"schema_version\": 1,\n  \"commit_triggers\"") {
+		t.Errorf("base-config.json = %s, want the version first and the rest kept", data)
+	}
+	if got, err := GetAliases(); err != nil || got["gs"] != "git status" {
+		t.Errorf("aliases = %v, %v, want gs", got, err)
+	}
+	if problems, err := ValidateConfig(); err != nil || len(problems) != 0 {
+		t.Errorf("problems after migrating = %v, %v", problems, err)
+	}
+
+	if done, err := MigrateConfig(); err != nil || len(done) != 0 {
+		t.Errorf("second migration ran %v, %v", done, err)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	setupFakeEnv(t, "{\n  \"schema_version\": 1\n}\n")
+	if err := GitInit(config.GetInstallPath()); err != nil {
+		t.Fatal(err)
+	}
+	writeFlakeFiles(t, "{\n  \"schema_version\": 1\n}\n")
+
+	if _, err := SetSetting("system.desktop", "gnome"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetSetting("commit_triggers", "install, remove"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := config.GetSetting("system.desktop"); err != nil || s.Value != "gnome" {
+		t.Errorf("system.desktop = %v, %v", s.Value, err)
+	}
+	if triggers, _ := config.GetCommitTriggers(); strings.Join(triggers, " ") != "install remove" {
+		t.Errorf("commit_triggers = %v", triggers)
+	}
+
+	var invalid *schema.Error
+	if _, err := SetSetting("system.type", "riscv"); !errors.As(err, &invalid) {
+		t.Errorf("expected a schema error, got %v", err)
+	}
+	if _, err := SetSetting("schema_version", "2"); err == nil {
+		t.Error("schema_version should be read-only")
+	}
+	if _, err := SetSetting("no.such.key", "x"); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+
+	if _, err := SetSetting("gc.keep_last", "9"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := UnsetSetting("gc.keep_last"); err != nil || s.Value != config.DefaultGCPolicy().KeepLast {
+		t.Errorf("gc.keep_last reset to %v, %v", s.Value, err)
+	}
+
+	if _, err := SetSetting("logHistoryRetention", "50"); err != nil {
+		t.Fatal(err)
+	}
+	if got := config.GetLogHistoryRetention(); got != 50 {
+		t.Errorf("logHistoryRetention = %d, want 50", got)
+	}
+	if _, err := UnsetSetting("logHistoryRetention"); err != nil {
+		t.Fatal(err)
+	}
+	if got := config.GetLogHistoryRetention(); got != 1000 {
+		t.Errorf("logHistoryRetention after unset = %d, want 1000", got)
+	}
+}
+
+func TestSettingsFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	installPath := filepath.Join(home, "pilo")
+
+	// Preferences set in the GUI by earlier versions are moved over.
+	fyneDir := filepath.Join(home, ".config", "fyne", config.AppID)
+	if err := os.MkdirAll(fyneDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	prefs := `{"installationPath": "` + installPath + `", "sshKeyPath": "/keys/id", "logHistoryRetention": 20, "tabPosition": "Top"}`
+	if err := os.WriteFile(filepath.Join(fyneDir, "preferences.json"), []byte(prefs), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := config.GetInstallPath(); got != installPath {
+		t.Errorf("install path = %q, want %q", got, installPath)
+	}
+	if got := config.GetLogHistoryRetention(); got != 20 {
+		t.Errorf("logHistoryRetention = %d, want 20", got)
+	}
+	data, err := os.ReadFile(config.SettingsFile())
+	if err != nil {
+		t.Fatalf("settings file not created: %v", err)
+	}
+	if !strings.Contains(string(data), `sshKeyPath = "/keys/id"`) || strings.Contains(string(data), "tabPosition") {
+		t.Errorf("unexpected settings file:\n%s", data)
+	}
+
+	config.SetNixpkgsUrl("github:me/nixpkgs")
+	t.Setenv("PILO_NIXPKGS_URL", "github:env/nixpkgs")
+	if got := config.GetNixpkgsUrl(); got != "github:env/nixpkgs" {
+		t.Errorf("nixpkgs URL = %q, the environment should override the file", got)
+	}
+	s, err := config.GetSetting("nixpkgsUrl")
+	if err != nil || s.Env != "PILO_NIXPKGS_URL" {
+		t.Errorf("setting = %+v, %v", s, err)
+	}
+}
+
+func TestBrokenSettingsFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	if err := os.MkdirAll(filepath.Dir(config.SettingsFile()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// A typo in a hand-edited file: the string is not closed.
+	broken := "sshKeyPath = \"/keys/id\"\nnixpkgsUrl = \"github:me/nixpkgs\n"
+	if err := os.WriteFile(config.SettingsFile(), []byte(broken), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.CheckSettingsFile(); err == nil {
+		t.Error("expected an error for a settings file that does not parse")
+	}
+	if _, err := config.GetSetting("sshKeyPath"); err == nil {
+		t.Error("expected GetSetting to report the broken settings file")
+	}
+	if err := config.SetPreference("registryName", "mine"); err == nil {
+		t.Error("expected SetPreference to refuse to overwrite the settings file")
+	}
+	if err := config.RemovePreference("sshKeyPath"); err == nil {
+		t.Error("expected RemovePreference to refuse to overwrite the settings file")
+	}
+	config.SetRegistryName("mine")
+	data, err := os.ReadFile(config.SettingsFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != broken {
+		t.Fatalf("settings file was overwritten:\n%s", data)
+	}
+}
@@ -3,7 +3,6 @@ package api
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"pilo/internal/config"
 	"pilo/internal/nix"
@@ -125,12 +124,11 @@ func RunInDevshell(name, command, flakePath string) (string, error) {
 	if flakePath == "" {
 		flakePath = config.GetFlakePath()
 	}
-	cmd := exec.Command("nix", "develop", flakePath+"#"+name, "--command", "sh", "-c", command)
-	output, err := cmd.CombinedOutput()
+	output, err := nix.RunCommand("nix", "develop", flakePath+"#"+name, "--command", "sh", "-c", command)
 	if err != nil {
-		return "", fmt.Errorf("error running command in devshell: %w\nOutput: %s", err, string(output))
+		return "", fmt.Errorf("error running command in devshell: %w", err)
 	}
-	return string(output), nil
+	return output, nil
 }
 
 // GetDevshellContent returns the content of a devshell file.
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"pilo/internal/config"
+	"pilo/internal/nix"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GCPlan lists what garbage collection would delete under a policy.
+type GCPlan struct {
+	Policy config.GCPolicy
+	// Deletions holds the generations that would be deleted, per profile.
+	Deletions []Generation
+	// FreedBytes estimates the space freed by deleting the generations. Store
+	// paths that are already unreachable are collected as well and not counted.
+	FreedBytes int64
+}
+
+// NeedsRoot reports whether running the plan deletes system generations.
+func (p *GCPlan) NeedsRoot() bool {
+	for _, g := range p.Deletions {
+		if g.Profile.IsSystem() {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary describes the plan in a few lines.
+func (p *GCPlan) Summary() string {
+	var b strings.Builder
+	if !p.Policy.DeletesGenerations() {
+		b.WriteString("No generations would be deleted: base-config.json sets no \"gc\" limits.\n")
+	} else if len(p.Deletions) == 0 {
+		b.WriteString("No generations would be deleted.\n")
+	} else {
+		fmt.Fprintf(&b, "%d generation(s) would be deleted:\n", len(p.Deletions))
+		for _, g := range p.Deletions {
+			pinned := ""
+			if g.Pinned {
+				pinned = " (pinned)"
+			}
+			fmt.Fprintf(&b, "  %s #%d from %s%s\n", g.Profile, g.Number, g.Date.Format("2006-01-02"), pinned)
+		}
+	}
+	fmt.Fprintf(&b, "Estimated space freed: %.1f MiB, plus any store paths that are already unused.\n", mebibytes(p.FreedBytes))
+	if p.Policy.Optimise {
+		b.WriteString("The store will be optimised afterwards.\n")
+	}
+	return b.String()
+}
+
+// gcProfiles returns the profiles on this machine whose generations the
+// policy applies to.
+func gcProfiles() []Profile {
+	var profiles []Profile
+	if _, err := os.Lstat(string(SystemProfile)); err == nil {
+		profiles = append(profiles, SystemProfile)
+	}
+	if hm, err := HomeManagerProfile(); err == nil {
+		if _, err := os.Lstat(string(hm)); err == nil {
+			profiles = append(profiles, hm)
+		}
+	}
+	return profiles
+}
+
+// expiredGenerations applies policy to gens, which must be sorted newest
+// first, and returns the generations to delete.
+func expiredGenerations(policy config.GCPolicy, gens []Generation, now time.Time) []Generation {
+	if !policy.DeletesGenerations() {
+		return nil
+	}
+	var expired []Generation
+	cutoff := now.AddDate(0, 0, -policy.OlderThanDays)
+	for i, g := range gens {
+		switch {
+		case g.Current:
+		case g.Pinned && policy.KeepPinned:
+		case policy.KeepLast > 0 && i < policy.KeepLast:
+		case policy.OlderThanDays > 0 && g.Date.After(cutoff):
+		default:
+			expired = append(expired, g)
+		}
+	}
+	return expired
+}
+
+// PlanGC works out which generations the configured policy would delete and
+// how much space that would free, without changing anything.
+func PlanGC(ctx context.Context) (*GCPlan, error) {
+	policy, err := config.GetGCPolicy()
+	if err != nil {
+		return nil, fmt.Errorf("could not read GC policy: %w", err)
+	}
+	plan := &GCPlan{Policy: policy}
+
+	var kept []string
+	var deleted []string
+	for _, profile := range gcProfiles() {
+		gens, err := Generations(ctx, profile)
+		if err != nil {
+			return nil, err
+		}
+		expired := expiredGenerations(policy, gens, time.Now())
+		plan.Deletions = append(plan.Deletions, expired...)
+
+		isExpired := make(map[int]bool)
+		for _, g := range expired {
+			isExpired[g.Number] = true
+			deleted = append(deleted, g.StorePath)
+		}
+		for _, g := range gens {
+			if !isExpired[g.Number] {
+				kept = append(kept, g.StorePath)
+			}
+		}
+	}
+
+	if len(deleted) > 0 {
+		plan.FreedBytes, err = freedBytes(ctx, deleted, kept)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// freedBytes sums the sizes of the store paths that are only reachable from
+// deleted and not from kept.
+func freedBytes(ctx context.Context, deleted, kept []string) (int64, error) {
+	dead, err := closurePathSizes(ctx, deleted)
+	if err != nil {
+		return 0, err
+	}
+	if len(kept) > 0 {
+		alive, err := closurePathSizes(ctx, kept)
+		if err != nil {
+			return 0, err
+		}
+		for path := range alive {
+			delete(dead, path)
+		}
+	}
+	var total int64
+	for _, size := range dead {
+		total += size
+	}
+	return total, nil
+}
+
+// closurePathSizes returns the size of every store path in the closure of paths.
+func closurePathSizes(ctx context.Context, paths []string) (map[string]int64, error) {
+	args := append([]string{"path-info", "--recursive", "--size"}, paths...)
+	out, err := nix.RunCommandContext(ctx, nil, "nix", args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query store paths: %w", err)
+	}
+	sizes := make(map[string]int64)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if size, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			sizes[fields[0]] = size
+		}
+	}
+	return sizes, nil
+}
+
+// RunGC deletes the generations in plan, collects garbage and, if the policy
+// asks for it, optimises the store. password is needed when the plan deletes
+// system generations.
+func RunGC(ctx context.Context, plan *GCPlan, password string, onLine nix.OutputFunc) (string, error) {
+	var out strings.Builder
+	byProfile := make(map[Profile][]int)
+	for _, g := range plan.Deletions {
+		if g.Pinned {
+			if err := UnpinGeneration(g.Profile, g.Number); err != nil {
+				return out.String(), err
+			}
+		}
+		byProfile[g.Profile] = append(byProfile[g.Profile], g.Number)
+	}
+
+	profiles := make([]Profile, 0, len(byProfile))
+	for profile := range byProfile {
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i] < profiles[j] })
+	for _, profile := range profiles {
+		fmt.Printf("Deleting %d generation(s) of %s...\n", len(byProfile[profile]), profile)
+		deleteOut, err := DeleteGenerations(profile, byProfile[profile], password)
+		out.WriteString(deleteOut)
+		if err != nil {
+			return out.String(), err
+		}
+	}
+
+	fmt.Println("Running garbage collection...")
+	gcOut, err := nix.RunCommandContext(ctx, onLine, "nix", "store", "gc")
+	out.WriteString(gcOut)
+	if err != nil {
+		return out.String(), err
+	}
+
+	if plan.Policy.Optimise {
+		fmt.Println("Optimising the store...")
+		optimiseOut, err := nix.RunCommandContext(ctx, onLine, "nix", "store", "optimise")
+		out.WriteString(optimiseOut)
+		if err != nil {
+			return out.String(), err
+		}
+	}
+	return out.String(), nil
+}
+
+// GC applies the configured garbage collection policy.
+func GC() (string, error) {
+	return GCContext(context.Background(), "", nil)
+}
+
+// GCContext applies the configured garbage collection policy, streaming nix's
+// output to onLine.
+func GCContext(ctx context.Context, password string, onLine nix.OutputFunc) (string, error) {
+	plan, err := PlanGC(ctx)
+	if err != nil {
+		return "", err
+	}
+	return RunGC(ctx, plan, password, onLine)
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"pilo/internal/config"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpiredGenerations(t *testing.T) {
+	now := time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	// Newest first: 6 is current, 4 is pinned.
+	gens := []Generation{
+		{Number: 6, Date: now.Add(-1 * day), Current: true},
+		{Number: 5, Date: now.Add(-2 * day)},
+		{Number: 4, Date: now.Add(-40 * day), Pinned: true},
+		{Number: 3, Date: now.Add(-45 * day)},
+		{Number: 2, Date: now.Add(-50 * day)},
+		{Number: 1, Date: now.Add(-60 * day)},
+	}
+
+	tests := []struct {
+		name   string
+		policy config.GCPolicy
+		want   []int
+	}{
+		{"keep last 3", config.GCPolicy{KeepLast: 3, KeepPinned: true}, []int{3, 2, 1}},
+		{"older than 30 days", config.GCPolicy{OlderThanDays: 30, KeepPinned: true}, []int{3, 2, 1}},
+		{"both limits", config.GCPolicy{KeepLast: 5, OlderThanDays: 30, KeepPinned: true}, []int{1}},
+		{"pinned not kept", config.GCPolicy{KeepLast: 2}, []int{4, 3, 2, 1}},
+		{"no limits", config.GCPolicy{KeepPinned: true}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, g := range expiredGenerations(tt.policy, gens, now) {
+				got = append(got, g.Number)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGCPolicyDefaults(t *testing.T) {
+	tests := []struct {
+		name       string
+		baseConfig string
+		want       config.GCPolicy
+	}{
+		// Configurations from before the policy existed keep their
+		// generations.
+		{"no section", `{"commit_triggers": []}`, config.GCPolicy{}},
+		{"partial section", `{"commit_triggers": [], "gc": {"keep_last": 3}}`, config.GCPolicy{KeepLast: 3, OlderThanDays: 30, KeepPinned: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupFakeEnv(t, tt.baseConfig)
+			policy, err := config.GetGCPolicy()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy != tt.want {
+				t.Errorf("policy = %+v, want %+v", policy, tt.want)
+			}
+		})
+	}
+}
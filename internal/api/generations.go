@@ -0,0 +1,452 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"pilo/internal/config"
+	"pilo/internal/nix"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Profile is the path of a Nix profile whose generations pilo manages,
+// e.g. /nix/var/nix/profiles/system.
+type Profile string
+
+// SystemProfile is the NixOS system profile.
+const SystemProfile Profile = "/nix/var/nix/profiles/system"
+
+// HomeManagerProfile returns the home-manager profile of the current user.
+func HomeManagerProfile() (Profile, error) {
+	u, err := user.Current()
+	if err != nil {
+		return "", fmt.Errorf("could not get current user: %w", err)
+	}
+	return Profile(homeManagerProfile(u)), nil
+}
+
+// DefaultProfile returns the profile that rebuilds switch on this machine.
+func DefaultProfile() (Profile, error) {
+	switch nix.GetNixMode() {
+	case nix.NixOS:
+		return SystemProfile, nil
+	case nix.MultiUser, nix.SingleUser:
+		return HomeManagerProfile()
+	default:
+		return "", fmt.Errorf("no supported Nix installation found")
+	}
+}
+
+// ProfileByName resolves "system" or "home" to a profile. An empty name
+// selects DefaultProfile.
+func ProfileByName(name string) (Profile, error) {
+	switch name {
+	case "":
+		return DefaultProfile()
+	case "system":
+		return SystemProfile, nil
+	case "home", "home-manager":
+		return HomeManagerProfile()
+	default:
+		return "", fmt.Errorf("unknown profile %q: use \"system\" or \"home\"", name)
+	}
+}
+
+// IsSystem reports whether p is the NixOS system profile, which needs root to change.
+func (p Profile) IsSystem() bool {
+	return p == SystemProfile
+}
+
+// link returns the path of the link to generation n.
+func (p Profile) link(n int) string {
+	return fmt.Sprintf("%s-%d-link", p, n)
+}
+
+// Generation is one generation of a profile.
+type Generation struct {
+	Profile   Profile
+	Number    int
+	Date      time.Time
+	StorePath string
+	// NixpkgsRevision is the nixpkgs commit the generation was built from, if known.
+	NixpkgsRevision string
+	// Commit is the pilo configuration commit the generation was built from, if known.
+	Commit      string
+	ClosureSize int64
+	Current     bool
+	Pinned      bool
+}
+
+// Path returns the profile link pointing at the generation.
+func (g Generation) Path() string {
+	return g.Profile.link(g.Number)
+}
+
+// Generations returns the generations of profile, newest first.
+func Generations(ctx context.Context, profile Profile) ([]Generation, error) {
+	links, err := filepath.Glob(string(profile) + "-*-link")
+	if err != nil {
+		return nil, err
+	}
+	current, _ := os.Readlink(string(profile))
+	state, err := readGenerationState()
+	if err != nil {
+		return nil, err
+	}
+
+	linkRe := regexp.MustCompile("^" + regexp.QuoteMeta(filepath.Base(string(profile))) + `-(\d+)-link$`)
+	var gens []Generation
+	var paths []string
+	for _, link := range links {
+		m := linkRe.FindStringSubmatch(filepath.Base(link))
+		if m == nil {
+			continue
+		}
+		number, _ := strconv.Atoi(m[1])
+		info, err := os.Lstat(link)
+		if err != nil {
+			return nil, err
+		}
+		storePath, err := os.Readlink(link)
+		if err != nil {
+			return nil, err
+		}
+		gen := Generation{
+			Profile:         profile,
+			Number:          number,
+			Date:            info.ModTime(),
+			StorePath:       storePath,
+			NixpkgsRevision: nixosRevision(link),
+			Current:         filepath.Base(current) == filepath.Base(link),
+			Commit:          state.commitFor(profile, number),
+			Pinned:          state.isPinned(profile, number),
+		}
+		gens = append(gens, gen)
+		paths = append(paths, storePath)
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i].Number > gens[j].Number })
+
+	if len(paths) > 0 {
+		sizes, err := closureSizes(ctx, paths...)
+		if err != nil {
+			return nil, err
+		}
+		for i := range gens {
+			gens[i].ClosureSize = sizes[gens[i].StorePath]
+		}
+	}
+	return gens, nil
+}
+
+// findGeneration returns generation n of profile.
+func findGeneration(ctx context.Context, profile Profile, n int) (Generation, []Generation, error) {
+	gens, err := Generations(ctx, profile)
+	if err != nil {
+		return Generation{}, nil, err
+	}
+	for _, g := range gens {
+		if g.Number == n {
+			return g, gens, nil
+		}
+	}
+	return Generation{}, gens, fmt.Errorf("generation %d of %s does not exist", n, profile)
+}
+
+// nixosRevision reads the nixpkgs revision from a NixOS generation's
+// nixos-version file, e.g. "24.05.20240601.abcdef1". Home Manager generations
+// do not record it.
+func nixosRevision(link string) string {
+	data, err := os.ReadFile(filepath.Join(link, "nixos-version"))
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(strings.TrimSpace(string(data)), ".")
+	if len(parts) < 4 {
+		return ""
+	}
+	return parts[len(parts)-1]
+}
+
+// closureSizes returns the closure size in bytes of each of paths.
+func closureSizes(ctx context.Context, paths ...string) (map[string]int64, error) {
+	args := append([]string{"path-info", "--closure-size"}, paths...)
+	out, err := nix.RunCommandContext(ctx, nil, "nix", args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not get closure sizes: %w", err)
+	}
+	sizes := make(map[string]int64)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if size, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			sizes[fields[0]] = size
+		}
+	}
+	return sizes, nil
+}
+
+// SwitchGeneration activates generation n of profile.
+func SwitchGeneration(profile Profile, n int, password string) (string, error) {
+	gen, _, err := findGeneration(context.Background(), profile, n)
+	if err != nil {
+		return "", err
+	}
+	if !profile.IsSystem() {
+		// A Home Manager generation points the profile back at itself when activated.
+		fmt.Printf("Activating Home Manager generation %d...\n", n)
+		return nix.RunCommand(filepath.Join(gen.Path(), "activate"))
+	}
+
+	fmt.Printf("Switching NixOS to generation %d...\n", n)
+	out, err := nix.RunSudoCommand(password, "nix-env", "--profile", string(profile), "--switch-generation", strconv.Itoa(n))
+	if err != nil {
+		return out, err
+	}
+	activateOut, err := nix.RunSudoCommand(password, filepath.Join(string(profile), "bin", "switch-to-configuration"), "switch")
+	return out + activateOut, err
+}
+
+// DeleteGenerations deletes the given generations of profile. The current
+// generation and pinned generations cannot be deleted.
+func DeleteGenerations(profile Profile, numbers []int, password string) (string, error) {
+	if len(numbers) == 0 {
+		return "", fmt.Errorf("no generations to delete")
+	}
+	gens, err := Generations(context.Background(), profile)
+	if err != nil {
+		return "", err
+	}
+	byNumber := make(map[int]Generation)
+	for _, g := range gens {
+		byNumber[g.Number] = g
+	}
+
+	args := []string{"nix-env", "--profile", string(profile), "--delete-generations"}
+	for _, n := range numbers {
+		g, ok := byNumber[n]
+		switch {
+		case !ok:
+			return "", fmt.Errorf("generation %d of %s does not exist", n, profile)
+		case g.Current:
+			return "", fmt.Errorf("generation %d is the current generation and cannot be deleted", n)
+		case g.Pinned:
+			return "", fmt.Errorf("generation %d is pinned: unpin it before deleting it", n)
+		}
+		args = append(args, strconv.Itoa(n))
+	}
+
+	if profile.IsSystem() {
+		return nix.RunSudoCommand(password, args...)
+	}
+	return nix.RunCommand(args[0], args[1:]...)
+}
+
+// DiffGenerations compares generation a of profile with generation b.
+func DiffGenerations(ctx context.Context, profile Profile, a, b int) (*ClosureDiff, error) {
+	for _, n := range []int{a, b} {
+		if _, err := os.Lstat(profile.link(n)); err != nil {
+			return nil, fmt.Errorf("generation %d of %s does not exist", n, profile)
+		}
+	}
+	return diffClosures(ctx, profile.link(a), profile.link(b))
+}
+
+// PinGeneration protects generation n of profile from deletion and garbage
+// collection by registering a GC root for it.
+func PinGeneration(profile Profile, n int) error {
+	gen, _, err := findGeneration(context.Background(), profile, n)
+	if err != nil {
+		return err
+	}
+	root := pinRoot(profile, n)
+	if err := os.MkdirAll(filepath.Dir(root), 0755); err != nil {
+		return fmt.Errorf("could not create GC root directory: %w", err)
+	}
+	if _, err := nix.RunCommand("nix-store", "--add-root", root, "--realise", gen.StorePath); err != nil {
+		return fmt.Errorf("could not add GC root: %w", err)
+	}
+
+	state, err := readGenerationState()
+	if err != nil {
+		return err
+	}
+	if state.isPinned(profile, n) {
+		return nil
+	}
+	key := string(profile)
+	state.Pinned[key] = append(state.Pinned[key], n)
+	sort.Ints(state.Pinned[key])
+	return writeGenerationState(state)
+}
+
+// UnpinGeneration removes the pin added by PinGeneration.
+func UnpinGeneration(profile Profile, n int) error {
+	if err := os.Remove(pinRoot(profile, n)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove GC root: %w", err)
+	}
+	state, err := readGenerationState()
+	if err != nil {
+		return err
+	}
+	key := string(profile)
+	pins := state.Pinned[key][:0]
+	for _, p := range state.Pinned[key] {
+		if p != n {
+			pins = append(pins, p)
+		}
+	}
+	state.Pinned[key] = pins
+	return writeGenerationState(state)
+}
+
+// pinRoot is the GC root that keeps a pinned generation alive.
+func pinRoot(profile Profile, n int) string {
+	return filepath.Join(config.GetStatePath(), "gcroots", fmt.Sprintf("%s-%d", filepath.Base(string(profile)), n))
+}
+
+// GenerationRecord ties a generation to the configuration commit it was built from.
+type GenerationRecord struct {
+	Profile    string    `json:"profile"`
+	Generation int       `json:"generation"`
+	Commit     string    `json:"commit"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// generationState is the machine-local record of pilo's generation metadata.
+type generationState struct {
+	// Pinned maps a profile path to its pinned generation numbers.
+	Pinned  map[string][]int   `json:"pinned"`
+	Records []GenerationRecord `json:"records"`
+}
+
+// commitFor returns the commit recorded for generation n of profile, if any.
+func (s *generationState) commitFor(profile Profile, n int) string {
+	commit := ""
+	for _, r := range s.Records {
+		if r.Profile == string(profile) && r.Generation == n {
+			commit = r.Commit
+		}
+	}
+	return commit
+}
+
+// RecordGeneration remembers that the current generation of profile was built
+// from commit.
+func RecordGeneration(profile Profile, commit string) error {
+	current, err := os.Readlink(string(profile))
+	if err != nil {
+		return fmt.Errorf("could not read profile %s: %w", profile, err)
+	}
+	m := regexp.MustCompile(`-(\d+)-link$`).FindStringSubmatch(current)
+	if m == nil {
+		return fmt.Errorf("could not determine the current generation of %s", profile)
+	}
+	n, _ := strconv.Atoi(m[1])
+
+	state, err := readGenerationState()
+	if err != nil {
+		return err
+	}
+	state.Records = append(state.Records, GenerationRecord{
+		Profile:    string(profile),
+		Generation: n,
+		Commit:     commit,
+		Timestamp:  time.Now(),
+	})
+	return writeGenerationState(state)
+}
+
+// GenerationsForCommit returns the recorded generations built from commit,
+// which may be abbreviated.
+func GenerationsForCommit(commit string) ([]GenerationRecord, error) {
+	state, err := readGenerationState()
+	if err != nil {
+		return nil, err
+	}
+	var records []GenerationRecord
+	for _, r := range state.Records {
+		if commit != "" && strings.HasPrefix(r.Commit, commit) {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func (s *generationState) isPinned(profile Profile, n int) bool {
+	for _, p := range s.Pinned[string(profile)] {
+		if p == n {
+			return true
+		}
+	}
+	return false
+}
+
+func generationStatePath() string {
+	return filepath.Join(config.GetStatePath(), "generations.json")
+}
+
+func readGenerationState() (*generationState, error) {
+	state := &generationState{}
+	data, err := os.ReadFile(generationStatePath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read generation state: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("could not parse generation state: %w", err)
+		}
+	}
+	if state.Pinned == nil {
+		state.Pinned = make(map[string][]int)
+	}
+	return state, nil
+}
+
+func writeGenerationState(state *generationState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(generationStatePath()), 0755); err != nil {
+		return fmt.Errorf("could not create state directory: %w", err)
+	}
+	// Installs made before the state directory existed do not ignore it yet.
+	if err := CreateGitignore(config.GetInstallPath()); err != nil {
+		return err
+	}
+	return os.WriteFile(generationStatePath(), data, 0644)
+}
+
+// GenerationsTable renders gens as an aligned text table.
+func GenerationsTable(gens []Generation) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GEN\tDATE\tNIXPKGS\tCOMMIT\tSIZE\t")
+	for _, g := range gens {
+		var flags []string
+		if g.Current {
+			flags = append(flags, "current")
+		}
+		if g.Pinned {
+			flags = append(flags, "pinned")
+		}
+		commit := g.Commit
+		if len(commit) > 8 {
+			commit = commit[:8]
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%.1f MiB\t%s\n", g.Number, g.Date.Format("2006-01-02 15:04"),
+			g.NixpkgsRevision, commit, mebibytes(g.ClosureSize), strings.Join(flags, ", "))
+	}
+	w.Flush()
+	return b.String()
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pilo/internal/nix"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setupProfile creates a profile with generations 1 to n, the last one current.
+func setupProfile(t *testing.T, n int) (Profile, *nix.FakeExecutor) {
+	t.Helper()
+	fake := setupFakeEnv(t, `{"commit_triggers": []}`)
+	dir := t.TempDir()
+
+	profile := Profile(filepath.Join(dir, "profiles", "system"))
+	if err := os.MkdirAll(filepath.Dir(string(profile)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var sizes []string
+	for i := 1; i <= n; i++ {
+		storePath := filepath.Join(dir, "store", fmt.Sprintf("gen%d", i))
+		if err := os.MkdirAll(storePath, 0755); err != nil {
+			t.Fatal(err)
+		}
+		version := fmt.Sprintf("24.05.2024060%d.abcdef%d", i, i)
+		if err := os.WriteFile(filepath.Join(storePath, "nixos-version"), []byte(version), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(storePath, profile.link(i)); err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, fmt.Sprintf("%s\t%d", storePath, i*1024*1024))
+	}
+	if err := os.Symlink(filepath.Base(profile.link(n)), string(profile)); err != nil {
+		t.Fatal(err)
+	}
+	fake.On("nix path-info --closure-size", nix.FakeResult{Stdout: strings.Join(sizes, "\n")})
+	return profile, fake
+}
+
+func TestGenerations(t *testing.T) {
+	profile, _ := setupProfile(t, 3)
+
+	gens, err := Generations(context.Background(), profile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var numbers []int
+	for _, g := range gens {
+		numbers = append(numbers, g.Number)
+	}
+	if !reflect.DeepEqual(numbers, []int{3, 2, 1}) {
+		t.Fatalf("generations = %v, want newest first", numbers)
+	}
+	if !gens[0].Current || gens[1].Current {
+		t.Error("only generation 3 should be current")
+	}
+	if gens[1].NixpkgsRevision != "abcdef2" {
+		t.Errorf("nixpkgs revision = %q, want abcdef2", gens[1].NixpkgsRevision)
+	}
+	if gens[2].ClosureSize != 1024*1024 {
+		t.Errorf("closure size = %d, want 1 MiB", gens[2].ClosureSize)
+	}
+}
+
+func TestDeleteGenerations(t *testing.T) {
+	profile, fake := setupProfile(t, 3)
+	if err := PinGeneration(profile, 2); err != nil {
+		t.Fatalf("unexpected error pinning: %v", err)
+	}
+
+	if _, err := DeleteGenerations(profile, []int{3}, ""); err == nil {
+		t.Error("deleting the current generation should fail")
+	}
+	if _, err := DeleteGenerations(profile, []int{1, 2}, ""); err == nil {
+		t.Error("deleting a pinned generation should fail")
+	}
+
+	fake.Reset()
+	if _, err := DeleteGenerations(profile, []int{1}, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "nix-env --profile " + string(profile) + " --delete-generations 1"
+	if lines := fake.CommandLines(); lines[len(lines)-1] != want {
+		t.Errorf("commands = %q, want last %q", lines, want)
+	}
+
+	if err := UnpinGeneration(profile, 2); err != nil {
+		t.Fatalf("unexpected error unpinning: %v", err)
+	}
+	if _, err := DeleteGenerations(profile, []int{2}, ""); err != nil {
+		t.Errorf("deleting an unpinned generation failed: %v", err)
+	}
+}
+
+func TestRecordGeneration(t *testing.T) {
+	profile, _ := setupProfile(t, 2)
+	if err := RecordGeneration(profile, "0123456789abcdef"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	gens, err := Generations(context.Background(), profile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gens[0].Commit != "0123456789abcdef" || gens[1].Commit != "" {
+		t.Errorf("commits = %q, %q; want only the current generation recorded", gens[0].Commit, gens[1].Commit)
+	}
+	records, err := GenerationsForCommit("01234567")
+	if err != nil || len(records) != 1 || records[0].Generation != 2 {
+		t.Errorf("GenerationsForCommit() = %+v, %v", records, err)
+	}
+}
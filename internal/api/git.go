@@ -8,161 +8,59 @@ import (
 	"os"
 	"path/filepath"
 	"pilo/internal/config"
+	"pilo/internal/vcs"
 	"strings"
 	"time"
 
 	"github.com/go-git/go-git/v5"
-	gitconfig "github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/pmezard/go-difflib/difflib"
 )
 
 // ErrDirtyRepository is returned when a git operation fails because the repository has uncommitted changes.
 var ErrDirtyRepository = errors.New("local repository has uncommitted changes")
 
-// getGitAuth determines the authentication method for Git operations.
-// It prioritizes a specific SSH key, but falls back to the SSH agent if no key is provided.
-func getGitAuth() (ssh.AuthMethod, error) {
-	sshKeyPath := config.GetSshKeyPath()
-	if sshKeyPath != "" {
-		auth, err := ssh.NewPublicKeysFromFile("git", sshKeyPath, "")
-		if err != nil {
-			return nil, fmt.Errorf("failed to load SSH key from %s: %w", sshKeyPath, err)
-		}
-		return auth, nil
-	}
-	// Use SSH agent if no key is specified
-	if os.Getenv("SSH_AUTH_SOCK") != "" {
-		auth, err := ssh.NewSSHAgentAuth("git")
-		if err == nil {
-			return auth, nil
-		}
-	}
-
-	// Fallback to searching for SSH keys
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user home directory: %w", err)
-	}
-
-	keyTypes := []string{"id_ed25519", "id_ecdsa", "id_rsa"}
-	for _, keyType := range keyTypes {
-		path := filepath.Join(homeDir, ".ssh", keyType)
-		if _, errStat := os.Stat(path); errStat == nil {
-			auth, err := ssh.NewPublicKeysFromFile("git", path, "")
-			if err == nil {
-				return auth, nil // Key found and loaded
-			}
-		}
-	}
-
-	return nil, errors.New("no usable SSH key found and SSH agent is not available")
-}
-
 func GitInit(repoPath string) error {
-	repo, err := git.PlainInit(repoPath, false)
+	repo, err := vcs.Init(repoPath)
 	if err != nil {
-		if err == git.ErrRepositoryAlreadyExists {
+		if err == vcs.ErrRepositoryExists {
 			return nil // Already a git repo, so we're good
 		}
 		return err
 	}
 
-	// Set the default branch to 'main'
-	cfg, err := repo.Config()
-	if err != nil {
-		return fmt.Errorf("failed to get git config: %w", err)
-	}
-	cfg.Init.DefaultBranch = "main"
-	if err := repo.SetConfig(cfg); err != nil {
-		return fmt.Errorf("failed to set default branch to main: %w", err)
-	}
-
-	// Create an initial empty commit
-	w, err := repo.Worktree()
-	if err != nil {
-		return err
-	}
-
 	// Add a .gitkeep file to ensure there's something to commit
 	gitkeepPath := filepath.Join(repoPath, ".gitkeep")
 	if err := os.WriteFile(gitkeepPath, []byte{}, 0644); err != nil {
 		return fmt.Errorf("failed to create .gitkeep file: %w", err)
 	}
-
-	_, err = w.Add(".gitkeep")
-	if err != nil {
-		return fmt.Errorf("failed to add .gitkeep: %w", err)
+	if err := repo.AddAll(); err != nil {
+		return fmt.Errorf("failed to stage the initial commit: %w", err)
 	}
 
-	commitHash, err := w.Commit("Initial commit", &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  "pilo",
-			Email: "pilo@localhost",
-			When:  time.Now(),
-		},
-	})
-	if err != nil {
+	if _, err := repo.Commit(withTrailer("Initial commit"), vcs.CommitOptions{
+		Author:     commitAuthor(repo),
+		AllowEmpty: true,
+	}); err != nil {
 		return fmt.Errorf("failed to create initial commit: %w", err)
 	}
-
-	// Explicitly create and checkout the 'main' branch
-	mainBranchRef := plumbing.NewBranchReferenceName("main")
-	if err := repo.Storer.SetReference(plumbing.NewHashReference(mainBranchRef, commitHash)); err != nil {
-		return fmt.Errorf("failed to create main branch: %w", err)
-	}
-	if err := w.Checkout(&git.CheckoutOptions{Branch: mainBranchRef}); err != nil {
-		return fmt.Errorf("failed to checkout main branch: %w", err)
-	}
-
 	return nil
 }
 
 func GitAdd(repoPath string) error {
-	repo, err := git.PlainOpen(repoPath)
-	if err != nil {
-		return err
-	}
-
-	w, err := repo.Worktree()
+	repo, err := vcs.Open(repoPath)
 	if err != nil {
 		return err
 	}
-
-	_, err = w.Add(".")
-	return err
+	return repo.AddAll()
 }
 
+// GitCommit commits the staged changes, completing a merge that GitSync left
+// for the user to resolve, and syncs with the remote if push_on_commit is set.
 func GitCommit(repoPath, message string) error {
-	repo, err := git.PlainOpen(repoPath)
-	if err != nil {
-		return err
-	}
-
-	w, err := repo.Worktree()
-	if err != nil {
-		return err
-	}
-
-	status, err := w.Status()
-	if err != nil {
-		return err
-	}
-
-	if status.IsClean() {
-		return nil
-	}
-
-	_, err = w.Commit(message, &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  "pilo",
-			Email: "pilo@localhost",
-			When:  time.Now(),
-		},
-	})
-	if err != nil {
+	committed, err := gitCommit(repoPath, message)
+	if err != nil || !committed {
 		return err
 	}
 
@@ -179,7 +77,7 @@ func GitCommit(repoPath, message string) error {
 			config.AddLogEntry(fmt.Sprintf("could not get remote URL: %v", err))
 		}
 		if remoteURL != "" {
-			if err := GitSync(repoPath); err != nil {
+			if _, err := GitSync(repoPath, false); err != nil {
 				// Log or handle push error, but don't fail the commit
 				config.AddLogEntry(fmt.Sprintf("failed to sync after commit: %v", err))
 			}
@@ -204,14 +102,11 @@ const (
 // GitRestore handles cloning or updating a repository from a remote URL.
 // If the repository is dirty, it uses the provided strategy to resolve the state.
 func GitRestore(repoPath, remoteURL, branch string, strategy *GitRestoreStrategy, commitMessage string) error {
-	// Log environment variables for debugging SSH issues
-	logMessage := "Environment variables:\n"
-	for _, e := range os.Environ() {
-		logMessage += e + "\n"
-	}
-	config.AddLogEntry(logMessage)
+	// The agent socket is all of the environment that SSH authentication
+	// depends on; the rest may hold credentials such as PILO_GIT_TOKEN.
+	config.AddLogEntry(fmt.Sprintf("SSH_AUTH_SOCK=%s", os.Getenv("SSH_AUTH_SOCK")))
 
-	auth, err := getGitAuth()
+	auth, err := getGitAuth(remoteURL)
 	if err != nil {
 		config.AddLogEntry(fmt.Sprintf("getGitAuth error: %v", err))
 		return err
@@ -237,16 +132,12 @@ func GitRestore(repoPath, remoteURL, branch string, strategy *GitRestoreStrategy
 	}
 
 	// Repository exists, check if it's dirty
-	w, err := repo.Worktree()
-	if err != nil {
-		return err
-	}
-	status, err := w.Status()
+	dirty, err := GitStatus(repoPath)
 	if err != nil {
 		return err
 	}
 
-	if !status.IsClean() {
+	if dirty {
 		if strategy == nil {
 			return ErrDirtyRepository
 		}
@@ -282,31 +173,8 @@ func GitRestore(repoPath, remoteURL, branch string, strategy *GitRestoreStrategy
 	}
 
 	// Now that the repo is clean, let's deal with the remote
-	remote, err := repo.Remote("origin")
-	if err != nil {
-		// If remote doesn't exist, create it
-		remote, err = repo.CreateRemote(&gitconfig.RemoteConfig{
-			Name: "origin",
-			URLs: []string{remoteURL},
-		})
-		if err != nil {
-			return err
-		}
-	}
-
-	// If the URL is different, update it
-	if len(remote.Config().URLs) == 0 || remote.Config().URLs[0] != remoteURL {
-		err = repo.DeleteRemote("origin")
-		if err != nil {
-			return err
-		}
-		_, err = repo.CreateRemote(&gitconfig.RemoteConfig{
-			Name: "origin",
-			URLs: []string{remoteURL},
-		})
-		if err != nil {
-			return err
-		}
+	if err := setOrigin(repo, remoteURL); err != nil {
+		return err
 	}
 
 	// Fetch and reset
@@ -362,6 +230,10 @@ func GitRestore(repoPath, remoteURL, branch string, strategy *GitRestoreStrategy
 	}
 
 	// Reset the worktree to the remote's HEAD
+	w, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
 	return w.Reset(&git.ResetOptions{
 		Commit: remoteRef.Hash(),
 		Mode:   git.HardReset,
@@ -370,24 +242,66 @@ func GitRestore(repoPath, remoteURL, branch string, strategy *GitRestoreStrategy
 
 // GitStatus checks if the repository at the given path has uncommitted changes.
 func GitStatus(repoPath string) (bool, error) {
-	repo, err := git.PlainOpen(repoPath)
+	repo, err := vcs.Open(repoPath)
 	if err != nil {
-		if err == git.ErrRepositoryNotExists {
+		if err == vcs.ErrNotRepository {
 			return false, nil // Not a git repo, so not dirty
 		}
 		return false, err
 	}
 
-	w, err := repo.Worktree()
+	status, err := repo.Status()
 	if err != nil {
 		return false, err
 	}
-	status, err := w.Status()
+	return !status.IsClean(), nil
+}
+
+// GitHead returns the hash of the commit checked out at repoPath.
+func GitHead(repoPath string) (string, error) {
+	repo, err := vcs.Open(repoPath)
 	if err != nil {
-		return false, err
+		return "", err
 	}
+	return repo.Head()
+}
 
-	return !status.IsClean(), nil
+// GitCheckoutTree replaces the working tree and index with the contents of
+// rev, which may be an abbreviated hash, while keeping the current branch.
+// Committing afterwards records the old configuration as a new commit.
+// It returns the full hash of rev.
+func GitCheckoutTree(repoPath, rev string) (string, error) {
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return "", err
+	}
+	target, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return "", fmt.Errorf("could not find commit %s: %w", rev, err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+
+	if dirty, err := GitStatus(repoPath); err != nil {
+		return "", err
+	} else if dirty {
+		return "", fmt.Errorf("the configuration has uncommitted changes: commit or discard them first")
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+
+	// A hard reset rewrites the tree; the soft reset then moves the branch back.
+	if err := w.Reset(&git.ResetOptions{Commit: *target, Mode: git.HardReset}); err != nil {
+		return "", err
+	}
+	if err := w.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.SoftReset}); err != nil {
+		return "", err
+	}
+	return target.String(), nil
 }
 
 func GitReset(repoPath string) error {
@@ -407,20 +321,15 @@ func GitReset(repoPath string) error {
 }
 
 func GetGitStatus(repoPath string) (string, error) {
-	repo, err := git.PlainOpen(repoPath)
+	repo, err := vcs.Open(repoPath)
 	if err != nil {
-		if err == git.ErrRepositoryNotExists {
+		if err == vcs.ErrNotRepository {
 			return "Not a git repository.", nil
 		}
 		return "", err
 	}
 
-	w, err := repo.Worktree()
-	if err != nil {
-		return "", err
-	}
-
-	status, err := w.Status()
+	status, err := repo.Status()
 	if err != nil {
 		return "", err
 	}
@@ -445,13 +354,13 @@ func GetGitDiff(repoPath string) (string, error) {
 		return "", fmt.Errorf("open repo: %w", err)
 	}
 
-	wt, err := repo.Worktree()
+	vcsRepo, err := vcs.Open(repoPath)
 	if err != nil {
-		return "", fmt.Errorf("worktree: %w", err)
+		return "", fmt.Errorf("open repo: %w", err)
 	}
 
 	// status lists changed files (tracked changes, staged, untracked, deleted)
-	status, err := wt.Status()
+	status, err := vcsRepo.Status()
 	if err != nil {
 		return "", fmt.Errorf("status: %w", err)
 	}
@@ -477,7 +386,8 @@ func GetGitDiff(repoPath string) (string, error) {
 	var out bytes.Buffer
 
 	// iterate changed files reported by Status
-	for path, fileStatus := range status {
+	for _, file := range status {
+		path := file.Path
 		// skip .git directory entries accidentally reported
 		if strings.HasPrefix(path, ".git/") {
 			continue
@@ -518,9 +428,6 @@ func GetGitDiff(repoPath string) (string, error) {
 			return "", fmt.Errorf("make diff: %w", err)
 		}
 		out.WriteString(patchStr)
-
-		// You might want to include submodule handling here if you need it.
-		_ = fileStatus // kept in case you want to show staged vs worktree info
 	}
 
 	result := out.String()
@@ -531,86 +438,18 @@ func GetGitDiff(repoPath string) (string, error) {
 }
 
 func GitPush(repoPath string) error {
-	repo, err := git.PlainOpen(repoPath)
+	repo, err := vcs.Open(repoPath)
 	if err != nil {
 		return err
 	}
 
-	auth, err := getGitAuth()
+	remoteURL, err := repo.RemoteURL("origin")
 	if err != nil {
 		return err
 	}
-
-	err = repo.Push(&git.PushOptions{
-		Auth: auth,
-	})
-	if err != nil && err != git.NoErrAlreadyUpToDate {
-		return err
-	}
-	return nil
-}
-
-// GitSync provides a safe way to push local changes to the remote, even if the branches have diverged.
-// It works by backing up local changes, pulling remote changes, restoring local changes, and then pushing.
-func GitSync(repoPath string) error {
-	// 1. Create a backup of the current state
-	if err := GitBackup(repoPath); err != nil {
-		return fmt.Errorf("failed to create backup before syncing: %w", err)
-	}
-
-	// 2. Pull remote changes, hard resetting to the remote state
-	repo, err := git.PlainOpen(repoPath)
+	auth, err := getGitAuth(remoteURL)
 	if err != nil {
 		return err
 	}
-	auth, err := getGitAuth()
-	if err != nil {
-		return err
-	}
-	if err := repo.Fetch(&git.FetchOptions{RemoteName: "origin", Auth: auth}); err != nil && err != git.NoErrAlreadyUpToDate {
-		return fmt.Errorf("failed to fetch from remote: %w", err)
-	}
-	// We don't need to inspect the remote ref directly, just fetch.
-	// The subsequent restore and push will handle the state.
-	// Before resetting, we need to ensure the .git directory is not deleted.
-	// The RestoreMostRecentBackup function will clear the repoPath.
-	// We need to preserve the .git directory.
-	gitDir := filepath.Join(repoPath, ".git")
-	tempGitDir, err := os.MkdirTemp("", "pilo-git-")
-	if err != nil {
-		return fmt.Errorf("failed to create temp git dir: %w", err)
-	}
-	defer os.RemoveAll(tempGitDir)
-	if err := os.Rename(gitDir, filepath.Join(tempGitDir, ".git")); err != nil {
-		return fmt.Errorf("failed to move .git dir: %w", err)
-	}
-
-	// 3. Restore the most recent backup
-	if err := RestoreMostRecentBackup(repoPath); err != nil {
-		// Attempt to restore the .git directory before failing
-		os.Rename(filepath.Join(tempGitDir, ".git"), gitDir)
-		return fmt.Errorf("failed to restore from backup: %w", err)
-	}
-
-	// 4. Move the .git directory back
-	if err := os.Rename(filepath.Join(tempGitDir, ".git"), gitDir); err != nil {
-		return fmt.Errorf("failed to move .git dir back: %w", err)
-	}
-
-	// 5. Commit the restored (local) changes
-	if err := GitAdd(repoPath); err != nil {
-		return fmt.Errorf("failed to add restored files: %w", err)
-	}
-	if err := GitCommit(repoPath, "pilo: sync local changes"); err != nil {
-		// It's possible there were no changes to commit, so we don't fail here
-		config.AddLogEntry(fmt.Sprintf("Note: could not create sync commit, possibly no changes: %v", err))
-	}
-
-	// 6. Push the synchronized changes
-	if err := GitPush(repoPath); err != nil {
-		return fmt.Errorf("failed to push synchronized changes: %w", err)
-	}
-
-	config.AddLogEntry("Successfully synced with remote. Your local changes have been preserved and pushed.")
-	return nil
+	return repo.Push(auth)
 }
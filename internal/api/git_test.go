@@ -0,0 +1,56 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitCheckoutTree(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	repo := t.TempDir()
+	if err := GitInit(repo); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit := func(msg string) string {
+		if err := GitAdd(repo); err != nil {
+			t.Fatal(err)
+		}
+		if err := GitCommit(repo, msg); err != nil {
+			t.Fatal(err)
+		}
+		head, err := GitHead(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return head
+	}
+
+	write("packages.json", "old")
+	old := commit("old")
+	write("packages.json", "new")
+	write("extra.nix", "{}")
+	head := commit("new")
+
+	full, err := GitCheckoutTree(repo, old[:8])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if full != old {
+		t.Errorf("resolved %s, want %s", full, old)
+	}
+	if data, _ := os.ReadFile(filepath.Join(repo, "packages.json")); string(data) != "old" {
+		t.Errorf("packages.json = %q, want old", data)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "extra.nix")); !os.IsNotExist(err) {
+		t.Error("extra.nix should have been removed")
+	}
+	if got, _ := GitHead(repo); got != head {
+		t.Errorf("HEAD moved to %s, want %s", got, head)
+	}
+}
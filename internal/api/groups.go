@@ -0,0 +1,337 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"pilo/internal/config"
+	"regexp"
+	"strings"
+)
+
+// PackageGroupInfo describes a package group and how this host uses it.
+type PackageGroupInfo struct {
+	config.PackageGroup
+	// Used reports whether this host installs the group's packages, which
+	// depends on its Enabled flag and the host's group selection.
+	Used     bool
+	Packages []string
+}
+
+var groupNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ListPackageGroups returns the groups in packages.json with their packages.
+func ListPackageGroups() ([]PackageGroupInfo, error) {
+	groups, err := config.ReadPackageGroups()
+	if err != nil {
+		return nil, err
+	}
+	packages, err := config.ReadPackagesConfig()
+	if err != nil {
+		return nil, err
+	}
+	host, err := CurrentHost()
+	if err != nil {
+		return nil, err
+	}
+	hostGroups, err := hostPackageGroups(host)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]PackageGroupInfo, len(groups))
+	for i, g := range groups {
+		infos[i] = PackageGroupInfo{PackageGroup: g, Used: config.GroupUsed(g, hostGroups)}
+		for _, pkg := range packages {
+			if pkg.Group == g.Name {
+				infos[i].Packages = append(infos[i].Packages, pkg.Name)
+			}
+		}
+	}
+	return infos, nil
+}
+
+// AddPackageGroup creates the group name if it does not exist yet and moves
+// the given packages into it, in a single commit. New groups are enabled.
+func AddPackageGroup(name, description string, packageNames []string) error {
+	if !groupNameRe.MatchString(name) {
+		return fmt.Errorf("invalid group name '%s'", name)
+	}
+	groups, err := config.ReadPackageGroups()
+	if err != nil {
+		return err
+	}
+	packages, err := config.ReadPackagesConfig()
+	if err != nil {
+		return err
+	}
+
+	if g := findGroup(groups, name); g < 0 {
+		groups = append(groups, config.PackageGroup{Name: name, Description: description, Enabled: true})
+	} else if description != "" {
+		groups[g].Description = description
+	}
+
+	move := make(map[string]bool)
+	for _, n := range packageNames {
+		move[n] = true
+	}
+	for i := range packages {
+		if move[packages[i].Name] {
+			packages[i].Group = name
+			delete(move, packages[i].Name)
+		}
+	}
+	for _, n := range packageNames {
+		if move[n] {
+			return fmt.Errorf("package '%s' not found", n)
+		}
+	}
+
+	if err := config.WritePackagesConfig(packages); err != nil {
+		return err
+	}
+	if err := config.WritePackageGroups(groups); err != nil {
+		return err
+	}
+
+	if len(packageNames) > 0 {
+		return commitChanges(packagesMessage("move", uniqueNames(packageNames)) + " to group " + name)
+	}
+	return commitChanges(fmt.Sprintf("pilo: add package group %s", name))
+}
+
+// RemovePackageGroup takes the given packages out of a group, in a single
+// commit. With no packages it deletes the group: its packages stay in
+// packages.json without a group, and hosts that selected it no longer do.
+func RemovePackageGroup(name string, packageNames []string) error {
+	groups, err := config.ReadPackageGroups()
+	if err != nil {
+		return err
+	}
+	g := findGroup(groups, name)
+	if g < 0 {
+		return fmt.Errorf("package group '%s' not found", name)
+	}
+	packages, err := config.ReadPackagesConfig()
+	if err != nil {
+		return err
+	}
+
+	if len(packageNames) > 0 {
+		ungroup := make(map[string]bool)
+		for _, n := range packageNames {
+			ungroup[n] = true
+		}
+		for i := range packages {
+			if ungroup[packages[i].Name] && packages[i].Group == name {
+				packages[i].Group = ""
+				delete(ungroup, packages[i].Name)
+			}
+		}
+		for _, n := range packageNames {
+			if ungroup[n] {
+				return fmt.Errorf("package '%s' is not in group '%s'", n, name)
+			}
+		}
+		if err := config.WritePackagesConfig(packages); err != nil {
+			return err
+		}
+		return commitChanges(packagesMessage("move", uniqueNames(packageNames)) + " out of group " + name)
+	}
+
+	groups = append(groups[:g], groups[g+1:]...)
+	for i := range packages {
+		if packages[i].Group == name {
+			packages[i].Group = ""
+		}
+	}
+	if err := config.WritePackagesConfig(packages); err != nil {
+		return err
+	}
+	if err := config.WritePackageGroups(groups); err != nil {
+		return err
+	}
+
+	system, err := config.GetSystem()
+	if err != nil {
+		return err
+	}
+	var hostGroups []string
+	for _, g := range system.Groups {
+		if g != name {
+			hostGroups = append(hostGroups, g)
+		}
+	}
+	if len(hostGroups) != len(system.Groups) {
+		system.Groups = hostGroups
+		if err := config.SetSystem(system); err != nil {
+			return err
+		}
+	}
+	if err := removeHostPackageGroup(name); err != nil {
+		return err
+	}
+
+	return commitChanges(fmt.Sprintf("pilo: remove package group %s", name))
+}
+
+// SetPackageGroupEnabled switches a group on or off for every host that does
+// not select its groups explicitly.
+func SetPackageGroupEnabled(name string, enabled bool) error {
+	groups, err := config.ReadPackageGroups()
+	if err != nil {
+		return err
+	}
+	i := findGroup(groups, name)
+	if i < 0 {
+		return fmt.Errorf("package group '%s' not found", name)
+	}
+	if groups[i].Enabled == enabled {
+		return nil
+	}
+	groups[i].Enabled = enabled
+	if err := config.WritePackageGroups(groups); err != nil {
+		return err
+	}
+
+	action := "disable"
+	if enabled {
+		action = "enable"
+	}
+	return commitChanges(fmt.Sprintf("pilo: %s package group %s", action, name))
+}
+
+// SetHostPackageGroups selects the groups a host uses. With an empty host
+// the selection goes to base-config.json and applies to every host that does
+// not make its own; otherwise it goes to the host's base-config.json. With no
+// names, the groups' Enabled flags decide again.
+func SetHostPackageGroups(host string, names []string) error {
+	groups, err := config.ReadPackageGroups()
+	if err != nil {
+		return err
+	}
+	names = uniqueNames(names)
+	for _, n := range names {
+		if findGroup(groups, n) < 0 {
+			return fmt.Errorf("package group '%s' not found", n)
+		}
+	}
+
+	if host == "" {
+		system, err := config.GetSystem()
+		if err != nil {
+			return err
+		}
+		system.Groups = names
+		if err := config.SetSystem(system); err != nil {
+			return err
+		}
+	} else {
+		if host, err = resolveHost(config.GetFlakePath(), host); err != nil {
+			return err
+		}
+		overrides, err := hostOverrides(host)
+		if err != nil {
+			return err
+		}
+		system, _ := overrides["system"].(map[string]interface{})
+		if system == nil {
+			system = map[string]interface{}{}
+		}
+		if len(names) == 0 {
+			delete(system, "groups")
+		} else {
+			system["groups"] = names
+		}
+		overrides["system"] = system
+		if err := writeHostOverrides(host, overrides); err != nil {
+			return err
+		}
+	}
+
+	message := "pilo: use enabled package groups"
+	if len(names) > 0 {
+		message = "pilo: use package groups " + strings.Join(names, ", ")
+	}
+	if host != "" {
+		message += " on " + host
+	}
+	return commitChanges(message)
+}
+
+// hostPackageGroups returns the package groups host selects: those in its own
+// base-config.json if it has any, and those in the top-level one otherwise.
+func hostPackageGroups(host string) ([]string, error) {
+	overrides, err := hostOverrides(host)
+	if err != nil {
+		return nil, err
+	}
+	if system, ok := overrides["system"].(map[string]interface{}); ok {
+		if list, ok := system["groups"].([]interface{}); ok {
+			var names []string
+			for _, n := range list {
+				if s, ok := n.(string); ok {
+					names = append(names, s)
+				}
+			}
+			return names, nil
+		}
+	}
+	system, err := config.GetSystem()
+	if err != nil {
+		return nil, err
+	}
+	return system.Groups, nil
+}
+
+// removeHostPackageGroup drops group from the selections in the hosts' own
+// base configs.
+func removeHostPackageGroup(group string) error {
+	hosts, err := ListHosts()
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, h := range hosts {
+		if !h.Overrides {
+			continue
+		}
+		overrides, err := hostOverrides(h.Name)
+		if err != nil {
+			return err
+		}
+		system, _ := overrides["system"].(map[string]interface{})
+		list, ok := system["groups"].([]interface{})
+		if !ok {
+			continue
+		}
+		kept := []interface{}{}
+		for _, g := range list {
+			if g != group {
+				kept = append(kept, g)
+			}
+		}
+		if len(kept) == len(list) {
+			continue
+		}
+		if len(kept) == 0 {
+			delete(system, "groups")
+		} else {
+			system["groups"] = kept
+		}
+		if err := writeHostOverrides(h.Name, overrides); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func findGroup(groups []config.PackageGroup, name string) int {
+	for i, g := range groups {
+		if g.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,225 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pilo/internal/config"
+	"regexp"
+	"sort"
+)
+
+// Host is a machine configured in the flake's hosts directory.
+type Host struct {
+	Name string
+	// Current reports whether rebuilding on this machine builds the host.
+	Current bool
+	// HardwareConfig reports whether the host has a hardware-configuration.nix.
+	HardwareConfig bool
+	// Overrides reports whether the host has a base-config.json of its own.
+	Overrides bool
+}
+
+// hostname is replaced in tests.
+var hostname = os.Hostname
+
+var hostNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]*$`)
+
+// commonHostDir holds the modules shared by every host and is not a host.
+const commonHostDir = "common"
+
+// hostConfigFiles are the files captured from /etc/nixos for a host.
+var hostConfigFiles = []string{"hardware-configuration.nix"}
+
+// ListHosts returns the hosts in the flake, sorted by name.
+func ListHosts() ([]Host, error) {
+	return listHosts(config.GetFlakePath())
+}
+
+func listHosts(flakePath string) ([]Host, error) {
+	entries, err := os.ReadDir(filepath.Join(flakePath, "hosts"))
+	if err != nil {
+		return nil, err
+	}
+	current, err := currentHost(flakePath)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []Host
+	for _, e := range entries {
+		dir := filepath.Join(flakePath, "hosts", e.Name())
+		if !e.IsDir() || e.Name() == commonHostDir || !fileExists(filepath.Join(dir, "default.nix")) {
+			continue
+		}
+		hosts = append(hosts, Host{
+			Name:           e.Name(),
+			Current:        e.Name() == current,
+			HardwareConfig: fileExists(filepath.Join(dir, "hardware-configuration.nix")),
+			Overrides:      fileExists(filepath.Join(dir, "base-config.json")),
+		})
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+	return hosts, nil
+}
+
+// CurrentHost returns the host this machine builds: the one named after its
+// hostname if the flake has it, and config.DefaultHost otherwise.
+func CurrentHost() (string, error) {
+	return currentHost(config.GetFlakePath())
+}
+
+func currentHost(flakePath string) (string, error) {
+	name, err := hostname()
+	if err != nil {
+		return "", fmt.Errorf("could not get hostname: %w", err)
+	}
+	if name != commonHostDir && fileExists(filepath.Join(flakePath, "hosts", name, "default.nix")) {
+		return name, nil
+	}
+	return config.DefaultHost, nil
+}
+
+// resolveHost returns host, or the current host if it is empty, after
+// checking that the flake at flakePath defines it.
+func resolveHost(flakePath, host string) (string, error) {
+	if host == "" {
+		return currentHost(flakePath)
+	}
+	if host == commonHostDir || !fileExists(filepath.Join(flakePath, "hosts", host, "default.nix")) {
+		return "", fmt.Errorf("host '%s' not found", host)
+	}
+	return host, nil
+}
+
+// AddHost creates hosts/<name> from this machine: its hardware configuration
+// is copied from /etc/nixos and a default.nix imports it next to the modules
+// shared by every host. An empty name uses the machine's hostname.
+func AddHost(name string) error {
+	if name == "" {
+		var err error
+		if name, err = hostname(); err != nil {
+			return fmt.Errorf("could not get hostname: %w", err)
+		}
+	}
+	if !hostNameRe.MatchString(name) || name == commonHostDir {
+		return fmt.Errorf("invalid host name '%s'", name)
+	}
+	dir := config.GetHostPath(name)
+	if fileExists(dir) {
+		return fmt.Errorf("host '%s' already exists", name)
+	}
+	hardware := filepath.Join("/etc/nixos", "hardware-configuration.nix")
+	if !fileExists(hardware) {
+		return fmt.Errorf("no hardware configuration found at %s; run this on the host itself", hardware)
+	}
+
+	if err := copyHostConfigs(dir); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "default.nix"), []byte(hostTemplate(stateVersion())), 0644); err != nil {
+		return err
+	}
+	return commitChanges(fmt.Sprintf("pilo: add host %s", name))
+}
+
+// RemoveHost deletes hosts/<name>. The default host cannot be removed.
+func RemoveHost(name string) error {
+	if name == config.DefaultHost {
+		return fmt.Errorf("the default host '%s' cannot be removed", name)
+	}
+	if _, err := resolveHost(config.GetFlakePath(), name); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(config.GetHostPath(name)); err != nil {
+		return err
+	}
+	return commitChanges(fmt.Sprintf("pilo: remove host %s", name))
+}
+
+// copyHostConfigs copies the host configuration files from /etc/nixos to dir.
+func copyHostConfigs(dir string) error {
+	for _, config := range hostConfigFiles {
+		source := filepath.Join("/etc/nixos", config)
+		dest := filepath.Join(dir, config)
+
+		if _, err := os.Stat(source); err == nil {
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return fmt.Errorf("failed to create destination directory for %s: %w", config, err)
+			}
+			data, err := os.ReadFile(source)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", config, err)
+			}
+			if err := os.WriteFile(dest, data, 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", config, err)
+			}
+		}
+	}
+	return nil
+}
+
+var stateVersionRe = regexp.MustCompile(`system\.stateVersion\s*=\s*"([^"]+)"`)
+
+// stateVersion returns the system.stateVersion of this machine's
+// /etc/nixos/configuration.nix, falling back to the flake's release.
+func stateVersion() string {
+	data, err := os.ReadFile("/etc/nixos/configuration.nix")
+	if err == nil {
+		if m := stateVersionRe.FindSubmatch(data); m != nil {
+			return string(m[1])
+		}
+	}
+	return "25.05"
+}
+
+func hostTemplate(stateVersion string) string {
+	return fmt.Sprintf(`{ ... }:
+
+{
+  imports = [
+    ../common
+    ./hardware-configuration.nix
+  ];
+
+  config = {
+    # The NixOS release this host was first installed with. Leave it as it
+    # is; see "man configuration.nix" before changing it.
+    system.stateVersion = %q;
+  };
+}
+`, stateVersion)
+}
+
+// hostOverrides reads hosts/<host>/base-config.json, which overrides the
+// settings of the top-level base-config.json for that host. A host without
+// one has no overrides.
+func hostOverrides(host string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(filepath.Join(config.GetHostPath(host), "base-config.json"))
+	if os.IsNotExist(err) {
+		return map[string]interface{}{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var overrides map[string]interface{}
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("could not parse the base config of host %s: %w", host, err)
+	}
+	if overrides == nil {
+		overrides = map[string]interface{}{}
+	}
+	return overrides, nil
+}
+
+func writeHostOverrides(host string, overrides map[string]interface{}) error {
+	data, err := json.MarshalIndent(overrides, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(config.GetHostPath(host), "base-config.json"), append(data, '\n'), 0644)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
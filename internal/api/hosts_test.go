@@ -0,0 +1,72 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"pilo/internal/config"
+	"pilo/internal/search"
+	"testing"
+)
+
+func TestHosts(t *testing.T) {
+	setupPackageRepo(t, `{"commit_triggers": []}`, search.Entry{AttrPath: "vlc", Pname: "vlc"})
+	for _, host := range []string{"common", "nixos", "laptop"} {
+		dir := config.GetHostPath(host)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "default.nix"), []byte("{ ... }: { }\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := "laptop"
+	hostname = func() (string, error) { return name, nil }
+	t.Cleanup(func() { hostname = os.Hostname })
+
+	hosts, err := ListHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 || hosts[0].Name != "laptop" || !hosts[0].Current || hosts[1].Name != "nixos" {
+		t.Fatalf("hosts = %+v, want the current laptop and nixos", hosts)
+	}
+	name = "desktop"
+	if host, err := CurrentHost(); err != nil || host != config.DefaultHost {
+		t.Fatalf("CurrentHost() = %q, %v, want the default host for an unknown hostname", host, err)
+	}
+	if _, err := resolveHost(config.GetFlakePath(), "common"); err == nil {
+		t.Fatal("expected an error when building the shared modules as a host")
+	}
+
+	// The laptop selects its own package groups.
+	if _, err := AddPackages([]string{"vlc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddPackageGroup("media", "", []string{"vlc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPackageGroupEnabled("media", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetHostPackageGroups("laptop", []string{"media"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	name = "laptop"
+	if groups, err := ListPackageGroups(); err != nil || !groups[0].Used {
+		t.Fatalf("groups = %+v, %v, want media used on the laptop", groups, err)
+	}
+	name = "nixos"
+	if groups, err := ListPackageGroups(); err != nil || groups[0].Used {
+		t.Fatalf("groups = %+v, %v, want media unused elsewhere", groups, err)
+	}
+
+	if err := RemoveHost(config.DefaultHost); err == nil {
+		t.Fatal("expected an error when removing the default host")
+	}
+	if err := RemoveHost("laptop"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(config.GetHostPath("laptop")); !os.IsNotExist(err) {
+		t.Fatalf("laptop host still exists: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"pilo/internal/config"
+	"pilo/internal/nix"
+	"pilo/internal/vcs"
+	"strings"
+	"time"
+)
+
+// piloTrailer ends the message of every commit pilo makes, so that the
+// history tells them from commits made by hand.
+const piloTrailer = "Committed-with: pilo"
+
+// commitAuthor returns who the commits pilo makes in repo are authored by:
+// the user of the git configuration, or else the entry of users.json for the
+// current user, or else pilo itself.
+func commitAuthor(repo vcs.Repository) vcs.Signature {
+	now := time.Now()
+	id, err := repo.Identity()
+	if err == nil {
+		id.When = now
+		return id
+	}
+	if err != vcs.ErrNoIdentity {
+		config.AddLogEntry(fmt.Sprintf("could not read the git configuration: %v", err))
+	}
+
+	if current, err := user.Current(); err == nil {
+		// users.json may not exist yet, as when the repository is created.
+		users, _ := config.ReadUsersConfig()
+		for _, u := range users {
+			if u.Username != current.Username || u.Email == "" {
+				continue
+			}
+			name := u.Name
+			if name == "" {
+				name = u.Username
+			}
+			return vcs.Signature{Name: name, Email: u.Email, When: now}
+		}
+	}
+	return vcs.Signature{Name: "pilo", Email: "pilo@localhost", When: now}
+}
+
+// withTrailer appends piloTrailer to message, unless it already ends with it.
+func withTrailer(message string) string {
+	message = strings.TrimRight(message, "\n")
+	if strings.HasSuffix(message, "\n"+piloTrailer) {
+		return message + "\n"
+	}
+	return message + "\n\n" + piloTrailer + "\n"
+}
+
+// commitSigner returns the Signer chosen by the signing settings of
+// base-config.json, or nil if commits are left unsigned. SSH keys are used
+// through ssh-keygen and GPG keys through gpg, as git does, so that their
+// agents can supply passphrases.
+func commitSigner() (vcs.Signer, error) {
+	signing, err := config.GetSigning()
+	if os.IsNotExist(err) {
+		return nil, nil // Nothing is configured yet
+	} else if err != nil {
+		return nil, err
+	}
+	switch signing.Format {
+	case "":
+		return nil, nil
+	case "ssh":
+		key := signing.Key
+		if key == "" {
+			key = config.GetSshKeyPath()
+		}
+		if key == "" {
+			return nil, errors.New("commits are signed with SSH, but neither signing.key nor the sshKeyPath setting names a key")
+		}
+		if strings.HasPrefix(key, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get user home directory: %w", err)
+			}
+			key = filepath.Join(home, key[2:])
+		}
+		return &commandSigner{name: "ssh-keygen", args: []string{"-Y", "sign", "-n", "git", "-f", key}}, nil
+	case "gpg":
+		args := []string{"--detach-sign", "--armor"}
+		if signing.Key != "" {
+			args = append(args, "--local-user", signing.Key)
+		}
+		return &commandSigner{name: "gpg", args: args}, nil
+	default:
+		return nil, fmt.Errorf("unknown signing format %q: use ssh or gpg", signing.Format)
+	}
+}
+
+// commandSigner signs with a command that reads the message on stdin and
+// writes the armored signature to stdout.
+type commandSigner struct {
+	name string
+	args []string
+}
+
+func (s *commandSigner) Sign(message io.Reader) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	err := nix.GetExecutor().Run(context.Background(), nix.Command{
+		Name:   s.name,
+		Args:   s.args,
+		Stdin:  message,
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("failed to sign the commit: %s", msg)
+		}
+		return nil, fmt.Errorf("failed to sign the commit: %w", err)
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("failed to sign the commit: %s printed no signature", s.name)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"pilo/internal/config"
+	"pilo/internal/nix"
+	"pilo/internal/vcs"
+	"strings"
+	"testing"
+)
+
+func TestCommitIdentity(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		name       string
+		signing    string
+		gitUser    [2]string
+		users      string
+		wantAuthor string
+		wantSigner string
+	}{
+		{
+			name:       "git config",
+			gitUser:    [2]string{"Ada Lovelace", "ada@example.com"},
+			users:      `{"users": [{"username": "` + current.Username + `", "email": "other@example.com"}]}`,
+			wantAuthor: "Ada Lovelace <ada@example.com>",
+		},
+		{
+			name:       "users.json",
+			users:      `{"users": [{"username": "someone"}, {"username": "` + current.Username + `", "email": "me@example.com", "name": "Me"}]}`,
+			wantAuthor: "Me <me@example.com>",
+		},
+		{
+			name:       "pilo",
+			users:      `{"users": []}`,
+			wantAuthor: "pilo <pilo@localhost>",
+		},
+		{
+			name:       "ssh signing",
+			signing:    `"signing": {"format": "ssh", "key": "~/.ssh/id_ed25519"}, `,
+			users:      `{"users": []}`,
+			wantAuthor: "pilo <pilo@localhost>",
+			wantSigner: "ssh-keygen -Y sign -n git -f {home}/.ssh/id_ed25519",
+		},
+		{
+			name:       "gpg signing",
+			signing:    `"signing": {"format": "gpg", "key": "ABCD1234"}, `,
+			users:      `{"users": []}`,
+			wantAuthor: "pilo <pilo@localhost>",
+			wantSigner: "gpg --detach-sign --armor --local-user ABCD1234",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := setupFakeEnv(t, `{`+tt.signing+`"commit_triggers": []}`)
+			fake.On("ssh-keygen", nix.FakeResult{Stdout: "-----BEGIN SSH SIGNATURE-----\n"})
+			fake.On("gpg", nix.FakeResult{Stdout: "-----BEGIN PGP SIGNATURE-----\n"})
+			if err := os.WriteFile(filepath.Join(config.GetFlakePath(), "users.json"), []byte(tt.users), 0644); err != nil {
+				t.Fatal(err)
+			}
+			repos := vcs.NewMemoryBackend()
+			vcs.SetBackend(repos)
+			t.Cleanup(func() { vcs.SetBackend(nil) })
+			repo, err := vcs.Init(config.GetInstallPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo.(*vcs.Memory).SetIdentity(tt.gitUser[0], tt.gitUser[1])
+			repo.(*vcs.Memory).WriteFile("flake/packages.json", []byte(`{"packages": ["git"]}`))
+
+			if err := commitChanges("pilo: add git"); err != nil {
+				t.Fatal(err)
+			}
+			commit := repos.Repository(config.GetInstallPath()).Log()[0]
+			if got := commit.Author.String(); got != tt.wantAuthor {
+				t.Errorf("author = %q, want %q", got, tt.wantAuthor)
+			}
+			if want := "pilo: add git\n\n" + piloTrailer + "\n"; commit.Message != want {
+				t.Errorf("message = %q, want %q", commit.Message, want)
+			}
+
+			calls := fake.Calls()
+			if tt.wantSigner == "" {
+				if len(calls) != 0 || commit.Signature != nil {
+					t.Fatalf("signed with %v, want unsigned", calls)
+				}
+				return
+			}
+			home, _ := os.UserHomeDir()
+			want := strings.ReplaceAll(tt.wantSigner, "{home}", home)
+			if len(calls) != 1 || calls[0].Line != want {
+				t.Fatalf("commands = %v, want %q", calls, want)
+			}
+			if !strings.Contains(calls[0].Stdin, "pilo: add git") {
+				t.Errorf("signed %q, want the commit", calls[0].Stdin)
+			}
+			if !strings.HasPrefix(string(commit.Signature), "-----BEGIN") {
+				t.Errorf("signature = %q", commit.Signature)
+			}
+		})
+	}
+}
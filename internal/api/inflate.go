@@ -12,7 +12,6 @@ import (
 
 	"github.com/go-git/go-git/v5"
 	gitconfig "github.com/go-git/go-git/v5/config"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 )
 
 var FlakeFS embed.FS
@@ -21,13 +20,6 @@ func SetFlakeFS(flakeFS embed.FS) {
 	FlakeFS = flakeFS
 }
 
-func must(s string, e error) string {
-	if e != nil {
-		panic(e)
-	}
-	return s
-}
-
 func Inflate(targetPath string, remoteURL string, cleanTargetPath bool) error {
 	// For internal installs, commit any pre-install changes and clean the directory
 	if remoteURL == "" {
@@ -41,7 +33,7 @@ func Inflate(targetPath string, remoteURL string, cleanTargetPath bool) error {
 
 	fmt.Println("Before cleanDir")
 	if cleanTargetPath || remoteURL == "" {
-		ignoreList := []string{".backups", ".git", ".gitignore"}
+		ignoreList := []string{".backups", ".pilo", ".git", ".gitignore", "settings.toml"}
 		if err := cleanDir(targetPath, ignoreList); err != nil {
 			return err
 		}
@@ -50,30 +42,9 @@ func Inflate(targetPath string, remoteURL string, cleanTargetPath bool) error {
 
 	// Check if we should install from remote
 	if remoteURL != "" {
-		var publicKeys *ssh.PublicKeys
-		var err error
-		sshKeyPath := config.GetSshKeyPath()
-		if sshKeyPath != "" {
-			publicKeys, err = ssh.NewPublicKeysFromFile("git", sshKeyPath, "")
-			if err != nil {
-				return fmt.Errorf("failed to load SSH key from %s: %w", sshKeyPath, err)
-			}
-		} else {
-			// Find an available SSH key
-			homeDir := must(os.UserHomeDir())
-			keyTypes := []string{"id_ed25519", "id_ecdsa", "id_rsa"}
-			for _, keyType := range keyTypes {
-				path := filepath.Join(homeDir, ".ssh", keyType)
-				if _, errStat := os.Stat(path); errStat == nil {
-					publicKeys, err = ssh.NewPublicKeysFromFile("git", path, "")
-					if err == nil {
-						break // Key found and loaded
-					}
-				}
-			}
-			if publicKeys == nil {
-				return fmt.Errorf("no usable SSH key found in ~/.ssh")
-			}
+		auth, err := getGitAuth(remoteURL)
+		if err != nil {
+			return err
 		}
 
 		repo, err := git.PlainOpen(targetPath)
@@ -101,7 +72,7 @@ func Inflate(targetPath string, remoteURL string, cleanTargetPath bool) error {
 			if err := repo.Fetch(&git.FetchOptions{
 				RemoteName: "new-origin",
 				Progress:   os.Stdout,
-				Auth:       publicKeys,
+				Auth:       auth,
 			}); err != nil && err.Error() != "already up-to-date" {
 				return err
 			}
@@ -130,7 +101,7 @@ func Inflate(targetPath string, remoteURL string, cleanTargetPath bool) error {
 			if _, err := git.PlainClone(targetPath, false, &git.CloneOptions{
 				URL:      remoteURL,
 				Progress: os.Stdout,
-				Auth:     publicKeys,
+				Auth:     auth,
 			}); err != nil {
 				return err
 			}
@@ -139,7 +110,7 @@ func Inflate(targetPath string, remoteURL string, cleanTargetPath bool) error {
 			if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
 				return err
 			}
-			if err := os.WriteFile(configPath, []byte("{\n  \"commit_triggers\": [],\n  \"remote_url\": \"\",\n  \"push_on_commit\": false\n}\n"), 0644); err != nil {
+			if err := os.WriteFile(configPath, []byte(fmt.Sprintf("{\n  \"schema_version\": %d,\n  \"commit_triggers\": [],\n  \"remote_url\": \"\",\n  \"push_on_commit\": false\n}\n", config.SchemaVersion)), 0644); err != nil {
 				return err
 			}
 		} else {
@@ -187,39 +158,44 @@ func Inflate(targetPath string, remoteURL string, cleanTargetPath bool) error {
 	return GitCommit(targetPath, "pilo: post-install changes")
 }
 
+// gitignoreEntries are the machine-local files kept out of the repository.
+// settings.toml is there when the repository is in the default install path.
+var gitignoreEntries = []string{"/.backups/", "/.pilo/", "/settings.toml"}
+
 // CreateGitignore creates or updates a .gitignore file at the specified path.
 func CreateGitignore(path string) error {
 	gitignorePath := filepath.Join(path, ".gitignore")
-	backupsEntry := "/.backups/"
 
 	// Check if the file exists
-	if _, err := os.Stat(gitignorePath); os.IsNotExist(err) {
-		// File does not exist, create it with the backups entry
-		return os.WriteFile(gitignorePath, []byte(backupsEntry+"\n"), 0644)
-	} else if err != nil {
-		// Another error occurred
-		return fmt.Errorf("error checking .gitignore: %w", err)
-	}
-
-	// File exists, read it and check if the backups entry is already there
 	content, err := os.ReadFile(gitignorePath)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("error reading .gitignore: %w", err)
 	}
 
-	if !strings.Contains(string(content), backupsEntry) {
-		// Entry does not exist, append it
-		f, err := os.OpenFile(gitignorePath, os.O_APPEND|os.O_WRONLY, 0644)
-		if err != nil {
-			return fmt.Errorf("error opening .gitignore for append: %w", err)
+	var missing []string
+	for _, entry := range gitignoreEntries {
+		if !strings.Contains(string(content), entry) {
+			missing = append(missing, entry)
 		}
-		defer f.Close()
+	}
+	if len(missing) == 0 {
+		return nil
+	}
 
-		if _, err := f.WriteString("\n" + backupsEntry + "\n"); err != nil {
-			return fmt.Errorf("error appending to .gitignore: %w", err)
-		}
+	// Append the entries that are not there yet
+	f, err := os.OpenFile(gitignorePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening .gitignore for append: %w", err)
 	}
+	defer f.Close()
 
+	addition := strings.Join(missing, "\n") + "\n"
+	if len(content) > 0 {
+		addition = "\n" + addition
+	}
+	if _, err := f.WriteString(addition); err != nil {
+		return fmt.Errorf("error appending to .gitignore: %w", err)
+	}
 	return nil
 }
 
@@ -47,7 +47,7 @@ func InstallPilo(path string, registry string, remoteURL string) error {
 
 	configPath := filepath.Join(flakePath, "base-config.json")
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		defaultConfig := []byte("{\n  \"commit_triggers\": [],\n  \"remote_url\": \"\",\n  \"push_on_commit\": false\n}\n")
+		defaultConfig := []byte(fmt.Sprintf("{\n  \"schema_version\": %d,\n  \"commit_triggers\": [],\n  \"remote_url\": \"\",\n  \"push_on_commit\": false\n}\n", config.SchemaVersion))
 		if err := os.WriteFile(configPath, defaultConfig, 0644); err != nil {
 			return fmt.Errorf("error creating default base-config.json: %w", err)
 		}
@@ -1,64 +1,394 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"pilo/internal/config"
 	"pilo/internal/nix"
+	"pilo/internal/search"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+// GetInstalledPackages returns the packages in packages.json, with their
+// metadata filled in from the search index if one has been built.
 func GetInstalledPackages() ([]config.Package, error) {
-	return config.ReadPackagesConfig()
+	packages, err := config.ReadPackagesConfig()
+	if err != nil {
+		return nil, err
+	}
+	if idx, err := search.Cached(config.GetFlakePath()); err == nil {
+		for i, pkg := range packages {
+			if entry, ok := idx.Lookup(pkg.Name); ok {
+				packages[i].Description = entry.Description
+				packages[i].Meta = packageMeta(entry)
+			}
+		}
+	}
+	return packages, nil
+}
+
+// packageMeta converts a search index entry into package metadata.
+func packageMeta(e search.Entry) *config.PackageMeta {
+	return &config.PackageMeta{
+		AttrPath:    e.AttrPath,
+		Pname:       e.Pname,
+		Version:     e.Version,
+		License:     e.License,
+		Homepage:    e.Homepage,
+		MainProgram: e.MainProgram,
+		Platforms:   e.Platforms,
+		Unfree:      e.Unfree,
+		Broken:      e.Broken,
+		Insecure:    e.Insecure,
+	}
 }
 
 func AddPackage(packageName string) error {
+	warnings, err := AddPackageFromSource(packageName, "")
+	for _, w := range warnings {
+		config.AddLogEntry("Warning: " + w)
+	}
+	return err
+}
+
+// AddPackageFromSource adds a package taken from source, which is one of
+// PackageSources, to packages.json.
+func AddPackageFromSource(packageName, source string) ([]string, error) {
+	return AddPackagesFromSource([]string{packageName}, source)
+}
+
+// AddPackages adds packages from stable nixpkgs in a single commit.
+func AddPackages(packageNames []string) ([]string, error) {
+	return AddPackagesFromSource(packageNames, "")
+}
+
+// AddPackagesFromSource adds packages taken from source in a single commit.
+// Every package is checked first, and nothing is written unless all of them
+// exist and none is configured yet; a missing package is reported as a
+// *PackageNotFoundError. Problems that do not stop a package from being added
+// are returned as warnings.
+func AddPackagesFromSource(packageNames []string, source string) ([]string, error) {
+	source, err := checkPackageSource(source)
+	if err != nil {
+		return nil, err
+	}
 	packages, err := config.ReadPackagesConfig()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	packageNames = uniqueNames(packageNames)
+	for _, pkg := range packages {
+		for _, name := range packageNames {
+			if pkg.Name == name {
+				return nil, fmt.Errorf("package '%s' already exists", name)
+			}
+		}
+	}
+
+	warnings, notFound := checkNewPackages(packageNames, source)
+	if len(notFound) > 0 {
+		errs := make([]error, len(notFound))
+		for i, e := range notFound {
+			errs[i] = e
+		}
+		return warnings, errors.Join(errs...)
+	}
+
+	for _, name := range packageNames {
+		packages = append(packages, config.Package{Name: name, Installed: true, Source: source})
+	}
+	if err := config.WritePackagesConfig(packages); err != nil {
+		return warnings, err
+	}
+
+	message := packagesMessage("add", packageNames)
+	if source != "" {
+		message += " from " + source
+	}
+	return warnings, commitChanges(message)
+}
+
+// ImportResult describes what ImportPackages did with each package.
+type ImportResult struct {
+	Added    []string
+	Existing []string
+	NotFound []*PackageNotFoundError
+	Warnings []string
+}
+
+// ImportPackages adds the packages that exist in stable nixpkgs and are not
+// configured yet in a single commit, and reports the rest instead of failing.
+func ImportPackages(packageNames []string) (*ImportResult, error) {
+	return importPackages(packageNames, nil)
+}
+
+// importPackages is ImportPackages with the source of each package taken from
+// sources; packages missing from it come from stable nixpkgs.
+func importPackages(packageNames []string, sources map[string]string) (*ImportResult, error) {
+	packages, err := config.ReadPackagesConfig()
+	if err != nil {
+		return nil, err
+	}
+	configured := make(map[string]bool)
 	for _, pkg := range packages {
-		if pkg.Name == packageName {
-			return fmt.Errorf("package '%s' already exists", packageName)
+		configured[pkg.Name] = true
+	}
+
+	result := &ImportResult{}
+	var candidates []string
+	bySource := make(map[string][]string)
+	var order []string
+	for _, name := range uniqueNames(packageNames) {
+		if configured[name] {
+			result.Existing = append(result.Existing, name)
+			continue
+		}
+		candidates = append(candidates, name)
+		source := sources[name]
+		if _, ok := bySource[source]; !ok {
+			order = append(order, source)
+		}
+		bySource[source] = append(bySource[source], name)
+	}
+
+	for _, source := range order {
+		warnings, notFound := checkNewPackages(bySource[source], source)
+		result.Warnings = append(result.Warnings, warnings...)
+		result.NotFound = append(result.NotFound, notFound...)
+	}
+	missing := make(map[string]bool)
+	for _, e := range result.NotFound {
+		missing[e.Name] = true
+	}
+	for _, name := range candidates {
+		if !missing[name] {
+			result.Added = append(result.Added, name)
+			packages = append(packages, config.Package{Name: name, Installed: true, Source: sources[name]})
+		}
+	}
+	if len(result.Added) == 0 {
+		return result, nil
+	}
+
+	if err := config.WritePackagesConfig(packages); err != nil {
+		return result, err
+	}
+	return result, commitChanges(packagesMessage("import", result.Added))
+}
+
+// checkNewPackages checks packages about to be added from source. Packages
+// that cannot be checked are let through with a warning.
+func checkNewPackages(packageNames []string, source string) ([]string, []*PackageNotFoundError) {
+	var warnings []string
+	var notFound []*PackageNotFoundError
+	checker, err := newPackageChecker()
+	for _, name := range packageNames {
+		if strings.HasPrefix(name, "github:") {
+			continue
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: it could not be checked: %v", name, err))
+			continue
 		}
+		check, err := checker.check(context.Background(), name, source)
+		switch {
+		case err != nil:
+			warnings = append(warnings, fmt.Sprintf("%s: it could not be checked: %v", name, err))
+		case !check.Found:
+			notFound = append(notFound, &PackageNotFoundError{Name: name, Source: source, Suggestions: check.Suggestions})
+		default:
+			for _, w := range check.Warnings {
+				warnings = append(warnings, name+": "+w)
+			}
+		}
+	}
+	return warnings, notFound
+}
+
+// packagesMessage is the commit message for applying verb to packages.
+func packagesMessage(verb string, packageNames []string) string {
+	switch {
+	case len(packageNames) == 1:
+		return fmt.Sprintf("pilo: %s package %s", verb, packageNames[0])
+	case len(packageNames) <= 5:
+		return fmt.Sprintf("pilo: %s packages %s", verb, strings.Join(packageNames, ", "))
+	default:
+		return fmt.Sprintf("pilo: %s %d packages", verb, len(packageNames))
+	}
+}
+
+func uniqueNames(names []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// SetPackageSource changes the package set a configured package comes from.
+func SetPackageSource(packageName, source string) error {
+	source, err := checkPackageSource(source)
+	if err != nil {
+		return err
+	}
+	packages, err := config.ReadPackagesConfig()
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i := range packages {
+		if packages[i].Name == packageName {
+			found = true
+			if packages[i].Source == source {
+				return nil
+			}
+			packages[i].Source = source
+		}
+	}
+	if !found {
+		return fmt.Errorf("package '%s' not found", packageName)
+	}
+
+	if err := config.WritePackagesConfig(packages); err != nil {
+		return err
+	}
+
+	if source == "" {
+		source = config.SourceStable
+	}
+	return commitChanges(fmt.Sprintf("pilo: take package %s from %s", packageName, source))
+}
+
+// SetPackageInstalled enables or disables a configured package. Disabled
+// packages stay in packages.json but are left out of the system.
+func SetPackageInstalled(packageName string, installed bool) error {
+	packages, err := config.ReadPackagesConfig()
+	if err != nil {
+		return err
 	}
 
-	newPackage := config.Package{Name: packageName, Installed: true}
-	packages = append(packages, newPackage)
+	found := false
+	for i := range packages {
+		if packages[i].Name == packageName {
+			found = true
+			if packages[i].Installed == installed {
+				return nil
+			}
+			packages[i].Installed = installed
+		}
+	}
+	if !found {
+		return fmt.Errorf("package '%s' not found", packageName)
+	}
 
 	if err := config.WritePackagesConfig(packages); err != nil {
 		return err
 	}
 
-	return commitChanges(fmt.Sprintf("pilo: add package %s", packageName))
+	action := "disable"
+	if installed {
+		action = "enable"
+	}
+	return commitChanges(fmt.Sprintf("pilo: %s package %s", action, packageName))
+}
+
+// PackageSources lists the sources packages can come from: stable, unstable
+// and the other inputs locked in flake.lock.
+func PackageSources() ([]string, error) {
+	sources := []string{config.SourceStable, config.SourceUnstable}
+	data, err := os.ReadFile(filepath.Join(config.GetFlakePath(), "flake.lock"))
+	if os.IsNotExist(err) {
+		return sources, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read flake.lock: %w", err)
+	}
+	var lock struct {
+		Nodes map[string]struct {
+			Inputs map[string]json.RawMessage `json:"inputs"`
+		} `json:"nodes"`
+		Root string `json:"root"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("could not parse flake.lock: %w", err)
+	}
+
+	var inputs []string
+	for name := range lock.Nodes[lock.Root].Inputs {
+		// The two nixpkgs inputs are offered as stable and unstable.
+		if name != "nixpkgs" && name != "nixpkgs-unstable" {
+			inputs = append(inputs, name)
+		}
+	}
+	sort.Strings(inputs)
+	return append(sources, inputs...), nil
+}
+
+// checkPackageSource validates source and returns it as stored in
+// packages.json, where stable is left out.
+func checkPackageSource(source string) (string, error) {
+	if source == "" || source == config.SourceStable {
+		return "", nil
+	}
+	sources, err := PackageSources()
+	if err != nil {
+		return "", err
+	}
+	for _, s := range sources {
+		if s == source {
+			return source, nil
+		}
+	}
+	return "", fmt.Errorf("unknown package source '%s', expected one of: %s", source, strings.Join(sources, ", "))
 }
 
 func RemovePackage(packageName string) error {
+	return RemovePackages([]string{packageName})
+}
+
+// RemovePackages removes packages from packages.json in a single commit. No
+// package is removed unless all of them are configured.
+func RemovePackages(packageNames []string) error {
 	packages, err := config.ReadPackagesConfig()
 	if err != nil {
 		return err
 	}
 
+	remove := make(map[string]bool)
+	for _, name := range packageNames {
+		remove[name] = true
+	}
 	var newPackages []config.Package
-	found := false
 	for _, pkg := range packages {
-		if pkg.Name == packageName {
-			found = true
+		if remove[pkg.Name] {
+			delete(remove, pkg.Name)
 		} else {
 			newPackages = append(newPackages, pkg)
 		}
 	}
 
-	if !found {
-		return fmt.Errorf("package '%s' not found", packageName)
+	for _, name := range packageNames {
+		if remove[name] {
+			return fmt.Errorf("package '%s' not found", name)
+		}
 	}
 
 	if err := config.WritePackagesConfig(newPackages); err != nil {
 		return err
 	}
 
-	return commitChanges(fmt.Sprintf("pilo: remove package %s", packageName))
+	return commitChanges(packagesMessage("remove", uniqueNames(packageNames)))
 }
 
 func AddGitPackage(url string) error {
@@ -74,9 +404,80 @@ func AddGitPackage(url string) error {
 
 // Search searches for packages in nixpkgs.
 func Search(query []string, sortByPopularity bool, freeOnly bool) ([]config.Package, error) {
+	return SearchContext(context.Background(), query, sortByPopularity, freeOnly)
+}
+
+// SearchContext searches the local index of the nixpkgs pinned in flake.lock.
+// Until the index is built it falls back to `nix search`, whose results are
+// ranked and filtered the same way; see StartSearchIndexBuild.
+func SearchContext(ctx context.Context, query []string, sortByPopularity bool, freeOnly bool) ([]config.Package, error) {
+	opts := search.Options{FreeOnly: freeOnly, SortByPopularity: sortByPopularity}
+	idx, err := search.Cached(config.GetFlakePath())
+	if err != nil {
+		config.AddLogEntry("Search index not ready, using nix search: " + err.Error())
+		if idx, err = nixSearch(ctx, query, freeOnly); err != nil {
+			return nil, err
+		}
+	}
+
+	results := idx.Search(query, opts)
+	packages := make([]config.Package, len(results))
+	for i, result := range results {
+		packages[i] = config.Package{
+			Name:        result.AttrPath,
+			Description: result.Description,
+			Meta:        packageMeta(result.Entry),
+		}
+	}
+	return packages, nil
+}
+
+// RefreshSearchIndex rebuilds the search index for the pinned nixpkgs.
+func RefreshSearchIndex(ctx context.Context) error {
+	_, err := search.Refresh(ctx, config.GetFlakePath())
+	return err
+}
+
+// SearchIndexReady reports whether searches are served from the index.
+func SearchIndexReady() bool {
+	_, err := search.Cached(config.GetFlakePath())
+	return err == nil
+}
+
+// StartSearchIndexBuild builds the search index in a detached
+// "pilo search --refresh-index", so that the build, which takes minutes,
+// outlives the command that started it. Nothing is started if the index is
+// ready or already being built. It reports whether the index is being built.
+func StartSearchIndexBuild() (bool, error) {
+	flakePath := config.GetFlakePath()
+	if SearchIndexReady() {
+		return false, nil
+	}
+	if search.Building(flakePath) {
+		return true, nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return false, fmt.Errorf("could not find the pilo executable: %w", err)
+	}
+	err = nix.GetExecutor().Run(context.Background(), nix.Command{
+		Name:   exe,
+		Args:   []string{"search", "--refresh-index"},
+		Detach: true,
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not start building the search index: %w", err)
+	}
+	return true, nil
+}
+
+// nixSearch searches nixpkgs with `nix search` and returns the packages it
+// finds as an index. `nix search` does not report licenses, so when withLicense
+// is set the packages found are evaluated to tell which are unfree.
+func nixSearch(ctx context.Context, query []string, withLicense bool) (*search.Index, error) {
 	searchArgs := []string{"search", "nixpkgs", "--json"}
 	searchArgs = append(searchArgs, query...)
-	out, err := nix.RunCommand("nix", searchArgs...)
+	out, err := nix.RunCommandStdout(ctx, nil, "nix", searchArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("error searching for packages: %w", err)
 	}
@@ -90,21 +491,67 @@ func Search(query []string, sortByPopularity bool, freeOnly bool) ([]config.Pack
 
 	var results map[string]struct {
 		Pname       string `json:"pname"`
+		Version     string `json:"version"`
 		Description string `json:"description"`
 	}
 	if err := json.Unmarshal([]byte(jsonOut), &results); err != nil {
 		return nil, fmt.Errorf("error unmarshaling search results: %w", err)
 	}
 
-	var packages []config.Package
-	for _, result := range results {
-		packages = append(packages, config.Package{
-			Name:        result.Pname,
+	idx := &search.Index{}
+	for attr, result := range results {
+		// Results are keyed by "legacyPackages.<system>.<attr path>".
+		if parts := strings.SplitN(attr, ".", 3); len(parts) == 3 {
+			attr = parts[2]
+		}
+		idx.Entries = append(idx.Entries, search.Entry{
+			AttrPath:    attr,
+			Pname:       result.Pname,
+			Version:     result.Version,
 			Description: result.Description,
 		})
 	}
+	sort.Slice(idx.Entries, func(i, j int) bool { return idx.Entries[i].AttrPath < idx.Entries[j].AttrPath })
 
-	return packages, nil
+	if withLicense && len(idx.Entries) > 0 {
+		unfree, err := unfreePackages(ctx, idx.Entries)
+		if err != nil {
+			return nil, err
+		}
+		for i := range idx.Entries {
+			idx.Entries[i].Unfree = unfree[idx.Entries[i].AttrPath]
+		}
+	}
+	return idx, nil
+}
+
+// unfreePackages returns the attribute paths of the entries that the
+// nixpkgs of `nix search` marks as unfree.
+func unfreePackages(ctx context.Context, entries []search.Entry) (map[string]bool, error) {
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = strconv.Quote(e.AttrPath)
+	}
+	expr := fmt.Sprintf(`let
+  nixpkgs = builtins.getFlake "nixpkgs";
+  pkgs = nixpkgs.legacyPackages.${builtins.currentSystem};
+  lib = nixpkgs.lib;
+in
+builtins.filter (name: (lib.attrByPath (lib.splitString "." name) {} pkgs).meta.unfree or false) [ %s ]`, strings.Join(names, " "))
+
+	out, err := nix.RunCommandStdout(ctx, nil, "nix", "eval", "--impure", "--json", "--expr", expr)
+	if err != nil {
+		return nil, fmt.Errorf("could not check the licenses of the packages found: %w", err)
+	}
+	var list []string
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		return nil, fmt.Errorf("could not check the licenses of the packages found: %w", err)
+	}
+	unfree := make(map[string]bool)
+	for _, name := range list {
+		unfree[name] = true
+	}
+	return unfree, nil
 }
 
 func TempInstallPackage(packageName string) error {
@@ -153,6 +600,12 @@ func List() (string, error) {
 	return output, nil
 }
 
+// ProfileListJSON returns the output of `nix profile list --json`.
+func ProfileListJSON(ctx context.Context) ([]byte, error) {
+	out, err := nix.RunCommandStdout(ctx, nil, "nix", "profile", "list", "--json")
+	return []byte(out), err
+}
+
 // Shell enters a temporary shell with the specified packages.
 func Shell(packages []string) error {
 	fmt.Println("Entering a temporary shell...")
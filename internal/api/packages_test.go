@@ -0,0 +1,483 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"pilo/internal/config"
+	"pilo/internal/nix"
+	"pilo/internal/search"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackageSource(t *testing.T) {
+	setupFakeEnv(t, `{"commit_triggers": []}`)
+	if err := GitInit(config.GetInstallPath()); err != nil {
+		t.Fatal(err)
+	}
+	// Initialising the repository clears the files setupFakeEnv wrote.
+	writeFlakeFiles(t, `{"commit_triggers": []}`)
+	lock := `{"nodes": {"root": {"inputs": {"nixpkgs": "nixpkgs", "nixpkgs-unstable": "nixpkgs-unstable", "neovim-nightly": "neovim-nightly"}}}, "root": "root"}`
+	if err := os.WriteFile(filepath.Join(config.GetFlakePath(), "flake.lock"), []byte(lock), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := PackageSources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"stable", "unstable", "neovim-nightly"}; !reflect.DeepEqual(sources, want) {
+		t.Fatalf("sources = %v, want %v", sources, want)
+	}
+
+	if _, err := AddPackageFromSource("gopls", config.SourceUnstable); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := AddPackageFromSource("ripgrep", "nowhere"); err == nil {
+		t.Fatal("expected an error for an unknown source")
+	}
+	if err := SetPackageSource("gopls", config.SourceStable); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := SetPackageSource("gopls", "neovim-nightly"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	packages, err := config.ReadPackagesConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []config.Package{{Name: "gopls", Installed: true, Source: "neovim-nightly"}}
+	if !reflect.DeepEqual(packages, want) {
+		t.Fatalf("packages = %+v, want %+v", packages, want)
+	}
+}
+
+func TestSetPackageInstalled(t *testing.T) {
+	setupFakeEnv(t, `{"commit_triggers": []}`)
+	if err := GitInit(config.GetInstallPath()); err != nil {
+		t.Fatal(err)
+	}
+	writeFlakeFiles(t, `{"commit_triggers": []}`)
+	if err := AddPackage("blender"); err != nil {
+		t.Fatal(err)
+	}
+
+	head := func() string {
+		h, err := GitHead(config.GetInstallPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	before := head()
+	if err := SetPackageInstalled("blender", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	disabled := head()
+	if disabled == before {
+		t.Fatal("disabling a package did not commit")
+	}
+	if err := SetPackageInstalled("blender", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if head() != disabled {
+		t.Fatal("disabling a disabled package committed")
+	}
+
+	packages, err := config.ReadPackagesConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 1 || packages[0].Installed {
+		t.Fatalf("packages = %+v, want blender disabled", packages)
+	}
+	if err := SetPackageInstalled("missing", true); err == nil {
+		t.Fatal("expected an error for a missing package")
+	}
+}
+
+func TestPackageGroups(t *testing.T) {
+	setupPackageRepo(t, `{"commit_triggers": []}`,
+		search.Entry{AttrPath: "go", Pname: "go"},
+		search.Entry{AttrPath: "gopls", Pname: "gopls"},
+		search.Entry{AttrPath: "vlc", Pname: "vlc"},
+	)
+	if _, err := AddPackages([]string{"go", "gopls", "vlc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AddPackageGroup("dev-go", "Go development", []string{"go", "gopls"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := AddPackageGroup("media", "", []string{"vlc"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := AddPackageGroup("bad name", "", nil); err == nil {
+		t.Fatal("expected an error for an invalid group name")
+	}
+	if err := SetPackageGroupEnabled("media", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	groups, err := ListPackageGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || !groups[0].Used || groups[1].Used || !reflect.DeepEqual(groups[0].Packages, []string{"go", "gopls"}) {
+		t.Fatalf("groups = %+v, want dev-go used and media unused", groups)
+	}
+
+	// Selecting groups for the host overrides their enabled flags.
+	if err := SetHostPackageGroups("", []string{"media"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if groups, _ = ListPackageGroups(); groups[0].Used || !groups[1].Used {
+		t.Fatalf("groups = %+v, want only media used", groups)
+	}
+
+	// Package changes keep the groups.
+	if err := SetPackageInstalled("go", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemovePackageGroup("media", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	system, err := config.GetSystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(system.Groups) != 0 {
+		t.Errorf("host groups = %v, want none after removing media", system.Groups)
+	}
+	packages, err := config.ReadPackagesConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pkg := range packages {
+		want := "dev-go"
+		if pkg.Name == "vlc" {
+			want = ""
+		}
+		if pkg.Group != want {
+			t.Errorf("%s is in group %q, want %q", pkg.Name, pkg.Group, want)
+		}
+	}
+}
+
+// setupPackageRepo prepares a git-tracked flake whose nixpkgs search index
+// holds entries. Packages missing from the index are evaluated, and found
+// not to exist.
+func setupPackageRepo(t *testing.T, baseConfig string, entries ...search.Entry) *nix.FakeExecutor {
+	t.Helper()
+	fake := setupFakeEnv(t, baseConfig)
+	if err := GitInit(config.GetInstallPath()); err != nil {
+		t.Fatal(err)
+	}
+	writeFlakeFiles(t, baseConfig)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	revision := t.Name()
+	lock := `{"nodes": {"nixpkgs": {"locked": {"rev": "` + revision + `"}}, "root": {"inputs": {"nixpkgs": "nixpkgs"}}}, "root": "root"}`
+	if err := os.WriteFile(filepath.Join(config.GetFlakePath(), "flake.lock"), []byte(lock), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx := &search.Index{Revision: revision, Entries: entries}
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+	fake.On("nix eval", nix.FakeResult{Stdout: "null"})
+	return fake
+}
+
+func TestAddPackageChecksPackage(t *testing.T) {
+	setupPackageRepo(t, `{"system": {"type": "aarch64-darwin"}, "commit_triggers": []}`,
+		search.Entry{AttrPath: "blender", Pname: "blender", Platforms: []string{"x86_64-linux"}},
+		search.Entry{AttrPath: "ripgrep", Pname: "ripgrep", Platforms: []string{"x86_64-linux", "aarch64-darwin"}},
+	)
+
+	_, err := AddPackageFromSource("ripgre", "")
+	var notFound *PackageNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("expected a PackageNotFoundError, got %v", err)
+	}
+	if len(notFound.Suggestions) == 0 || notFound.Suggestions[0] != "ripgrep" {
+		t.Errorf("suggestions = %v, want ripgrep first", notFound.Suggestions)
+	}
+
+	warnings, err := AddPackageFromSource("blender", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"blender: it is not supported on aarch64-darwin"}; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %v, want %v", warnings, want)
+	}
+
+	// Packages the index lacks, such as nested sets, and packages from other
+	// sources are checked by evaluating them.
+	fake := nix.NewFakeExecutor()
+	fake.On("nix eval", nix.FakeResult{Stdout: `{"pname": "requests", "version": "2.32.3", "broken": true}`})
+	SetExecutor(fake)
+	warnings, err = AddPackageFromSource("python3Packages.requests", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"python3Packages.requests: it is marked as broken"}; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %v, want %v", warnings, want)
+	}
+
+	SetExecutor(nix.NewFakeExecutor().On("nix eval", nix.FakeResult{Stdout: `{"pname": "gopls", "version": "0.16.0", "unfree": true}`}))
+	warnings, err = AddPackageFromSource("gopls", config.SourceUnstable)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"gopls: it is unfree"}; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %v, want %v", warnings, want)
+	}
+}
+
+func TestBulkPackages(t *testing.T) {
+	setupPackageRepo(t, `{"commit_triggers": []}`,
+		search.Entry{AttrPath: "fd", Pname: "fd"},
+		search.Entry{AttrPath: "git", Pname: "git"},
+		search.Entry{AttrPath: "ripgrep", Pname: "ripgrep"},
+	)
+	head := func() string {
+		h, err := GitHead(config.GetInstallPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	names := func() []string {
+		packages, err := config.ReadPackagesConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, pkg := range packages {
+			out = append(out, pkg.Name)
+		}
+		return out
+	}
+
+	if _, err := AddPackages([]string{"git"}); err != nil {
+		t.Fatal(err)
+	}
+	before := head()
+	if _, err := AddPackages([]string{"ripgrep", "nosuch"}); err == nil {
+		t.Fatal("expected an error when one package is missing")
+	}
+	if head() != before || !reflect.DeepEqual(names(), []string{"git"}) {
+		t.Fatalf("a failed bulk add changed the configuration: %v", names())
+	}
+
+	result, err := ImportPackages([]string{"ripgrep", "nosuch", "git", "fd"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result.Added, []string{"ripgrep", "fd"}) || !reflect.DeepEqual(result.Existing, []string{"git"}) ||
+		len(result.NotFound) != 1 || result.NotFound[0].Name != "nosuch" {
+		t.Fatalf("unexpected import result: %+v", result)
+	}
+	imported := head()
+	if imported == before {
+		t.Fatal("import did not commit")
+	}
+
+	if err := RemovePackages([]string{"fd", "nosuch"}); err == nil {
+		t.Fatal("expected an error when removing a missing package")
+	}
+	if err := RemovePackages([]string{"fd", "ripgrep"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(names(), []string{"git"}) {
+		t.Fatalf("packages = %v, want [git]", names())
+	}
+}
+
+func TestAdoptPackages(t *testing.T) {
+	fake := setupPackageRepo(t, `{"commit_triggers": []}`,
+		search.Entry{AttrPath: "git", Pname: "git"},
+		search.Entry{AttrPath: "ripgrep", Pname: "ripgrep"},
+	)
+	if _, err := AddPackages([]string{"git"}); err != nil {
+		t.Fatal(err)
+	}
+	fake.On("nix profile list --json", nix.FakeResult{Stdout: `{"version": 3, "elements": {
+		"git": {"attrPath": "legacyPackages.x86_64-linux.git", "originalUrl": "flake:nixpkgs"},
+		"ripgrep": {"attrPath": "legacyPackages.x86_64-linux.ripgrep", "originalUrl": "github:NixOS/nixpkgs/nixos-unstable"},
+		"oldtool": {"attrPath": "legacyPackages.x86_64-linux.oldtool", "originalUrl": "flake:nixpkgs"},
+		"home-manager-path": {"attrPath": "", "originalUrl": ""}
+	}}`})
+
+	untracked, err := UntrackedPackages(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range untracked {
+		names = append(names, e.AttrPath)
+	}
+	if want := []string{"oldtool", "ripgrep"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("untracked = %v, want %v", names, want)
+	}
+
+	// A ~/.nix-profile makes this a single-user install, rebuilt with Home
+	// Manager, whose profile the rebuild is recorded against.
+	if err := os.MkdirAll(filepath.Join(os.Getenv("HOME"), ".nix-profile"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	stateHome := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateHome)
+	profiles := filepath.Join(stateHome, "nix", "profiles")
+	if err := os.MkdirAll(profiles, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("home-manager-1-link", filepath.Join(profiles, "home-manager")); err != nil {
+		t.Fatal(err)
+	}
+	// A successful rebuild reloads the devshells.
+	if err := os.MkdirAll(filepath.Join(config.GetFlakePath(), "devshells"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Packages from nixpkgs-unstable are checked by evaluating them, as
+	// oldtool is, which the fake cannot tell apart, so they are adopted
+	// separately.
+	result, _, err := AdoptPackages(context.Background(), untracked[:1], RebuildOptions{FlakePath: config.GetFlakePath()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Added) != 0 || len(result.NotFound) != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	fake = nix.NewFakeExecutor().On("nix eval", nix.FakeResult{Stdout: `{"pname": "ripgrep", "version": "14.1.1"}`})
+	nix.SetExecutor(fake)
+	result, _, err = AdoptPackages(context.Background(), untracked[1:], RebuildOptions{FlakePath: config.GetFlakePath()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result.Added, []string{"ripgrep"}) {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	packages, err := config.ReadPackagesConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := packages[len(packages)-1]; last.Name != "ripgrep" || last.Source != config.SourceUnstable {
+		t.Errorf("adopted %+v, want ripgrep from unstable", last)
+	}
+	removed, switched := -1, -1
+	for i, line := range fake.CommandLines() {
+		switch {
+		case line == "nix profile remove ripgrep":
+			removed = i
+		case strings.HasPrefix(line, "home-manager switch"):
+			switched = i
+		case strings.HasPrefix(line, "nix profile install"):
+			t.Errorf("reinstalled after a successful switch: %q", line)
+		}
+	}
+	if removed < 0 || switched < removed {
+		t.Fatalf("adopted packages were not removed from the profile before the switch: %v", fake.CommandLines())
+	}
+
+	// A failed switch puts the packages back.
+	if err := RemovePackage("ripgrep"); err != nil {
+		t.Fatal(err)
+	}
+	failing := nix.NewFakeExecutor().
+		On("nix eval", nix.FakeResult{Stdout: `{"pname": "ripgrep", "version": "14.1.1"}`}).
+		On("home-manager switch", nix.FakeResult{Err: errors.New("exit status 1")})
+	nix.SetExecutor(failing)
+	if _, _, err := AdoptPackages(context.Background(), untracked[1:], RebuildOptions{FlakePath: config.GetFlakePath()}); err == nil {
+		t.Fatal("expected an error when the switch fails")
+	}
+	lines := failing.CommandLines()
+	if want := "nix profile install github:NixOS/nixpkgs/nixos-unstable#ripgrep"; len(lines) == 0 || lines[len(lines)-1] != want {
+		t.Fatalf("last command = %q, want %q", lines[len(lines)-1], want)
+	}
+}
+
+func TestRemoveLast(t *testing.T) {
+	setupFakeEnv(t, `{"commit_triggers": []}`)
+	if err := GitInit(config.GetInstallPath()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddPackageFromSource("ripgrep", config.SourceStable); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddUser("ada", "Ada", "ada@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the last entry used to write null, which the schemas reject.
+	if err := RemovePackage("ripgrep"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := RemoveUser("ada"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if packages, err := config.ReadPackagesConfig(); err != nil || len(packages) != 0 {
+		t.Errorf("packages = %v, %v, want none", packages, err)
+	}
+	if users, err := config.ReadUsersConfig(); err != nil || len(users) != 0 {
+		t.Errorf("users = %v, %v, want none", users, err)
+	}
+	if err := config.WriteConfig(&config.BaseConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.ReadConfig(); err != nil {
+		t.Errorf("reading a config written without slices: %v", err)
+	}
+}
+
+func TestSearchWithoutIndex(t *testing.T) {
+	fake := setupFakeEnv(t, `{"commit_triggers": []}`)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	lock := `{"nodes": {"nixpkgs": {"locked": {"rev": "abc123"}}, "root": {"inputs": {"nixpkgs": "nixpkgs"}}}, "root": "root"}`
+	if err := os.WriteFile(filepath.Join(config.GetFlakePath(), "flake.lock"), []byte(lock), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fake.On("nix search", nix.FakeResult{Stdout: `{
+		"legacyPackages.x86_64-linux.hello": {"pname": "hello", "version": "2.12.1", "description": "Greeter"},
+		"legacyPackages.x86_64-linux.hello-pro": {"pname": "hello-pro", "version": "1.0", "description": "Greeter"}
+	}`})
+	fake.On("nix eval", nix.FakeResult{Stdout: `["hello-pro"]`})
+
+	if SearchIndexReady() {
+		t.Fatal("expected no search index")
+	}
+	building, err := StartSearchIndexBuild()
+	if err != nil || !building {
+		t.Fatalf("StartSearchIndexBuild() = %v, %v, want true", building, err)
+	}
+	lines := fake.CommandLines()
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], " search --refresh-index") {
+		t.Fatalf("commands = %q, want a detached index build", lines)
+	}
+
+	packages, err := SearchContext(context.Background(), []string{"hello"}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 2 || packages[0].Name != "hello" {
+		t.Errorf("packages = %+v, want hello and hello-pro from nix search", packages)
+	}
+
+	packages, err = SearchContext(context.Background(), []string{"hello"}, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 1 || packages[0].Name != "hello" {
+		t.Errorf("packages = %+v, want only the free hello", packages)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := SearchContext(ctx, []string{"hello"}, false, false); err == nil {
+		t.Error("expected a cancelled search to fail")
+	}
+}
@@ -0,0 +1,322 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"pilo/internal/config"
+	"pilo/internal/nix"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/acarl005/stripansi"
+)
+
+// PackageChange describes how one package differs between two closures.
+type PackageChange struct {
+	Name string
+	// OldVersions and NewVersions are empty when the package is added or removed.
+	OldVersions []string
+	NewVersions []string
+	// SizeDelta is the change in the package's size in bytes. Nix omits small
+	// deltas, in which case it is 0.
+	SizeDelta int64
+}
+
+// Added reports whether the package is new in the closure.
+func (c PackageChange) Added() bool {
+	return len(c.OldVersions) == 0 && len(c.NewVersions) > 0
+}
+
+// Removed reports whether the package is no longer in the closure.
+func (c PackageChange) Removed() bool {
+	return len(c.OldVersions) > 0 && len(c.NewVersions) == 0
+}
+
+// ClosureDiff is the difference between the running generation and the one a
+// rebuild would produce.
+type ClosureDiff struct {
+	CurrentPath string
+	NewPath     string
+	Changes     []PackageChange
+	// CurrentSize and NewSize are the closure sizes in bytes.
+	CurrentSize int64
+	NewSize     int64
+}
+
+// SizeDelta is the change in closure size in bytes.
+func (d *ClosureDiff) SizeDelta() int64 {
+	return d.NewSize - d.CurrentSize
+}
+
+// Empty reports whether switching would change nothing.
+func (d *ClosureDiff) Empty() bool {
+	return d.CurrentPath == d.NewPath || (len(d.Changes) == 0 && d.SizeDelta() == 0)
+}
+
+// Table renders the changes as an aligned text table followed by the closure
+// size change.
+func (d *ClosureDiff) Table() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHANGE\tPACKAGE\tOLD\tNEW\tSIZE")
+	for _, c := range d.Changes {
+		kind := "changed"
+		switch {
+		case c.Added():
+			kind = "added"
+		case c.Removed():
+			kind = "removed"
+		}
+		size := ""
+		if c.SizeDelta != 0 {
+			size = FormatSize(c.SizeDelta)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", kind, c.Name,
+			strings.Join(c.OldVersions, ", "), strings.Join(c.NewVersions, ", "), size)
+	}
+	w.Flush()
+	fmt.Fprintf(&b, "\nClosure size: %.1f MiB -> %.1f MiB (%s)\n",
+		mebibytes(d.CurrentSize), mebibytes(d.NewSize), FormatSize(d.SizeDelta()))
+	return b.String()
+}
+
+// PreviewRebuild builds the configuration described by opts without
+// activating it and compares the result with the current generation.
+// Before the first switch there is no current generation, and every package
+// of the new one is added. Build output and progress are reported through
+// opts like a rebuild.
+func PreviewRebuild(ctx context.Context, opts RebuildOptions) (*ClosureDiff, error) {
+	flakePath := opts.FlakePath
+	if flakePath == "" {
+		flakePath = config.GetFlakePath()
+	}
+
+	attr, current, err := previewTarget(flakePath, opts.Host)
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(current)
+	firstSwitch := os.IsNotExist(err)
+	if err != nil && !firstSwitch {
+		return nil, fmt.Errorf("could not find the current generation: %w", err)
+	}
+
+	args := append([]string{"build", "--no-link", "--print-out-paths", attr}, opts.inputOverrides()...)
+	out, err := nix.RunCommandProgress(ctx, opts.OnOutput, opts.OnProgress, "nix", args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not build the new configuration: %w", err)
+	}
+	newPath := lastStorePath(out)
+	if newPath == "" {
+		return nil, fmt.Errorf("nix build did not print an output path")
+	}
+
+	if firstSwitch {
+		return newClosure(ctx, newPath)
+	}
+	return diffClosures(ctx, current, newPath)
+}
+
+// newClosure describes switching to path from nothing: every package of its
+// closure is added.
+func newClosure(ctx context.Context, path string) (*ClosureDiff, error) {
+	diff := &ClosureDiff{NewPath: path}
+	out, err := nix.RunCommandContext(ctx, nil, "nix", "path-info", "--recursive", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the closure of %s: %w", path, err)
+	}
+	versions := make(map[string][]string)
+	for _, line := range strings.Fields(out) {
+		if !strings.HasPrefix(line, "/nix/store/") {
+			continue
+		}
+		name, version := splitStoreName(filepath.Base(line))
+		if !slices.Contains(versions[name], version) {
+			versions[name] = append(versions[name], version)
+		}
+	}
+	for name, vs := range versions {
+		sort.Strings(vs)
+		diff.Changes = append(diff.Changes, PackageChange{Name: name, NewVersions: vs})
+	}
+	sort.Slice(diff.Changes, func(i, j int) bool { return diff.Changes[i].Name < diff.Changes[j].Name })
+
+	if diff.NewSize, err = closureSize(ctx, path); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// splitStoreName splits the base name of a store path, such as
+// "<hash>-hello-2.12", into the package name and its version the way
+// `nix store diff-closures` does: the version starts at the first dash
+// followed by a digit.
+func splitStoreName(base string) (name, version string) {
+	if _, rest, ok := strings.Cut(base, "-"); ok {
+		base = rest
+	}
+	for i := 0; i+1 < len(base); i++ {
+		if base[i] == '-' && base[i+1] >= '0' && base[i+1] <= '9' {
+			return base[:i], base[i+1:]
+		}
+	}
+	return base, ""
+}
+
+// diffClosures compares the closures of two store paths or profile links.
+func diffClosures(ctx context.Context, from, to string) (*ClosureDiff, error) {
+	diff := &ClosureDiff{CurrentPath: from, NewPath: to}
+	out, err := nix.RunCommandContext(ctx, nil, "nix", "store", "diff-closures", from, to)
+	if err != nil {
+		return nil, fmt.Errorf("could not diff closures: %w", err)
+	}
+	diff.Changes = ParseDiffClosures(out)
+
+	if diff.CurrentSize, err = closureSize(ctx, from); err != nil {
+		return nil, err
+	}
+	if diff.NewSize, err = closureSize(ctx, to); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// previewTarget returns the flake attribute that builds the configuration of
+// host and the profile holding the generation currently in use.
+func previewTarget(flakePath, host string) (attr, current string, err error) {
+	switch nix.GetNixMode() {
+	case nix.NixOS:
+		if host, err = resolveHost(flakePath, host); err != nil {
+			return "", "", err
+		}
+		return fmt.Sprintf("%s#nixosConfigurations.%s.config.system.build.toplevel", flakePath, strconv.Quote(host)), "/run/current-system", nil
+	case nix.MultiUser, nix.SingleUser:
+		u, err := user.Current()
+		if err != nil {
+			return "", "", fmt.Errorf("could not get current user: %w", err)
+		}
+		system, err := config.GetSystem()
+		if err != nil {
+			return "", "", err
+		}
+		attr = fmt.Sprintf("%s#homeConfigurations.\"%s@%s\".activationPackage", flakePath, u.Username, system.Type)
+		return attr, homeManagerProfile(u), nil
+	default:
+		return "", "", fmt.Errorf("no supported Nix installation found")
+	}
+}
+
+// homeManagerProfile returns the home-manager profile link of u. Newer
+// home-manager versions keep it under the XDG state directory.
+func homeManagerProfile(u *user.User) string {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		stateHome = filepath.Join(u.HomeDir, ".local", "state")
+	}
+	profile := filepath.Join(stateHome, "nix", "profiles", "home-manager")
+	if _, err := os.Lstat(profile); err == nil {
+		return profile
+	}
+	return filepath.Join("/nix/var/nix/profiles/per-user", u.Username, "home-manager")
+}
+
+// closureSize returns the total size in bytes of path and its dependencies.
+func closureSize(ctx context.Context, path string) (int64, error) {
+	out, err := nix.RunCommandContext(ctx, nil, "nix", "path-info", "--closure-size", path)
+	if err != nil {
+		return 0, fmt.Errorf("could not get closure size of %s: %w", path, err)
+	}
+	fields := strings.Fields(out)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("unexpected output from nix path-info: %q", out)
+	}
+	return strconv.ParseInt(fields[len(fields)-1], 10, 64)
+}
+
+// lastStorePath returns the last line of out that is a store path.
+func lastStorePath(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); strings.HasPrefix(line, "/nix/store/") {
+			return line
+		}
+	}
+	return ""
+}
+
+// ParseDiffClosures parses the output of `nix store diff-closures`, whose
+// lines look like
+//
+//	firefox: 120.0 → 121.0, +2048.0 KiB
+//	hello: ∅ → 2.12, +120.5 KiB
+//	zlib: +12.3 KiB
+//
+// Changes are returned sorted by name.
+func ParseDiffClosures(out string) []PackageChange {
+	var changes []PackageChange
+	for _, line := range strings.Split(stripansi.Strip(out), "\n") {
+		name, rest, ok := strings.Cut(strings.TrimSpace(line), ": ")
+		if !ok || name == "" {
+			continue
+		}
+		change := PackageChange{Name: name}
+		items := strings.Split(rest, ", ")
+		if last := items[len(items)-1]; strings.HasSuffix(last, " KiB") {
+			kib, err := strconv.ParseFloat(strings.TrimSuffix(last, " KiB"), 64)
+			if err == nil {
+				change.SizeDelta = int64(kib * 1024)
+				items = items[:len(items)-1]
+			}
+		}
+		if versions := strings.Join(items, ", "); versions != "" {
+			before, after, ok := strings.Cut(versions, " → ")
+			if !ok {
+				continue
+			}
+			change.OldVersions = parseVersionSet(before)
+			change.NewVersions = parseVersionSet(after)
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// parseVersionSet splits a comma-separated version list, where "∅" means the
+// package is absent and "ε" stands for an empty version.
+func parseVersionSet(s string) []string {
+	if s == "∅" {
+		return nil
+	}
+	var versions []string
+	for _, v := range strings.Split(s, ", ") {
+		if v == "ε" {
+			v = ""
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+// FormatSize formats a byte count as a signed size in MiB or KiB.
+func FormatSize(delta int64) string {
+	sign := "+"
+	if delta < 0 {
+		sign = "-"
+		delta = -delta
+	}
+	if delta >= 1024*1024 {
+		return fmt.Sprintf("%s%.1f MiB", sign, mebibytes(delta))
+	}
+	return fmt.Sprintf("%s%.1f KiB", sign, float64(delta)/1024)
+}
+
+func mebibytes(n int64) float64 {
+	return float64(n) / (1024 * 1024)
+}
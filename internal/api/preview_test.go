@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"pilo/internal/nix"
+	"reflect"
+	"testing"
+)
+
+func TestParseDiffClosures(t *testing.T) {
+	out := "firefox: 120.0 → 121.0, +2048.0 KiB\n" +
+		"hello: ∅ → 2.12, +120.5 KiB\n" +
+		"\x1b[31;1mnano\x1b[0m: 7.2 → ∅, -2560.0 KiB\n" +
+		"python3: 3.10.9, 3.11.4 → 3.11.5\n" +
+		"zlib: +12.0 KiB\n" +
+		"source: ε → ∅\n"
+
+	want := []PackageChange{
+		{Name: "firefox", OldVersions: []string{"120.0"}, NewVersions: []string{"121.0"}, SizeDelta: 2048 * 1024},
+		{Name: "hello", NewVersions: []string{"2.12"}, SizeDelta: 123392},
+		{Name: "nano", OldVersions: []string{"7.2"}, SizeDelta: -2560 * 1024},
+		{Name: "python3", OldVersions: []string{"3.10.9", "3.11.4"}, NewVersions: []string{"3.11.5"}},
+		{Name: "source", OldVersions: []string{""}},
+		{Name: "zlib", SizeDelta: 12 * 1024},
+	}
+	got := ParseDiffClosures(out)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseDiffClosures() =\n%+v\nwant\n%+v", got, want)
+	}
+	if !got[1].Added() || !got[2].Removed() || got[0].Added() || got[0].Removed() {
+		t.Error("Added/Removed misclassified changes")
+	}
+}
+
+func TestPreviewFirstSwitch(t *testing.T) {
+	if nix.GetNixMode() != nix.None {
+		t.Skip("needs a machine without Nix")
+	}
+	fake := setupFakeEnv(t, `{"commit_triggers": []}`)
+	// A ~/.nix-profile makes this a single-user install without a
+	// home-manager generation yet.
+	if err := os.MkdirAll(filepath.Join(os.Getenv("HOME"), ".nix-profile"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	newPath := "/nix/store/a1b2c3d4e5f6g7h8i9j0k1l2m3n4o5p6-home-manager-generation"
+	fake.On("nix build", nix.FakeResult{Stdout: newPath + "\n"})
+	fake.On("nix path-info --recursive", nix.FakeResult{Stdout: newPath + "\n" +
+		"/nix/store/b1b2c3d4e5f6g7h8i9j0k1l2m3n4o5p6-hello-2.12\n" +
+		"/nix/store/c1b2c3d4e5f6g7h8i9j0k1l2m3n4o5p6-python3-3.11.5\n" +
+		"/nix/store/d1b2c3d4e5f6g7h8i9j0k1l2m3n4o5p6-python3-3.11.5-env\n"})
+	fake.On("nix path-info --closure-size", nix.FakeResult{Stdout: newPath + "\t2097152\n"})
+
+	diff, err := PreviewRebuild(context.Background(), RebuildOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []PackageChange{
+		{Name: "hello", NewVersions: []string{"2.12"}},
+		{Name: "home-manager-generation", NewVersions: []string{""}},
+		{Name: "python3", NewVersions: []string{"3.11.5", "3.11.5-env"}},
+	}
+	if !reflect.DeepEqual(diff.Changes, want) {
+		t.Errorf("changes = %+v, want %+v", diff.Changes, want)
+	}
+	if diff.CurrentSize != 0 || diff.NewSize != 2097152 || diff.Empty() {
+		t.Errorf("sizes = %d -> %d, want 0 -> 2097152", diff.CurrentSize, diff.NewSize)
+	}
+}
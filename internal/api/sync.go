@@ -0,0 +1,578 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"pilo/internal/config"
+	"pilo/internal/merge"
+	"pilo/internal/vcs"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Files in the .git directory that record a merge waiting for its conflicts
+// to be resolved. MERGE_HEAD is what git uses; the list of conflicted files
+// is pilo's own, since go-git cannot record conflicts in the index.
+const (
+	mergeHeadFile      = "MERGE_HEAD"
+	mergeConflictsFile = "PILO_MERGE_CONFLICTS"
+)
+
+// SyncResult describes what GitSync did.
+type SyncResult struct {
+	// Branch is the remote branch that was synced, such as "origin/main".
+	Branch string
+	// FastForward reports whether the remote changes were taken as they
+	// are, as there were no local ones.
+	FastForward bool
+	// Merged reports whether local and remote changes were merged.
+	Merged bool
+	// Resolved lists the settings in pilo's JSON files that were changed
+	// differently on both sides. The local value was kept.
+	Resolved []merge.Conflict
+}
+
+// String summarises the result for the user.
+func (r SyncResult) String() string {
+	var msg string
+	switch {
+	case r.FastForward:
+		msg = fmt.Sprintf("Updated to %s and pushed.", r.Branch)
+	case r.Merged:
+		msg = fmt.Sprintf("Merged %s and pushed.", r.Branch)
+	default:
+		msg = fmt.Sprintf("Pushed to %s.", r.Branch)
+	}
+	for _, c := range r.Resolved {
+		msg += fmt.Sprintf("\nKept the local value of %s in %s: %s (remote: %s)", c.Field, c.File, c.Ours, c.Theirs)
+	}
+	return msg
+}
+
+// MergeConflictError is returned when local and remote changes to the same
+// lines of a file cannot be merged. The files hold both versions between
+// conflict markers; once they are edited, GitSync or any commit completes
+// the merge.
+type MergeConflictError struct {
+	Conflicts []merge.Conflict
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("merging the remote changes left conflicts in %s; edit the files and sync again", strings.Join(conflictFiles(e.Conflicts), ", "))
+}
+
+// GitSync merges the remote branch into the local one and pushes the result.
+// Local changes are committed first. Changes to pilo's JSON files are merged
+// setting by setting, so only changes to the same lines of other files can
+// conflict; those are returned as a *MergeConflictError and nothing is
+// pushed. With resolve, settings changed differently on both sides stop the
+// merge too instead of keeping the local value, so that they can be settled
+// with MergeConflicts and ResolveConflicts.
+func GitSync(repoPath string, resolve bool) (SyncResult, error) {
+	var result SyncResult
+	if err := GitBackup(repoPath); err != nil {
+		return result, fmt.Errorf("failed to create backup before syncing: %w", err)
+	}
+
+	// Committing also completes a merge whose conflicts have been resolved.
+	message := "pilo: sync local changes"
+	if _, _, merging, err := readMergeState(repoPath); err != nil {
+		return result, err
+	} else if merging {
+		message = ""
+	}
+	if err := GitAdd(repoPath); err != nil {
+		return result, fmt.Errorf("failed to add local changes: %w", err)
+	}
+	if _, err := gitCommit(repoPath, message); err != nil {
+		return result, err
+	}
+
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return result, err
+	}
+	branch, err := syncBranch(repo)
+	if err != nil {
+		return result, err
+	}
+	result.Branch = "origin/" + branch
+	if remoteURL, _ := config.GetRemoteUrl(); remoteURL != "" {
+		if err := setOrigin(repo, remoteURL); err != nil {
+			return result, err
+		}
+	}
+
+	auth, err := getGitAuth(originURL(repo))
+	if err != nil {
+		return result, err
+	}
+	if err := repo.Fetch(&git.FetchOptions{RemoteName: "origin", Auth: auth}); err != nil && err != git.NoErrAlreadyUpToDate {
+		return result, fmt.Errorf("failed to fetch from remote: %w", err)
+	}
+
+	remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+	if err == nil {
+		if result, err = mergeCommit(repoPath, remoteRef.Hash(), result.Branch, resolve); err != nil {
+			return result, err
+		}
+	} else if err != plumbing.ErrReferenceNotFound {
+		return result, err
+	}
+
+	if err := GitPush(repoPath); err != nil {
+		return result, fmt.Errorf("failed to push synchronized changes: %w", err)
+	}
+	config.AddLogEntry(fmt.Sprintf("Successfully synced with %s.", result.Branch))
+	return result, nil
+}
+
+// mergeCommit merges the commit theirs, labelled label, into the current
+// branch of the clean repository at repoPath. With resolve, conflicting
+// settings stop the merge like conflicting lines do.
+func mergeCommit(repoPath string, theirs plumbing.Hash, label string, resolve bool) (SyncResult, error) {
+	result := SyncResult{Branch: label}
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return result, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return result, err
+	}
+	ourCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return result, err
+	}
+	theirCommit, err := repo.CommitObject(theirs)
+	if err != nil {
+		return result, err
+	}
+
+	if upToDate, err := theirCommit.IsAncestor(ourCommit); err != nil || upToDate {
+		return result, err
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return result, err
+	}
+	if behind, err := ourCommit.IsAncestor(theirCommit); err != nil {
+		return result, err
+	} else if behind {
+		result.FastForward = true
+		return result, w.Reset(&git.ResetOptions{Commit: theirs, Mode: git.HardReset})
+	}
+
+	baseFiles, ourFiles, theirFiles, err := mergeVersions(ourCommit, theirCommit)
+	if err != nil {
+		return result, err
+	}
+	var conflicts, all []merge.Conflict
+	for _, path := range unionPaths(baseFiles, ourFiles, theirFiles) {
+		r := merge.File(path, baseFiles[path], ourFiles[path], theirFiles[path])
+		all = append(all, r.Conflicts...)
+		for _, c := range r.Conflicts {
+			if c.Field != "" && !resolve {
+				result.Resolved = append(result.Resolved, c)
+			} else {
+				conflicts = append(conflicts, c)
+			}
+		}
+		if err := writeMerged(repoPath, path, ourFiles[path], r.Content, fileMode(theirCommit, path)); err != nil {
+			return result, err
+		}
+	}
+	for _, c := range result.Resolved {
+		config.AddLogEntry(fmt.Sprintf("Merge kept the local value of %s in %s: %s (remote: %s)", c.Field, c.File, c.Ours, c.Theirs))
+	}
+
+	// Every conflicted file is recorded, so that settings that kept their
+	// local value can still be resolved while the merge is in progress.
+	if err := writeMergeState(repoPath, theirs, label, conflictFiles(all)); err != nil {
+		return result, err
+	}
+	if len(conflicts) > 0 {
+		return result, &MergeConflictError{Conflicts: conflicts}
+	}
+	if err := GitAdd(repoPath); err != nil {
+		return result, err
+	}
+	if _, err := gitCommit(repoPath, ""); err != nil {
+		return result, err
+	}
+	result.Merged = true
+	return result, nil
+}
+
+// mergeVersions returns the files of the common ancestor of ours and
+// theirs and of both commits, by path.
+func mergeVersions(ours, theirs *object.Commit) (base, ourFiles, theirFiles map[string][]byte, err error) {
+	if bases, err := ours.MergeBase(theirs); err != nil {
+		return nil, nil, nil, err
+	} else if len(bases) > 0 {
+		if base, err = commitFiles(bases[0]); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if ourFiles, err = commitFiles(ours); err != nil {
+		return nil, nil, nil, err
+	}
+	if theirFiles, err = commitFiles(theirs); err != nil {
+		return nil, nil, nil, err
+	}
+	return base, ourFiles, theirFiles, nil
+}
+
+// FileConflict is a file of the merge in progress with conflicts that are
+// waiting to be resolved.
+type FileConflict struct {
+	// File is the path of the file, relative to the repository.
+	File string
+	// Conflicts are the conflicts of the file, in the order that
+	// ResolveConflicts expects choices for them.
+	Conflicts []merge.Conflict
+
+	base, ours, theirs, merged []byte
+	// mode is what the file is created with if it does not exist.
+	mode os.FileMode
+}
+
+// MergeConflicts returns the conflicted files of the merge in progress at
+// repoPath, or nil if there is none. Files edited since the merge are taken
+// as resolved.
+func MergeConflicts(repoPath string) ([]FileConflict, error) {
+	theirs, _, merging, err := readMergeState(repoPath)
+	if err != nil || !merging {
+		return nil, err
+	}
+	paths, err := mergeConflictFiles(repoPath)
+	if err != nil || len(paths) == 0 {
+		return nil, err
+	}
+
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	ourCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	theirCommit, err := repo.CommitObject(theirs)
+	if err != nil {
+		return nil, err
+	}
+	baseFiles, ourFiles, theirFiles, err := mergeVersions(ourCommit, theirCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []FileConflict
+	for _, path := range paths {
+		r := merge.File(path, baseFiles[path], ourFiles[path], theirFiles[path])
+		current, err := os.ReadFile(filepath.Join(repoPath, filepath.FromSlash(path)))
+		if os.IsNotExist(err) {
+			current = nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(r.Conflicts) == 0 || (current == nil) != (r.Content == nil) || !bytes.Equal(current, r.Content) {
+			continue
+		}
+		files = append(files, FileConflict{
+			File:      path,
+			Conflicts: r.Conflicts,
+			base:      baseFiles[path],
+			ours:      ourFiles[path],
+			theirs:    theirFiles[path],
+			merged:    r.Content,
+			mode:      fileMode(theirCommit, path),
+		})
+	}
+	return files, nil
+}
+
+// ResolveConflicts settles the conflicts of file with choices, one for each
+// of its conflicts, writes the result and marks the file as resolved.
+func ResolveConflicts(repoPath string, file FileConflict, choices []merge.Choice) error {
+	content, err := merge.Resolve(file.File, file.base, file.ours, file.theirs, choices)
+	if err != nil {
+		return err
+	}
+	if err := writeMerged(repoPath, file.File, file.merged, content, file.mode); err != nil {
+		return err
+	}
+	paths, err := mergeConflictFiles(repoPath)
+	if err != nil {
+		return err
+	}
+	var remaining []string
+	for _, path := range paths {
+		if path != file.File {
+			remaining = append(remaining, path)
+		}
+	}
+	config.AddLogEntry(fmt.Sprintf("Resolved the merge conflicts in %s.", file.File))
+	return setMergeConflictFiles(repoPath, remaining)
+}
+
+// CompleteMerge commits the merge in progress at repoPath once its conflicts
+// are resolved.
+func CompleteMerge(repoPath string) error {
+	if err := GitAdd(repoPath); err != nil {
+		return err
+	}
+	return GitCommit(repoPath, "")
+}
+
+// gitCommit commits the staged changes and reports whether it did. While a
+// merge is in progress the commit gets the merged commit as a second parent,
+// and is refused while files still hold conflict markers. An empty message
+// uses the one recorded for the merge.
+func gitCommit(repoPath, message string) (bool, error) {
+	repo, err := vcs.Open(repoPath)
+	if err != nil {
+		return false, err
+	}
+	status, err := repo.Status()
+	if err != nil {
+		return false, err
+	}
+
+	signer, err := commitSigner()
+	if err != nil {
+		return false, err
+	}
+	opts := vcs.CommitOptions{Author: commitAuthor(repo), Signer: signer}
+	theirs, mergeMessage, merging, err := readMergeState(repoPath)
+	if err != nil {
+		return false, err
+	}
+	if merging {
+		unresolved, err := UnresolvedConflicts(repoPath)
+		if err != nil {
+			return false, err
+		}
+		if len(unresolved) > 0 {
+			return false, fmt.Errorf("resolve the merge conflicts in %s first", strings.Join(unresolved, ", "))
+		}
+		head, err := repo.Head()
+		if err != nil {
+			return false, err
+		}
+		opts.Parents = []string{head, theirs.String()}
+		opts.AllowEmpty = true
+		if message == "" {
+			message = mergeMessage
+		}
+	} else if status.IsClean() {
+		return false, nil
+	}
+
+	if _, err := repo.Commit(withTrailer(message), opts); err != nil {
+		return false, err
+	}
+	if merging {
+		return true, clearMergeState(repoPath)
+	}
+	return true, nil
+}
+
+// UnresolvedConflicts returns the files of the merge in progress at repoPath
+// that still hold conflict markers.
+func UnresolvedConflicts(repoPath string) ([]string, error) {
+	paths, err := mergeConflictFiles(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	var unresolved []string
+	for _, path := range paths {
+		content, err := os.ReadFile(filepath.Join(repoPath, path))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if merge.HasConflictMarkers(content) {
+			unresolved = append(unresolved, path)
+		}
+	}
+	return unresolved, nil
+}
+
+func writeMergeState(repoPath string, theirs plumbing.Hash, label string, conflicted []string) error {
+	gitDir := filepath.Join(repoPath, ".git")
+	if err := os.WriteFile(filepath.Join(gitDir, mergeHeadFile), []byte(theirs.String()+"\n"), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(gitDir, "MERGE_MSG"), []byte("pilo: merge "+label+"\n"), 0644); err != nil {
+		return err
+	}
+	return setMergeConflictFiles(repoPath, conflicted)
+}
+
+// mergeConflictFiles returns the files recorded as conflicted by the merge
+// in progress.
+func mergeConflictFiles(repoPath string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(repoPath, ".git", mergeConflictsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+func setMergeConflictFiles(repoPath string, paths []string) error {
+	return os.WriteFile(filepath.Join(repoPath, ".git", mergeConflictsFile), []byte(strings.Join(paths, "\n")), 0644)
+}
+
+// readMergeState returns the commit being merged and the message of the
+// merge commit, and whether a merge is in progress at all.
+func readMergeState(repoPath string) (plumbing.Hash, string, bool, error) {
+	gitDir := filepath.Join(repoPath, ".git")
+	data, err := os.ReadFile(filepath.Join(gitDir, mergeHeadFile))
+	if os.IsNotExist(err) {
+		return plumbing.ZeroHash, "", false, nil
+	} else if err != nil {
+		return plumbing.ZeroHash, "", false, err
+	}
+	message := "pilo: merge remote changes"
+	if msg, err := os.ReadFile(filepath.Join(gitDir, "MERGE_MSG")); err == nil {
+		message = strings.TrimSpace(string(msg))
+	}
+	return plumbing.NewHash(strings.TrimSpace(string(data))), message, true, nil
+}
+
+func clearMergeState(repoPath string) error {
+	for _, name := range []string{mergeHeadFile, "MERGE_MSG", mergeConflictsFile} {
+		if err := os.Remove(filepath.Join(repoPath, ".git", name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncBranch returns the branch to sync with: remote_branch from the base
+// config, or else the current branch.
+func syncBranch(repo *git.Repository) (string, error) {
+	if branch, _ := config.GetRemoteBranch(); branch != "" {
+		return branch, nil
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+	if !head.Name().IsBranch() {
+		return "", errors.New("no branch is checked out")
+	}
+	return head.Name().Short(), nil
+}
+
+// setOrigin points the origin remote at url, creating it if needed.
+func setOrigin(repo *git.Repository, url string) error {
+	remote, err := repo.Remote("origin")
+	if err == nil {
+		if urls := remote.Config().URLs; len(urls) > 0 && urls[0] == url {
+			return nil
+		}
+		if err := repo.DeleteRemote("origin"); err != nil {
+			return err
+		}
+	} else if err != git.ErrRemoteNotFound {
+		return err
+	}
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{Name: "origin", URLs: []string{url}})
+	return err
+}
+
+// commitFiles returns the contents of every file in commit by path.
+func commitFiles(commit *object.Commit) (map[string][]byte, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	err = tree.Files().ForEach(func(f *object.File) error {
+		r, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		files[f.Name] = data
+		return nil
+	})
+	return files, err
+}
+
+func unionPaths(sets ...map[string][]byte) []string {
+	seen := map[string]bool{}
+	var paths []string
+	for _, set := range sets {
+		for path := range set {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// writeMerged updates the worktree file at path from our version to the
+// merged one. An existing file keeps its mode; a new one is created with mode.
+func writeMerged(repoPath, path string, ours, merged []byte, mode os.FileMode) error {
+	full := filepath.Join(repoPath, filepath.FromSlash(path))
+	if merged == nil {
+		if ours == nil {
+			return nil
+		}
+		return os.Remove(full)
+	}
+	if ours != nil && string(ours) == string(merged) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(full, merged, mode)
+}
+
+// fileMode returns the permissions of the file at path in commit, or 0644 if
+// commit has no such file.
+func fileMode(commit *object.Commit, path string) os.FileMode {
+	if f, err := commit.File(path); err == nil {
+		if mode, err := f.Mode.ToOSFileMode(); err == nil {
+			return mode.Perm()
+		}
+	}
+	return 0644
+}
+
+func conflictFiles(conflicts []merge.Conflict) []string {
+	var files []string
+	seen := map[string]bool{}
+	for _, c := range conflicts {
+		if !seen[c.File] {
+			seen[c.File] = true
+			files = append(files, c.File)
+		}
+	}
+	return files
+}
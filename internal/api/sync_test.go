@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"pilo/internal/config"
+	"pilo/internal/merge"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestMergeCommit(t *testing.T) {
+	setupFakeEnv(t, "{\n  \"schema_version\": 1,\n  \"commit_triggers\": []\n}\n")
+	repoPath := config.GetInstallPath()
+	if err := GitInit(repoPath); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		path := filepath.Join(config.GetFlakePath(), name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(config.GetFlakePath(), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	commit := func(msg string) plumbing.Hash {
+		if err := GitAdd(repoPath); err != nil {
+			t.Fatal(err)
+		}
+		if err := GitCommit(repoPath, msg); err != nil {
+			t.Fatal(err)
+		}
+		head, err := GitHead(repoPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return plumbing.NewHash(head)
+	}
+
+	write("packages.json", `{"packages": [{"name": "git", "installed": true}]}`)
+	write("system.nix", "{\n  a = 1;\n  x = 0;\n  y = 0;\n  b = 2;\n}\n")
+	base := commit("base")
+
+	// The remote installs ripgrep and changes a.
+	write("packages.json", `{"packages": [{"name": "git", "installed": true}, {"name": "ripgrep", "installed": true}]}`)
+	write("system.nix", "{\n  a = 10;\n  x = 0;\n  y = 0;\n  b = 2;\n}\n")
+	// It also adds a script, which must stay executable.
+	if err := os.WriteFile(filepath.Join(config.GetFlakePath(), "setup.sh"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	theirs := commit("theirs")
+
+	// Locally, go is installed and b changed.
+	repo, err := git.PlainOpen(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := repo.Worktree()
+	if err := w.Reset(&git.ResetOptions{Commit: base, Mode: git.HardReset}); err != nil {
+		t.Fatal(err)
+	}
+	write("packages.json", `{"packages": [{"name": "git", "installed": true}, {"name": "go", "installed": true}]}`)
+	write("system.nix", "{\n  a = 1;\n  x = 0;\n  y = 0;\n  b = 20;\n}\n")
+	ours := commit("ours")
+
+	result, err := mergeCommit(repoPath, theirs, "origin/main", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Merged {
+		t.Errorf("result = %+v, want a merge", result)
+	}
+	if got := read("system.nix"); got != "{\n  a = 10;\n  x = 0;\n  y = 0;\n  b = 20;\n}\n" {
+		t.Errorf("system.nix = %q", got)
+	}
+	if info, err := os.Stat(filepath.Join(config.GetFlakePath(), "setup.sh")); err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Errorf("setup.sh = %v, %v, want it executable", info, err)
+	}
+	pkgs, err := config.ReadPackagesConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range pkgs {
+		names = append(names, p.Name)
+	}
+	if strings.Join(names, " ") != "git go ripgrep" {
+		t.Errorf("packages = %v", names)
+	}
+	head, _ := repo.Head()
+	merged, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.ParentHashes) != 2 || merged.ParentHashes[0] != ours || merged.ParentHashes[1] != theirs {
+		t.Errorf("merge commit parents = %v", merged.ParentHashes)
+	}
+
+	// Both sides change a: the conflict is left for the user.
+	write("system.nix", "{\n  a = 11;\n  x = 0;\n  y = 0;\n  b = 20;\n}\n")
+	theirs = commit("theirs again")
+	if err := w.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.HardReset}); err != nil {
+		t.Fatal(err)
+	}
+	write("system.nix", "{\n  a = 12;\n  x = 0;\n  y = 0;\n  b = 20;\n}\n")
+	commit("ours again")
+
+	_, err = mergeCommit(repoPath, theirs, "origin/main", false)
+	var conflicts *MergeConflictError
+	if !errors.As(err, &conflicts) || len(conflicts.Conflicts) != 1 || conflicts.Conflicts[0].File != "flake/system.nix" {
+		t.Fatalf("expected a conflict in flake/system.nix, got %v", err)
+	}
+	if err := GitCommit(repoPath, "too early"); err == nil {
+		t.Error("committing with unresolved conflicts should fail")
+	}
+
+	files, err := MergeConflicts(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || len(files[0].Conflicts) != 1 {
+		t.Fatalf("MergeConflicts = %+v", files)
+	}
+	if err := ResolveConflicts(repoPath, files[0], []merge.Choice{merge.Theirs}); err != nil {
+		t.Fatal(err)
+	}
+	if got := read("system.nix"); got != "{\n  a = 11;\n  x = 0;\n  y = 0;\n  b = 20;\n}\n" {
+		t.Errorf("resolved system.nix = %q", got)
+	}
+	if err := CompleteMerge(repoPath); err != nil {
+		t.Fatalf("completing the merge: %v", err)
+	}
+	head, _ = repo.Head()
+	merged, _ = repo.CommitObject(head.Hash())
+	if len(merged.ParentHashes) != 2 || merged.Message != withTrailer("pilo: merge origin/main") {
+		t.Errorf("merge commit %q has parents %v", merged.Message, merged.ParentHashes)
+	}
+}
@@ -1,44 +1,30 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"os/exec"
 	"os/user"
-	"path/filepath"
 	"pilo/internal/config"
 	"pilo/internal/nix"
+	"pilo/internal/vcs"
 	"strings"
-
-	"github.com/acarl005/stripansi"
 )
 
-// BaseConfig represents the structure of the base-config.json file.
-type BaseConfig struct {
-	System struct {
-		Type string `json:"type"`
-	} `json:"system"`
+// RunCommandAndCommit executes a command and commits the changes if the command is a trigger.
+func RunCommandAndCommit(commandName string, password string, args ...string) (string, error) {
+	return RunCommandAndCommitContext(context.Background(), nil, commandName, password, args...)
 }
 
-// getSystemType reads the system type from the base-config.json file.
-func getSystemType() (string, error) {
-	configPath := filepath.Join(config.GetFlakePath(), "base-config.json")
-	file, err := ioutil.ReadFile(configPath)
-	if err != nil {
-		return "", fmt.Errorf("could not read base-config.json: %w", err)
-	}
-
-	var baseConfig BaseConfig
-	if err := json.Unmarshal(file, &baseConfig); err != nil {
-		return "", fmt.Errorf("could not unmarshal base-config.json: %w", err)
-	}
-
-	return baseConfig.System.Type, nil
+// RunCommandAndCommitContext is like RunCommandAndCommit, but streams the command's
+// output to onLine and stops the command when ctx is cancelled.
+func RunCommandAndCommitContext(ctx context.Context, onLine nix.OutputFunc, commandName string, password string, args ...string) (string, error) {
+	return runCommandAndCommit(ctx, onLine, nil, commandName, password, args...)
 }
 
-// RunCommandAndCommit executes a command and commits the changes if the command is a trigger.
-func RunCommandAndCommit(commandName string, password string, args ...string) (string, error) {
+// runCommandAndCommit does the work of RunCommandAndCommitContext. When
+// onProgress is set the command runs with nix's structured logging and build
+// progress is reported as it changes.
+func runCommandAndCommit(ctx context.Context, onLine nix.OutputFunc, onProgress nix.ProgressFunc, commandName string, password string, args ...string) (string, error) {
 	triggers, err := config.GetCommitTriggers()
 	if err != nil {
 		return "", fmt.Errorf("could not get commit triggers: %w", err)
@@ -51,12 +37,21 @@ func RunCommandAndCommit(commandName string, password string, args ...string) (s
 			break
 		}
 	}
+	// A dry run changes nothing, so it never creates a commit.
+	if commandName == string(RebuildDryRun) {
+		commit = false
+	}
 
 	var output string
-	if password != "" {
-		output, err = nix.RunSudoCommand(password, args...)
-	} else {
-		output, err = nix.RunCommand(args[0], args[1:]...)
+	switch {
+	case onProgress != nil && password != "":
+		output, err = nix.RunSudoCommandProgress(ctx, password, onLine, onProgress, args...)
+	case onProgress != nil:
+		output, err = nix.RunCommandProgress(ctx, onLine, onProgress, args[0], args[1:]...)
+	case password != "":
+		output, err = nix.RunSudoCommandContext(ctx, password, onLine, args...)
+	default:
+		output, err = nix.RunCommandContext(ctx, onLine, args[0], args[1:]...)
 	}
 
 	if err != nil {
@@ -64,52 +59,160 @@ func RunCommandAndCommit(commandName string, password string, args ...string) (s
 	}
 
 	if commit {
-		pushOnCommit, err := config.GetPushOnCommit()
-		if err != nil {
-			return output, fmt.Errorf("could not get push on commit setting: %w", err)
-		}
-		if pushOnCommit {
-			if err := nix.CommitAndPush(config.GetInstallPath(), fmt.Sprintf("pilo: %s", commandName)); err != nil {
-				return output, fmt.Errorf("could not commit and push changes: %w", err)
-			}
-		} else {
-			if err := nix.Commit(config.GetInstallPath(), fmt.Sprintf("pilo: %s", commandName)); err != nil {
-				return output, fmt.Errorf("could not commit changes: %w", err)
-			}
+		// GitCommit also syncs with the remote when push_on_commit is set.
+		if err := commitChanges(fmt.Sprintf("pilo: %s", commandName)); err != nil {
+			return output, err
 		}
 	}
 
 	return output, nil
 }
 
+// RebuildMode selects what a rebuild does with the configuration it builds.
+type RebuildMode string
+
+const (
+	// RebuildSwitch builds the configuration and activates it immediately.
+	RebuildSwitch RebuildMode = "switch"
+	// RebuildBoot builds the configuration and makes it the default at next boot (NixOS only).
+	RebuildBoot RebuildMode = "boot"
+	// RebuildBuild builds the configuration without activating it.
+	RebuildBuild RebuildMode = "build"
+	// RebuildDryRun evaluates the configuration and shows what would be built.
+	RebuildDryRun RebuildMode = "dry-run"
+)
+
+// RebuildModes lists every rebuild mode, in the order they are offered to users.
+var RebuildModes = []RebuildMode{RebuildSwitch, RebuildBoot, RebuildBuild, RebuildDryRun}
+
+// Activates reports whether the mode changes the running or next-boot system.
+func (m RebuildMode) Activates() bool {
+	return m == RebuildSwitch || m == RebuildBoot
+}
+
+// commandName is the name checked against the commit triggers. Modes that
+// activate the configuration share the "rebuild" trigger.
+func (m RebuildMode) commandName() string {
+	if m.Activates() {
+		return "rebuild"
+	}
+	return string(m)
+}
+
+// RebuildOptions configures a rebuild started with RebuildContext.
+type RebuildOptions struct {
+	FlakePath      string
+	Password       string
+	NixpkgsUrl     string
+	HomeManagerUrl string
+	// Host is the NixOS host to build. It defaults to the one named after
+	// this machine's hostname, or config.DefaultHost.
+	Host string
+	// Mode defaults to RebuildSwitch.
+	Mode RebuildMode
+	// OnOutput, if set, receives the rebuild output line by line as it is produced.
+	OnOutput nix.OutputFunc
+	// OnProgress, if set, receives a build progress snapshot whenever it changes.
+	OnProgress nix.ProgressFunc
+}
+
 // Rebuild rebuilds the system configuration.
 func Rebuild(flakePath, password, nixpkgsUrl, homeManagerUrl string) (string, error) {
-	if flakePath == "" {
-		flakePath = config.GetFlakePath()
-	}
+	return RebuildContext(context.Background(), RebuildOptions{
+		FlakePath:      flakePath,
+		Password:       password,
+		NixpkgsUrl:     nixpkgsUrl,
+		HomeManagerUrl: homeManagerUrl,
+	})
+}
 
-	// Use provided URLs, otherwise get from config
+// inputOverrides returns the --override-input flags for the nixpkgs and
+// home-manager URLs, falling back to the configured ones.
+func (opts RebuildOptions) inputOverrides() []string {
+	nixpkgsUrl := opts.NixpkgsUrl
 	if nixpkgsUrl == "" {
 		nixpkgsUrl = config.GetNixpkgsUrl()
 	}
+	homeManagerUrl := opts.HomeManagerUrl
 	if homeManagerUrl == "" {
 		homeManagerUrl = config.GetHomeManagerUrl()
 	}
+	var overrides []string
+	if nixpkgsUrl != "" {
+		overrides = append(overrides, "--override-input", "nixpkgs", nixpkgsUrl)
+	}
+	if homeManagerUrl != "" {
+		overrides = append(overrides, "--override-input", "home-manager", homeManagerUrl)
+	}
+	return overrides
+}
+
+// rebuildArgs returns the command line that runs mode for flakeRef under the
+// given Nix installation, followed by extra.
+func rebuildArgs(nixMode nix.NixMode, mode RebuildMode, flakeRef string, extra ...string) ([]string, error) {
+	var args []string
+	switch nixMode {
+	case nix.NixOS:
+		action := map[RebuildMode]string{
+			RebuildSwitch: "switch",
+			RebuildBoot:   "boot",
+			RebuildBuild:  "build",
+			RebuildDryRun: "dry-build",
+		}[mode]
+		if action == "" {
+			return nil, fmt.Errorf("unknown rebuild mode %q", mode)
+		}
+		args = []string{"nixos-rebuild", action, "--flake", flakeRef}
+	case nix.MultiUser, nix.SingleUser:
+		switch mode {
+		case RebuildSwitch:
+			args = []string{"home-manager", "switch", "--flake", flakeRef}
+		case RebuildBuild:
+			args = []string{"home-manager", "build", "--no-out-link", "--flake", flakeRef}
+		case RebuildDryRun:
+			args = []string{"home-manager", "build", "--dry-run", "--no-out-link", "--flake", flakeRef}
+		case RebuildBoot:
+			return nil, fmt.Errorf("rebuild mode %q is only supported on NixOS", mode)
+		default:
+			return nil, fmt.Errorf("unknown rebuild mode %q", mode)
+		}
+	default:
+		return nil, fmt.Errorf("no supported Nix installation found")
+	}
+	return append(args, extra...), nil
+}
+
+// RebuildContext rebuilds the system configuration, streaming output to
+// opts.OnOutput and build progress to opts.OnProgress. Cancelling ctx stops
+// the running build. After an activating rebuild the configuration is
+// committed and the new generation is linked to that commit.
+func RebuildContext(ctx context.Context, opts RebuildOptions) (string, error) {
+	flakePath := opts.FlakePath
+	if flakePath == "" {
+		flakePath = config.GetFlakePath()
+	}
+	mode := opts.Mode
+	if mode == "" {
+		mode = RebuildSwitch
+	}
+
+	overrides := opts.inputOverrides()
 
 	var args []string
 	var out string
 	var err error
-	switch nix.GetNixMode() {
+	switch nixMode := nix.GetNixMode(); nixMode {
 	case nix.NixOS:
-		fmt.Println("NixOS detected, running nixos-rebuild...")
-		args = []string{"nixos-rebuild", "switch", "--flake", flakePath + "#nixos"}
-		if nixpkgsUrl != "" {
-			args = append(args, "--override-input", "nixpkgs", nixpkgsUrl)
+		var host string
+		if host, err = resolveHost(flakePath, opts.Host); err != nil {
+			break
 		}
-		if homeManagerUrl != "" {
-			args = append(args, "--override-input", "home-manager", homeManagerUrl)
+		args, err = rebuildArgs(nixMode, mode, flakePath+"#"+host, overrides...)
+		if err != nil {
+			break
 		}
-		out, err = RunCommandAndCommit("rebuild", password, args...)
+		fmt.Printf("NixOS detected, running %s...\n", strings.Join(args[:2], " "))
+		out, err = runCommandAndCommit(ctx, opts.OnOutput, opts.OnProgress, mode.commandName(), opts.Password, args...)
 	case nix.MultiUser, nix.SingleUser:
 		var u *user.User
 		u, err = user.Current()
@@ -118,27 +221,46 @@ func Rebuild(flakePath, password, nixpkgsUrl, homeManagerUrl string) (string, er
 		}
 		username := u.Username
 
-		systemType, err := getSystemType()
+		var system config.System
+		system, err = config.GetSystem()
 		if err != nil {
 			return "", err
 		}
+		systemType := system.Type
 
-		fmt.Println("Home Manager detected, running home-manager switch...")
 		flakeRef := fmt.Sprintf("%s#%s@%s", flakePath, username, systemType)
-		args = []string{"home-manager", "switch", "--flake", flakeRef}
-		if nixpkgsUrl != "" {
-			args = append(args, "--override-input", "nixpkgs", nixpkgsUrl)
+		args, err = rebuildArgs(nixMode, mode, flakeRef, overrides...)
+		if err != nil {
+			break
 		}
-		if homeManagerUrl != "" {
-			args = append(args, "--override-input", "home-manager", homeManagerUrl)
+
+		// home-manager cannot emit structured logs, so build the activation
+		// package with nix first to report progress. The command that follows
+		// only has to activate what is already in the store.
+		if opts.OnProgress != nil && mode != RebuildDryRun {
+			attr := fmt.Sprintf("%s#homeConfigurations.\"%s@%s\".activationPackage", flakePath, username, systemType)
+			buildArgs := append([]string{"build", "--no-link", attr}, overrides...)
+			if out, err = nix.RunCommandProgress(ctx, opts.OnOutput, opts.OnProgress, "nix", buildArgs...); err != nil {
+				break
+			}
 		}
-		out, err = RunCommandAndCommit("rebuild", "", args...)
+
+		fmt.Printf("Home Manager detected, running %s...\n", strings.Join(args[:2], " "))
+		var hmOut string
+		hmOut, err = RunCommandAndCommitContext(ctx, opts.OnOutput, mode.commandName(), "", args...)
+		out += hmOut
 	default:
 		err = fmt.Errorf("no supported Nix installation found")
 	}
 	if err != nil {
 		return out, fmt.Errorf("rebuild failed: %w\nOutput:\n%s", err, out)
 	}
+	if !mode.Activates() {
+		return out, nil
+	}
+	if err := commitRebuild(); err != nil {
+		return out, fmt.Errorf("rebuild succeeded, but %w", err)
+	}
 
 	// Refresh the data
 	if _, err := GetInstalledPackages(); err != nil {
@@ -154,16 +276,67 @@ func Rebuild(flakePath, password, nixpkgsUrl, homeManagerUrl string) (string, er
 	return out, err
 }
 
+// commitRebuild commits the configuration that was just activated and records
+// which generation it produced.
+func commitRebuild() error {
+	path := config.GetInstallPath()
+	if err := GitAdd(path); err != nil {
+		return fmt.Errorf("could not add changes: %w", err)
+	}
+	if err := GitCommit(path, "pilo: rebuild"); err != nil {
+		return fmt.Errorf("could not commit changes: %w", err)
+	}
+	commit, err := GitHead(path)
+	if err != nil {
+		return fmt.Errorf("could not read the configuration commit: %w", err)
+	}
+	profile, err := DefaultProfile()
+	if err != nil {
+		return err
+	}
+	if err := RecordGeneration(profile, commit); err != nil {
+		return fmt.Errorf("could not record the new generation: %w", err)
+	}
+	return nil
+}
+
+// RollbackToCommit restores the configuration as it was at commit, commits
+// it on top of the current history and rebuilds it.
+func RollbackToCommit(ctx context.Context, commit string, opts RebuildOptions) (string, error) {
+	path := config.GetInstallPath()
+	full, err := GitCheckoutTree(path, commit)
+	if err != nil {
+		return "", fmt.Errorf("could not restore configuration: %w", err)
+	}
+	short := full
+	if len(short) > 8 {
+		short = short[:8]
+	}
+	if err := GitAdd(path); err != nil {
+		return "", err
+	}
+	if err := GitCommit(path, fmt.Sprintf("pilo: restore configuration from %s", short)); err != nil {
+		return "", err
+	}
+	opts.Mode = RebuildSwitch
+	return RebuildContext(ctx, opts)
+}
+
 // Update updates the flake inputs.
 func Update(inputName string) (string, error) {
+	return UpdateContext(context.Background(), inputName, nil)
+}
+
+// UpdateContext updates the flake inputs, streaming nix's output to onLine.
+func UpdateContext(ctx context.Context, inputName string, onLine nix.OutputFunc) (string, error) {
 	fmt.Println("Updating flake inputs...")
 	flakePath := config.GetFlakePath()
 	fmt.Printf("DEBUG: Running 'nix flake update' on flake: %s\n", flakePath)
 	if inputName != "" {
 		fmt.Printf("Updating input: %s\n", inputName)
-		return nix.RunCommand("nix", "flake", "lock", "--update-input", inputName, "--flake", flakePath)
+		return nix.RunCommandContext(ctx, onLine, "nix", "flake", "lock", "--update-input", inputName, "--flake", flakePath)
 	}
-	return nix.RunCommand("nix", "flake", "update", "--flake", flakePath)
+	return nix.RunCommandContext(ctx, onLine, "nix", "flake", "update", "--flake", flakePath)
 }
 
 // InstallConfig installs the Nix configuration.
@@ -199,43 +372,50 @@ func Upgrade() (string, error) {
 	return nix.RunCommand("nix", "flake", "update", "pilo")
 }
 
-func GC() (string, error) {
-	fmt.Println("Running garbage collection...")
-	return nix.RunCommand("nix", "store", "gc")
+// Rollback switches to the generation before the current one.
+func Rollback(password string) (string, error) {
+	profile, err := DefaultProfile()
+	if err != nil {
+		return "", fmt.Errorf("no supported Nix installation found for rollback")
+	}
+	gens, err := Generations(context.Background(), profile)
+	if err != nil {
+		return "", fmt.Errorf("rollback failed: %w", err)
+	}
+	// gens is sorted newest first, so the previous generation follows the current one.
+	for i, g := range gens {
+		if g.Current && i+1 < len(gens) {
+			return RollbackTo(gens[i+1].Number, password)
+		}
+	}
+	return "", fmt.Errorf("rollback failed: no generation before the current one")
 }
 
-func Rollback(password string) (string, error) {
-	var out string
-	var err error
-	switch nix.GetNixMode() {
-	case nix.NixOS:
-		fmt.Println("Rolling back NixOS to previous generation...")
-		out, err = nix.RunSudoCommand(password, "nixos-rebuild", "switch", "--rollback")
-	case nix.MultiUser, nix.SingleUser:
-		fmt.Println("Rolling back Home Manager to previous generation...")
-		out, err = nix.RunCommand("home-manager", "switch", "--rollback")
-	default:
-		err = fmt.Errorf("no supported Nix installation found for rollback")
+// RollbackTo switches the default profile to generation n.
+func RollbackTo(n int, password string) (string, error) {
+	profile, err := DefaultProfile()
+	if err != nil {
+		return "", fmt.Errorf("no supported Nix installation found for rollback")
 	}
+	out, err := SwitchGeneration(profile, n, password)
 	if err != nil {
 		return out, fmt.Errorf("rollback failed: %w\nOutput:\n%s", err, out)
 	}
-	return out, err
+	return out, nil
 }
 
-// ListGenerations lists the NixOS generations in reverse chronological order.
+// ListGenerations lists the generations of the default profile, newest first.
 func ListGenerations() (string, error) {
-	fmt.Println("Listing NixOS generations...")
-	out, err := nix.RunCommand("nix", "profile", "history", "--profile", "/nix/var/nix/profiles/system")
+	profile, err := DefaultProfile()
 	if err != nil {
 		return "", err
 	}
-	sanitizedOutput := stripansi.Strip(string(out))
-	lines := strings.Split(strings.TrimSpace(sanitizedOutput), "\n")
-	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
-		lines[i], lines[j] = lines[j], lines[i]
+	fmt.Printf("Listing generations of %s...\n", profile)
+	gens, err := Generations(context.Background(), profile)
+	if err != nil {
+		return "", err
 	}
-	return strings.Join(lines, "\n"), nil
+	return GenerationsTable(gens), nil
 }
 
 // InstallNix ensures that Nix is installed on the system.
@@ -245,40 +425,36 @@ func InstallNix() error {
 
 // GetPendingActions returns a list of pending actions.
 func GetPendingActions() ([]string, error) {
-	flakePath := config.GetFlakePath()
+	path := config.GetInstallPath()
 	var actions []string
-	if hasUncommittedChanges(filepath.Dir(flakePath)) {
+	if dirty, err := GitStatus(path); err == nil && dirty {
 		actions = append(actions, "Uncommitted changes")
 	}
-	unpushedCommits, err := getUnpushedCommits(filepath.Dir(flakePath))
+	ahead, behind, err := aheadBehind(path)
 	if err != nil {
-		// Handle error, maybe log it
+		config.AddLogEntry(fmt.Sprintf("could not compare with the remote: %v", err))
+	}
+	if ahead > 0 {
+		actions = append(actions, fmt.Sprintf("%d unpushed commits", ahead))
 	}
-	if unpushedCommits > 0 {
-		actions = append(actions, fmt.Sprintf("%d unpushed commits", unpushedCommits))
+	if behind > 0 {
+		actions = append(actions, fmt.Sprintf("%d commits to pull", behind))
 	}
 	return actions, nil
 }
 
-// hasUncommittedChanges checks if there are uncommitted changes in the git repository.
-func hasUncommittedChanges(path string) bool {
-	cmd := exec.Command("git", "-C", path, "status", "--porcelain")
-	output, err := cmd.Output()
-	if err != nil {
-		// If git status fails, assume no changes to be safe
-		return false
+// aheadBehind counts the commits not yet pushed and those not yet pulled,
+// as of the last sync. A repository without a remote has neither.
+func aheadBehind(path string) (int, int, error) {
+	repo, err := vcs.Open(path)
+	if err == vcs.ErrNotRepository {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
 	}
-	return len(strings.TrimSpace(string(output))) > 0
-}
-
-// getUnpushedCommits checks if there are unpushed commits in the git repository.
-func getUnpushedCommits(path string) (int, error) {
-	cmd := exec.Command("git", "-C", path, "rev-list", "--count", "@{u}..")
-	output, err := cmd.Output()
-	if err != nil {
-		return 0, err
+	ahead, behind, err := repo.AheadBehind()
+	if err == vcs.ErrNoUpstream || err == vcs.ErrNoCommits {
+		return 0, 0, nil
 	}
-	count := 0
-	fmt.Sscanf(string(output), "%d", &count)
-	return count, nil
+	return ahead, behind, err
 }
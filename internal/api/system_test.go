@@ -0,0 +1,293 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"pilo/internal/config"
+	"pilo/internal/nix"
+	"pilo/internal/search"
+	"pilo/internal/vcs"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setupFakeEnv points the pilo install path at a temporary home directory,
+// writes a minimal base-config.json and installs a fake executor.
+func setupFakeEnv(t *testing.T, baseConfig string) *nix.FakeExecutor {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	writeFlakeFiles(t, baseConfig)
+
+	fake := nix.NewFakeExecutor()
+	SetExecutor(fake)
+	t.Cleanup(func() { SetExecutor(nil) })
+	return fake
+}
+
+// writeFlakeFiles writes the JSON configuration files into the flake directory.
+func writeFlakeFiles(t *testing.T, baseConfig string) {
+	t.Helper()
+	flakeDir := config.GetFlakePath()
+	if err := os.MkdirAll(flakeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"base-config.json": baseConfig,
+		"packages.json":    `{"packages": []}`,
+		"aliases.json":     `{}`,
+		"users.json":       `{"users": []}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(flakeDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNixCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func() (string, error)
+		want []string
+	}{
+		{
+			name: "gc",
+			run:  GC,
+			want: []string{"nix store gc"},
+		},
+		{
+			name: "upgrade",
+			run:  Upgrade,
+			want: []string{"nix flake update pilo"},
+		},
+		{
+			name: "update all inputs",
+			run:  func() (string, error) { return Update("") },
+			want: []string{"nix flake update --flake {flake}"},
+		},
+		{
+			name: "update single input",
+			run:  func() (string, error) { return Update("nixpkgs") },
+			want: []string{"nix flake lock --update-input nixpkgs --flake {flake}"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := setupFakeEnv(t, `{"commit_triggers": []}`)
+			if _, err := tt.run(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var want []string
+			for _, line := range tt.want {
+				want = append(want, expandFlake(line))
+			}
+			if got := fake.CommandLines(); !reflect.DeepEqual(got, want) {
+				t.Errorf("commands = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNixCommandFailure(t *testing.T) {
+	fake := setupFakeEnv(t, `{"commit_triggers": []}`)
+	fake.On("nix store gc", nix.FakeResult{Stderr: "error: cannot connect to daemon", ExitCode: 1})
+
+	if _, err := GC(); err == nil {
+		t.Fatal("expected an error from a failing command")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	fake := setupFakeEnv(t, `{"commit_triggers": []}`)
+	fake.On("nix search nixpkgs --json hello", nix.FakeResult{
+		Stdout: `evaluating...{"legacyPackages.x86_64-linux.hello": {"pname": "hello", "description": "A program that produces a familiar, friendly greeting"}}`,
+	})
+
+	pkgs, err := Search([]string{"hello"}, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pkgs) != 1 || pkgs[0].Name != "hello" {
+		t.Fatalf("unexpected search results: %+v", pkgs)
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	setupFakeEnv(t, `{"commit_triggers": []}`)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	flakeDir := config.GetFlakePath()
+	lock := `{"nodes": {"nixpkgs": {"locked": {"rev": "test-rev"}}, "root": {"inputs": {"nixpkgs": "nixpkgs"}}}, "root": "root"}`
+	if err := os.WriteFile(filepath.Join(flakeDir, "flake.lock"), []byte(lock), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(flakeDir, "packages.json"), []byte(`{"packages": [{"name": "kdePackages.kcalc", "installed": true}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx := &search.Index{Revision: "test-rev", Entries: []search.Entry{
+		{AttrPath: "kdePackages.kcalc", Pname: "kcalc", Version: "24.05", Description: "Calculator", License: []string{"GPL-2.0-or-later"}},
+		{AttrPath: "unfree-calc", Pname: "unfree-calc", Version: "1.0", Description: "Calculator", Unfree: true},
+	}}
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	pkgs, err := Search([]string{"calc"}, false, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pkgs) != 1 || pkgs[0].Name != "kdePackages.kcalc" || pkgs[0].Meta == nil || pkgs[0].Meta.Version != "24.05" {
+		t.Fatalf("unexpected search results: %+v", pkgs)
+	}
+
+	installed, err := GetInstalledPackages()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(installed) != 1 || installed[0].Meta == nil || installed[0].Meta.Pname != "kcalc" {
+		t.Fatalf("installed packages are missing metadata: %+v", installed)
+	}
+}
+
+func TestRunCommandAndCommit(t *testing.T) {
+	tests := []struct {
+		name       string
+		triggers   string
+		password   string
+		wantLines  []string
+		wantStdin0 string
+		wantCommit string
+	}{
+		{
+			name:      "no trigger",
+			triggers:  `[]`,
+			wantLines: []string{"home-manager switch"},
+		},
+		{
+			name:       "trigger commits",
+			triggers:   `["rebuild"]`,
+			wantLines:  []string{"home-manager switch"},
+			wantCommit: withTrailer("pilo: rebuild"),
+		},
+		{
+			name:       "sudo receives password",
+			triggers:   `[]`,
+			password:   "hunter2",
+			wantLines:  []string{"sudo -S home-manager switch"},
+			wantStdin0: "hunter2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := setupFakeEnv(t, `{"commit_triggers": `+tt.triggers+`}`)
+			repos := vcs.NewMemoryBackend()
+			vcs.SetBackend(repos)
+			t.Cleanup(func() { vcs.SetBackend(nil) })
+			repo, err := vcs.Init(config.GetInstallPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			repo.(*vcs.Memory).WriteFile("flake/packages.json", []byte(`{"packages": ["git"]}`))
+
+			if _, err := RunCommandAndCommit("rebuild", tt.password, "home-manager", "switch"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := fake.CommandLines(); !reflect.DeepEqual(got, tt.wantLines) {
+				t.Errorf("commands = %q, want %q", got, tt.wantLines)
+			}
+			if got := fake.Calls()[0].Stdin; got != tt.wantStdin0 {
+				t.Errorf("stdin = %q, want %q", got, tt.wantStdin0)
+			}
+			var got string
+			if log := repos.Repository(config.GetInstallPath()).Log(); len(log) > 0 {
+				got = log[0].Message
+			}
+			if got != tt.wantCommit {
+				t.Errorf("commit = %q, want %q", got, tt.wantCommit)
+			}
+		})
+	}
+}
+
+func expandFlake(line string) string {
+	home, _ := os.UserHomeDir()
+	return strings.ReplaceAll(line, "{flake}", filepath.Join(home, ".config", "pilo", "flake"))
+}
+
+func TestRebuildArgs(t *testing.T) {
+	tests := []struct {
+		nixMode nix.NixMode
+		mode    RebuildMode
+		want    string
+		wantErr bool
+	}{
+		{nix.NixOS, RebuildSwitch, "nixos-rebuild switch --flake /f#nixos", false},
+		{nix.NixOS, RebuildBoot, "nixos-rebuild boot --flake /f#nixos", false},
+		{nix.NixOS, RebuildBuild, "nixos-rebuild build --flake /f#nixos", false},
+		{nix.NixOS, RebuildDryRun, "nixos-rebuild dry-build --flake /f#nixos", false},
+		{nix.MultiUser, RebuildSwitch, "home-manager switch --flake /f#nixos", false},
+		{nix.MultiUser, RebuildBuild, "home-manager build --no-out-link --flake /f#nixos", false},
+		{nix.SingleUser, RebuildDryRun, "home-manager build --dry-run --no-out-link --flake /f#nixos", false},
+		{nix.MultiUser, RebuildBoot, "", true},
+		{nix.NixOS, "reboot", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.nixMode)+"/"+string(tt.mode), func(t *testing.T) {
+			args, err := rebuildArgs(tt.nixMode, tt.mode, "/f#nixos")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := strings.Join(args, " "); got != tt.want {
+				t.Errorf("args = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDryRunNeverCommits(t *testing.T) {
+	fake := setupFakeEnv(t, `{"commit_triggers": ["rebuild", "dry-run"]}`)
+	vcs.SetBackend(vcs.NewMemoryBackend())
+	t.Cleanup(func() { vcs.SetBackend(nil) })
+	repo, err := vcs.Init(config.GetInstallPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := repo.(*vcs.Memory)
+	memory.WriteFile("flake/packages.json", []byte(`{"packages": []}`))
+	memory.AddAll()
+	base, err := memory.Commit("base", vcs.CommitOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An uncommitted change that a triggered commit would pick up.
+	memory.WriteFile("flake/packages.json", []byte(`{"packages": ["git"]}`))
+
+	if _, err := RunCommandAndCommit(RebuildDryRun.commandName(), "", "home-manager", "build", "--dry-run"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"home-manager build --dry-run"}
+	if got := fake.CommandLines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+	if head, err := repo.Head(); err != nil || head != base {
+		t.Fatalf("head after a dry run = %q, %v, want %q", head, err, base)
+	}
+
+	if _, err := RunCommandAndCommit(RebuildSwitch.commandName(), "", "home-manager", "switch"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if head, err := repo.Head(); err != nil || head == base {
+		t.Errorf("head after a switch = %q, %v, want a new commit", head, err)
+	}
+}
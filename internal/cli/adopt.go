@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"pilo/internal/api"
+	"pilo/internal/config"
+	"pilo/internal/nix"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/spf13/cobra"
+)
+
+var adoptCmd = &cobra.Command{
+	Use:   "adopt",
+	Short: "Moves packages installed with nix profile into your configuration",
+	Long: `This command lists the packages installed into your user profile with
+"nix profile install" that packages.json does not track, and offers to move
+them into the declarative configuration.
+
+Adopted packages are added to packages.json in one commit, removed from the
+imperative profile and the configuration is rebuilt. If the rebuild fails, they
+are installed into the profile again.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := interruptContext()
+		defer stop()
+
+		untracked, err := api.UntrackedPackages(ctx)
+		if err != nil {
+			fmt.Println("Error inspecting your profile:", err)
+			os.Exit(1)
+		}
+		if len(untracked) == 0 {
+			fmt.Println("Every package in your profile is tracked by your configuration.")
+			return
+		}
+		fmt.Printf("%d package(s) in your profile are not in packages.json:\n", len(untracked))
+		for _, e := range untracked {
+			fmt.Printf("  %s (from %s)\n", e.AttrPath, e.OriginalURL)
+		}
+		if list, _ := cmd.Flags().GetBool("list"); list {
+			return
+		}
+
+		if yes, _ := cmd.Flags().GetBool("yes"); !yes {
+			confirm := false
+			prompt := &survey.Confirm{
+				Message: "Move them into your configuration?",
+				Default: true,
+			}
+			if err := survey.AskOne(prompt, &confirm); err != nil || !confirm {
+				fmt.Println("Adopt cancelled.")
+				return
+			}
+		}
+
+		opts := api.RebuildOptions{
+			FlakePath:  config.GetFlakePath(),
+			OnOutput:   printLine,
+			OnProgress: printProgress,
+		}
+		if nix.GetNixMode() == nix.NixOS {
+			prompt := &survey.Password{
+				Message: "Please enter your password:",
+			}
+			survey.AskOne(prompt, &opts.Password)
+		}
+
+		result, _, err := api.AdoptPackages(ctx, untracked, opts)
+		clearProgress()
+		if result != nil {
+			printPackageWarnings(result.Warnings)
+			if len(result.Added) > 0 {
+				fmt.Printf("Adopted %s.\n", strings.Join(result.Added, ", "))
+			}
+			for _, missing := range result.NotFound {
+				fmt.Println("Left in your profile:", missing)
+			}
+		}
+		if err != nil {
+			fmt.Println("Error adopting packages:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(adoptCmd)
+	adoptCmd.Flags().BoolP("list", "l", false, "Only list the untracked packages")
+	adoptCmd.Flags().BoolP("yes", "y", false, "Adopt without asking for confirmation")
+}
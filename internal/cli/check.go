@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"pilo/internal/api"
+	"pilo/internal/spinner"
+
+	"github.com/spf13/cobra"
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Checks the packages in your configuration",
+	Long: `This command checks that every package in packages.json exists in its
+source for the configured system type, and warns about packages that are
+unfree, broken, insecure or unsupported on this platform.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := interruptContext()
+		defer stop()
+
+		spinner := spinner.NewSpinner("Checking packages...")
+		spinner.Start()
+		checks, err := api.CheckPackages(ctx)
+		spinner.Stop()
+		if err != nil {
+			fmt.Println("Error checking packages:", err)
+			os.Exit(1)
+		}
+
+		missing := 0
+		for _, check := range checks {
+			if !check.Found {
+				missing++
+				err := &api.PackageNotFoundError{Name: check.Name, Source: check.Source, Suggestions: check.Suggestions}
+				fmt.Printf("✗ %s\n", err)
+				continue
+			}
+			if len(check.Warnings) == 0 {
+				fmt.Printf("✓ %s\n", check.Name)
+				continue
+			}
+			fmt.Printf("! %s: %s\n", check.Name, strings.Join(check.Warnings, "; "))
+		}
+		if missing > 0 {
+			fmt.Printf("%d package(s) could not be found.\n", missing)
+			os.Exit(1)
+		}
+	},
+}
+
+// printPackageWarnings prints the warnings raised while adding packages.
+func printPackageWarnings(warnings []string) {
+	for _, w := range warnings {
+		fmt.Println("Warning:", w)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+}
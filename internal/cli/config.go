@@ -0,0 +1,222 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"pilo/internal/api"
+	"pilo/internal/config"
+	"pilo/internal/schema"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/spf13/cobra"
+)
+
+var getConfigCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the value of a setting.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setting, err := config.GetSetting(args[0])
+		if err != nil {
+			fmt.Println("Error reading setting:", err)
+			os.Exit(1)
+		}
+		fmt.Println(formatSettingValue(setting.Value))
+	},
+}
+
+var setConfigCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Change a setting.",
+	Long: `This command changes a setting. Lists such as commit_triggers or system.groups
+take comma-separated values or a JSON array, and booleans take true or false.
+Changes to base-config.json are checked against its schema and committed.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		setting, err := api.SetSetting(args[0], args[1])
+		if err != nil {
+			fmt.Println("Error changing setting:", settingError(err))
+			os.Exit(1)
+		}
+		fmt.Printf("%s set to: %s\n", setting.Key, formatSettingValue(setting.Value))
+	},
+}
+
+var unsetConfigCmd = &cobra.Command{
+	Use:   "unset <key>",
+	Short: "Reset a setting to its default.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setting, err := api.UnsetSetting(args[0])
+		if err != nil {
+			fmt.Println("Error resetting setting:", settingError(err))
+			os.Exit(1)
+		}
+		fmt.Printf("%s reset to: %s\n", setting.Key, formatSettingValue(setting.Value))
+	},
+}
+
+var listConfigCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List every setting and its value.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		settings, err := config.Settings()
+		if err != nil {
+			fmt.Println("Error reading settings:", err)
+			os.Exit(1)
+		}
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			data, err := json.MarshalIndent(settings, "", "  ")
+			if err != nil {
+				fmt.Println("Error encoding settings:", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+			return
+		}
+		store := ""
+		for _, s := range settings {
+			if s.Store != store {
+				if store != "" {
+					fmt.Println()
+				}
+				store = s.Store
+				fmt.Printf("# %s\n", store)
+			}
+			fmt.Printf("%s = %s", s.Key, formatSettingValue(s.Value))
+			if s.Env != "" {
+				fmt.Printf("  (from %s)", s.Env)
+			}
+			fmt.Println()
+		}
+	},
+}
+
+var editConfigCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Edit base-config.json in your editor.",
+	Long: `This command opens base-config.json in $VISUAL or $EDITOR, falling back to vi.
+The file is checked against its schema when the editor exits; an invalid file
+is not saved, and you can go back to fix it. A valid file is committed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := editBaseConfig(); err != nil {
+			fmt.Println("Error editing configuration:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// editBaseConfig edits a copy of base-config.json so the real file only ever
+// holds a valid configuration.
+func editBaseConfig() error {
+	original, err := os.ReadFile(filepath.Join(config.GetFlakePath(), "base-config.json"))
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "base-config-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(original); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	for {
+		if err := runEditor(tmp.Name()); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(tmp.Name())
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(data, original) {
+			fmt.Println("No changes made.")
+			return nil
+		}
+
+		err = api.SaveBaseConfig(data)
+		var invalid *schema.Error
+		if !errors.As(err, &invalid) {
+			if err == nil {
+				fmt.Println("Configuration saved.")
+			}
+			return err
+		}
+		for _, p := range invalid.Problems {
+			fmt.Println(p)
+		}
+		again := true
+		prompt := &survey.Confirm{Message: "The configuration is invalid. Edit it again?", Default: true}
+		if err := survey.AskOne(prompt, &again); err != nil || !again {
+			return fmt.Errorf("changes discarded")
+		}
+	}
+}
+
+// runEditor opens path in the user's editor and waits for it to exit.
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// The editor may come with arguments, such as "code --wait".
+	fields := strings.Fields(editor)
+	c := exec.Command(fields[0], append(fields[1:], path)...)
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w", fields[0], err)
+	}
+	return nil
+}
+
+// settingError describes err without the file positions of schema problems,
+// which point into a base-config.json that was never written.
+func settingError(err error) string {
+	var invalid *schema.Error
+	if !errors.As(err, &invalid) {
+		return err.Error()
+	}
+	msgs := make([]string, len(invalid.Problems))
+	for i, p := range invalid.Problems {
+		msgs[i] = p.Path + ": " + p.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// formatSettingValue prints strings as they are and anything else as JSON.
+func formatSettingValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func init() {
+	configCmd.AddCommand(getConfigCmd)
+	configCmd.AddCommand(setConfigCmd)
+	configCmd.AddCommand(unsetConfigCmd)
+	configCmd.AddCommand(listConfigCmd)
+	configCmd.AddCommand(editConfigCmd)
+	listConfigCmd.Flags().Bool("json", false, "Print the settings as JSON")
+}
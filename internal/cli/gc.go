@@ -5,20 +5,47 @@ import (
 	"os"
 
 	"pilo/internal/api"
-	"pilo/internal/spinner"
 
+	"github.com/AlecAivazis/survey/v2"
 	"github.com/spf13/cobra"
 )
 
 var gcCmd = &cobra.Command{
 	Use:   "gc",
 	Short: "Runs the garbage collector to free up disk space.",
-	Long:  `This command runs the garbage collector to free up disk space.`,
+	Long: `This command deletes the generations that fall outside the "gc" policy in
+base-config.json and then runs the garbage collector to free up disk space.
+Without a "gc" section no generations are deleted. What would be deleted is
+shown for confirmation first; pass --yes to skip this step, or --dry-run to
+only show it.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		spinner := spinner.NewSpinner("Running garbage collector...")
-		spinner.Start()
-		defer spinner.Stop()
-		if _, err := api.GC(); err != nil {
+		ctx, stop := interruptContext()
+		defer stop()
+		plan, err := api.PlanGC(ctx)
+		if err != nil {
+			fmt.Println("Error planning garbage collection:", err)
+			os.Exit(1)
+		}
+		fmt.Print(plan.Summary())
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			return
+		}
+		if yes, _ := cmd.Flags().GetBool("yes"); !yes {
+			confirm := false
+			prompt := &survey.Confirm{
+				Message: "Run garbage collection?",
+			}
+			if err := survey.AskOne(prompt, &confirm); err != nil || !confirm {
+				fmt.Println("Garbage collection cancelled.")
+				return
+			}
+		}
+
+		var password string
+		if plan.NeedsRoot() {
+			survey.AskOne(&survey.Password{Message: "Please enter your password:"}, &password)
+		}
+		if _, err := api.RunGC(ctx, plan, password, printLine); err != nil {
 			fmt.Println("Error running garbage collector:", err)
 			os.Exit(1)
 		}
@@ -27,5 +54,7 @@ var gcCmd = &cobra.Command{
 }
 
 func init() {
+	gcCmd.Flags().Bool("dry-run", false, "Show what would be deleted without deleting anything")
+	gcCmd.Flags().BoolP("yes", "y", false, "Collect without asking for confirmation")
 	rootCmd.AddCommand(gcCmd)
 }
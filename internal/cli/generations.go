@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"pilo/internal/api"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/spf13/cobra"
+)
+
+var generationsCmd = &cobra.Command{
+	Use:   "generations",
+	Short: "Manage system or Home Manager generations",
+	Long: `Manage the generations of the NixOS system profile or the Home Manager profile.
+
+By default the profile that "pilo rebuild" switches is used. Pass --profile
+system or --profile home to pick one explicitly.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var listGenerationsCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List generations, newest first",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		profile := generationsProfile(cmd)
+		ctx, stop := interruptContext()
+		defer stop()
+		gens, err := api.Generations(ctx, profile)
+		if err != nil {
+			fmt.Println("Error listing generations:", err)
+			os.Exit(1)
+		}
+		fmt.Print(api.GenerationsTable(gens))
+	},
+}
+
+var diffGenerationsCmd = &cobra.Command{
+	Use:   "diff [from] [to]",
+	Short: "Show the package changes between two generations",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		profile := generationsProfile(cmd)
+		from, to := parseGeneration(args[0]), parseGeneration(args[1])
+		ctx, stop := interruptContext()
+		defer stop()
+		diff, err := api.DiffGenerations(ctx, profile, from, to)
+		if err != nil {
+			fmt.Println("Error comparing generations:", err)
+			os.Exit(1)
+		}
+		fmt.Print(diff.Table())
+	},
+}
+
+var switchGenerationCmd = &cobra.Command{
+	Use:   "switch [generation]",
+	Short: "Switch to a generation",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		profile := generationsProfile(cmd)
+		n := parseGeneration(args[0])
+		out, err := api.SwitchGeneration(profile, n, generationsPassword(profile))
+		if err != nil {
+			fmt.Println("Error switching generation:", err)
+			fmt.Println(out)
+			os.Exit(1)
+		}
+		fmt.Printf("Switched to generation %d.\n", n)
+	},
+}
+
+var deleteGenerationsCmd = &cobra.Command{
+	Use:   "delete [generation|from..to]...",
+	Short: "Delete generations",
+	Long:  `Delete one or more generations, given as numbers or inclusive ranges such as 3..7. The current generation and pinned generations are never deleted.`,
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		profile := generationsProfile(cmd)
+		var numbers []int
+		for _, arg := range args {
+			numbers = append(numbers, parseGenerationRange(arg)...)
+		}
+		out, err := api.DeleteGenerations(profile, numbers, generationsPassword(profile))
+		if err != nil {
+			fmt.Println("Error deleting generations:", err)
+			fmt.Println(out)
+			os.Exit(1)
+		}
+		fmt.Printf("Deleted %d generation(s).\n", len(numbers))
+	},
+}
+
+var pinGenerationCmd = &cobra.Command{
+	Use:   "pin [generation]",
+	Short: "Protect a generation from deletion and garbage collection",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		n := parseGeneration(args[0])
+		if err := api.PinGeneration(generationsProfile(cmd), n); err != nil {
+			fmt.Println("Error pinning generation:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Generation %d pinned.\n", n)
+	},
+}
+
+var unpinGenerationCmd = &cobra.Command{
+	Use:   "unpin [generation]",
+	Short: "Remove the pin from a generation",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		n := parseGeneration(args[0])
+		if err := api.UnpinGeneration(generationsProfile(cmd), n); err != nil {
+			fmt.Println("Error unpinning generation:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Generation %d unpinned.\n", n)
+	},
+}
+
+// generationsProfile returns the profile selected with --profile.
+func generationsProfile(cmd *cobra.Command) api.Profile {
+	name, _ := cmd.Flags().GetString("profile")
+	profile, err := api.ProfileByName(name)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	return profile
+}
+
+// generationsPassword asks for the sudo password when profile belongs to root.
+func generationsPassword(profile api.Profile) string {
+	var password string
+	if profile.IsSystem() {
+		survey.AskOne(&survey.Password{Message: "Please enter your password:"}, &password)
+	}
+	return password
+}
+
+func parseGeneration(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		fmt.Printf("Invalid generation number: %s\n", s)
+		os.Exit(1)
+	}
+	return n
+}
+
+// parseGenerationRange expands "N" or "N..M" into generation numbers.
+func parseGenerationRange(s string) []int {
+	from, to, isRange := strings.Cut(s, "..")
+	if !isRange {
+		return []int{parseGeneration(s)}
+	}
+	a, b := parseGeneration(from), parseGeneration(to)
+	if a > b {
+		a, b = b, a
+	}
+	var numbers []int
+	for n := a; n <= b; n++ {
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+func init() {
+	rootCmd.AddCommand(generationsCmd)
+	generationsCmd.PersistentFlags().String("profile", "", `Profile to manage: "system" or "home"`)
+	generationsCmd.AddCommand(listGenerationsCmd)
+	generationsCmd.AddCommand(diffGenerationsCmd)
+	generationsCmd.AddCommand(switchGenerationCmd)
+	generationsCmd.AddCommand(deleteGenerationsCmd)
+	generationsCmd.AddCommand(pinGenerationCmd)
+	generationsCmd.AddCommand(unpinGenerationCmd)
+}
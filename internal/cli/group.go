@@ -0,0 +1,169 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"pilo/internal/api"
+
+	"github.com/spf13/cobra"
+)
+
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Manage package groups",
+	Long: `Manage the package groups in packages.json.
+
+A group, such as "core", "dev-go" or "media", is switched on or off as a whole.
+Packages without a group are always used. A host uses every enabled group
+unless "pilo group use" selects its groups, which lets one configuration serve
+both a workstation and a lightweight laptop. Run "pilo rebuild" to apply a
+change.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var listGroupCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List package groups and their packages",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		groups, err := api.ListPackageGroups()
+		if err != nil {
+			fmt.Println("Error listing package groups:", err)
+			os.Exit(1)
+		}
+		if len(groups) == 0 {
+			fmt.Println("No package groups defined.")
+			return
+		}
+		for _, g := range groups {
+			state := "unused"
+			if g.Used {
+				state = "used"
+			}
+			if !g.Enabled {
+				state += ", disabled"
+			}
+			fmt.Printf("%s (%s)", g.Name, state)
+			if g.Description != "" {
+				fmt.Printf(" - %s", g.Description)
+			}
+			fmt.Println()
+			if len(g.Packages) > 0 {
+				fmt.Printf("  %s\n", strings.Join(g.Packages, " "))
+			}
+		}
+	},
+}
+
+var addGroupCmd = &cobra.Command{
+	Use:   "add <group> [package]...",
+	Short: "Create a group and move packages into it",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		description, _ := cmd.Flags().GetString("description")
+		if err := api.AddPackageGroup(args[0], description, args[1:]); err != nil {
+			fmt.Println("Error updating package group:", err)
+			os.Exit(1)
+		}
+		if len(args) > 1 {
+			fmt.Printf("Moved %d package(s) to group %s.\n", len(args)-1, args[0])
+		} else {
+			fmt.Printf("Package group %s is ready.\n", args[0])
+		}
+	},
+}
+
+var removeGroupCmd = &cobra.Command{
+	Use:   "remove <group> [package]...",
+	Short: "Take packages out of a group, or delete the group",
+	Long: `Take the given packages out of a group. Without packages the group itself is
+deleted; its packages stay in the configuration without a group.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := api.RemovePackageGroup(args[0], args[1:]); err != nil {
+			fmt.Println("Error updating package group:", err)
+			os.Exit(1)
+		}
+		if len(args) > 1 {
+			fmt.Printf("Moved %d package(s) out of group %s.\n", len(args)-1, args[0])
+		} else {
+			fmt.Printf("Removed package group %s.\n", args[0])
+		}
+	},
+}
+
+var enableGroupCmd = &cobra.Command{
+	Use:   "enable <group>...",
+	Short: "Enable package groups",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setGroupsEnabled(args, true)
+	},
+}
+
+var disableGroupCmd = &cobra.Command{
+	Use:   "disable <group>...",
+	Short: "Disable package groups without removing their packages",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setGroupsEnabled(args, false)
+	},
+}
+
+var useGroupCmd = &cobra.Command{
+	Use:   "use [group]...",
+	Short: "Select the package groups the hosts use",
+	Long: `Record which package groups are used, regardless of whether they are enabled.
+The selection goes to base-config.json, or with --host to the base-config.json
+of that host in hosts/<host>. Without groups, the hosts go back to using every
+enabled group.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		host, _ := cmd.Flags().GetString("host")
+		if err := api.SetHostPackageGroups(host, args); err != nil {
+			fmt.Println("Error selecting package groups:", err)
+			os.Exit(1)
+		}
+		who := "Hosts without their own selection now use"
+		if host != "" {
+			who = "Host " + host + " now uses"
+		}
+		if len(args) == 0 {
+			fmt.Printf("%s every enabled package group.\n", who)
+		} else {
+			fmt.Printf("%s the package groups %s.\n", who, strings.Join(args, ", "))
+		}
+		fmt.Println("Run 'pilo rebuild' to apply the changes.")
+	},
+}
+
+// setGroupsEnabled enables or disables each group, one commit each.
+func setGroupsEnabled(names []string, enabled bool) {
+	action := "Disabled"
+	if enabled {
+		action = "Enabled"
+	}
+	for _, name := range names {
+		if err := api.SetPackageGroupEnabled(name, enabled); err != nil {
+			fmt.Printf("Error updating package group %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s package group %s.\n", action, name)
+	}
+	fmt.Println("Run 'pilo rebuild' to apply the changes.")
+}
+
+func init() {
+	rootCmd.AddCommand(groupCmd)
+	groupCmd.AddCommand(listGroupCmd)
+	groupCmd.AddCommand(addGroupCmd)
+	groupCmd.AddCommand(removeGroupCmd)
+	groupCmd.AddCommand(enableGroupCmd)
+	groupCmd.AddCommand(disableGroupCmd)
+	groupCmd.AddCommand(useGroupCmd)
+	addGroupCmd.Flags().String("description", "", "Describe what the group is for")
+	useGroupCmd.Flags().String("host", "", "Select the groups for this host only")
+}
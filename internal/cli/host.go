@@ -0,0 +1,97 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"pilo/internal/api"
+	"pilo/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+var hostCmd = &cobra.Command{
+	Use:   "host",
+	Short: "Manage the hosts that share this configuration",
+	Long: `Manage the NixOS hosts in the flake's hosts directory.
+
+Each host lives in hosts/<hostname> with its own hardware configuration and
+imports the modules in hosts/common. A host may also have a base-config.json
+whose settings override the top-level one, such as a different desktop or
+package groups. "pilo rebuild" builds the host named after this machine's
+hostname, falling back to "` + config.DefaultHost + `"; pass --host to pick another.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var listHostCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the hosts",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		hosts, err := api.ListHosts()
+		if err != nil {
+			fmt.Println("Error listing hosts:", err)
+			os.Exit(1)
+		}
+		for _, h := range hosts {
+			marker := " "
+			if h.Current {
+				marker = "*"
+			}
+			var notes []string
+			if !h.HardwareConfig {
+				notes = append(notes, "no hardware configuration")
+			}
+			if h.Overrides {
+				notes = append(notes, "own base config")
+			}
+			if len(notes) > 0 {
+				fmt.Printf("%s %s (%s)\n", marker, h.Name, strings.Join(notes, ", "))
+			} else {
+				fmt.Printf("%s %s\n", marker, h.Name)
+			}
+		}
+	},
+}
+
+var addHostCmd = &cobra.Command{
+	Use:   "add [hostname]",
+	Short: "Add this machine as a host",
+	Long: `Add this machine as a host, capturing its hardware configuration from
+/etc/nixos into hosts/<hostname>. The hostname defaults to this machine's.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := ""
+		if len(args) > 0 {
+			name = args[0]
+		}
+		if err := api.AddHost(name); err != nil {
+			fmt.Println("Error adding host:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Host added. Run 'pilo rebuild' on it to apply its configuration.")
+	},
+}
+
+var removeHostCmd = &cobra.Command{
+	Use:   "remove <hostname>",
+	Short: "Remove a host",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := api.RemoveHost(args[0]); err != nil {
+			fmt.Println("Error removing host:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed host %s.\n", args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(hostCmd)
+	hostCmd.AddCommand(listHostCmd)
+	hostCmd.AddCommand(addHostCmd)
+	hostCmd.AddCommand(removeHostCmd)
+}
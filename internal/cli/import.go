@@ -0,0 +1,110 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"pilo/internal/api"
+	"pilo/internal/importer"
+
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Imports packages from another package list",
+	Long: `This command reads a package list kept by another tool and adds the
+packages it names to packages.json in a single commit.
+
+Supported formats are the output of "nix profile list --json", a NixOS
+configuration.nix with an environment.systemPackages list, a Brewfile and a
+plain text file with one or more names per line. The format is guessed from the
+file name unless --format is given. With --from-profile the packages of your
+current nix profile are imported.
+
+Packages that are not found in nixpkgs are reported and skipped.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fromProfile, _ := cmd.Flags().GetBool("from-profile")
+		formatFlag, _ := cmd.Flags().GetString("format")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		var data []byte
+		var format importer.Format
+		switch {
+		case fromProfile && len(args) == 0:
+			ctx, stop := interruptContext()
+			defer stop()
+			out, err := api.ProfileListJSON(ctx)
+			if err != nil {
+				fmt.Println("Error listing your nix profile:", err)
+				os.Exit(1)
+			}
+			data, format = out, importer.NixProfile
+		case !fromProfile && len(args) == 1:
+			var err error
+			data, err = os.ReadFile(args[0])
+			if err != nil {
+				fmt.Println("Error reading package list:", err)
+				os.Exit(1)
+			}
+			format = importer.DetectFormat(args[0])
+		default:
+			fmt.Println("Pass either a file to import or --from-profile.")
+			os.Exit(1)
+		}
+		if formatFlag != "" {
+			format = importer.Format(formatFlag)
+		}
+
+		names, err := importer.Parse(format, data)
+		if err != nil {
+			fmt.Println("Error reading package list:", err)
+			os.Exit(1)
+		}
+		if len(names) == 0 {
+			fmt.Println("No packages found.")
+			return
+		}
+		if dryRun {
+			fmt.Printf("Found %d package(s):\n", len(names))
+			for _, name := range names {
+				fmt.Println("  " + name)
+			}
+			return
+		}
+
+		result, err := api.ImportPackages(names)
+		if result != nil {
+			printPackageWarnings(result.Warnings)
+			if len(result.Added) > 0 {
+				fmt.Printf("Imported %d package(s): %s\n", len(result.Added), strings.Join(result.Added, ", "))
+			}
+			if len(result.Existing) > 0 {
+				fmt.Printf("Already configured: %s\n", strings.Join(result.Existing, ", "))
+			}
+			for _, missing := range result.NotFound {
+				fmt.Println("Skipped:", missing)
+			}
+		}
+		if err != nil {
+			fmt.Println("Error importing packages:", err)
+			os.Exit(1)
+		}
+		if result != nil && len(result.Added) > 0 {
+			fmt.Println("Run 'pilo rebuild' to apply the changes.")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	formats := make([]string, len(importer.Formats))
+	for i, f := range importer.Formats {
+		formats[i] = string(f)
+	}
+	importCmd.Flags().String("format", "", "Format of the package list: "+strings.Join(formats, ", "))
+	importCmd.Flags().Bool("from-profile", false, "Import the packages of your current nix profile")
+	importCmd.Flags().Bool("dry-run", false, "Only list the packages that would be imported")
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"pilo/internal/api"
+	"pilo/internal/config"
 
 	"github.com/spf13/cobra"
 )
@@ -13,9 +14,34 @@ import (
 var installPkgCmd = &cobra.Command{
 	Use:   "install-pkg [pkg...]",
 	Short: "Installs packages to your user profile (non-NixOS) or provides a temporary shell (NixOS).",
-	Long:  `This command installs packages to your user profile (non-NixOS) or provides a temporary shell (NixOS).`,
-	Args:  cobra.MinimumNArgs(1),
+	Long: `This command installs packages to your user profile (non-NixOS) or provides a temporary shell (NixOS).
+
+With --unstable or --source, the packages are added to your configuration
+instead, taken from nixpkgs-unstable or the named flake input, and the system
+is rebuilt.`,
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		source, _ := cmd.Flags().GetString("source")
+		if unstable, _ := cmd.Flags().GetBool("unstable"); unstable {
+			source = config.SourceUnstable
+		}
+		if source != "" {
+			warnings, err := api.AddPackagesFromSource(args, source)
+			printPackageWarnings(warnings)
+			if err != nil {
+				fmt.Println("Error adding packages:", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Added %s from %s.\n", strings.Join(args, ", "), source)
+			fmt.Println("Rebuilding system...")
+			if _, err := api.Rebuild("", "", "", ""); err != nil {
+				fmt.Printf("Error rebuilding system: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println("System rebuilt successfully.")
+			return
+		}
+
 		for _, pkg := range args {
 			if strings.HasPrefix(pkg, "github:") {
 				if err := api.AddGitPackage(pkg); err != nil {
@@ -46,4 +72,7 @@ var installPkgCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(installPkgCmd)
+	installPkgCmd.Flags().Bool("unstable", false, "Add the packages to your configuration from nixpkgs-unstable")
+	installPkgCmd.Flags().String("source", "", "Add the packages to your configuration from this source: stable, unstable or a flake input")
+	installPkgCmd.MarkFlagsMutuallyExclusive("unstable", "source")
 }
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"pilo/internal/nix"
+)
+
+// interruptContext returns a context that is cancelled when the user presses
+// Ctrl+C, so long-running nix commands can be stopped cleanly.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// clearLine moves the cursor to the start of the line and erases it.
+const clearLine = "\r\033[K"
+
+var (
+	outputMu sync.Mutex
+	// status is the progress line currently shown below the command output.
+	status string
+)
+
+// printLine prints a line of command output as soon as it is produced,
+// keeping the progress status line, if any, below it.
+func printLine(line string) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if status != "" {
+		fmt.Print(clearLine)
+	}
+	fmt.Println(line)
+	if status != "" {
+		fmt.Print(status)
+	}
+}
+
+// printProgress replaces the status line with a summary of p. It does nothing
+// when stdout is not a terminal.
+func printProgress(p nix.Progress) {
+	if !isTerminal(os.Stdout) {
+		return
+	}
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	status = p.String()
+	if f := p.Fraction(); f >= 0 {
+		status = fmt.Sprintf("[%3.0f%%] %s", f*100, status)
+	}
+	fmt.Print(clearLine + status)
+}
+
+// clearProgress removes the status line once the command has finished.
+func clearProgress() {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if status != "" {
+		fmt.Print(clearLine)
+		status = ""
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
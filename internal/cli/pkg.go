@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"pilo/internal/api"
+
+	"github.com/spf13/cobra"
+)
+
+var pkgCmd = &cobra.Command{
+	Use:   "pkg",
+	Short: "Manage the packages in your configuration",
+	Long: `Manage the packages recorded in packages.json.
+
+Disabled packages stay in the configuration but are left out of the system
+until they are enabled again. Run "pilo rebuild" to apply the change.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var addPkgCmd = &cobra.Command{
+	Use:   "add <name>...",
+	Short: "Add packages to the configuration in a single commit",
+	Long: `Add packages to packages.json in a single commit. Every package is checked
+first, and nothing is added unless all of them exist.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		source, _ := cmd.Flags().GetString("source")
+		warnings, err := api.AddPackagesFromSource(args, source)
+		printPackageWarnings(warnings)
+		if err != nil {
+			fmt.Println("Error adding packages:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Added %d package(s). Run 'pilo rebuild' to apply the changes.\n", len(args))
+	},
+}
+
+var removeFromConfigPkgCmd = &cobra.Command{
+	Use:   "remove <name>...",
+	Short: "Remove packages from the configuration in a single commit",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := api.RemovePackages(args); err != nil {
+			fmt.Println("Error removing packages:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed %d package(s). Run 'pilo rebuild' to apply the changes.\n", len(args))
+	},
+}
+
+var enablePkgCmd = &cobra.Command{
+	Use:   "enable <name>...",
+	Short: "Enable disabled packages",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setPackagesInstalled(args, true)
+	},
+}
+
+var disablePkgCmd = &cobra.Command{
+	Use:   "disable <name>...",
+	Short: "Disable packages without removing them from the configuration",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setPackagesInstalled(args, false)
+	},
+}
+
+// setPackagesInstalled enables or disables each package, one commit each.
+func setPackagesInstalled(names []string, installed bool) {
+	action := "Disabled"
+	if installed {
+		action = "Enabled"
+	}
+	for _, name := range names {
+		if err := api.SetPackageInstalled(name, installed); err != nil {
+			fmt.Printf("Error updating package %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s package %s.\n", action, name)
+	}
+	fmt.Println("Run 'pilo rebuild' to apply the changes.")
+}
+
+func init() {
+	rootCmd.AddCommand(pkgCmd)
+	pkgCmd.AddCommand(addPkgCmd)
+	pkgCmd.AddCommand(removeFromConfigPkgCmd)
+	pkgCmd.AddCommand(enablePkgCmd)
+	pkgCmd.AddCommand(disablePkgCmd)
+	addPkgCmd.Flags().String("source", "", "Take the packages from this source: stable, unstable or a flake input")
+}
@@ -0,0 +1,28 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/AlecAivazis/survey/v2"
+)
+
+// terminalPrompter asks for SSH key passphrases and confirms unknown hosts
+// on the terminal, the way ssh does.
+type terminalPrompter struct{}
+
+func (terminalPrompter) Passphrase(path string) (string, error) {
+	var passphrase string
+	err := survey.AskOne(&survey.Password{Message: fmt.Sprintf("Enter passphrase for key '%s':", path)}, &passphrase)
+	return passphrase, err
+}
+
+func (terminalPrompter) ConfirmHostKey(host, keyType, fingerprint string) (bool, error) {
+	fmt.Printf("The authenticity of host '%s' can't be established.\n", host)
+	fmt.Printf("%s key fingerprint is %s.\n", keyType, fingerprint)
+	trust := false
+	prompt := &survey.Confirm{
+		Message: "Are you sure you want to continue connecting and add it to known_hosts?",
+	}
+	err := survey.AskOne(prompt, &trust)
+	return trust, err
+}
@@ -16,7 +16,18 @@ import (
 var rebuildCmd = &cobra.Command{
 	Use:   "rebuild",
 	Short: "Rebuilds your NixOS or Home Manager configuration.",
-	Long:  `This command rebuilds your NixOS or Home Manager configuration.`,
+	Long: `This command rebuilds your NixOS or Home Manager configuration.
+
+By default the new configuration is activated immediately. Use --boot to make it
+the default at next boot (NixOS only), --build-only to build it without
+activating it, or --dry-run to only check that it evaluates and show what would
+be built. Build-only and dry runs do not commit the rebuild.
+
+On NixOS the host named after this machine's hostname is built, or the default
+host if the flake has no such host. Use --host to build another one.
+
+Before switching, the new configuration is built and the packages it adds,
+removes or upgrades are shown for confirmation. Pass --yes to skip this step.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		flakePath, _ := cmd.Flags().GetString("flake")
 		if flakePath == "" {
@@ -24,30 +35,67 @@ var rebuildCmd = &cobra.Command{
 		}
 		nixpkgsURL, _ := cmd.Flags().GetString("nixpkgs")
 		homeManagerURL, _ := cmd.Flags().GetString("home-manager")
+		host, _ := cmd.Flags().GetString("host")
+		mode := api.RebuildSwitch
+		if boot, _ := cmd.Flags().GetBool("boot"); boot {
+			mode = api.RebuildBoot
+		}
+		if buildOnly, _ := cmd.Flags().GetBool("build-only"); buildOnly {
+			mode = api.RebuildBuild
+		}
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+			mode = api.RebuildDryRun
+		}
+
+		ctx, stop := interruptContext()
+		defer stop()
+		opts := api.RebuildOptions{
+			FlakePath:      flakePath,
+			NixpkgsUrl:     nixpkgsURL,
+			HomeManagerUrl: homeManagerURL,
+			Host:           host,
+			Mode:           mode,
+			OnOutput:       printLine,
+			OnProgress:     printProgress,
+		}
+
+		if yes, _ := cmd.Flags().GetBool("yes"); mode.Activates() && !yes {
+			diff, err := api.PreviewRebuild(ctx, opts)
+			clearProgress()
+			if err != nil {
+				fmt.Println("Error previewing rebuild:", err)
+				os.Exit(1)
+			}
+			if diff.Empty() {
+				fmt.Println("No changes to the current generation.")
+			} else {
+				fmt.Println()
+				fmt.Print(diff.Table())
+			}
+			confirm := false
+			prompt := &survey.Confirm{
+				Message: "Switch to the new configuration?",
+				Default: true,
+			}
+			if err := survey.AskOne(prompt, &confirm); err != nil || !confirm {
+				fmt.Println("Rebuild cancelled.")
+				return
+			}
+		}
 
-		var password string
 		if nix.GetNixMode() == nix.NixOS {
 			prompt := &survey.Password{
 				Message: "Please enter your password:",
 			}
-			survey.AskOne(prompt, &password)
+			survey.AskOne(prompt, &opts.Password)
 		}
 
-		output, err := api.Rebuild(flakePath, password, nixpkgsURL, homeManagerURL)
-		fmt.Println(output)
+		_, err := api.RebuildContext(ctx, opts)
+		clearProgress()
 		if err != nil {
 			fmt.Println("Error rebuilding:", err)
 			os.Exit(1)
-		} else {
-			path := config.GetInstallPath()
-			if err := api.GitAdd(path); err != nil {
-				fmt.Println("Error adding changes:", err)
-				os.Exit(1)
-			}
-			if err := api.GitCommit(path, "pilo: rebuild"); err != nil {
-				fmt.Println("Error committing changes:", err)
-				os.Exit(1)
-			}
+		} else if mode.Activates() {
 			gui.Refresh()
 		}
 	},
@@ -57,5 +105,11 @@ func init() {
 	rebuildCmd.Flags().StringP("flake", "f", "", "Path to the flake to rebuild")
 	rebuildCmd.Flags().String("nixpkgs", "", "URL of the nixpkgs flake to use")
 	rebuildCmd.Flags().String("home-manager", "", "URL of the home-manager flake to use")
+	rebuildCmd.Flags().String("host", "", "NixOS host to build (see 'pilo host list')")
+	rebuildCmd.Flags().Bool("dry-run", false, "Evaluate the configuration and show what would be built")
+	rebuildCmd.Flags().Bool("build-only", false, "Build the configuration without activating it")
+	rebuildCmd.Flags().Bool("boot", false, "Activate the configuration at next boot (NixOS only)")
+	rebuildCmd.Flags().BoolP("yes", "y", false, "Switch without previewing the changes first")
+	rebuildCmd.MarkFlagsMutuallyExclusive("dry-run", "build-only", "boot")
 	rootCmd.AddCommand(rebuildCmd)
 }
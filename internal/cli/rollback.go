@@ -11,14 +11,51 @@ import (
 )
 
 var rollbackCmd = &cobra.Command{
-	Use:   "rollback",
-	Short: "Rolls back to the previous generation.",
-	Long:  `This command rolls back to the previous generation.`,
+	Use:   "rollback [generation]",
+	Short: "Rolls back to the previous generation, or to the given one.",
+	Long: `This command switches to the generation before the current one. Pass a generation number to switch to that generation instead; "pilo generations list" shows them.
+
+With --to-commit, the configuration is restored as it was at the given commit,
+committed on top of the current history and rebuilt.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		profile, err := api.DefaultProfile()
+		if err != nil {
+			fmt.Println("Error rolling back:", err)
+			os.Exit(1)
+		}
+		password := generationsPassword(profile)
+
+		if commit, _ := cmd.Flags().GetString("to-commit"); commit != "" {
+			if len(args) > 0 {
+				fmt.Println("Error: a generation and --to-commit cannot be used together")
+				os.Exit(1)
+			}
+			ctx, stop := interruptContext()
+			defer stop()
+			_, err := api.RollbackToCommit(ctx, commit, api.RebuildOptions{
+				Password:   password,
+				OnOutput:   printLine,
+				OnProgress: printProgress,
+			})
+			clearProgress()
+			if err != nil {
+				fmt.Println("Error rolling back:", err)
+				os.Exit(1)
+			}
+			fmt.Println("Rollback complete!")
+			return
+		}
+
 		spinner := spinner.NewSpinner("Rolling back...")
 		spinner.Start()
-		defer spinner.Stop()
-		if _, err := api.Rollback(""); err != nil {
+		if len(args) == 1 {
+			_, err = api.RollbackTo(parseGeneration(args[0]), password)
+		} else {
+			_, err = api.Rollback(password)
+		}
+		spinner.Stop()
+		if err != nil {
 			fmt.Println("Error rolling back:", err)
 			os.Exit(1)
 		}
@@ -27,5 +64,6 @@ var rollbackCmd = &cobra.Command{
 }
 
 func init() {
+	rollbackCmd.Flags().String("to-commit", "", "Restore and rebuild the configuration from this commit")
 	rootCmd.AddCommand(rollbackCmd)
 }
@@ -21,11 +21,18 @@ It allows you to easily manage your NixOS configurations, packages, and developm
 
 With Pilo, you can perform tasks such as system rebuilds, package installations, and configuration rollbacks with simple, easy-to-remember commands, making your Nix experience smoother and more productive.`,
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// Without its settings, pilo would look for the configuration
+			// in the default place and install one there.
+			if err := config.CheckSettingsFile(); err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
 			// Check if the command is 'install' and if so, skip the auto-install logic
 			if cmd.Name() == "install" {
 				return
 			}
 			handleAutoInstall()
+			migrateConfig()
 		},
 		Run: func(cmd *cobra.Command, args []string) {
 			cmd.Help()
@@ -36,7 +43,13 @@ With Pilo, you can perform tasks such as system rebuilds, package installations,
 var configCmd = &cobra.Command{
 	Use:   "config",
 	Short: "Manage Pilo configuration.",
-	Long:  `The config command allows you to view and edit Pilo configuration settings.`,
+	Long: `The config command allows you to view and edit Pilo configuration settings.
+
+Settings are either fields of base-config.json, named by their dotted path such
+as "system.desktop" or "gc.keep_last", or settings of this machine such as
+"nixpkgsUrl". The latter are kept in $XDG_CONFIG_HOME/pilo/settings.toml, which
+the GUI shares, and PILO_* environment variables such as PILO_NIXPKGS_URL
+override them. Run "pilo config list" to see them all.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		cmd.Help()
 	},
@@ -57,14 +70,40 @@ var setNixPathCmd = &cobra.Command{
 	},
 }
 
+var validateConfigCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration files against their schemas.",
+	Long: `This command checks base-config.json, packages.json, aliases.json, users.json
+and the base-config.json of every host against their JSON schemas, and reports
+every problem with its line and column.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		problems, err := api.ValidateConfig()
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if err != nil {
+			fmt.Printf("Error validating configuration: %v\n", err)
+			os.Exit(1)
+		}
+		if len(problems) > 0 {
+			fmt.Printf("%d problem(s) found.\n", len(problems))
+			os.Exit(1)
+		}
+		fmt.Println("The configuration is valid.")
+	},
+}
+
 func init() {
 	configCmd.AddCommand(setNixPathCmd)
+	configCmd.AddCommand(validateConfigCmd)
 	rootCmd.AddCommand(configCmd)
 }
 
 func Execute(flakeFS embed.FS, version string) {
 	rootCmd.Version = version
 	api.SetFlakeFS(flakeFS)
+	api.SetPrompter(terminalPrompter{})
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -84,3 +123,15 @@ func handleAutoInstall() {
 		}
 	}
 }
+
+// migrateConfig upgrades a configuration written by an older version of pilo.
+func migrateConfig() {
+	done, err := api.MigrateConfig()
+	for _, d := range done {
+		fmt.Println("Migrated configuration:", d)
+	}
+	if err != nil {
+		fmt.Printf("Failed to migrate the Pilo configuration: %v\n", err)
+		os.Exit(1)
+	}
+}
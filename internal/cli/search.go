@@ -1,10 +1,13 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"pilo/internal/api"
+	"pilo/internal/config"
 	"pilo/internal/spinner"
 
 	"github.com/spf13/cobra"
@@ -13,27 +16,123 @@ import (
 var searchCmd = &cobra.Command{
 	Use:   "search [query]",
 	Short: "Searches for packages in nixpkgs.",
-	Long:  `This command searches for packages in nixpkgs.`,
-	Args:  cobra.MinimumNArgs(1),
+	Long: `This command searches for packages in the nixpkgs pinned by flake.lock.
+The first search starts building a local index of every package in the
+background, which takes a few minutes. Until it is ready, results come from
+nix search, without license, broken or insecure flags.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if refresh, _ := cmd.Flags().GetBool("refresh-index"); refresh {
+			return nil
+		}
+		return cobra.MinimumNArgs(1)(cmd, args)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		spinner := spinner.NewSpinner("Searching for packages...")
-		spinner.Start()
+		ctx, stop := interruptContext()
+		defer stop()
+
+		if refresh, _ := cmd.Flags().GetBool("refresh-index"); refresh {
+			spinner := spinner.NewSpinner("Rebuilding the search index...")
+			spinner.Start()
+			err := api.RefreshSearchIndex(ctx)
+			spinner.Stop()
+			if err != nil {
+				fmt.Println("Error rebuilding the search index:", err)
+				os.Exit(1)
+			}
+			if len(args) == 0 {
+				fmt.Println("Search index rebuilt.")
+				return
+			}
+		}
+
 		sortByPopularity, _ := cmd.Flags().GetBool("sort-by-popularity")
 		freeOnly, _ := cmd.Flags().GetBool("free-only")
-		out, err := api.Search(args, sortByPopularity, freeOnly)
-		spinner.Stop()
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		if !api.SearchIndexReady() {
+			if building, err := api.StartSearchIndexBuild(); err != nil {
+				fmt.Fprintln(os.Stderr, "Warning:", err)
+			} else if building {
+				fmt.Fprintln(os.Stderr, "The search index is being built in the background; until it is ready, results come from nix search.")
+			}
+		}
+
+		// The spinner writes to stdout, which must hold only JSON with --json.
+		spinner := spinner.NewSpinner("Searching for packages...")
+		if !asJSON {
+			spinner.Start()
+		}
+		out, err := api.SearchContext(ctx, args, sortByPopularity, freeOnly)
+		if !asJSON {
+			spinner.Stop()
+		}
 		if err != nil {
-			fmt.Println("Error searching for packages:", err)
+			fmt.Fprintln(os.Stderr, "Error searching for packages:", err)
 			os.Exit(1)
 		}
+
+		if asJSON {
+			printSearchJSON(out)
+			return
+		}
 		for _, pkg := range out {
-			fmt.Printf("%s - %s\n", pkg.Name, pkg.Description)
+			version := ""
+			if pkg.Meta != nil && pkg.Meta.Version != "" {
+				version = " (" + pkg.Meta.Version + ")"
+			}
+			fmt.Printf("%s%s%s - %s\n", pkg.Name, version, packageFlags(pkg.Meta), pkg.Description)
 		}
 	},
 }
 
+// searchResult is a search result as printed by `pilo search --json`.
+type searchResult struct {
+	config.PackageMeta
+	Description string `json:"description,omitempty"`
+}
+
+func printSearchJSON(packages []config.Package) {
+	results := make([]searchResult, len(packages))
+	for i, pkg := range packages {
+		if pkg.Meta != nil {
+			results[i].PackageMeta = *pkg.Meta
+		}
+		results[i].AttrPath = pkg.Name
+		results[i].Description = pkg.Description
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error encoding search results:", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(data))
+}
+
+// packageFlags marks packages that are unfree, broken or insecure.
+func packageFlags(meta *config.PackageMeta) string {
+	if meta == nil {
+		return ""
+	}
+	var flags []string
+	if meta.Unfree {
+		flags = append(flags, "unfree")
+	}
+	if meta.Broken {
+		flags = append(flags, "broken")
+	}
+	if meta.Insecure {
+		flags = append(flags, "insecure")
+	}
+	if len(flags) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(flags, ", ") + "]"
+}
+
 func init() {
 	rootCmd.AddCommand(searchCmd)
 	searchCmd.Flags().BoolP("sort-by-popularity", "p", false, "Sort results by popularity")
 	searchCmd.Flags().BoolP("free-only", "f", false, "Show only free software")
+	searchCmd.Flags().Bool("json", false, "Print results as JSON with full package metadata")
+	searchCmd.Flags().Bool("refresh-index", false, "Rebuild the search index before searching")
 }
@@ -0,0 +1,162 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"pilo/internal/api"
+	"pilo/internal/config"
+	"pilo/internal/merge"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/spf13/cobra"
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Merge the remote configuration with yours and push the result.",
+	Long: `This command commits your local changes, fetches the remote branch, merges it
+into your configuration and pushes the result.
+
+Changes to packages.json, aliases.json, users.json and base-config.json are
+merged setting by setting, so packages installed on two machines both end up
+installed. Where the same setting was changed differently on both sides, your
+value is kept and reported. Changes to the same lines of other files, such as
+.nix files, are left between conflict markers for you to edit; run
+"pilo sync" again afterwards to finish the merge.
+
+With --resolve, you are instead asked about every conflict: whether to keep
+your version, the remote one or both, with the two versions shown side by
+side.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		resolve, _ := cmd.Flags().GetBool("resolve")
+		repoPath := config.GetInstallPath()
+		if resolve {
+			if err := resolveMerge(repoPath); err != nil {
+				fmt.Printf("Failed to resolve the merge: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		result, err := api.GitSync(repoPath, resolve)
+		var conflicts *api.MergeConflictError
+		for resolve && errors.As(err, &conflicts) {
+			if err := resolveMerge(repoPath); err != nil {
+				fmt.Printf("Failed to resolve the merge: %v\n", err)
+				os.Exit(1)
+			}
+			result, err = api.GitSync(repoPath, resolve)
+		}
+		if errors.As(err, &conflicts) {
+			fmt.Println("The remote changes conflict with yours:")
+			for _, c := range conflicts.Conflicts {
+				if c.Line > 0 || c.Field != "" {
+					fmt.Printf("  %s\n", c)
+				} else {
+					fmt.Printf("  %s (deleted on one side, changed on the other)\n", c.File)
+				}
+			}
+			fmt.Println("Edit the files to resolve the conflicts, then run 'pilo sync' again,")
+			fmt.Println("or run 'pilo sync --resolve' to resolve them one by one.")
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Printf("Failed to sync: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(result)
+	},
+}
+
+// resolveMerge asks how to resolve each conflict of the merge in progress,
+// if there is one, and commits the merge.
+func resolveMerge(repoPath string) error {
+	files, err := api.MergeConflicts(repoPath)
+	if err != nil || len(files) == 0 {
+		return err
+	}
+	for _, file := range files {
+		fmt.Printf("\n== %s ==\n", file.File)
+		var choices []merge.Choice
+		for i, c := range file.Conflicts {
+			fmt.Printf("\nConflict %d of %d: %s\n", i+1, len(file.Conflicts), c)
+			fmt.Print(sideBySide(conflictSide(c.Ours, c.Field), conflictSide(c.Theirs, c.Field)))
+			choice, err := askChoice(c)
+			if err != nil {
+				return err
+			}
+			choices = append(choices, choice)
+		}
+		if err := api.ResolveConflicts(repoPath, file, choices); err != nil {
+			return err
+		}
+	}
+	return api.CompleteMerge(repoPath)
+}
+
+// askChoice asks whether to keep ours, theirs or both versions of c.
+func askChoice(c merge.Conflict) (merge.Choice, error) {
+	options := []string{"Keep ours (local)", "Keep theirs (remote)"}
+	if merge.CanCombine(c) {
+		options = append(options, "Keep both")
+	}
+	var answer int
+	prompt := &survey.Select{
+		Message: "Which version do you want to keep?",
+		Options: options,
+	}
+	if err := survey.AskOne(prompt, &answer); err != nil {
+		return merge.Ours, err
+	}
+	return merge.Choice(answer), nil
+}
+
+// conflictSide describes one side of a conflict for display.
+func conflictSide(text, field string) string {
+	switch {
+	case text == "" && field != "":
+		return "(not set)"
+	case text == "":
+		return "(deleted)"
+	}
+	return text
+}
+
+// sideBySideWidth is the width of each column of sideBySide.
+const sideBySideWidth = 38
+
+// sideBySide lays out the lines of ours and theirs in two columns, cutting
+// lines that do not fit.
+func sideBySide(ours, theirs string) string {
+	left := strings.Split(strings.TrimSuffix(ours, "\n"), "\n")
+	right := strings.Split(strings.TrimSuffix(theirs, "\n"), "\n")
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-*s | %s\n", sideBySideWidth, "ours (local)", "theirs (remote)")
+	fmt.Fprintf(&b, "%s-+-%s\n", strings.Repeat("-", sideBySideWidth), strings.Repeat("-", sideBySideWidth))
+	for i := 0; i < len(left) || i < len(right); i++ {
+		var l, r string
+		if i < len(left) {
+			l = fitColumn(left[i])
+		}
+		if i < len(right) {
+			r = fitColumn(right[i])
+		}
+		fmt.Fprintf(&b, "%-*s | %s\n", sideBySideWidth, l, r)
+	}
+	return b.String()
+}
+
+func fitColumn(line string) string {
+	line = strings.ReplaceAll(line, "\t", "  ")
+	if runes := []rune(line); len(runes) > sideBySideWidth {
+		return string(runes[:sideBySideWidth-1]) + "…"
+	}
+	return line
+}
+
+func init() {
+	syncCmd.Flags().Bool("resolve", false, "Resolve each conflict interactively")
+	rootCmd.AddCommand(syncCmd)
+}
@@ -5,7 +5,6 @@ import (
 	"os"
 
 	"pilo/internal/api"
-	"pilo/internal/spinner"
 
 	"github.com/spf13/cobra"
 )
@@ -16,14 +15,14 @@ var updateCmd = &cobra.Command{
 	Long:  `This command updates your flake's inputs by modifying the flake.lock file. Provide an optional input name to update only that specific dependency.`,
 	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		spinner := spinner.NewSpinner("Updating flake inputs...")
-		defer spinner.Stop()
 		var inputName string
 		if len(args) > 0 {
 			inputName = args[0]
 		}
 
-		if _, err := api.Update(inputName); err != nil {
+		ctx, stop := interruptContext()
+		defer stop()
+		if _, err := api.UpdateContext(ctx, inputName, printLine); err != nil {
 			fmt.Println("Error updating flake inputs:", err)
 			os.Exit(1)
 		}
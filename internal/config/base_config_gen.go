@@ -6,10 +6,10 @@ import (
 	"sort"
 )
 
-// GenerateBaseConfig creates a default BaseConfig and writes it to the specified file path.
-// It also generates the separate packages, aliases, and users files.
-func GenerateBaseConfig(filePath string) error {
-	config := BaseConfig{
+// DefaultBaseConfig returns the settings of a new base-config.json.
+func DefaultBaseConfig() BaseConfig {
+	return BaseConfig{
+		SchemaVersion:  SchemaVersion,
 		CommitTriggers: []string{},
 		PushOnCommit:   true,
 		RemoteBranch:   "main",
@@ -23,7 +23,15 @@ func GenerateBaseConfig(filePath string) error {
 			},
 		},
 		NixBinPath: "",
+		GC:         DefaultGCPolicy(),
+		Signing:    Signing{},
 	}
+}
+
+// GenerateBaseConfig creates a default BaseConfig and writes it to the specified file path.
+// It also generates the separate packages, aliases, and users files.
+func GenerateBaseConfig(filePath string) error {
+	config := DefaultBaseConfig()
 
 	// Sort slices to ensure canonical representation
 	sort.Strings(config.CommitTriggers)
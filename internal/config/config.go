@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"pilo/internal/schema"
 	"sort"
 
 	"fyne.io/fyne/v2"
@@ -17,6 +18,9 @@ type System struct {
 	Desktop  string `json:"desktop"`
 	Type     string `json:"type"`
 	Ollama   Ollama `json:"ollama"`
+	// Groups selects the package groups this host uses. When it is empty
+	// the groups' own Enabled flags decide.
+	Groups []string `json:"groups,omitempty"`
 }
 
 type Ollama struct {
@@ -24,9 +28,37 @@ type Ollama struct {
 }
 
 type Package struct {
+	// Name is the attribute path of the package, such as "kdePackages.kcalc".
 	Name        string `json:"name"`
 	Description string `json:"description,omitempty"`
 	Installed   bool   `json:"installed"`
+	// Source is the package set the package comes from: SourceStable (the
+	// default when empty), SourceUnstable, or the name of a flake input.
+	Source string `json:"source,omitempty"`
+	// Group is the name of the package group the package belongs to, if any.
+	Group string `json:"group,omitempty"`
+	// Meta is looked up from nixpkgs and never written to packages.json.
+	Meta *PackageMeta `json:"-"`
+}
+
+// Package sources that are always available.
+const (
+	SourceStable   = "stable"
+	SourceUnstable = "unstable"
+)
+
+// PackageMeta is the nixpkgs metadata of a package.
+type PackageMeta struct {
+	AttrPath    string   `json:"attr_path"`
+	Pname       string   `json:"pname"`
+	Version     string   `json:"version"`
+	License     []string `json:"license,omitempty"`
+	Homepage    string   `json:"homepage,omitempty"`
+	MainProgram string   `json:"main_program,omitempty"`
+	Platforms   []string `json:"platforms,omitempty"`
+	Unfree      bool     `json:"unfree"`
+	Broken      bool     `json:"broken"`
+	Insecure    bool     `json:"insecure"`
 }
 
 type User struct {
@@ -36,6 +68,9 @@ type User struct {
 }
 
 type BaseConfig struct {
+	// SchemaVersion is the version of the file schemas the configuration
+	// was written with; see Migrate.
+	SchemaVersion  int               `json:"schema_version"`
 	CommitTriggers []string          `json:"commit_triggers"`
 	Packages       []Package         `json:"-"`
 	Aliases        map[string]string `json:"-"`
@@ -45,11 +80,86 @@ type BaseConfig struct {
 	System         System            `json:"system"`
 	Users          []User            `json:"-"`
 	NixBinPath     string            `json:"nix_bin_path"`
+	GC             GCPolicy          `json:"gc"`
+	Signing        Signing           `json:"signing"`
+}
+
+// Signing decides how GitCommit signs the commits it makes, for remotes
+// whose branch protection requires signed commits.
+type Signing struct {
+	// Format is "ssh" or "gpg"; empty leaves commits unsigned.
+	Format string `json:"format"`
+	// Key is the SSH key file for "ssh", the sshKeyPath setting when empty,
+	// or the GPG key ID for "gpg", gpg's default key when empty.
+	Key string `json:"key"`
+}
+
+// GCPolicy decides which generations garbage collection deletes. A
+// generation is deleted only when it falls outside every limit that is set;
+// a zero value disables that limit. A policy that sets neither limit, as when
+// base-config.json has no "gc" section, deletes no generations. The current
+// generation is always kept.
+type GCPolicy struct {
+	// KeepLast keeps the newest N generations of each profile.
+	KeepLast int `json:"keep_last"`
+	// OlderThanDays keeps generations younger than this many days.
+	OlderThanDays int `json:"older_than_days"`
+	// KeepPinned keeps generations pinned with "pilo generations pin".
+	KeepPinned bool `json:"keep_pinned"`
+	// Optimise deduplicates the store after collecting garbage.
+	Optimise bool `json:"optimise"`
+}
+
+// DefaultGCPolicy is the policy of a new base-config.json. It also supplies
+// the fields a "gc" section leaves out.
+func DefaultGCPolicy() GCPolicy {
+	return GCPolicy{KeepLast: 5, OlderThanDays: 30, KeepPinned: true}
+}
+
+// DeletesGenerations reports whether the policy sets any limit.
+func (p GCPolicy) DeletesGenerations() bool {
+	return p.KeepLast > 0 || p.OlderThanDays > 0
+}
+
+// UnmarshalJSON reads a "gc" section on top of DefaultGCPolicy. It is not
+// called when base-config.json has no such section, which leaves the policy
+// without limits.
+func (p *GCPolicy) UnmarshalJSON(data []byte) error {
+	type plain GCPolicy
+	policy := plain(DefaultGCPolicy())
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return err
+	}
+	*p = GCPolicy(policy)
+	return nil
 }
 
 // PackagesConfig defines the structure for the packages.json file.
 type PackagesConfig struct {
-	Packages []Package `json:"packages"`
+	Groups   []PackageGroup `json:"groups,omitempty"`
+	Packages []Package      `json:"packages"`
+}
+
+// PackageGroup is a named set of packages, such as "dev-go" or "media", that
+// is switched on or off as a whole.
+type PackageGroup struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// GroupUsed reports whether a host whose base config selects hostGroups
+// installs the packages of group.
+func GroupUsed(group PackageGroup, hostGroups []string) bool {
+	if len(hostGroups) == 0 {
+		return group.Enabled
+	}
+	for _, name := range hostGroups {
+		if name == group.Name {
+			return true
+		}
+	}
+	return false
 }
 
 // AliasesConfig defines the structure for the aliases.json file.
@@ -73,25 +183,47 @@ func GetFlakePath() string {
 	return filepath.Join(GetInstallPath(), "flake")
 }
 
-// GetInstallPath retrieves the installation path from preferences.
+// DefaultHost is the host that is built when the machine's hostname has no
+// directory of its own in the flake's hosts directory.
+const DefaultHost = "nixos"
+
+// GetHostPath returns the directory holding the configuration of host.
+func GetHostPath(host string) string {
+	return filepath.Join(GetFlakePath(), "hosts", host)
+}
+
+// GetInstallPath retrieves the installation path from the settings, falling
+// back to ~/.config/pilo.
 func GetInstallPath() string {
-	if App == nil {
-		// This is a fallback for CLI mode
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return ""
-		}
-		return filepath.Join(home, ".config", "pilo")
+	if path := preferenceString("installationPath"); path != "" {
+		return path
 	}
-	return App.Preferences().StringWithFallback("installationPath", must(os.UserHomeDir())+"/.config/pilo")
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "pilo")
 }
 
-// ReadConfig reads and unmarshals the configuration from multiple files.
+// SetInstallPath sets the installation path in the settings.
+func SetInstallPath(path string) {
+	setPreference("installationPath", path)
+}
+
+// GetStatePath returns the directory holding pilo's machine-local state. It
+// lives inside the install path but is excluded from the git repository.
+func GetStatePath() string {
+	return filepath.Join(GetInstallPath(), ".pilo")
+}
+
+// ReadConfig reads and unmarshals the configuration from multiple files. A
+// missing base-config.json is created with the defaults; one that does not
+// match its schema is an error, never overwritten.
 func ReadConfig() (*BaseConfig, error) {
 	// Read base config
 	configPath := filepath.Join(GetInstallPath(), "flake", "base-config.json")
-	data, err := os.ReadFile(configPath)
-	if err != nil {
+	var config BaseConfig
+	if err := readJSONFile(configPath, &config); err != nil {
 		if os.IsNotExist(err) {
 			if err := writeDefaultConfig(configPath); err != nil {
 				return nil, err
@@ -100,13 +232,8 @@ func ReadConfig() (*BaseConfig, error) {
 		}
 		return nil, err
 	}
-
-	var config BaseConfig
-	if err := json.Unmarshal(data, &config); err != nil {
-		if err := writeDefaultConfig(configPath); err != nil {
-			return nil, err
-		}
-		return ReadConfig()
+	if config.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("%s has schema version %d, but this version of pilo only knows up to %d; please upgrade pilo", configPath, config.SchemaVersion, SchemaVersion)
 	}
 
 	// Read packages config
@@ -135,39 +262,38 @@ func ReadConfig() (*BaseConfig, error) {
 
 // ReadPackagesConfig reads and unmarshals the packages.json file.
 func ReadPackagesConfig() ([]Package, error) {
-	path := filepath.Join(GetInstallPath(), "flake", "packages.json")
-	data, err := os.ReadFile(path)
+	packagesConfig, err := readPackagesFile()
+	if err != nil {
+		return nil, err
+	}
+	return packagesConfig.Packages, nil
+}
+
+// ReadPackageGroups reads the package groups defined in packages.json.
+func ReadPackageGroups() ([]PackageGroup, error) {
+	packagesConfig, err := readPackagesFile()
 	if err != nil {
 		return nil, err
 	}
+	return packagesConfig.Groups, nil
+}
 
+func readPackagesFile() (*PackagesConfig, error) {
+	path := filepath.Join(GetInstallPath(), "flake", "packages.json")
 	var packagesConfig PackagesConfig
-	if err := json.Unmarshal(data, &packagesConfig); err != nil {
+	if err := readJSONFile(path, &packagesConfig); err != nil {
 		return nil, err
 	}
-	return packagesConfig.Packages, nil
+	return &packagesConfig, nil
 }
 
 // ReadAliasesConfig reads and unmarshals the aliases.json file.
 func ReadAliasesConfig() (map[string]string, error) {
 	path := filepath.Join(GetInstallPath(), "flake", "aliases.json")
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
 	var aliases map[string]string
-	// Attempt to unmarshal into a nested structure first for backward compatibility
-	var nested AliasesConfig
-	if err := json.Unmarshal(data, &nested); err == nil && nested.Aliases != nil {
-		aliases = nested.Aliases
-	} else {
-		// Fallback to unmarshaling as a flat map
-		if err := json.Unmarshal(data, &aliases); err != nil {
-			return nil, err
-		}
+	if err := readJSONFile(path, &aliases); err != nil {
+		return nil, err
 	}
-
 	if aliases == nil {
 		aliases = make(map[string]string)
 	}
@@ -177,18 +303,26 @@ func ReadAliasesConfig() (map[string]string, error) {
 // ReadUsersConfig reads and unmarshals the users.json file.
 func ReadUsersConfig() ([]User, error) {
 	path := filepath.Join(GetInstallPath(), "flake", "users.json")
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
 	var usersConfig UsersConfig
-	if err := json.Unmarshal(data, &usersConfig); err != nil {
+	if err := readJSONFile(path, &usersConfig); err != nil {
 		return nil, err
 	}
 	return usersConfig.Users, nil
 }
 
+// readJSONFile validates the pilo JSON file at path against its schema and
+// unmarshals it into v. Errors reading the file are returned unwrapped.
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := schema.Check(path, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
 // writeDefaultConfig creates a default base-config.json file.
 func writeDefaultConfig(path string) error {
 	return GenerateBaseConfig(path)
@@ -196,7 +330,11 @@ func writeDefaultConfig(path string) error {
 
 // WriteConfig marshals and writes the config to their respective files.
 func WriteConfig(config *BaseConfig) error {
-	// Sort all string slices to ensure canonical representation
+	// Sort all string slices to ensure canonical representation. A nil
+	// slice would be written as null, which the schema rejects.
+	if config.CommitTriggers == nil {
+		config.CommitTriggers = []string{}
+	}
 	sort.Strings(config.CommitTriggers)
 
 	// Write base config (without packages, aliases, users)
@@ -221,13 +359,42 @@ func WriteConfig(config *BaseConfig) error {
 	return WriteUsersConfig(config.Users)
 }
 
-// WritePackagesConfig marshals and writes the packages to packages.json.
+// WritePackagesConfig marshals and writes the packages to packages.json,
+// keeping the groups already defined there.
 func WritePackagesConfig(packages []Package) error {
-	sort.Slice(packages, func(i, j int) bool {
-		return packages[i].Name < packages[j].Name
+	packagesConfig, err := readPackagesFile()
+	if os.IsNotExist(err) {
+		packagesConfig = &PackagesConfig{}
+	} else if err != nil {
+		return err
+	}
+	packagesConfig.Packages = packages
+	return writePackagesFile(packagesConfig)
+}
+
+// WritePackageGroups writes the package groups to packages.json, keeping the
+// packages already listed there.
+func WritePackageGroups(groups []PackageGroup) error {
+	packagesConfig, err := readPackagesFile()
+	if err != nil {
+		return err
+	}
+	packagesConfig.Groups = groups
+	return writePackagesFile(packagesConfig)
+}
+
+func writePackagesFile(packagesConfig *PackagesConfig) error {
+	if packagesConfig.Packages == nil {
+		packagesConfig.Packages = []Package{}
+	}
+	sort.Slice(packagesConfig.Packages, func(i, j int) bool {
+		return packagesConfig.Packages[i].Name < packagesConfig.Packages[j].Name
+	})
+	sort.Slice(packagesConfig.Groups, func(i, j int) bool {
+		return packagesConfig.Groups[i].Name < packagesConfig.Groups[j].Name
 	})
 	path := filepath.Join(GetInstallPath(), "flake", "packages.json")
-	newData, err := json.MarshalIndent(PackagesConfig{Packages: packages}, "", "  ")
+	newData, err := json.MarshalIndent(packagesConfig, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -236,6 +403,9 @@ func WritePackagesConfig(packages []Package) error {
 
 // WriteAliasesConfig marshals and writes the aliases to aliases.json.
 func WriteAliasesConfig(aliases map[string]string) error {
+	if aliases == nil {
+		aliases = map[string]string{}
+	}
 	path := filepath.Join(GetInstallPath(), "flake", "aliases.json")
 	newData, err := json.MarshalIndent(aliases, "", "  ")
 	if err != nil {
@@ -246,6 +416,9 @@ func WriteAliasesConfig(aliases map[string]string) error {
 
 // WriteUsersConfig marshals and writes the users to users.json.
 func WriteUsersConfig(users []User) error {
+	if users == nil {
+		users = []User{}
+	}
 	path := filepath.Join(GetInstallPath(), "flake", "users.json")
 	newData, err := json.MarshalIndent(UsersConfig{Users: users}, "", "  ")
 	if err != nil {
@@ -254,78 +427,47 @@ func WriteUsersConfig(users []User) error {
 	return os.WriteFile(path, newData, 0644)
 }
 
-// GetNixpkgsUrl retrieves the Nixpkgs URL from preferences.
+// GetNixpkgsUrl retrieves the Nixpkgs URL from the settings.
 func GetNixpkgsUrl() string {
-	if App == nil {
-		return "github:NixOS/nixpkgs/nixos-25.05"
-	}
-	return App.Preferences().StringWithFallback("nixpkgsUrl", "github:NixOS/nixpkgs/nixos-25.05")
+	return preferenceString("nixpkgsUrl")
 }
 
-// SetNixpkgsUrl sets the Nixpkgs URL in preferences.
+// SetNixpkgsUrl sets the Nixpkgs URL in the settings.
 func SetNixpkgsUrl(url string) {
-	if App == nil {
-		return
-	}
-	App.Preferences().SetString("nixpkgsUrl", url)
+	setPreference("nixpkgsUrl", url)
 }
 
-// GetHomeManagerUrl retrieves the Home Manager URL from preferences.
+// GetHomeManagerUrl retrieves the Home Manager URL from the settings.
 func GetHomeManagerUrl() string {
-	if App == nil {
-		return "github:nix-community/home-manager/release-25.05"
-	}
-	return App.Preferences().StringWithFallback("homeManagerUrl", "github:nix-community/home-manager/release-25.05")
+	return preferenceString("homeManagerUrl")
 }
 
-// SetHomeManagerUrl sets the Home Manager URL in preferences.
+// SetHomeManagerUrl sets the Home Manager URL in the settings.
 func SetHomeManagerUrl(url string) {
-	if App == nil {
-		return
-	}
-	App.Preferences().SetString("homeManagerUrl", url)
-}
-
-func must(s string, e error) string {
-	if e != nil {
-		panic(e)
-	}
-	return s
+	setPreference("homeManagerUrl", url)
 }
 
-// GetNixInstallCmd retrieves the Nix installation command from preferences.
+// GetNixInstallCmd retrieves the Nix installation command from the settings.
 func GetNixInstallCmd() string {
-	if App == nil {
-		return "curl --proto '=https' --tlsv1.2 -L https://nixos.org/nix/install | sh -s -- --no-daemon"
-	}
-	return App.Preferences().StringWithFallback("nixInstallCmd", "curl --proto '=https' --tlsv1.2 -L https://nixos.org/nix/install | sh -s -- --no-daemon")
+	return preferenceString("nixInstallCmd")
 }
 
-// SetNixInstallCmd sets the Nix installation command in preferences.
+// SetNixInstallCmd sets the Nix installation command in the settings.
 func SetNixInstallCmd(cmd string) {
-	if App == nil {
-		return
-	}
-	App.Preferences().SetString("nixInstallCmd", cmd)
+	setPreference("nixInstallCmd", cmd)
 }
 
-// GetLogHistoryRetention retrieves the log history retention from preferences.
+// GetLogHistoryRetention retrieves the log history retention from the settings.
 func GetLogHistoryRetention() int {
-	if App == nil {
-		return 1000
-	}
-	return App.Preferences().IntWithFallback("logHistoryRetention", 1000)
+	return preferenceInt("logHistoryRetention")
 }
 
-// SetLogHistoryRetention sets the log history retention in preferences.
+// SetLogHistoryRetention sets the log history retention in the settings.
 func SetLogHistoryRetention(retention int) {
-	if App == nil {
-		return
-	}
-	App.Preferences().SetInt("logHistoryRetention", retention)
+	setPreference("logHistoryRetention", retention)
 }
 
-// GetLogs retrieves the logs from preferences.
+// GetLogs retrieves the logs from the app preferences.
 func GetLogs() []string {
 	if App == nil {
 		return []string{}
@@ -333,7 +475,7 @@ func GetLogs() []string {
 	return App.Preferences().StringListWithFallback("logs", []string{})
 }
 
-// SetLogs sets the logs in preferences.
+// SetLogs sets the logs in the app preferences.
 func SetLogs(logs []string) {
 	if App == nil {
 		return
@@ -418,6 +560,34 @@ func SetPushOnCommit(push bool) error {
 	return WriteConfig(config)
 }
 
+// GetGCPolicy retrieves the garbage collection policy from the base config file.
+func GetGCPolicy() (GCPolicy, error) {
+	config, err := ReadConfig()
+	if err != nil {
+		return GCPolicy{}, err
+	}
+	return config.GC, nil
+}
+
+// SetGCPolicy sets the garbage collection policy in the base config file.
+func SetGCPolicy(policy GCPolicy) error {
+	config, err := ReadConfig()
+	if err != nil {
+		return err
+	}
+	config.GC = policy
+	return WriteConfig(config)
+}
+
+// GetSigning retrieves the commit signing settings from the base config file.
+func GetSigning() (Signing, error) {
+	config, err := ReadConfig()
+	if err != nil {
+		return Signing{}, err
+	}
+	return config.Signing, nil
+}
+
 // GetRemoteBranch retrieves the remote branch from the base config file.
 func GetRemoteBranch() (string, error) {
 	config, err := ReadConfig()
@@ -565,76 +735,49 @@ func SetDesktop(desktop string) error {
 	return WriteConfig(config)
 }
 
-// GetInstallFromRemote retrieves the install from remote flag from preferences.
+// GetInstallFromRemote retrieves the install from remote flag from the settings.
 func GetInstallFromRemote() bool {
-	if App == nil {
-		return false
-	}
-	return App.Preferences().BoolWithFallback("installFromRemote", false)
+	return preferenceBool("installFromRemote")
 }
 
-// GetCustomTerminal retrieves the custom terminal command from preferences.
+// GetCustomTerminal retrieves the custom terminal command from the settings.
 func GetCustomTerminal() string {
-	if App == nil {
-		return ""
-	}
-	return App.Preferences().StringWithFallback("customTerminal", "")
+	return preferenceString("customTerminal")
 }
 
-// SetCustomTerminal sets the custom terminal command in preferences.
+// SetCustomTerminal sets the custom terminal command in the settings.
 func SetCustomTerminal(cmd string) {
-	if App == nil {
-		return
-	}
-	App.Preferences().SetString("customTerminal", cmd)
+	setPreference("customTerminal", cmd)
 }
 
-// GetRemoteGitUrl retrieves the remote Git URL from preferences.
+// GetRemoteGitUrl retrieves the remote Git URL from the settings.
 func GetRemoteGitUrl() string {
-	if App == nil {
-		return ""
-	}
-	return App.Preferences().StringWithFallback("remoteGitUrl", "")
+	return preferenceString("remoteGitUrl")
 }
 
-// SetRemoteGitUrl sets the remote Git URL in preferences.
+// SetRemoteGitUrl sets the remote Git URL in the settings.
 func SetRemoteGitUrl(url string) {
-	if App == nil {
-		return
-	}
-	App.Preferences().SetString("remoteGitUrl", url)
+	setPreference("remoteGitUrl", url)
 }
 
-// GetSshKeyPath retrieves the SSH key path from preferences.
+// GetSshKeyPath retrieves the SSH key path from the settings.
 func GetSshKeyPath() string {
-	if App == nil {
-		return ""
-	}
-	return App.Preferences().StringWithFallback("sshKeyPath", "")
+	return preferenceString("sshKeyPath")
 }
 
-// SetSshKeyPath sets the SSH key path in preferences.
+// SetSshKeyPath sets the SSH key path in the settings.
 func SetSshKeyPath(path string) {
-	if App == nil {
-		return
-	}
-	App.Preferences().SetString("sshKeyPath", path)
+	setPreference("sshKeyPath", path)
 }
 
-// GetRegistryName retrieves the registry name from preferences.
+// GetRegistryName retrieves the registry name from the settings.
 func GetRegistryName() string {
-	if App == nil {
-		return "pilo"
-	}
-	return App.Preferences().StringWithFallback("registryName", "pilo")
+	return preferenceString("registryName")
 }
 
-// SetRegistryName sets the registry name in preferences.
+// SetRegistryName sets the registry name in the settings.
 func SetRegistryName(name string) {
-	if App == nil {
-		return
-	}
-	App.Preferences().SetString("registryName", name)
+	setPreference("registryName", name)
 }
 
 // GetNixBinPath retrieves the Nix binary path from the base config file.
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pilo/internal/schema"
+	"regexp"
+	"strings"
+)
+
+// SchemaVersion is the schema version of the JSON files this version of
+// pilo reads and writes. It is recorded as schema_version in
+// base-config.json; files without it are version 0.
+const SchemaVersion = 1
+
+// migration upgrades the files of a flake from the schema version before to
+// the next one.
+type migration struct {
+	description string
+	apply       func(flakePath string) error
+}
+
+// migrations holds the migration from each schema version to the next, so
+// migrations[v] upgrades version v to v+1.
+var migrations = []migration{
+	{description: "store aliases.json as a flat map of aliases", apply: flattenAliases},
+}
+
+// ReadSchemaVersion returns the schema_version of base-config.json.
+func ReadSchemaVersion() (int, error) {
+	data, err := os.ReadFile(filepath.Join(GetFlakePath(), "base-config.json"))
+	if err != nil {
+		return 0, err
+	}
+	var v struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return 0, fmt.Errorf("could not read the schema version: %w", err)
+	}
+	return v.SchemaVersion, nil
+}
+
+// Migrate upgrades the configuration files in place to SchemaVersion and
+// returns what each migration it ran did. The schema version is recorded
+// after every step, so an interrupted upgrade resumes where it stopped.
+func Migrate() ([]string, error) {
+	version, err := ReadSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("the configuration has schema version %d, but this version of pilo only knows up to %d; please upgrade pilo", version, SchemaVersion)
+	}
+
+	var done []string
+	for ; version < SchemaVersion; version++ {
+		m := migrations[version]
+		if err := m.apply(GetFlakePath()); err != nil {
+			return done, fmt.Errorf("could not migrate to schema version %d (%s): %w", version+1, m.description, err)
+		}
+		if err := setSchemaVersion(version + 1); err != nil {
+			return done, err
+		}
+		done = append(done, m.description)
+	}
+	return done, nil
+}
+
+var schemaVersionRe = regexp.MustCompile(`("schema_version"\s*:\s*)-?\d+`)
+
+// setSchemaVersion records version in base-config.json. The file is edited
+// as text so the order and layout of the other settings are kept.
+func setSchemaVersion(version int) error {
+	path := filepath.Join(GetFlakePath(), "base-config.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	text := string(data)
+	switch {
+	case schemaVersionRe.MatchString(text):
+		text = schemaVersionRe.ReplaceAllString(text, fmt.Sprintf("${1}%d", version))
+	case strings.TrimSpace(text) == "{}":
+		text = fmt.Sprintf("{\n  \"schema_version\": %d\n}\n", version)
+	default:
+		i := strings.Index(text, "{")
+		if i < 0 {
+			return fmt.Errorf("%s is not a JSON object", path)
+		}
+		text = text[:i+1] + fmt.Sprintf("\n  \"schema_version\": %d,", version) + text[i+1:]
+	}
+	return os.WriteFile(path, []byte(text), 0644)
+}
+
+// flattenAliases rewrites an aliases.json of the form {"aliases": {...}},
+// which older versions of pilo wrote, as the plain map the flake reads.
+func flattenAliases(flakePath string) error {
+	path := filepath.Join(flakePath, "aliases.json")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var nested AliasesConfig
+	if err := json.Unmarshal(data, &nested); err != nil || nested.Aliases == nil {
+		// Already flat, or an alias is named "aliases".
+		return nil
+	}
+	return WriteAliasesConfig(nested.Aliases)
+}
+
+// Validate checks every JSON file of the configuration against its schema,
+// including the base-config.json of each host, and returns all the problems
+// found.
+func Validate() ([]schema.Problem, error) {
+	var paths []string
+	for _, name := range schema.Files {
+		paths = append(paths, filepath.Join(GetFlakePath(), name))
+	}
+	hostConfigs, err := filepath.Glob(filepath.Join(GetFlakePath(), "hosts", "*", "base-config.json"))
+	if err != nil {
+		return nil, err
+	}
+	paths = append(paths, hostConfigs...)
+
+	var problems []schema.Problem
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			problems = append(problems, schema.Problem{File: path, Line: 1, Column: 1, Message: "the file is missing"})
+			continue
+		} else if err != nil {
+			return problems, err
+		}
+		problems = append(problems, schema.Validate(path, data)...)
+	}
+
+	if version, err := ReadSchemaVersion(); err == nil && version != SchemaVersion {
+		path := filepath.Join(GetFlakePath(), "base-config.json")
+		msg := fmt.Sprintf("schema version %d is older than %d; run any pilo command to migrate it", version, SchemaVersion)
+		if version > SchemaVersion {
+			msg = fmt.Sprintf("schema version %d is newer than this version of pilo knows (%d)", version, SchemaVersion)
+		}
+		problems = append(problems, schema.Problem{File: path, Line: 1, Column: 1, Path: "schema_version", Message: msg})
+	}
+	return problems, nil
+}
@@ -0,0 +1,268 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+)
+
+// AppID is the unique ID of the pilo app. Fyne keeps the app's preferences
+// under it.
+const AppID = "dev.stewlab.pilo"
+
+// Preference is a machine-local setting. Preferences are kept in the
+// settings file, which the CLI and the GUI share, and each can be overridden
+// with a PILO_* environment variable.
+type Preference struct {
+	Key         string
+	Description string
+	// Default is the value used when the preference is not set. Its type,
+	// string, int or bool, is the type of the preference.
+	Default interface{}
+}
+
+// Preferences lists the preferences "pilo config" can change.
+var Preferences = []Preference{
+	{Key: "installationPath", Description: "Directory holding the pilo repository; empty for ~/.config/pilo", Default: ""},
+	{Key: "nixpkgsUrl", Description: "Flake URL of the stable nixpkgs", Default: "github:NixOS/nixpkgs/nixos-25.05"},
+	{Key: "homeManagerUrl", Description: "Flake URL of Home Manager", Default: "github:nix-community/home-manager/release-25.05"},
+	{Key: "customTerminal", Description: "Command that opens a terminal; empty to detect one", Default: ""},
+	{Key: "sshKeyPath", Description: "SSH key used to push to the remote", Default: ""},
+	{Key: "registryName", Description: "Name of the flake in the Nix registry", Default: "pilo"},
+	{Key: "logHistoryRetention", Description: "Number of log entries to keep", Default: 1000},
+	{Key: "nixInstallCmd", Description: "Command that installs Nix", Default: "curl --proto '=https' --tlsv1.2 -L https://nixos.org/nix/install | sh -s -- --no-daemon"},
+	{Key: "remoteGitUrl", Description: "Repository the configuration was installed from", Default: ""},
+	{Key: "installFromRemote", Description: "Install the configuration from remoteGitUrl", Default: false},
+}
+
+// FindPreference returns the preference named key.
+func FindPreference(key string) (Preference, bool) {
+	for _, p := range Preferences {
+		if p.Key == key {
+			return p, true
+		}
+	}
+	return Preference{}, false
+}
+
+// EnvVar returns the environment variable that overrides the preference,
+// such as PILO_NIXPKGS_URL for nixpkgsUrl.
+func (p Preference) EnvVar() string {
+	var b strings.Builder
+	b.WriteString("PILO_")
+	for i, r := range p.Key {
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// preferenceValue returns the value of p: its environment variable if set,
+// then the settings file, then its default.
+func preferenceValue(p Preference) interface{} {
+	if env, ok := os.LookupEnv(p.EnvVar()); ok {
+		if v, err := parsePreference(p, env); err == nil {
+			return v
+		}
+	}
+	// A settings file that does not parse is reported by CheckSettingsFile.
+	values, _ := readSettingsFile()
+	if v, ok := values[p.Key]; ok {
+		if v, err := convertPreference(p, v); err == nil {
+			return v
+		}
+	}
+	return p.Default
+}
+
+func preferenceString(key string) string {
+	p, _ := FindPreference(key)
+	s, _ := preferenceValue(p).(string)
+	return s
+}
+
+func preferenceInt(key string) int {
+	p, _ := FindPreference(key)
+	i, _ := preferenceValue(p).(int)
+	return i
+}
+
+func preferenceBool(key string) bool {
+	p, _ := FindPreference(key)
+	b, _ := preferenceValue(p).(bool)
+	return b
+}
+
+// parsePreference parses s as the type of p.
+func parsePreference(p Preference, s string) (interface{}, error) {
+	switch p.Default.(type) {
+	case int:
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a whole number", p.Key)
+		}
+		return i, nil
+	case bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", p.Key)
+		}
+		return b, nil
+	default:
+		return s, nil
+	}
+}
+
+// convertPreference converts v, as decoded from the settings file or the
+// Fyne preferences, to the type of p.
+func convertPreference(p Preference, v interface{}) (interface{}, error) {
+	switch p.Default.(type) {
+	case int:
+		switch n := v.(type) {
+		case int64:
+			return int(n), nil
+		case float64:
+			return int(n), nil
+		case int:
+			return n, nil
+		}
+	case bool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	default:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has the wrong type %T", p.Key, v)
+}
+
+// SetPreference stores value, which must have the type of the preference,
+// in the settings file.
+func SetPreference(key string, value interface{}) error {
+	p, ok := FindPreference(key)
+	if !ok {
+		return fmt.Errorf("unknown preference '%s'", key)
+	}
+	v, err := convertPreference(p, value)
+	if err != nil {
+		return err
+	}
+	values, err := readSettingsFile()
+	if err != nil {
+		return err
+	}
+	values[key] = v
+	return writeSettingsFile(values)
+}
+
+// setPreference is SetPreference for the setters of config.go, which log
+// the preferences they fail to store.
+func setPreference(key string, value interface{}) {
+	if err := SetPreference(key, value); err != nil {
+		AddLogEntry(fmt.Sprintf("Could not save %s: %v", key, err))
+	}
+}
+
+// RemovePreference resets the preference key to its default.
+func RemovePreference(key string) error {
+	values, err := readSettingsFile()
+	if err != nil {
+		return err
+	}
+	if _, ok := values[key]; !ok {
+		return nil
+	}
+	delete(values, key)
+	return writeSettingsFile(values)
+}
+
+// SettingsFile is where the preferences are kept:
+// $XDG_CONFIG_HOME/pilo/settings.toml.
+func SettingsFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pilo", "settings.toml")
+}
+
+// CheckSettingsFile reports an error if the settings file exists but cannot
+// be read. Until it is fixed, preferences take their defaults and none can be
+// changed, as saving one would overwrite the others.
+func CheckSettingsFile() error {
+	_, err := readSettingsFile()
+	return err
+}
+
+// readSettingsFile reads the settings file. When it does not exist yet, the
+// preferences earlier versions kept in the Fyne app preferences are moved
+// into a new one.
+func readSettingsFile() (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if _, err := toml.DecodeFile(SettingsFile(), &values); err != nil {
+		if os.IsNotExist(err) {
+			return migrateFynePreferences(), nil
+		}
+		return nil, fmt.Errorf("could not read %s: %w", SettingsFile(), err)
+	}
+	return values, nil
+}
+
+func writeSettingsFile(values map[string]interface{}) error {
+	path := SettingsFile()
+	if path == "" {
+		return fmt.Errorf("could not find the user configuration directory")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("# Settings of this machine, shared by the pilo command and app.\n")
+	buf.WriteString("# PILO_* environment variables override them; see \"pilo config list\".\n\n")
+	if err := toml.NewEncoder(&buf).Encode(values); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// fynePreferencesFile is where Fyne stores the preferences of the pilo app.
+func fynePreferencesFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "fyne", AppID, "preferences.json")
+}
+
+// migrateFynePreferences creates the settings file from the preferences in
+// the Fyne app preferences and returns them. The Fyne file is left alone, as
+// it also holds state of the GUI such as its logs.
+func migrateFynePreferences() map[string]interface{} {
+	values := map[string]interface{}{}
+	var prefs map[string]interface{}
+	if data, err := os.ReadFile(fynePreferencesFile()); err == nil {
+		json.Unmarshal(data, &prefs)
+	}
+	for _, p := range Preferences {
+		if v, ok := prefs[p.Key]; ok {
+			if v, err := convertPreference(p, v); err == nil {
+				values[p.Key] = v
+			}
+		}
+	}
+	if err := writeSettingsFile(values); err != nil {
+		fmt.Fprintf(os.Stderr, "could not create %s: %v\n", SettingsFile(), err)
+	}
+	return values
+}
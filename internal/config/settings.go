@@ -0,0 +1,253 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pilo/internal/schema"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Where a setting is stored.
+const (
+	StoreBaseConfig = "base-config"
+	StoreSettings   = "settings"
+)
+
+// Setting is a value "pilo config" reads and changes: a field of
+// base-config.json named by its dotted JSON path, such as "system.desktop",
+// or one of the Preferences.
+type Setting struct {
+	Key   string      `json:"key"`
+	Store string      `json:"store"`
+	Value interface{} `json:"value"`
+	// Env names the environment variable the value comes from, if any.
+	Env string `json:"env,omitempty"`
+}
+
+// readOnlySettings are base-config.json fields that pilo manages itself.
+var readOnlySettings = map[string]bool{"schema_version": true}
+
+// Settings returns every setting with its current value, the base-config.json
+// fields first.
+func Settings() ([]Setting, error) {
+	cfg, err := readBaseConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckSettingsFile(); err != nil {
+		return nil, err
+	}
+	var settings []Setting
+	for _, f := range baseConfigFields(reflect.ValueOf(cfg).Elem(), "") {
+		settings = append(settings, Setting{Key: f.key, Store: StoreBaseConfig, Value: fieldValue(f.value)})
+	}
+	for _, p := range Preferences {
+		settings = append(settings, preferenceSetting(p))
+	}
+	return settings, nil
+}
+
+// GetSetting returns the setting named key.
+func GetSetting(key string) (Setting, error) {
+	if p, ok := FindPreference(key); ok {
+		if err := CheckSettingsFile(); err != nil {
+			return Setting{}, err
+		}
+		return preferenceSetting(p), nil
+	}
+	cfg, err := readBaseConfigFile()
+	if err != nil {
+		return Setting{}, err
+	}
+	f, err := findBaseConfigField(cfg, key)
+	if err != nil {
+		return Setting{}, err
+	}
+	return Setting{Key: key, Store: StoreBaseConfig, Value: fieldValue(f)}, nil
+}
+
+// SetSetting parses value as the type of the setting named key and stores
+// it. Lists take a JSON array or comma-separated values. A base-config.json
+// that would not match its schema is not written.
+func SetSetting(key, value string) (Setting, error) {
+	if p, ok := FindPreference(key); ok {
+		v, err := parsePreference(p, value)
+		if err != nil {
+			return Setting{}, err
+		}
+		if err := SetPreference(key, v); err != nil {
+			return Setting{}, err
+		}
+		return preferenceSetting(p), nil
+	}
+
+	return updateBaseConfig(key, func(f reflect.Value) error {
+		return parseSettingValue(f, key, value)
+	})
+}
+
+// UnsetSetting resets the setting named key to its default.
+func UnsetSetting(key string) (Setting, error) {
+	if p, ok := FindPreference(key); ok {
+		if err := RemovePreference(key); err != nil {
+			return Setting{}, err
+		}
+		return preferenceSetting(p), nil
+	}
+
+	defaults := DefaultBaseConfig()
+	def, err := findBaseConfigField(&defaults, key)
+	if err != nil {
+		return Setting{}, err
+	}
+	return updateBaseConfig(key, func(f reflect.Value) error {
+		f.Set(def)
+		return nil
+	})
+}
+
+// updateBaseConfig changes the base-config.json field key with update and
+// writes the file if it still matches its schema.
+func updateBaseConfig(key string, update func(reflect.Value) error) (Setting, error) {
+	if readOnlySettings[key] {
+		return Setting{}, fmt.Errorf("%s is managed by pilo and cannot be changed", key)
+	}
+	cfg, err := readBaseConfigFile()
+	if err != nil {
+		return Setting{}, err
+	}
+	f, err := findBaseConfigField(cfg, key)
+	if err != nil {
+		return Setting{}, err
+	}
+	if err := update(f); err != nil {
+		return Setting{}, err
+	}
+	if err := writeBaseConfigFile(cfg); err != nil {
+		return Setting{}, err
+	}
+	return Setting{Key: key, Store: StoreBaseConfig, Value: fieldValue(f)}, nil
+}
+
+// fieldValue returns the value of a base-config.json field, with missing
+// lists as empty ones.
+func fieldValue(f reflect.Value) interface{} {
+	if f.Kind() == reflect.Slice && f.IsNil() {
+		return reflect.MakeSlice(f.Type(), 0, 0).Interface()
+	}
+	return f.Interface()
+}
+
+// preferenceSetting returns p with its current value, noting the environment
+// variable that overrides it.
+func preferenceSetting(p Preference) Setting {
+	s := Setting{Key: p.Key, Store: StoreSettings, Value: preferenceValue(p)}
+	if _, ok := os.LookupEnv(p.EnvVar()); ok {
+		s.Env = p.EnvVar()
+	}
+	return s
+}
+
+// readBaseConfigFile reads base-config.json alone, without the files
+// ReadConfig also reads.
+func readBaseConfigFile() (*BaseConfig, error) {
+	var cfg BaseConfig
+	if err := readJSONFile(filepath.Join(GetFlakePath(), "base-config.json"), &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// writeBaseConfigFile writes base-config.json after checking it against its
+// schema.
+func writeBaseConfigFile(cfg *BaseConfig) error {
+	if cfg.CommitTriggers == nil {
+		cfg.CommitTriggers = []string{}
+	}
+	sort.Strings(cfg.CommitTriggers)
+	path := filepath.Join(GetFlakePath(), "base-config.json")
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := schema.Check(path, data); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+type baseConfigField struct {
+	key   string
+	value reflect.Value
+}
+
+// baseConfigFields lists the leaf fields of the struct v that are written
+// to JSON, keyed by their dotted JSON path.
+func baseConfigFields(v reflect.Value, prefix string) []baseConfigField {
+	var fields []baseConfigField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		if f := v.Field(i); f.Kind() == reflect.Struct {
+			fields = append(fields, baseConfigFields(f, key+".")...)
+		} else {
+			fields = append(fields, baseConfigField{key: key, value: f})
+		}
+	}
+	return fields
+}
+
+func findBaseConfigField(cfg *BaseConfig, key string) (reflect.Value, error) {
+	for _, f := range baseConfigFields(reflect.ValueOf(cfg).Elem(), "") {
+		if f.key == key {
+			return f.value, nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("unknown setting '%s'; run 'pilo config list' to see them all", key)
+}
+
+// parseSettingValue parses value into the field f of the setting key.
+func parseSettingValue(f reflect.Value, key, value string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false", key)
+		}
+		f.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a whole number", key)
+		}
+		f.SetInt(int64(i))
+	case reflect.Slice:
+		items := []string{}
+		if strings.HasPrefix(strings.TrimSpace(value), "[") {
+			if err := json.Unmarshal([]byte(value), &items); err != nil {
+				return fmt.Errorf("%s must be a JSON array of strings: %w", key, err)
+			}
+		} else {
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+		}
+		f.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s cannot be set from the command line", key)
+	}
+	return nil
+}
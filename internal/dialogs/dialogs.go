@@ -1,8 +1,11 @@
 package dialogs
 
 import (
+	"context"
 	"fmt"
 	"pilo/internal/api"
+	"pilo/internal/merge"
+	"pilo/internal/nix"
 	"strings"
 	"time"
 
@@ -201,34 +204,101 @@ func ShowPasswordDialog(win fyne.Window, onConfirm func(password string)) {
 	form.Show()
 }
 
+// CommandAction is a long-running action shown by ShowRunningCommandDialog.
+// It should stop when ctx is cancelled and may report output through onLine.
+type CommandAction func(ctx context.Context, onLine func(line string)) (string, error)
+
+// ProgressAction is a CommandAction that can also report structured build
+// progress through onProgress.
+type ProgressAction func(ctx context.Context, onLine func(line string), onProgress func(nix.Progress)) (string, error)
+
 // ShowRunningCommandDialog shows a dialog for a running command using LogViewer.
+// Output reported by the action is appended live, and the Cancel button cancels
+// the action's context. onClose runs once the action has finished and the dialog
+// has been closed.
 func ShowRunningCommandDialog(
 	win fyne.Window,
 	title string,
-	action func() (string, error),
+	action CommandAction,
+	onClose func(output string, err error),
+) {
+	ShowProgressCommandDialog(win, title, func(ctx context.Context, onLine func(string), _ func(nix.Progress)) (string, error) {
+		return action(ctx, onLine)
+	}, onClose)
+}
+
+// ShowProgressCommandDialog is like ShowRunningCommandDialog, but also shows
+// the build progress reported by the action above the log. The progress bar
+// stays indeterminate until the action reports how much work there is.
+func ShowProgressCommandDialog(
+	win fyne.Window,
+	title string,
+	action ProgressAction,
 	onClose func(output string, err error),
 ) {
 	logViewer := NewLogViewer()
 	progress := widget.NewProgressBarInfinite()
-	content := container.NewBorder(progress, nil, nil, nil, logViewer)
+	progressBar := widget.NewProgressBar()
+	progressBar.Hide()
+	status := widget.NewLabel("")
+	status.Truncation = fyne.TextTruncateEllipsis
+	status.Hide()
+	header := container.NewVBox(progress, progressBar, status)
+	content := container.NewBorder(header, nil, nil, nil, logViewer)
 
 	var result string
 	var err error
 
-	d := dialog.NewCustom(title, "Cancel", content, win)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d := dialog.NewCustomWithoutButtons(title, content, win)
+	cancelButton := widget.NewButton("Cancel", nil)
+	cancelButton.OnTapped = func() {
+		cancel()
+		cancelButton.SetText("Cancelling...")
+		cancelButton.Disable()
+	}
+	closeButton := widget.NewButton("Close", func() {
+		d.Hide()
+	})
+	d.SetButtons([]fyne.CanvasObject{cancelButton})
 	d.SetOnClosed(func() {
+		cancel()
 		if onClose != nil {
 			onClose(result, err)
 		}
 	})
 
 	go func() {
-		result, err = action()
+		// Output that was already streamed is not repeated at the end.
+		streamed := false
+		result, err = action(ctx, func(line string) {
+			streamed = true
+			fyne.Do(func() {
+				logViewer.AppendLog(line)
+			})
+		}, func(p nix.Progress) {
+			fyne.Do(func() {
+				status.SetText(p.String())
+				status.Show()
+				if f := p.Fraction(); f >= 0 {
+					progress.Hide()
+					progressBar.Show()
+					progressBar.SetValue(f)
+				}
+			})
+		})
 		var outputLines []string
-		if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			outputLines = []string{"Cancelled."}
+		case err != nil:
 			outputLines = strings.Split(fmt.Sprintf("Error: %v\n\n%s", err, result), "\n")
-		} else {
-			outputLines = append([]string{"Command executed successfully!"}, strings.Split(result, "\n")...)
+		default:
+			outputLines = []string{"Command executed successfully!"}
+			if result != "" && !streamed {
+				outputLines = append(outputLines, strings.Split(result, "\n")...)
+			}
 		}
 
 		fyne.Do(func() {
@@ -236,7 +306,10 @@ func ShowRunningCommandDialog(
 				logViewer.AppendLog(line)
 			}
 			progress.Hide()
-			d.SetDismissText("Close")
+			if err == nil && progressBar.Visible() {
+				progressBar.SetValue(1)
+			}
+			d.SetButtons([]fyne.CanvasObject{closeButton})
 		})
 	}()
 
@@ -244,6 +317,22 @@ func ShowRunningCommandDialog(
 	d.Show()
 }
 
+// ShowClosureDiffDialog shows the changes a rebuild would make and asks
+// whether to go ahead with it.
+func ShowClosureDiffDialog(win fyne.Window, diff *api.ClosureDiff, callback func(bool)) {
+	var content fyne.CanvasObject
+	if diff.Empty() {
+		content = widget.NewLabel("The new configuration does not change the current generation.")
+	} else {
+		table := widget.NewLabel(diff.Table())
+		table.TextStyle = fyne.TextStyle{Monospace: true}
+		content = container.NewScroll(table)
+	}
+	d := dialog.NewCustomConfirm("Review changes", "Switch", "Cancel", content, callback, win)
+	d.Resize(fyne.NewSize(800, 500))
+	d.Show()
+}
+
 // ShowForm shows a form dialog.
 func ShowForm(win fyne.Window, title, confirm, dismiss string, items []*widget.FormItem, callback func(bool)) {
 	dialog.ShowForm(title, confirm, dismiss, items, callback, win)
@@ -254,6 +343,11 @@ func ShowConfirm(win fyne.Window, title, message string, callback func(bool)) {
 	dialog.ShowConfirm(title, message, callback, win)
 }
 
+// ShowInformation shows a dialog with an informational message.
+func ShowInformation(win fyne.Window, title, message string) {
+	dialog.ShowInformation(title, message, win)
+}
+
 // ShowErrorDialog shows a dialog to display an error message.
 func ShowErrorDialog(err error, win fyne.Window) {
 	dialog.ShowError(err, win)
@@ -281,3 +375,158 @@ func ShowCommitDialog(win fyne.Window, onConfirm func(message string)) {
 	form.Resize(fyne.NewSize(400, 200))
 	form.Show()
 }
+
+// Labels of the choices offered for a merge conflict, in the order of
+// merge.Choice.
+var conflictChoices = []string{"Keep ours (local)", "Keep theirs (remote)", "Keep both"}
+
+// ShowMergeConflictDialog goes through the conflicted files of the merge in
+// progress at repoPath one at a time. Each conflict shows the local and
+// remote versions side by side with a choice of which to keep. Once every
+// file is resolved the merge is committed and onDone is called.
+func ShowMergeConflictDialog(win fyne.Window, repoPath string, onDone func()) {
+	files, err := api.MergeConflicts(repoPath)
+	if err != nil {
+		dialog.ShowError(err, win)
+		return
+	}
+
+	complete := func() {
+		go func() {
+			err := api.CompleteMerge(repoPath)
+			fyne.Do(func() {
+				if err != nil {
+					dialog.ShowError(err, win)
+					return
+				}
+				if onDone != nil {
+					onDone()
+				}
+			})
+		}()
+	}
+
+	var showFile func(i int)
+	showFile = func(i int) {
+		if i == len(files) {
+			complete()
+			return
+		}
+		file := files[i]
+		rows := container.NewVBox()
+		choices := make([]*widget.RadioGroup, len(file.Conflicts))
+		for n, c := range file.Conflicts {
+			options := conflictChoices[:2]
+			if merge.CanCombine(c) {
+				options = conflictChoices
+			}
+			choices[n] = widget.NewRadioGroup(options, nil)
+			choices[n].Horizontal = true
+			choices[n].Required = true
+			choices[n].SetSelected(conflictChoices[merge.Ours])
+
+			rows.Add(widget.NewLabelWithStyle(c.String(), fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+			rows.Add(container.NewGridWithColumns(2,
+				conflictVersion("Ours (local)", c.Ours, c.Field),
+				conflictVersion("Theirs (remote)", c.Theirs, c.Field),
+			))
+			rows.Add(choices[n])
+			rows.Add(widget.NewSeparator())
+		}
+
+		confirm := "Next"
+		if i == len(files)-1 {
+			confirm = "Resolve"
+		}
+		title := fmt.Sprintf("Merge conflicts in %s (%d of %d)", file.File, i+1, len(files))
+		d := dialog.NewCustomConfirm(title, confirm, "Cancel", container.NewVScroll(rows), func(ok bool) {
+			if !ok {
+				return
+			}
+			picked := make([]merge.Choice, len(choices))
+			for n, radio := range choices {
+				for choice, label := range conflictChoices {
+					if radio.Selected == label {
+						picked[n] = merge.Choice(choice)
+					}
+				}
+			}
+			if err := api.ResolveConflicts(repoPath, file, picked); err != nil {
+				dialog.ShowError(err, win)
+				return
+			}
+			showFile(i + 1)
+		}, win)
+		d.Resize(fyne.NewSize(900, 600))
+		d.Show()
+	}
+	showFile(0)
+}
+
+// conflictVersion shows one side of a merge conflict under title.
+func conflictVersion(title, text, field string) fyne.CanvasObject {
+	switch {
+	case text == "" && field != "":
+		text = "(not set)"
+	case text == "":
+		text = "(deleted)"
+	}
+	version := widget.NewLabel(strings.TrimSuffix(text, "\n"))
+	version.TextStyle = fyne.TextStyle{Monospace: true}
+	version.Wrapping = fyne.TextWrapBreak
+	return container.NewBorder(widget.NewLabelWithStyle(title, fyne.TextAlignLeading, fyne.TextStyle{Italic: true}), nil, nil, nil, version)
+}
+
+// GitPrompter asks for SSH key passphrases and confirms unknown hosts with
+// dialogs. Its methods wait for the user's answer, so git operations that
+// may need them must run off the UI goroutine, as runCmd does.
+type GitPrompter struct {
+	win fyne.Window
+}
+
+// NewGitPrompter returns a GitPrompter showing its dialogs over win.
+func NewGitPrompter(win fyne.Window) *GitPrompter {
+	return &GitPrompter{win: win}
+}
+
+// Passphrase asks for the passphrase of the SSH key at path.
+func (p *GitPrompter) Passphrase(path string) (string, error) {
+	type answer struct {
+		passphrase string
+		ok         bool
+	}
+	answers := make(chan answer, 1)
+	fyne.Do(func() {
+		entry := widget.NewPasswordEntry()
+		d := dialog.NewForm("SSH Key Passphrase", "Unlock", "Cancel", []*widget.FormItem{
+			widget.NewFormItem("Passphrase", entry),
+		}, func(ok bool) {
+			answers <- answer{entry.Text, ok}
+		}, p.win)
+		d.Resize(fyne.NewSize(400, 150))
+		d.Show()
+		p.win.Canvas().Focus(entry)
+	})
+	a := <-answers
+	if !a.ok {
+		return "", fmt.Errorf("no passphrase was given for %s", path)
+	}
+	return a.passphrase, nil
+}
+
+// ConfirmHostKey asks whether to trust host, showing its key fingerprint.
+func (p *GitPrompter) ConfirmHostKey(host, keyType, fingerprint string) (bool, error) {
+	answers := make(chan bool, 1)
+	fyne.Do(func() {
+		message := widget.NewLabel(fmt.Sprintf(
+			"The authenticity of host %s can't be established.\n\n%s key fingerprint:\n%s\n\nOnly continue if the fingerprint matches the one published for the host. It will be added to known_hosts.",
+			host, keyType, fingerprint))
+		message.Wrapping = fyne.TextWrapWord
+		d := dialog.NewCustomConfirm("Unknown Host", "Trust Host", "Cancel", message, func(ok bool) {
+			answers <- ok
+		}, p.win)
+		d.Resize(fyne.NewSize(500, 250))
+		d.Show()
+	})
+	return <-answers, nil
+}
@@ -1,6 +1,7 @@
 package gui
 
 import (
+	"context"
 	"fmt"
 	"image/color"
 	"os"
@@ -28,16 +29,29 @@ var (
 )
 
 func run() {
-	a := app.NewWithID("dev.stewlab.pilo")
+	a := app.NewWithID(config.AppID)
 	config.Init(a)
 	a.Settings().SetTheme(&myTheme{})
 	w := a.NewWindow("pilo")
 	w.Resize(fyne.NewSize(800, 600))
 	w.CenterOnScreen()
+	api.SetPrompter(dialogs.NewGitPrompter(w))
 
 	// Handle auto-installation if the config path doesn't exist
 	configEditorTabContent := tabs.CreateConfigEditorTab(w)
-	handleAutoInstall(w, configEditorTabContent)
+	if err := config.CheckSettingsFile(); err != nil {
+		// The default install path may not be the configuration in use.
+		dialogs.ShowErrorDialog(fmt.Errorf("%w; the default settings are used and none is saved until it is fixed", err), w)
+	} else {
+		handleAutoInstall(w, configEditorTabContent)
+	}
+	if done, err := api.MigrateConfig(); err != nil {
+		dialogs.ShowErrorDialog(fmt.Errorf("could not migrate the configuration: %w", err), w)
+	} else {
+		for _, d := range done {
+			config.AddLogEntry("Migrated configuration: " + d)
+		}
+	}
 
 	if nix.GetNixMode() == nix.None {
 		dialog.NewInformation("Nix Not Found", "Nix is not installed. Please install it from the preferences tab for full functionality.", w).Show()
@@ -51,7 +65,7 @@ func run() {
 	flakePathEntry := widget.NewEntry()
 	flakePathEntry.SetText(config.GetInstallPath())
 	flakePathEntry.OnChanged = func(s string) {
-		a.Preferences().SetString("installationPath", s)
+		config.SetInstallPath(s)
 	}
 
 	pendingActionsBinding := binding.NewStringList()
@@ -73,9 +87,9 @@ func run() {
 		}()
 	})
 
-	runCmd := func(f func() (string, error), msg string, showOutput bool, refresh func()) {
+	runStreamCmd := func(f dialogs.ProgressAction, msg string, showOutput bool, refresh func()) {
 		config.App.Preferences().SetString("currentTime", time.Now().Format(time.RFC3339)) // Set current time for logging
-		dialogs.ShowRunningCommandDialog(w, msg, f, func(output string, err error) {
+		dialogs.ShowProgressCommandDialog(w, msg, f, func(output string, err error) {
 			fyne.Do(func() {
 				if err != nil {
 					config.AddLogEntry(fmt.Sprintf("Error: %v", err))
@@ -94,6 +108,12 @@ func run() {
 		})
 	}
 
+	runCmd := func(f func() (string, error), msg string, showOutput bool, refresh func()) {
+		runStreamCmd(func(context.Context, func(string), func(nix.Progress)) (string, error) {
+			return f()
+		}, msg, showOutput, refresh)
+	}
+
 	refreshPendingActions = func() {
 		go func() {
 			actions, err := api.GetPendingActions()
@@ -135,9 +155,7 @@ func run() {
 		}
 	}
 
-	systemTabContent := tabs.CreateSystemTab(func(f func() (string, error), msg string, showOutput bool, refreshFunc func()) {
-		runCmd(f, msg, showOutput, refreshFunc)
-	}, config.GetFlakePath(), a.Preferences(), w, refreshPendingActions)
+	systemTabContent := tabs.CreateSystemTab(runStreamCmd, config.GetFlakePath(), a.Preferences(), w, refreshPendingActions)
 	packagesTabContent := tabs.CreatePackagesTab(func(f func() error, msg string, showOutput bool, refreshFunc func()) {
 		runCmd(func() (string, error) {
 			err := f()
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 
 	"pilo/internal/config"
+	"pilo/internal/schema"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -160,6 +161,13 @@ func CreateConfigEditorTab(win fyne.Window) *ConfigEditorTab {
 		if tab.selectedFile == "" {
 			return
 		}
+		// Refuse to save a pilo JSON file that does not match its schema.
+		if _, err := schema.For(tab.selectedFile); err == nil {
+			if err := schema.Check(tab.selectedFile, []byte(tab.editor.Text)); err != nil {
+				dialog.ShowError(err, win)
+				return
+			}
+		}
 		err := os.WriteFile(tab.selectedFile, []byte(tab.editor.Text), 0644)
 		if err != nil {
 			dialog.ShowError(err, win)
@@ -0,0 +1,173 @@
+package tabs
+
+import (
+	"context"
+	"fmt"
+	"pilo/internal/api"
+	"pilo/internal/config"
+	"pilo/internal/dialogs"
+	"pilo/internal/nix"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/widget"
+)
+
+// GenerationsPanel lists the generations of the default profile and offers
+// switching, comparing, pinning and deleting them.
+type GenerationsPanel struct {
+	fyne.CanvasObject
+	list        *widget.List
+	status      *widget.Label
+	profile     api.Profile
+	generations []api.Generation
+}
+
+// Refresh reloads the generations in the background.
+func (p *GenerationsPanel) Refresh() {
+	go func() {
+		var gens []api.Generation
+		profile, err := api.DefaultProfile()
+		if err == nil {
+			gens, err = api.Generations(context.Background(), profile)
+		}
+		fyne.Do(func() {
+			if err != nil {
+				p.status.SetText("Could not load generations: " + err.Error())
+				return
+			}
+			p.profile = profile
+			p.generations = gens
+			p.status.SetText(fmt.Sprintf("%d generations of %s", len(gens), profile))
+			p.list.Refresh()
+		})
+	}()
+}
+
+func newGenerationsPanel(
+	runCmd func(f dialogs.ProgressAction, msg string, showOutput bool, refresh func()),
+	w fyne.Window,
+) *GenerationsPanel {
+	p := &GenerationsPanel{status: widget.NewLabel("Loading generations...")}
+
+	withPassword := func(onConfirm func(password string)) {
+		if p.profile.IsSystem() {
+			dialogs.ShowPasswordDialog(w, onConfirm)
+		} else {
+			onConfirm("")
+		}
+	}
+
+	p.list = widget.NewList(
+		func() int {
+			return len(p.generations)
+		},
+		func() fyne.CanvasObject {
+			return container.NewHBox(
+				widget.NewLabel("template"),
+				layout.NewSpacer(),
+				widget.NewButton("...", nil),
+			)
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			gen := p.generations[i]
+			hbox := o.(*fyne.Container)
+			label := hbox.Objects[0].(*widget.Label)
+			text := fmt.Sprintf("#%d  %s  %.1f MiB", gen.Number, gen.Date.Format("2006-01-02 15:04"), float64(gen.ClosureSize)/(1024*1024))
+			if gen.NixpkgsRevision != "" {
+				text += "  nixpkgs " + gen.NixpkgsRevision
+			}
+			if gen.Current {
+				text += "  (current)"
+			}
+			if gen.Pinned {
+				text += "  📌"
+			}
+			label.SetText(text)
+
+			button := hbox.Objects[2].(*widget.Button)
+			button.OnTapped = func() {
+				pinLabel := "📌  Pin"
+				if gen.Pinned {
+					pinLabel = "📌  Unpin"
+				}
+				menu := fyne.NewMenu("",
+					fyne.NewMenuItem("↩️  Switch to", func() {
+						withPassword(func(password string) {
+							runCmd(func(context.Context, func(string), func(nix.Progress)) (string, error) {
+								out, err := api.SwitchGeneration(gen.Profile, gen.Number, password)
+								if err != nil {
+									config.AddLogEntry(fmt.Sprintf("Error switching to generation %d: %v", gen.Number, err))
+									return out, err
+								}
+								config.AddLogEntry(fmt.Sprintf("Switched to generation %d", gen.Number))
+								return out, nil
+							}, fmt.Sprintf("↩️  Switching to generation %d...", gen.Number), true, p.Refresh)
+						})
+					}),
+					fyne.NewMenuItem("🔍  Compare with current", func() {
+						current := currentGeneration(p.generations)
+						if current == 0 {
+							dialogs.ShowErrorDialog(fmt.Errorf("the current generation is unknown"), w)
+							return
+						}
+						runCmd(func(ctx context.Context, _ func(string), _ func(nix.Progress)) (string, error) {
+							diff, err := api.DiffGenerations(ctx, gen.Profile, current, gen.Number)
+							if err != nil {
+								return "", err
+							}
+							return diff.Table(), nil
+						}, fmt.Sprintf("🔍  Comparing generation %d with %d...", current, gen.Number), true, nil)
+					}),
+					fyne.NewMenuItem(pinLabel, func() {
+						var err error
+						if gen.Pinned {
+							err = api.UnpinGeneration(gen.Profile, gen.Number)
+						} else {
+							err = api.PinGeneration(gen.Profile, gen.Number)
+						}
+						if err != nil {
+							dialogs.ShowErrorDialog(err, w)
+						}
+						p.Refresh()
+					}),
+					fyne.NewMenuItem("🗑️  Delete", func() {
+						dialogs.ShowConfirm(w, "Delete generation", fmt.Sprintf("Delete generation %d?", gen.Number), func(ok bool) {
+							if !ok {
+								return
+							}
+							withPassword(func(password string) {
+								runCmd(func(context.Context, func(string), func(nix.Progress)) (string, error) {
+									return api.DeleteGenerations(gen.Profile, []int{gen.Number}, password)
+								}, fmt.Sprintf("🗑️  Deleting generation %d...", gen.Number), true, p.Refresh)
+							})
+						})
+					}),
+				)
+				widget.NewPopUpMenu(menu, w.Canvas()).ShowAtPosition(fyne.CurrentApp().Driver().AbsolutePositionForObject(button))
+			}
+		},
+	)
+
+	p.CanvasObject = container.NewBorder(
+		container.NewVBox(
+			widget.NewLabelWithStyle("Generations", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+			p.status,
+		),
+		nil, nil, nil,
+		p.list,
+	)
+	p.Refresh()
+	return p
+}
+
+// currentGeneration returns the number of the current generation, or 0.
+func currentGeneration(gens []api.Generation) int {
+	for _, g := range gens {
+		if g.Current {
+			return g.Number
+		}
+	}
+	return 0
+}
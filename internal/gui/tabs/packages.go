@@ -1,8 +1,12 @@
 package tabs
 
 import (
+	"context"
+	"fmt"
 	"pilo/internal/api"
 	"pilo/internal/config"
+	"pilo/internal/importer"
+	"pilo/internal/nix"
 	"strings"
 
 	"fyne.io/fyne/v2"
@@ -18,11 +22,13 @@ type PackagesTab struct {
 	fyne.CanvasObject
 	refreshInstalled func(showDialog bool)
 	refreshCustom    func()
+	refreshUntracked func()
 }
 
 func (t *PackagesTab) Refresh() {
 	t.refreshInstalled(false) // Do not show dialog on automatic refresh
 	t.refreshCustom()
+	t.refreshUntracked()
 }
 
 func CreatePackagesTab(runCmd func(func() error, string, bool, func()), a fyne.App, w fyne.Window, refreshPendingActions func()) *PackagesTab {
@@ -33,15 +39,16 @@ func CreatePackagesTab(runCmd func(func() error, string, bool, func()), a fyne.A
 	var installedPackagesList *widget.List
 	refreshInstalled := func(showDialog bool) {
 		go func() {
-			getPackages := func() (string, error) {
+			getPackages := func(context.Context, func(string)) (string, error) {
 				pkgs, err := api.GetInstalledPackages()
 				if err != nil {
 					return "", err
 				}
-				var items []interface{}
-				for _, pkg := range pkgs {
-					items = append(items, pkg)
+				groups, err := api.ListPackageGroups()
+				if err != nil {
+					return "", err
 				}
+				items := groupedPackageItems(pkgs, groups)
 				fyne.Do(func() {
 					installedPackagesBinding.Set(items)
 					installedPackagesList.Refresh()
@@ -52,27 +59,87 @@ func CreatePackagesTab(runCmd func(func() error, string, bool, func()), a fyne.A
 			if showDialog {
 				dialogs.ShowRunningCommandDialog(w, "Getting installed packages...", getPackages, nil)
 			} else {
-				getPackages()
+				getPackages(context.Background(), nil)
 			}
 		}()
 	}
 
+	packageSources, sourcesErr := api.PackageSources()
+	if sourcesErr != nil {
+		config.AddLogEntry("Error reading package sources: " + sourcesErr.Error())
+		packageSources = []string{config.SourceStable, config.SourceUnstable}
+	}
+
 	installedPackagesList = widget.NewListWithData(
 		installedPackagesBinding,
 		func() fyne.CanvasObject {
-			return container.NewBorder(nil, nil, widget.NewLabel("Template"), widget.NewButton("🗑️  Remove", nil))
+			enabledCheck := widget.NewCheck("Enabled", nil)
+			sourceSelect := widget.NewSelect(packageSources, nil)
+			return container.NewBorder(nil, nil, widget.NewLabel("Template"), container.NewHBox(enabledCheck, sourceSelect, widget.NewButton("🗑️  Remove", nil)))
 		},
 		func(i binding.DataItem, o fyne.CanvasObject) {
 			untyped, _ := i.(binding.Untyped).Get()
-			pkg := untyped.(config.Package)
 			label := o.(*fyne.Container).Objects[0].(*widget.Label)
-			// if !pkg.Installed {
-			// 	label.SetText(pkg.Name + " (pending)")
-			// } else {
-			// 	label.SetText(pkg.Name)
-			// }
-			label.SetText(pkg.Name)
-			removeButton := o.(*fyne.Container).Objects[1].(*widget.Button)
+			controls := o.(*fyne.Container).Objects[1].(*fyne.Container)
+			if header, ok := untyped.(packageGroupHeader); ok {
+				updatePackageGroupHeader(header, label, controls, runCmd, func() {
+					refreshInstalled(false)
+					refreshPendingActions()
+				})
+				return
+			}
+			label.TextStyle = fyne.TextStyle{}
+			for _, c := range controls.Objects {
+				c.Show()
+			}
+
+			pkg := untyped.(config.Package)
+			if !pkg.Installed {
+				label.SetText(pkg.Name + " (disabled)")
+			} else {
+				label.SetText(pkg.Name)
+			}
+
+			enabledCheck := controls.Objects[0].(*widget.Check)
+			enabledCheck.OnChanged = nil
+			enabledCheck.SetChecked(pkg.Installed)
+			enabledCheck.OnChanged = func(enabled bool) {
+				if enabled == pkg.Installed {
+					return
+				}
+				message := "⏸️  Disabling package..."
+				if enabled {
+					message = "▶️  Enabling package..."
+				}
+				runCmd(func() error {
+					return api.SetPackageInstalled(pkg.Name, enabled)
+				}, message, false, func() {
+					refreshInstalled(false)
+					refreshPendingActions()
+				})
+			}
+
+			sourceSelect := controls.Objects[1].(*widget.Select)
+			source := pkg.Source
+			if source == "" {
+				source = config.SourceStable
+			}
+			// Clear the handler so showing the current source does not change it.
+			sourceSelect.OnChanged = nil
+			sourceSelect.SetSelected(source)
+			sourceSelect.OnChanged = func(selected string) {
+				if selected == source {
+					return
+				}
+				runCmd(func() error {
+					return api.SetPackageSource(pkg.Name, selected)
+				}, "🔀  Changing package source...", false, func() {
+					refreshInstalled(false)
+					refreshPendingActions()
+				})
+			}
+
+			removeButton := controls.Objects[2].(*widget.Button)
 			removeButton.OnTapped = func() {
 				dialogs.ShowConfirm(w, "Remove Package", "Are you sure you want to remove "+pkg.Name+"?", func(ok bool) {
 					if ok {
@@ -88,10 +155,20 @@ func CreatePackagesTab(runCmd func(func() error, string, bool, func()), a fyne.A
 		},
 	)
 
+	installedDetail := newPackageDetail()
+	installedPackagesList.OnSelected = func(id widget.ListItemID) {
+		if item, err := installedPackagesBinding.GetValue(id); err == nil {
+			if pkg, ok := item.(config.Package); ok {
+				showPackageDetail(installedDetail, pkg)
+			}
+		}
+	}
+
 	refreshInstalledButton := widget.NewButton("🔄  Refresh", func() {
 		refreshInstalled(true)
 	})
-	installedBox := container.NewBorder(container.NewVBox(widget.NewLabel("Installed Packages"), refreshInstalledButton), nil, nil, nil, installedPackagesList)
+	installedBox := container.NewBorder(container.NewVBox(widget.NewLabel("Installed Packages"), refreshInstalledButton), nil, nil, nil,
+		packageDetailSplit(installedPackagesList, installedDetail))
 	refreshInstalled(false) // Initial load without dialog
 
 	// --- Search packages Tab ---
@@ -126,7 +203,13 @@ func CreatePackagesTab(runCmd func(func() error, string, bool, func()), a fyne.A
 				dialogs.ShowConfirm(w, "Add Package to Config", "Are you sure you want to add "+pkg.Name+" to your config?", func(ok bool) {
 					if ok {
 						runCmd(func() error {
-							return api.AddPackage(pkg.Name)
+							warnings, err := api.AddPackageFromSource(pkg.Name, "")
+							if len(warnings) > 0 {
+								fyne.Do(func() {
+									dialogs.ShowInformation(w, "Package Warnings", pkg.Name+" was added, but:\n\n• "+strings.Join(warnings, "\n• "))
+								})
+							}
+							return err
 						}, "📥  Adding package...", false, func() {
 							refreshInstalled(false)
 							refreshPendingActions()
@@ -153,8 +236,15 @@ func CreatePackagesTab(runCmd func(func() error, string, bool, func()), a fyne.A
 	freeOnlyCheck := widget.NewCheck("Show only free software", nil)
 
 	searchButton := widget.NewButton("🔍  Search", func() {
-		dialogs.ShowRunningCommandDialog(w, "🔍  Searching...", func() (string, error) {
-			out, err := api.Search(strings.Fields(searchEntry.Text), sortByPopularityCheck.Checked, freeOnlyCheck.Checked)
+		if !api.SearchIndexReady() {
+			if building, err := api.StartSearchIndexBuild(); err != nil {
+				config.AddLogEntry("Error building the search index: " + err.Error())
+			} else if building {
+				config.AddLogEntry("The search index is being built in the background; until it is ready, results come from nix search.")
+			}
+		}
+		dialogs.ShowRunningCommandDialog(w, "🔍  Searching...", func(ctx context.Context, _ func(string)) (string, error) {
+			out, err := api.SearchContext(ctx, strings.Fields(searchEntry.Text), sortByPopularityCheck.Checked, freeOnlyCheck.Checked)
 			if err != nil {
 				config.AddLogEntry("Error searching packages: " + err.Error())
 				return "", err
@@ -179,7 +269,13 @@ func CreatePackagesTab(runCmd func(func() error, string, bool, func()), a fyne.A
 		freeOnlyCheck,
 		searchButton,
 	)
-	searchBox := container.NewBorder(searchControls, nil, nil, nil, resultsList)
+	searchDetail := newPackageDetail()
+	resultsList.OnSelected = func(id widget.ListItemID) {
+		if item, err := resultsBinding.GetValue(id); err == nil {
+			showPackageDetail(searchDetail, item.(config.Package))
+		}
+	}
+	searchBox := container.NewBorder(searchControls, nil, nil, nil, packageDetailSplit(resultsList, searchDetail))
 
 	// --- Custom Packages Tab ---
 	var list *widget.List
@@ -344,16 +440,239 @@ func CreatePackagesTab(runCmd func(func() error, string, bool, func()), a fyne.A
 	)
 	customPackagesBox := container.NewBorder(customPackagesControls, nil, nil, nil, list)
 
+	// --- Untracked Packages Tab ---
+	var untracked []importer.ProfileElement
+	untrackedLabel := widget.NewLabel("")
+	untrackedLabel.Wrapping = fyne.TextWrapWord
+	untrackedList := widget.NewList(
+		func() int {
+			return len(untracked)
+		},
+		func() fyne.CanvasObject {
+			return widget.NewLabel("Template")
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			o.(*widget.Label).SetText(untracked[i].AttrPath + "  (from " + untracked[i].OriginalURL + ")")
+		},
+	)
+
+	refreshUntracked := func() {
+		go func() {
+			elements, err := api.UntrackedPackages(context.Background())
+			fyne.Do(func() {
+				switch {
+				case err != nil:
+					untracked = nil
+					untrackedLabel.SetText("Could not inspect your profile: " + err.Error())
+				case len(elements) == 0:
+					untracked = nil
+					untrackedLabel.SetText("Every package in your profile is tracked by your configuration.")
+				default:
+					untracked = elements
+					untrackedLabel.SetText(fmt.Sprintf("%d package(s) were installed with \"nix profile install\" and are not in packages.json.", len(elements)))
+				}
+				untrackedList.Refresh()
+			})
+		}()
+	}
+	refreshUntracked()
+
+	adoptButton := widget.NewButton("📦  Adopt All", func() {
+		if len(untracked) == 0 {
+			return
+		}
+		elements := untracked
+		adopt := func(password string) {
+			dialogs.ShowProgressCommandDialog(w, "📦  Adopting packages...", func(ctx context.Context, onLine func(string), onProgress func(nix.Progress)) (string, error) {
+				result, out, err := api.AdoptPackages(ctx, elements, api.RebuildOptions{
+					FlakePath:  config.GetFlakePath(),
+					Password:   password,
+					OnOutput:   onLine,
+					OnProgress: onProgress,
+				})
+				if result != nil {
+					for _, missing := range result.NotFound {
+						onLine("Left in your profile: " + missing.Error())
+					}
+				}
+				return out, err
+			}, func(string, error) {
+				refreshUntracked()
+				refreshInstalled(false)
+				refreshPendingActions()
+			})
+		}
+		dialogs.ShowConfirm(w, "Adopt Packages", "Move these packages into your configuration and rebuild? They are removed from your profile once the rebuild succeeds.", func(ok bool) {
+			if !ok {
+				return
+			}
+			if nix.GetNixMode() == nix.NixOS {
+				dialogs.ShowPasswordDialog(w, adopt)
+			} else {
+				adopt("")
+			}
+		})
+	})
+	refreshUntrackedButton := widget.NewButton("🔄  Refresh", refreshUntracked)
+	untrackedControls := container.NewVBox(
+		widget.NewLabel("Untracked Packages"),
+		untrackedLabel,
+		container.NewHBox(refreshUntrackedButton, adoptButton),
+	)
+	untrackedBox := container.NewBorder(untrackedControls, nil, nil, nil, untrackedList)
+
 	tabs := container.NewAppTabs(
 		container.NewTabItem("Search", searchBox),
 		container.NewTabItem("Installed", installedBox),
 		container.NewTabItem("Custom", customPackagesBox),
+		container.NewTabItem("Untracked", untrackedBox),
 	)
 
 	tab := &PackagesTab{
 		CanvasObject:     container.NewPadded(tabs),
 		refreshInstalled: refreshInstalled,
 		refreshCustom:    refreshCustom,
+		refreshUntracked: refreshUntracked,
 	}
 	return tab
 }
+
+// newPackageDetail creates the pane that shows the metadata of the selected package.
+func newPackageDetail() *widget.RichText {
+	detail := widget.NewRichTextFromMarkdown("*Select a package to see its details.*")
+	detail.Wrapping = fyne.TextWrapWord
+	return detail
+}
+
+// packageDetailSplit places a package list next to its detail pane.
+func packageDetailSplit(list fyne.CanvasObject, detail *widget.RichText) fyne.CanvasObject {
+	split := container.NewHSplit(list, container.NewVScroll(detail))
+	split.Offset = 0.6
+	return split
+}
+
+func showPackageDetail(detail *widget.RichText, pkg config.Package) {
+	detail.ParseMarkdown(packageDetailMarkdown(pkg))
+}
+
+// packageDetailMarkdown describes a package and its nixpkgs metadata.
+func packageDetailMarkdown(pkg config.Package) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## %s\n\n", pkg.Name)
+	if pkg.Description != "" {
+		fmt.Fprintf(&b, "%s\n\n", pkg.Description)
+	}
+	if pkg.Source != "" {
+		fmt.Fprintf(&b, "Taken from **%s**.\n\n", pkg.Source)
+	}
+	meta := pkg.Meta
+	if meta == nil {
+		b.WriteString("*No metadata available. Search for the package to index nixpkgs.*\n")
+		return b.String()
+	}
+
+	var warnings []string
+	if meta.Unfree {
+		warnings = append(warnings, "unfree")
+	}
+	if meta.Broken {
+		warnings = append(warnings, "broken")
+	}
+	if meta.Insecure {
+		warnings = append(warnings, "insecure")
+	}
+	if len(warnings) > 0 {
+		fmt.Fprintf(&b, "**⚠️  %s**\n\n", strings.Join(warnings, ", "))
+	}
+
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "- **%s:** %s\n", name, value)
+		}
+	}
+	field("Attribute path", meta.AttrPath)
+	field("Package name", meta.Pname)
+	field("Version", meta.Version)
+	field("License", strings.Join(meta.License, ", "))
+	field("Homepage", meta.Homepage)
+	field("Main program", meta.MainProgram)
+	field("Platforms", strings.Join(meta.Platforms, ", "))
+	return b.String()
+}
+
+// packageGroupHeader is a row of the installed packages list that starts a
+// package group. Group is nil for the packages without a group.
+type packageGroupHeader struct {
+	Group *api.PackageGroupInfo
+}
+
+// groupedPackageItems lists the packages under a header for each group,
+// followed by the packages without a group. Without groups there are no
+// headers.
+func groupedPackageItems(pkgs []config.Package, groups []api.PackageGroupInfo) []interface{} {
+	var items []interface{}
+	if len(groups) == 0 {
+		for _, pkg := range pkgs {
+			items = append(items, pkg)
+		}
+		return items
+	}
+
+	grouped := make(map[string]bool)
+	for i := range groups {
+		items = append(items, packageGroupHeader{Group: &groups[i]})
+		grouped[groups[i].Name] = true
+		for _, pkg := range pkgs {
+			if pkg.Group == groups[i].Name {
+				items = append(items, pkg)
+			}
+		}
+	}
+	items = append(items, packageGroupHeader{})
+	for _, pkg := range pkgs {
+		if !grouped[pkg.Group] {
+			items = append(items, pkg)
+		}
+	}
+	return items
+}
+
+// updatePackageGroupHeader shows a group header in an installed package row,
+// reusing the row's enable check for the group.
+func updatePackageGroupHeader(header packageGroupHeader, label *widget.Label, controls *fyne.Container, runCmd func(func() error, string, bool, func()), refresh func()) {
+	label.TextStyle = fyne.TextStyle{Bold: true}
+	for _, c := range controls.Objects {
+		c.Hide()
+	}
+	group := header.Group
+	if group == nil {
+		label.SetText("Other packages")
+		return
+	}
+
+	text := fmt.Sprintf("%s (%d)", group.Name, len(group.Packages))
+	if group.Description != "" {
+		text += " - " + group.Description
+	}
+	if !group.Used {
+		text += " (not used on this host)"
+	}
+	label.SetText(text)
+
+	enabledCheck := controls.Objects[0].(*widget.Check)
+	enabledCheck.OnChanged = nil
+	enabledCheck.SetChecked(group.Enabled)
+	enabledCheck.OnChanged = func(enabled bool) {
+		if enabled == group.Enabled {
+			return
+		}
+		message := "⏸️  Disabling package group..."
+		if enabled {
+			message = "▶️  Enabling package group..."
+		}
+		runCmd(func() error {
+			return api.SetPackageGroupEnabled(group.Name, enabled)
+		}, message, false, refresh)
+	}
+	enabledCheck.Show()
+}
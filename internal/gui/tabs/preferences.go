@@ -1,9 +1,11 @@
 package tabs
 
 import (
+	"errors"
 	"fmt"
 	"pilo/internal/api"
 	"pilo/internal/config" // New import
+	"pilo/internal/dialogs"
 	"pilo/internal/gui/components"
 	"pilo/internal/nix"
 	"strconv"
@@ -88,15 +90,14 @@ func CreatePreferencesTab(
 	tab.installPathEntry = components.NewSafeEntry()
 	tab.installPathEntry.SetText(config.GetInstallPath())
 	tab.installPathEntry.OnChanged = func(s string) {
-		prefs.SetString("installationPath", s)
+		config.SetInstallPath(s)
 		flakePathEntry.SetText(s)
 	}
 
-	registryName := prefs.StringWithFallback("registryName", "pilo")
 	tab.registryNameEntry = components.NewSafeEntry()
-	tab.registryNameEntry.SetText(registryName)
+	tab.registryNameEntry.SetText(config.GetRegistryName())
 	tab.registryNameEntry.OnChanged = func(s string) {
-		prefs.SetString("registryName", s)
+		config.SetRegistryName(s)
 	}
 
 	// Remote Git Repository
@@ -257,6 +258,36 @@ func CreatePreferencesTab(
 		nixInstallButton.Disable()
 	}
 
+	// Syncing stops at every conflict with the remote, which is then
+	// resolved in the conflict dialog before syncing again.
+	var syncWithRemote func()
+	syncWithRemote = func() {
+		repoPath := config.GetInstallPath()
+		conflicted := false
+		runCmd(func() (string, error) {
+			if files, err := api.MergeConflicts(repoPath); err != nil {
+				return "", err
+			} else if len(files) > 0 {
+				conflicted = true
+				return "A merge with the remote has conflicts to resolve.", nil
+			}
+			result, err := api.GitSync(repoPath, true)
+			var conflicts *api.MergeConflictError
+			if errors.As(err, &conflicts) {
+				conflicted = true
+				return "The remote changes conflict with yours; choose which versions to keep.", nil
+			}
+			if err != nil {
+				return "", err
+			}
+			return result.String(), nil
+		}, " syncing with remote", true, func() {
+			if conflicted {
+				dialogs.ShowMergeConflictDialog(w, repoPath, syncWithRemote)
+			}
+		})
+	}
+
 	// Commit Triggers
 	updateCommitTriggers := func() {
 		var triggers []string
@@ -356,15 +387,7 @@ func CreatePreferencesTab(
 				return "Backup created successfully!", nil
 			}, "💾 Creating Backup", true, nil)
 		}),
-			widget.NewButton("🚀 Sync with Remote", func() {
-				runCmd(func() (string, error) {
-					err := api.GitSync(config.GetInstallPath())
-					if err != nil {
-						return "", err
-					}
-					return "Successfully synced with remote!", nil
-				}, " syncing with remote", true, nil)
-			}),
+			widget.NewButton("🚀 Sync with Remote", syncWithRemote),
 		)),
 		widget.NewLabelWithStyle("Git Status", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		func() fyne.CanvasObject {
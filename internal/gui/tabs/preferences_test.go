@@ -10,6 +10,8 @@ import (
 )
 
 func TestPreferencesTab_Refresh(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
 	a := test.NewApp()
 	w := a.NewWindow("Test")
 	defer w.Close()
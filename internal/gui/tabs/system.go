@@ -1,10 +1,12 @@
 package tabs
 
 import (
+	"context"
 	"pilo/internal/api"
 	"pilo/internal/config"
 	"pilo/internal/dialogs" // New import
 	"pilo/internal/gui/components"
+	"pilo/internal/nix"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -26,7 +28,7 @@ func (t *SystemTab) Refresh() {
 
 // CreateSystemTab creates the content for the "System" tab
 func CreateSystemTab(
-	runCmd func(f func() (string, error), msg string, showOutput bool, refresh func()),
+	runCmd func(f dialogs.ProgressAction, msg string, showOutput bool, refresh func()),
 	flakePath string,
 	prefs fyne.Preferences,
 	w fyne.Window, // Add w here
@@ -37,36 +39,77 @@ func CreateSystemTab(
 		refreshPendingActions: refreshPendingActions,
 	}
 
-	rebuildButton := widget.NewButton("🚀  Commit & Rebuild", func() {
+	generationsPanel := newGenerationsPanel(runCmd, w)
+
+	modeLabels := map[string]api.RebuildMode{
+		"Switch now":          api.RebuildSwitch,
+		"Switch on next boot": api.RebuildBoot,
+		"Build only":          api.RebuildBuild,
+		"Dry run":             api.RebuildDryRun,
+	}
+	modeSelect := widget.NewSelect([]string{"Switch now", "Switch on next boot", "Build only", "Dry run"}, nil)
+	modeSelect.SetSelected("Switch now")
+
+	rebuild := func(mode api.RebuildMode) {
 		dialogs.ShowPasswordDialog(w, func(password string) {
-			runCmd(func() (string, error) {
-				out, err := api.Rebuild(flakePath, password, "", "")
+			runCmd(func(ctx context.Context, onLine func(string), onProgress func(nix.Progress)) (string, error) {
+				out, err := api.RebuildContext(ctx, api.RebuildOptions{
+					FlakePath:  flakePath,
+					Password:   password,
+					Mode:       mode,
+					OnOutput:   onLine,
+					OnProgress: onProgress,
+				})
 				if err != nil {
 					config.AddLogEntry("Error rebuilding system: " + err.Error())
 					return out, err
 				}
-				config.AddLogEntry("System rebuilt successfully!")
-
-				// Add and commit changes after successful rebuild
-				path := config.GetInstallPath()
-				if err := api.GitAdd(path); err != nil {
-					config.AddLogEntry("Error adding changes: " + err.Error())
-					// Don't return error, just log it
-				}
-				if err := api.GitCommit(path, "pilo: rebuild"); err != nil {
-					config.AddLogEntry("Error committing changes: " + err.Error())
-					// Don't return error, just log it
+				if !mode.Activates() {
+					config.AddLogEntry("Configuration built successfully (" + string(mode) + ")")
+					return out, nil
 				}
+				config.AddLogEntry("System rebuilt successfully!")
 
 				refreshPendingActions()
 				return out, nil
-			}, "🚀  Rebuilding system...", true, nil)
+			}, "🚀  Rebuilding system...", true, generationsPanel.Refresh)
+		})
+	}
+
+	rebuildButton := widget.NewButton("🚀  Commit & Rebuild", func() {
+		mode := modeLabels[modeSelect.Selected]
+		if !mode.Activates() {
+			rebuild(mode)
+			return
+		}
+
+		// Build the new configuration first and let the user review what
+		// switching to it would change.
+		var diff *api.ClosureDiff
+		dialogs.ShowProgressCommandDialog(w, "🔍  Previewing changes...", func(ctx context.Context, onLine func(string), onProgress func(nix.Progress)) (string, error) {
+			var err error
+			diff, err = api.PreviewRebuild(ctx, api.RebuildOptions{
+				FlakePath:  flakePath,
+				OnOutput:   onLine,
+				OnProgress: onProgress,
+			})
+			return "", err
+		}, func(_ string, err error) {
+			if err != nil {
+				config.AddLogEntry("Error previewing rebuild: " + err.Error())
+				return
+			}
+			dialogs.ShowClosureDiffDialog(w, diff, func(confirmed bool) {
+				if confirmed {
+					rebuild(mode)
+				}
+			})
 		})
 	})
 
 	updateButton := widget.NewButton("🔄  Update", func() {
-		runCmd(func() (string, error) {
-			out, err := api.Update("")
+		runCmd(func(ctx context.Context, onLine func(string), _ func(nix.Progress)) (string, error) {
+			out, err := api.UpdateContext(ctx, "", onLine)
 			if err != nil {
 				config.AddLogEntry("Error updating flake inputs: " + err.Error())
 				return out, err
@@ -79,7 +122,7 @@ func CreateSystemTab(
 
 	rollbackButton := widget.NewButton("↩️  Rollback System", func() {
 		dialogs.ShowPasswordDialog(w, func(password string) {
-			runCmd(func() (string, error) {
+			runCmd(func(context.Context, func(string), func(nix.Progress)) (string, error) {
 				out, err := api.Rollback(password)
 				if err != nil {
 					config.AddLogEntry("Error rolling back system: " + err.Error())
@@ -88,12 +131,12 @@ func CreateSystemTab(
 				config.AddLogEntry("System rolled back successfully!")
 				refreshPendingActions() // Call refresh after rollback
 				return out, nil
-			}, "↩️  Rolling back to previous generation...", true, nil)
+			}, "↩️  Rolling back to previous generation...", true, generationsPanel.Refresh)
 		})
 	})
 
 	upgradeButton := widget.NewButton("⬆️  Upgrade Packages", func() {
-		runCmd(func() (string, error) {
+		runCmd(func(context.Context, func(string), func(nix.Progress)) (string, error) {
 			out, err := api.Upgrade()
 			if err != nil {
 				config.AddLogEntry("Error upgrading packages: " + err.Error())
@@ -105,9 +148,9 @@ func CreateSystemTab(
 		}, "⬆️  Upgrading packages...", true, nil)
 	})
 
-	gcButton := widget.NewButton("🗑️  Run Garbage Collection", func() {
-		runCmd(func() (string, error) {
-			out, err := api.GC()
+	runGC := func(plan *api.GCPlan, password string) {
+		runCmd(func(ctx context.Context, onLine func(string), _ func(nix.Progress)) (string, error) {
+			out, err := api.RunGC(ctx, plan, password, onLine)
 			if err != nil {
 				config.AddLogEntry("Error running garbage collection: " + err.Error())
 				return out, err
@@ -115,11 +158,42 @@ func CreateSystemTab(
 			config.AddLogEntry("Garbage collection completed successfully!")
 			refreshPendingActions() // Call refresh after garbage collection
 			return out, nil
-		}, "🗑️  Running garbage collector...", true, nil)
+		}, "🗑️  Running garbage collector...", true, generationsPanel.Refresh)
+	}
+
+	gcButton := widget.NewButton("🗑️  Run Garbage Collection", func() {
+		// Show what the policy would delete before deleting anything.
+		var plan *api.GCPlan
+		dialogs.ShowRunningCommandDialog(w, "🗑️  Planning garbage collection...", func(ctx context.Context, _ func(string)) (string, error) {
+			var err error
+			plan, err = api.PlanGC(ctx)
+			if err != nil {
+				return "", err
+			}
+			return plan.Summary(), nil
+		}, func(_ string, err error) {
+			if err != nil {
+				config.AddLogEntry("Error planning garbage collection: " + err.Error())
+				return
+			}
+			summary := widget.NewLabel(plan.Summary())
+			dialogs.ShowCustomConfirm(w, "Run garbage collection?", "Collect", "Cancel", container.NewVScroll(summary), func(ok bool) {
+				if !ok {
+					return
+				}
+				if plan.NeedsRoot() {
+					dialogs.ShowPasswordDialog(w, func(password string) {
+						runGC(plan, password)
+					})
+				} else {
+					runGC(plan, "")
+				}
+			})
+		})
 	})
 
 	listButton := widget.NewButton("📜  List Generations", func() {
-		runCmd(func() (string, error) {
+		runCmd(func(context.Context, func(string), func(nix.Progress)) (string, error) {
 			out, err := api.ListGenerations()
 			if err != nil {
 				config.AddLogEntry("Error listing generations: " + err.Error())
@@ -134,6 +208,7 @@ func CreateSystemTab(
 		container.NewGridWithColumns(3,
 			container.NewVBox(
 				rebuildButton,
+				modeSelect,
 				widget.NewLabelWithStyle("Apply pending configuration changes to the system.", fyne.TextAlignCenter, fyne.TextStyle{}),
 			),
 			container.NewVBox(
@@ -231,7 +306,7 @@ func CreateSystemTab(
 		nil,
 		nil,
 		nil,
-		pendingActionsList,
+		container.NewVSplit(pendingActionsList, generationsPanel),
 	)
 
 	tab.CanvasObject = container.NewPadded(content)
@@ -0,0 +1,286 @@
+// Package importer reads package lists kept by other tools and returns the
+// nixpkgs attribute paths they name.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format is a kind of package list.
+type Format string
+
+const (
+	// NixProfile is the output of `nix profile list --json`.
+	NixProfile Format = "nix-profile"
+	// ConfigurationNix is a NixOS configuration.nix with an
+	// environment.systemPackages list.
+	ConfigurationNix Format = "configuration.nix"
+	// Brewfile is a Homebrew Bundle Brewfile.
+	Brewfile Format = "brewfile"
+	// Text is a plain list of package names, one or more per line.
+	Text Format = "text"
+)
+
+// Formats lists the supported formats.
+var Formats = []Format{NixProfile, ConfigurationNix, Brewfile, Text}
+
+// DetectFormat guesses the format of the file at path from its name.
+func DetectFormat(path string) Format {
+	base := filepath.Base(path)
+	switch {
+	case strings.EqualFold(base, "Brewfile"):
+		return Brewfile
+	case strings.HasSuffix(base, ".nix"):
+		return ConfigurationNix
+	case strings.HasSuffix(base, ".json"):
+		return NixProfile
+	default:
+		return Text
+	}
+}
+
+// Parse returns the package names in data, without duplicates and in the
+// order they first appear.
+func Parse(format Format, data []byte) ([]string, error) {
+	var names []string
+	var err error
+	switch format {
+	case NixProfile:
+		names, err = parseNixProfile(data)
+	case ConfigurationNix:
+		names, err = parseConfigurationNix(data)
+	case Brewfile:
+		names = parseBrewfile(data)
+	case Text:
+		names = parseText(data)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return unique(names), nil
+}
+
+// ProfileElement is a package installed with `nix profile install`.
+type ProfileElement struct {
+	// Name identifies the element to `nix profile remove`. Older versions of
+	// nix have no element names, so it is the element's index there.
+	Name string
+	// AttrPath is the attribute path without the leading
+	// legacyPackages.<system>, such as "kdePackages.kcalc".
+	AttrPath    string
+	OriginalURL string
+}
+
+// FromNixpkgs reports whether the element was installed from nixpkgs.
+func (e ProfileElement) FromNixpkgs() bool {
+	return strings.Contains(e.OriginalURL, "nixpkgs")
+}
+
+// FromUnstable reports whether the element was installed from an unstable
+// branch of nixpkgs, such as github:NixOS/nixpkgs/nixos-unstable.
+func (e ProfileElement) FromUnstable() bool {
+	return e.FromNixpkgs() && strings.Contains(e.OriginalURL, "unstable")
+}
+
+// ProfileElements reads `nix profile list --json`. Newer versions of nix key
+// the elements by name, older ones list them.
+func ProfileElements(data []byte) ([]ProfileElement, error) {
+	type element struct {
+		AttrPath    string `json:"attrPath"`
+		OriginalURL string `json:"originalUrl"`
+	}
+	var profile struct {
+		Elements json.RawMessage `json:"elements"`
+	}
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("could not parse nix profile list: %w", err)
+	}
+
+	var elements []ProfileElement
+	var byName map[string]element
+	var list []element
+	if err := json.Unmarshal(profile.Elements, &byName); err == nil {
+		for name, e := range byName {
+			elements = append(elements, ProfileElement{Name: name, AttrPath: stripSystemPrefix(e.AttrPath), OriginalURL: e.OriginalURL})
+		}
+		sort.Slice(elements, func(i, j int) bool { return elements[i].Name < elements[j].Name })
+	} else if err := json.Unmarshal(profile.Elements, &list); err == nil {
+		for i, e := range list {
+			elements = append(elements, ProfileElement{Name: strconv.Itoa(i), AttrPath: stripSystemPrefix(e.AttrPath), OriginalURL: e.OriginalURL})
+		}
+	} else {
+		return nil, fmt.Errorf("could not parse nix profile elements: %w", err)
+	}
+	return elements, nil
+}
+
+// parseNixProfile returns the nixpkgs packages of a nix profile.
+func parseNixProfile(data []byte) ([]string, error) {
+	elements, err := ProfileElements(data)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range elements {
+		if e.FromNixpkgs() && e.AttrPath != "" {
+			names = append(names, e.AttrPath)
+		}
+	}
+	return names, nil
+}
+
+// stripSystemPrefix turns "legacyPackages.x86_64-linux.hello" into "hello".
+func stripSystemPrefix(attrPath string) string {
+	parts := strings.SplitN(attrPath, ".", 3)
+	if len(parts) == 3 && (parts[0] == "legacyPackages" || parts[0] == "packages") {
+		return parts[2]
+	}
+	return attrPath
+}
+
+var systemPackagesRe = regexp.MustCompile(`environment\.systemPackages\s*=\s*(with\s+pkgs\s*;\s*)?\[`)
+
+// parseConfigurationNix reads the environment.systemPackages lists of a
+// NixOS configuration. Plain package references are returned; expressions
+// such as (python3.withPackages ...) are skipped.
+func parseConfigurationNix(data []byte) ([]string, error) {
+	src := stripNixComments(string(data))
+	matches := systemPackagesRe.FindAllStringIndex(src, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no environment.systemPackages list found")
+	}
+
+	var names []string
+	for _, m := range matches {
+		depth := 0
+		var token strings.Builder
+		flush := func() {
+			if depth == 0 && token.Len() > 0 {
+				if name := nixPackageRef(token.String()); name != "" {
+					names = append(names, name)
+				}
+			}
+			token.Reset()
+		}
+	scan:
+		for _, r := range src[m[1]:] {
+			switch {
+			case r == '(' || r == '[' || r == '{':
+				flush()
+				depth++
+			case r == ')' || r == '}':
+				depth--
+			case r == ']':
+				if depth == 0 {
+					flush()
+					break scan
+				}
+				depth--
+			case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+				flush()
+			default:
+				if depth == 0 {
+					token.WriteRune(r)
+				}
+			}
+		}
+	}
+	return names, nil
+}
+
+var nixAttrRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_'-]*(\.[A-Za-z_][A-Za-z0-9_'-]*)*$`)
+
+// nixPackageRef returns the attribute path token refers to, or "" if it is
+// not a plain reference into pkgs.
+func nixPackageRef(token string) string {
+	token = strings.TrimPrefix(token, "pkgs.")
+	if !nixAttrRe.MatchString(token) || token == "pkgs" {
+		return ""
+	}
+	return token
+}
+
+// stripNixComments removes # line comments and /* block */ comments.
+func stripNixComments(src string) string {
+	var b strings.Builder
+	inString := false
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '"' && (i == 0 || src[i-1] != '\\'):
+			inString = !inString
+		case !inString && c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case !inString && c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return b.String()
+			}
+			i += end + 3
+			continue
+		}
+		if i < len(src) {
+			b.WriteByte(src[i])
+		}
+	}
+	return b.String()
+}
+
+var brewRe = regexp.MustCompile(`^\s*(brew|cask)\s+["']([^"']+)["']`)
+
+// parseBrewfile reads the brew and cask entries of a Brewfile. Formulae from
+// taps are reduced to their last path element.
+func parseBrewfile(data []byte) []string {
+	var names []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		m := brewRe.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		name := m[2]
+		if i := strings.LastIndexByte(name, '/'); i >= 0 {
+			name = name[i+1:]
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// parseText reads whitespace-separated names, ignoring # comments.
+func parseText(data []byte) []string {
+	var names []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		names = append(names, strings.Fields(line)...)
+	}
+	return names
+}
+
+func unique(names []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, n := range names {
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	return out
+}
@@ -0,0 +1,92 @@
+package importer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		format Format
+		data   string
+		want   []string
+	}{
+		{
+			name:   "nix profile by name",
+			format: NixProfile,
+			data: `{"version": 3, "elements": {
+				"ripgrep": {"attrPath": "legacyPackages.x86_64-linux.ripgrep", "originalUrl": "flake:nixpkgs"},
+				"kcalc": {"attrPath": "legacyPackages.x86_64-linux.kdePackages.kcalc", "originalUrl": "github:NixOS/nixpkgs/nixos-unstable"},
+				"tool": {"attrPath": "packages.x86_64-linux.default", "originalUrl": "github:someone/tool"}
+			}}`,
+			want: []string{"kdePackages.kcalc", "ripgrep"},
+		},
+		{
+			name:   "nix profile list",
+			format: NixProfile,
+			data:   `{"version": 2, "elements": [{"attrPath": "legacyPackages.aarch64-darwin.hello", "originalUrl": "flake:nixpkgs"}]}`,
+			want:   []string{"hello"},
+		},
+		{
+			name:   "configuration.nix",
+			format: ConfigurationNix,
+			data: `{ config, pkgs, ... }:
+{
+  # environment.systemPackages = [ pkgs.commented ];
+  environment.systemPackages = with pkgs; [
+    vim # the editor
+    git
+    kdePackages.kcalc
+    (python3.withPackages (ps: [ ps.requests ]))
+    /* firefox */
+    pkgs.wget
+  ];
+  services.openssh.enable = true;
+}`,
+			want: []string{"vim", "git", "kdePackages.kcalc", "wget"},
+		},
+		{
+			name:   "brewfile",
+			format: Brewfile,
+			data: `tap "homebrew/bundle"
+brew "ripgrep"
+brew "neovim", args: ["HEAD"]
+brew "hashicorp/tap/terraform"
+cask "firefox"
+mas "Xcode", id: 497799835
+brew "ripgrep"`,
+			want: []string{"ripgrep", "neovim", "terraform", "firefox"},
+		},
+		{
+			name:   "text",
+			format: Text,
+			data:   "# tools\nripgrep fd\n\nneovim  # editor\n",
+			want:   []string{"ripgrep", "fd", "neovim"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.format, []byte(tt.data))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	for path, want := range map[string]Format{
+		"/etc/nixos/configuration.nix": ConfigurationNix,
+		"Brewfile":                     Brewfile,
+		"profile.json":                 NixProfile,
+		"packages.txt":                 Text,
+	} {
+		if got := DetectFormat(path); got != want {
+			t.Errorf("DetectFormat(%q) = %s, want %s", path, got, want)
+		}
+	}
+}
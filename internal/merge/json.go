@@ -0,0 +1,461 @@
+package merge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// object is a JSON object that keeps the order of its keys, so a merged
+// file lists its settings in the order the local file did.
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// absent stands for a value that is missing from one version of an object
+// or list.
+type absent struct{}
+
+// identityKeys are the fields that identify the items of a list of objects,
+// such as users by username and packages and groups by name. Users also
+// have a name, so username is tried first.
+var identityKeys = []string{"username", "name"}
+
+type jsonMerger struct {
+	file      string
+	choices   chooser
+	conflicts []Conflict
+}
+
+// mergeJSON merges three versions of a pilo JSON file. It reports false if
+// any version is not valid JSON.
+func mergeJSON(path string, base, ours, theirs []byte, choices chooser) (Result, bool) {
+	var b interface{} = absent{}
+	if base != nil {
+		v, err := decodeJSON(base)
+		if err != nil {
+			return Result{}, false
+		}
+		b = v
+	}
+	o, err := decodeJSON(ours)
+	if err != nil {
+		return Result{}, false
+	}
+	t, err := decodeJSON(theirs)
+	if err != nil {
+		return Result{}, false
+	}
+
+	m := &jsonMerger{file: path, choices: choices}
+	merged := m.value("", b, o, t)
+	var buf bytes.Buffer
+	encodeJSON(&buf, merged, "")
+	if bytes.HasSuffix(ours, []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	return Result{Content: buf.Bytes(), Conflicts: m.conflicts}, true
+}
+
+// value merges one value. Where both sides changed it differently, objects
+// and lists are merged item by item and anything else is a conflict that is
+// settled by its choice, or else keeps our value.
+func (m *jsonMerger) value(path string, base, ours, theirs interface{}) interface{} {
+	switch {
+	case equalJSON(ours, theirs), equalJSON(base, theirs):
+		return ours
+	case equalJSON(base, ours):
+		return theirs
+	}
+
+	if o, ok := ours.(*object); ok {
+		if t, ok := theirs.(*object); ok {
+			b, _ := base.(*object)
+			return m.object(path, b, o, t)
+		}
+	}
+	if o, ok := ours.([]interface{}); ok {
+		if t, ok := theirs.([]interface{}); ok {
+			b, _ := base.([]interface{})
+			if key := identityKey(b, o, t); key != "" {
+				return m.keyedList(path, key, b, o, t)
+			}
+			if allStrings(b) && allStrings(o) && allStrings(t) {
+				return stringSet(b, o, t)
+			}
+		}
+	}
+
+	choice, _ := m.choices.pick(len(m.conflicts))
+	m.conflicts = append(m.conflicts, Conflict{
+		File:   m.file,
+		Field:  path,
+		Base:   showJSON(base),
+		Ours:   showJSON(ours),
+		Theirs: showJSON(theirs),
+	})
+	switch choice {
+	case Theirs:
+		return theirs
+	case Both:
+		if v, ok := combine(ours, theirs); ok {
+			return v
+		}
+	}
+	return ours
+}
+
+// combine returns the keys of both objects or the items of both lists,
+// ours first. It reports false if ours and theirs are not both objects or
+// both lists.
+func combine(ours, theirs interface{}) (interface{}, bool) {
+	switch o := ours.(type) {
+	case *object:
+		t, ok := theirs.(*object)
+		if !ok {
+			return nil, false
+		}
+		combined := &object{keys: append([]string(nil), o.keys...), values: map[string]interface{}{}}
+		for k, v := range o.values {
+			combined.values[k] = v
+		}
+		for _, k := range t.keys {
+			if _, ok := o.values[k]; !ok {
+				combined.keys = append(combined.keys, k)
+				combined.values[k] = t.values[k]
+			}
+		}
+		return combined, true
+	case []interface{}:
+		t, ok := theirs.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		// Items of theirs that share an identity with one of ours are
+		// the same item, and ours is kept.
+		key := identityKey(o, t)
+		combined := append([]interface{}(nil), o...)
+		for _, item := range t {
+			dup := false
+			for _, own := range o {
+				if key != "" && identity(key, own) == identity(key, item) || key == "" && equalJSON(own, item) {
+					dup = true
+					break
+				}
+			}
+			if !dup {
+				combined = append(combined, item)
+			}
+		}
+		return combined, true
+	}
+	return nil, false
+}
+
+// object merges objects key by key. Keys keep our order, followed by the
+// keys only they added.
+func (m *jsonMerger) object(path string, base, ours, theirs *object) *object {
+	if base == nil {
+		base = &object{values: map[string]interface{}{}}
+	}
+	merged := &object{values: map[string]interface{}{}}
+	seen := map[string]bool{}
+	for _, keys := range [][]string{ours.keys, theirs.keys, base.keys} {
+		for _, k := range keys {
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			v := m.value(joinField(path, k), lookup(base, k), lookup(ours, k), lookup(theirs, k))
+			if _, gone := v.(absent); !gone {
+				merged.keys = append(merged.keys, k)
+				merged.values[k] = v
+			}
+		}
+	}
+	return merged
+}
+
+// keyedList merges lists of objects identified by key, such as packages by
+// name, item by item. The result is sorted by key if our list was.
+func (m *jsonMerger) keyedList(path, key string, base, ours, theirs []interface{}) []interface{} {
+	b, o, t := indexBy(key, base), indexBy(key, ours), indexBy(key, theirs)
+	var merged []interface{}
+	seen := map[string]bool{}
+	for _, items := range [][]interface{}{ours, theirs, base} {
+		for _, item := range items {
+			id := identity(key, item)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			v := m.value(fmt.Sprintf("%s[%s]", path, id), get(b, id), get(o, id), get(t, id))
+			if _, gone := v.(absent); !gone {
+				merged = append(merged, v)
+			}
+		}
+	}
+	if sort.SliceIsSorted(ours, func(i, j int) bool { return identity(key, ours[i]) < identity(key, ours[j]) }) {
+		sort.SliceStable(merged, func(i, j int) bool { return identity(key, merged[i]) < identity(key, merged[j]) })
+	}
+	if merged == nil {
+		merged = []interface{}{}
+	}
+	return merged
+}
+
+// stringSet merges lists of strings as sets: an item removed on either side
+// is removed, and items added on either side are kept.
+func stringSet(base, ours, theirs []interface{}) []interface{} {
+	inBase, inOurs, inTheirs := stringsIn(base), stringsIn(ours), stringsIn(theirs)
+	merged := []interface{}{}
+	seen := map[string]bool{}
+	for _, items := range [][]interface{}{ours, theirs} {
+		for _, item := range items {
+			s := item.(string)
+			if seen[s] || (inBase[s] && (!inOurs[s] || !inTheirs[s])) {
+				continue
+			}
+			seen[s] = true
+			merged = append(merged, s)
+		}
+	}
+	if sort.SliceIsSorted(ours, func(i, j int) bool { return ours[i].(string) < ours[j].(string) }) {
+		sort.SliceStable(merged, func(i, j int) bool { return merged[i].(string) < merged[j].(string) })
+	}
+	return merged
+}
+
+func lookup(o *object, key string) interface{} {
+	if v, ok := o.values[key]; ok {
+		return v
+	}
+	return absent{}
+}
+
+func get(items map[string]interface{}, id string) interface{} {
+	if v, ok := items[id]; ok {
+		return v
+	}
+	return absent{}
+}
+
+// identityKey returns the field that identifies the items of the lists, or
+// "" if their items are not objects with a unique string in one of the
+// identityKeys.
+func identityKey(lists ...[]interface{}) string {
+	for _, key := range identityKeys {
+		if identifiedBy(key, lists...) {
+			return key
+		}
+	}
+	return ""
+}
+
+func identifiedBy(key string, lists ...[]interface{}) bool {
+	found := false
+	for _, items := range lists {
+		seen := map[string]bool{}
+		for _, item := range items {
+			o, ok := item.(*object)
+			if !ok {
+				return false
+			}
+			id, ok := o.values[key].(string)
+			if !ok || seen[id] {
+				return false
+			}
+			seen[id] = true
+			found = true
+		}
+	}
+	return found
+}
+
+func identity(key string, item interface{}) string {
+	id, _ := item.(*object).values[key].(string)
+	return id
+}
+
+func indexBy(key string, items []interface{}) map[string]interface{} {
+	index := make(map[string]interface{}, len(items))
+	for _, item := range items {
+		index[identity(key, item)] = item
+	}
+	return index
+}
+
+func allStrings(items []interface{}) bool {
+	for _, item := range items {
+		if _, ok := item.(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func stringsIn(items []interface{}) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item.(string)] = true
+	}
+	return set
+}
+
+func joinField(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func equalJSON(a, b interface{}) bool {
+	switch a := a.(type) {
+	case *object:
+		b, ok := b.(*object)
+		if !ok || len(a.keys) != len(b.keys) {
+			return false
+		}
+		for _, k := range a.keys {
+			bv, ok := b.values[k]
+			if !ok || !equalJSON(a.values[k], bv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equalJSON(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// showJSON returns v as compact JSON, or "" if it is absent.
+func showJSON(v interface{}) string {
+	if _, ok := v.(absent); ok {
+		return ""
+	}
+	var buf bytes.Buffer
+	encodeJSON(&buf, v, "")
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, buf.Bytes()); err != nil {
+		return buf.String()
+	}
+	return compact.String()
+}
+
+// decodeJSON decodes data into a value that can be compared and encoded
+// again with encodeJSON. Objects keep the order of their keys and numbers
+// are kept as json.Number.
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		o := &object{values: map[string]interface{}{}}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := keyTok.(string)
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := o.values[key]; !dup {
+				o.keys = append(o.keys, key)
+			}
+			o.values[key] = v
+		}
+		_, err := dec.Token() // }
+		return o, err
+	case json.Delim('['):
+		items := []interface{}{}
+		for dec.More() {
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		_, err := dec.Token() // ]
+		return items, err
+	default:
+		return tok, nil
+	}
+}
+
+// encodeJSON writes v indented by two spaces, as pilo writes its files.
+func encodeJSON(buf *bytes.Buffer, v interface{}, indent string) {
+	switch v := v.(type) {
+	case *object:
+		if len(v.keys) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		buf.WriteString("{\n")
+		for i, k := range v.keys {
+			buf.WriteString(indent + "  ")
+			writeScalar(buf, k)
+			buf.WriteString(": ")
+			encodeJSON(buf, v.values[k], indent+"  ")
+			if i < len(v.keys)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(indent + "}")
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		buf.WriteString("[\n")
+		for i, item := range v {
+			buf.WriteString(indent + "  ")
+			encodeJSON(buf, item, indent+"  ")
+			if i < len(v)-1 {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(indent + "]")
+	default:
+		writeScalar(buf, v)
+	}
+}
+
+// writeScalar writes a string, json.Number, bool or nil. These always
+// marshal.
+func writeScalar(buf *bytes.Buffer, v interface{}) {
+	data, _ := json.Marshal(v)
+	buf.Write(data)
+}
@@ -0,0 +1,170 @@
+// Package merge does three-way merges of the files of a pilo configuration.
+//
+// The JSON files pilo manages are merged value by value: objects key by key,
+// lists of packages, groups and users by name, and lists of strings as sets,
+// so that changes to different parts of a file never conflict. Other files
+// are merged line by line like git does.
+package merge
+
+import (
+	"bytes"
+	"fmt"
+	"pilo/internal/schema"
+)
+
+// Conflict is a change made on both sides of a merge that could not be
+// combined.
+type Conflict struct {
+	// File is the path of the file, relative to the repository.
+	File string
+	// Field is the path of the value in a pilo JSON file, such as
+	// "system.desktop" or "packages[firefox].installed". It is empty for
+	// conflicts between lines of text and between whole files.
+	Field string
+	// Line is the line of the merged file where the conflict markers of a
+	// text conflict start.
+	Line int
+	// Base, Ours and Theirs hold the conflicting text, or the values of a
+	// JSON field as JSON. They are empty where the file or field is missing.
+	Base, Ours, Theirs string
+}
+
+// Result is the outcome of merging one file.
+type Result struct {
+	// Content is the merged file, or nil when the file is deleted. JSON
+	// conflicts keep our value; text conflicts are written with conflict
+	// markers.
+	Content []byte
+	// Conflicts lists the changes that could not be merged.
+	Conflicts []Conflict
+}
+
+// Conflict markers written into text files.
+const (
+	MarkerOurs   = "<<<<<<< local"
+	MarkerSep    = "======="
+	MarkerTheirs = ">>>>>>> remote"
+)
+
+// Choice settles a conflict.
+type Choice int
+
+const (
+	// Ours keeps the local version.
+	Ours Choice = iota
+	// Theirs keeps the remote version.
+	Theirs
+	// Both keeps the lines of both versions, ours first, or the items or
+	// keys of both versions of a JSON list or object.
+	Both
+)
+
+// File merges the versions of the file at path: base from the common
+// ancestor, ours from the local branch and theirs from the remote. A nil
+// version means the file does not exist on that side.
+func File(path string, base, ours, theirs []byte) Result {
+	return mergeFile(path, base, ours, theirs, nil)
+}
+
+// Resolve merges the versions of the file at path like File does, settling
+// its conflicts with choices, one for each conflict File reports and in the
+// same order. It returns the merged file, or nil if it is deleted.
+func Resolve(path string, base, ours, theirs []byte, choices []Choice) ([]byte, error) {
+	conflicts := File(path, base, ours, theirs).Conflicts
+	if len(choices) != len(conflicts) {
+		return nil, fmt.Errorf("%s has %d conflicts but %d choices were given", path, len(conflicts), len(choices))
+	}
+	for i, c := range conflicts {
+		if choices[i] == Both && !CanCombine(c) {
+			return nil, fmt.Errorf("cannot keep both versions of %s", c)
+		}
+	}
+	return mergeFile(path, base, ours, theirs, choices).Content, nil
+}
+
+// CanCombine reports whether c can be settled with Both: conflicting lines
+// of text can, and so can two versions of a JSON list or object.
+func CanCombine(c Conflict) bool {
+	if c.Field == "" {
+		return c.Line > 0
+	}
+	ours, err := decodeJSON([]byte(c.Ours))
+	if err != nil {
+		return false
+	}
+	theirs, err := decodeJSON([]byte(c.Theirs))
+	if err != nil {
+		return false
+	}
+	_, ok := combine(ours, theirs)
+	return ok
+}
+
+// String names the conflicting setting, lines or file.
+func (c Conflict) String() string {
+	switch {
+	case c.Field != "":
+		return fmt.Sprintf("%s in %s", c.Field, c.File)
+	case c.Line > 0:
+		return fmt.Sprintf("%s:%d", c.File, c.Line)
+	default:
+		return c.File
+	}
+}
+
+// chooser holds the choices that settle the conflicts of a merge. Without a
+// choice, a JSON conflict keeps our value and a text conflict is written
+// with conflict markers.
+type chooser []Choice
+
+// pick returns the choice for the nth conflict, if there is one.
+func (c chooser) pick(n int) (Choice, bool) {
+	if n < len(c) {
+		return c[n], true
+	}
+	return Ours, false
+}
+
+func mergeFile(path string, base, ours, theirs []byte, choices chooser) Result {
+	switch {
+	case sameFile(ours, theirs), sameFile(base, theirs):
+		return Result{Content: ours}
+	case sameFile(base, ours):
+		return Result{Content: theirs}
+	}
+
+	// Deleted on one side and changed on the other.
+	if ours == nil || theirs == nil {
+		kept := ours
+		if kept == nil {
+			kept = theirs
+		}
+		if choice, ok := choices.pick(0); ok {
+			kept = ours
+			if choice == Theirs {
+				kept = theirs
+			}
+		}
+		return Result{Content: kept, Conflicts: []Conflict{{
+			File: path, Base: string(base), Ours: string(ours), Theirs: string(theirs),
+		}}}
+	}
+
+	if IsJSON(path) {
+		if r, ok := mergeJSON(path, base, ours, theirs, choices); ok {
+			return r
+		}
+	}
+	return mergeText(path, base, ours, theirs, choices)
+}
+
+// IsJSON reports whether path is one of the pilo JSON files, which are
+// merged value by value.
+func IsJSON(path string) bool {
+	_, err := schema.For(path)
+	return err == nil
+}
+
+func sameFile(a, b []byte) bool {
+	return (a == nil) == (b == nil) && bytes.Equal(a, b)
+}
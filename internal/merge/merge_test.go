@@ -0,0 +1,166 @@
+package merge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergePackages(t *testing.T) {
+	base := `{
+  "packages": [
+    {"name": "firefox", "installed": true},
+    {"name": "git", "installed": true},
+    {"name": "vlc", "installed": true}
+  ]
+}
+`
+	// We install go and uninstall vlc; they install ripgrep and remove git.
+	ours := `{
+  "packages": [
+    {"name": "firefox", "installed": true},
+    {"name": "git", "installed": true},
+    {"name": "go", "installed": true},
+    {"name": "vlc", "installed": false}
+  ]
+}
+`
+	theirs := `{
+  "packages": [
+    {"name": "firefox", "installed": true},
+    {"name": "ripgrep", "installed": true},
+    {"name": "vlc", "installed": true}
+  ]
+}
+`
+	r := File("flake/packages.json", []byte(base), []byte(ours), []byte(theirs))
+	if len(r.Conflicts) > 0 {
+		t.Fatalf("unexpected conflicts: %+v", r.Conflicts)
+	}
+	want := `{
+  "packages": [
+    {
+      "name": "firefox",
+      "installed": true
+    },
+    {
+      "name": "go",
+      "installed": true
+    },
+    {
+      "name": "ripgrep",
+      "installed": true
+    },
+    {
+      "name": "vlc",
+      "installed": false
+    }
+  ]
+}
+`
+	if string(r.Content) != want {
+		t.Errorf("merged packages:\n%s\nwant:\n%s", r.Content, want)
+	}
+}
+
+func TestMergeAliases(t *testing.T) {
+	base := `{"gs": "git status", "ll": "ls -l"}`
+	ours := `{"gs": "git status -s", "ll": "ls -l", "k": "kubectl"}`
+	theirs := `{"gs": "git status --short", "la": "ls -a"}`
+
+	r := File("flake/aliases.json", []byte(base), []byte(ours), []byte(theirs))
+	want := "{\n  \"gs\": \"git status -s\",\n  \"k\": \"kubectl\",\n  \"la\": \"ls -a\"\n}"
+	if string(r.Content) != want {
+		t.Errorf("merged aliases:\n%s\nwant:\n%s", r.Content, want)
+	}
+	if len(r.Conflicts) != 1 {
+		t.Fatalf("conflicts = %+v, want one for gs", r.Conflicts)
+	}
+	c := r.Conflicts[0]
+	if c.Field != "gs" || c.Ours != `"git status -s"` || c.Theirs != `"git status --short"` {
+		t.Errorf("unexpected conflict %+v", c)
+	}
+}
+
+func TestMergeStringSet(t *testing.T) {
+	base := `{"commit_triggers": ["install", "remove"]}`
+	ours := `{"commit_triggers": ["install", "rebuild", "remove"]}`
+	theirs := `{"commit_triggers": ["install", "update"]}`
+
+	r := File("flake/base-config.json", []byte(base), []byte(ours), []byte(theirs))
+	want := "{\n  \"commit_triggers\": [\n    \"install\",\n    \"rebuild\",\n    \"update\"\n  ]\n}"
+	if len(r.Conflicts) > 0 || string(r.Content) != want {
+		t.Errorf("merged:\n%s\nconflicts: %+v", r.Content, r.Conflicts)
+	}
+}
+
+func TestMergeText(t *testing.T) {
+	base := "{\n  a = 1;\n  b = 2;\n  c = 3;\n}\n"
+	ours := "{\n  a = 10;\n  b = 2;\n  c = 3;\n}\n"
+	theirs := "{\n  a = 1;\n  b = 2;\n  c = 30;\n}\n"
+
+	r := File("flake/hosts/common/system.nix", []byte(base), []byte(ours), []byte(theirs))
+	if len(r.Conflicts) > 0 || string(r.Content) != "{\n  a = 10;\n  b = 2;\n  c = 30;\n}\n" {
+		t.Errorf("merged:\n%s\nconflicts: %+v", r.Content, r.Conflicts)
+	}
+
+	theirs = "{\n  a = 100;\n  b = 2;\n  c = 3;\n}\n"
+	r = File("flake/hosts/common/system.nix", []byte(base), []byte(ours), []byte(theirs))
+	want := "{\n" + MarkerOurs + "\n  a = 10;\n" + MarkerSep + "\n  a = 100;\n" + MarkerTheirs + "\n  b = 2;\n  c = 3;\n}\n"
+	if string(r.Content) != want {
+		t.Errorf("merged:\n%s\nwant:\n%s", r.Content, want)
+	}
+	if len(r.Conflicts) != 1 || r.Conflicts[0].Line != 2 || r.Conflicts[0].Base != "  a = 1;\n" {
+		t.Errorf("conflicts = %+v", r.Conflicts)
+	}
+	if !HasConflictMarkers(r.Content) {
+		t.Error("conflict markers not detected")
+	}
+}
+
+func TestMergeDeleted(t *testing.T) {
+	r := File("flake/apps/foo.nix", []byte("a\n"), nil, []byte("a\n"))
+	if r.Content != nil || len(r.Conflicts) > 0 {
+		t.Errorf("a file deleted locally should stay deleted: %+v", r)
+	}
+	r = File("flake/apps/foo.nix", []byte("a\n"), nil, []byte("b\n"))
+	if len(r.Conflicts) != 1 || !strings.Contains(string(r.Content), "b") {
+		t.Errorf("deleting a changed file should conflict: %+v", r)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	base := `{"gs": "git status", "paths": ["/bin"]}`
+	ours := `{"gs": "git status -s", "paths": ["/bin", "/opt"]}`
+	theirs := `{"gs": "git status --short", "paths": ["/usr/bin"]}`
+
+	// paths is a list of strings, so it merges as a set; only gs conflicts.
+	conflicts := File("flake/aliases.json", []byte(base), []byte(ours), []byte(theirs)).Conflicts
+	if len(conflicts) != 1 || CanCombine(conflicts[0]) {
+		t.Fatalf("conflicts = %+v", conflicts)
+	}
+	got, err := Resolve("flake/aliases.json", []byte(base), []byte(ours), []byte(theirs), []Choice{Theirs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), `"gs": "git status --short"`) {
+		t.Errorf("resolved:\n%s", got)
+	}
+	if _, err := Resolve("flake/aliases.json", []byte(base), []byte(ours), []byte(theirs), []Choice{Both}); err == nil {
+		t.Error("keeping both versions of a string should fail")
+	}
+
+	// Both keeps the lines of each side, ours first.
+	base = "a\nb\nc\n"
+	ours = "a\nB1\nc\n"
+	theirs = "a\nB2\nc\n"
+	for choice, want := range map[Choice]string{
+		Ours:   "a\nB1\nc\n",
+		Theirs: "a\nB2\nc\n",
+		Both:   "a\nB1\nB2\nc\n",
+	} {
+		got, err := Resolve("flake/system.nix", []byte(base), []byte(ours), []byte(theirs), []Choice{choice})
+		if err != nil || string(got) != want {
+			t.Errorf("choice %d: got %q, %v; want %q", choice, got, err, want)
+		}
+	}
+}
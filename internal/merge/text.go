@@ -0,0 +1,137 @@
+package merge
+
+import (
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// mergeText merges three versions of a text file line by line, the way
+// diff3 does. Lines changed on only one side are taken from that side; where
+// both sides changed the same lines differently, the choice for the conflict
+// is taken, or else both versions are written between conflict markers.
+func mergeText(path string, base, ours, theirs []byte, choices chooser) Result {
+	o, a, b := splitLines(string(base)), splitLines(string(ours)), splitLines(string(theirs))
+	matchA, matchB := matchLines(o, a), matchLines(o, b)
+
+	var out []string
+	var conflicts []Conflict
+	i, j, k := 0, 0, 0
+	for i < len(o) || j < len(a) || k < len(b) {
+		// A line unchanged on both sides.
+		if i < len(o) && aligned(matchA, i, j) && aligned(matchB, i, k) {
+			out = append(out, o[i])
+			i, j, k = i+1, j+1, k+1
+			continue
+		}
+
+		// Find the next line unchanged on both sides; what comes before it
+		// was changed on at least one side.
+		ni, nj, nk := len(o), len(a), len(b)
+		for n := i; n < len(o); n++ {
+			ja, okA := matchA[n]
+			kb, okB := matchB[n]
+			if okA && okB && ja >= j && kb >= k {
+				ni, nj, nk = n, ja, kb
+				break
+			}
+		}
+		chunkO, chunkA, chunkB := o[i:ni], a[j:nj], b[k:nk]
+		switch {
+		case equalLines(chunkO, chunkA):
+			out = append(out, chunkB...)
+		case equalLines(chunkO, chunkB), equalLines(chunkA, chunkB):
+			out = append(out, chunkA...)
+		default:
+			choice, chosen := choices.pick(len(conflicts))
+			conflicts = append(conflicts, Conflict{
+				File:   path,
+				Line:   len(out) + 1,
+				Base:   strings.Join(chunkO, ""),
+				Ours:   strings.Join(chunkA, ""),
+				Theirs: strings.Join(chunkB, ""),
+			})
+			switch {
+			case !chosen:
+				out = append(out, MarkerOurs+"\n")
+				out = append(out, terminated(chunkA)...)
+				out = append(out, MarkerSep+"\n")
+				out = append(out, terminated(chunkB)...)
+				out = append(out, MarkerTheirs+"\n")
+			case choice == Ours:
+				out = append(out, chunkA...)
+			case choice == Theirs:
+				out = append(out, chunkB...)
+			default:
+				out = append(out, terminated(chunkA)...)
+				out = append(out, chunkB...)
+			}
+		}
+		i, j, k = ni, nj, nk
+	}
+	return Result{Content: []byte(strings.Join(out, "")), Conflicts: conflicts}
+}
+
+// HasConflictMarkers reports whether data still holds conflict markers
+// written by a merge.
+func HasConflictMarkers(data []byte) bool {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == MarkerOurs || line == MarkerTheirs {
+			return true
+		}
+	}
+	return false
+}
+
+// splitLines splits text into lines that keep their line endings.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// matchLines maps each line of base that is unchanged in other to its index
+// in other. Frequent lines, such as the "}" that fill nix files, still
+// count as matches.
+func matchLines(base, other []string) map[int]int {
+	matches := map[int]int{}
+	for _, m := range difflib.NewMatcherWithJunk(base, other, false, nil).GetMatchingBlocks() {
+		for n := 0; n < m.Size; n++ {
+			matches[m.A+n] = m.B + n
+		}
+	}
+	return matches
+}
+
+func aligned(matches map[int]int, i, j int) bool {
+	m, ok := matches[i]
+	return ok && m == j
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// terminated returns lines with a line ending after the last one, so a
+// conflict marker that follows starts on a line of its own.
+func terminated(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+	out := append([]string(nil), lines...)
+	out[len(out)-1] += "\n"
+	return out
+}
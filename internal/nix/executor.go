@@ -0,0 +1,135 @@
+package nix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Command describes a single process invocation made through an Executor.
+type Command struct {
+	Name string
+	Args []string
+	Dir  string
+	// Env holds extra "KEY=value" variables added to the inherited environment.
+	Env    []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Detach starts the process and returns without waiting for it to exit.
+	Detach bool
+}
+
+// String returns the command line as it would be typed in a shell.
+func (c Command) String() string {
+	return strings.TrimSpace(c.Name + " " + strings.Join(c.Args, " "))
+}
+
+// Executor runs the external commands issued by this package. The default
+// executor starts real processes; tests can swap it for a FakeExecutor.
+type Executor interface {
+	Run(ctx context.Context, cmd Command) error
+}
+
+// ExitError is returned by an Executor when a command exits with a non-zero status.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// killGracePeriod is how long a cancelled process group has to exit after
+// SIGTERM before it is sent SIGKILL. Tests shorten it.
+var killGracePeriod = 5 * time.Second
+
+var executor Executor = osExecutor{}
+
+// SetExecutor replaces the executor used by every command in this package.
+// Passing nil restores the default executor.
+func SetExecutor(e Executor) {
+	if e == nil {
+		e = osExecutor{}
+	}
+	executor = e
+}
+
+// GetExecutor returns the executor currently in use.
+func GetExecutor() Executor {
+	return executor
+}
+
+// osExecutor runs commands as real child processes.
+type osExecutor struct{}
+
+func (osExecutor) Run(ctx context.Context, c Command) error {
+	name := c.Name
+	args := c.Args
+	if name == "nix" {
+		name = getNixExecutable()
+		if name == "" {
+			return fmt.Errorf("nix executable not found: please ensure Nix is installed and in your PATH")
+		}
+	}
+	if strings.HasSuffix(name, "nix") {
+		args = append([]string{"--extra-experimental-features", "nix-command", "--extra-experimental-features", "flakes"}, args...)
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = c.Dir
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+	if ctx.Done() != nil {
+		// Cancellable commands get their own process group so that cancelling
+		// also stops the builders and helpers they spawn. Interactive commands
+		// run without a context and stay in the terminal's foreground group.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.Cancel = func() error {
+			return killProcessGroup(cmd.Process.Pid)
+		}
+		cmd.WaitDelay = killGracePeriod + time.Second
+	}
+	if c.Detach {
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		// Reap the process when it exits so that it does not linger as a zombie.
+		go cmd.Wait()
+		return nil
+	}
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &ExitError{Code: exitErr.ExitCode()}
+	}
+	return err
+}
+
+// killProcessGroup asks every process in the group led by pid to terminate and
+// kills whatever is left once the grace period has passed, including members
+// that ignored SIGTERM after the leader exited. The group ID cannot be handed
+// out again while any member is alive, and once none is the kill fails with
+// ESRCH. SIGTERM comes first because sudo relays it to the command it runs,
+// while SIGKILL cannot be relayed.
+func killProcessGroup(pid int) error {
+	if err := syscall.Kill(-pid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	time.AfterFunc(killGracePeriod, func() {
+		// ESRCH only means that the whole group has exited.
+		syscall.Kill(-pid, syscall.SIGKILL)
+	})
+	return nil
+}
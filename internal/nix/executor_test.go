@@ -0,0 +1,94 @@
+package nix
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// running reports whether the process pid is alive. A zombie counts as
+// exited, as it may wait for a parent that never reaps it.
+func running(pid int) bool {
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil {
+		fields := strings.Fields(string(data))
+		return len(fields) > 2 && fields[2] != "Z"
+	}
+	return syscall.Kill(pid, 0) == nil
+}
+
+func TestCancelKillsProcessGroup(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The shell prints the pid of a child it spawned, which cancelling must
+	// stop along with the shell.
+	child := 0
+	start := time.Now()
+	_, err := runLines(ctx, Command{Name: "sh", Args: []string{"-c", "sleep 30 & echo $!; wait"}}, func(line string) {
+		if pid, err := strconv.Atoi(line); err == nil && child == 0 {
+			child = pid
+			cancel()
+		}
+	})
+	if err == nil {
+		t.Fatal("expected an error from a cancelled command")
+	}
+	if child == 0 {
+		t.Fatal("the child's pid was not printed")
+	}
+	if elapsed := time.Since(start); elapsed >= killGracePeriod {
+		t.Errorf("the command took %v to stop, want it to exit on SIGTERM", elapsed)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for running(child) {
+		if time.Now().After(deadline) {
+			syscall.Kill(child, syscall.SIGKILL)
+			t.Fatalf("child %d still runs after the command was cancelled", child)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCancelKillsIgnoringMembers(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	grace := killGracePeriod
+	killGracePeriod = 200 * time.Millisecond
+	t.Cleanup(func() { killGracePeriod = grace })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The child ignores SIGTERM and no longer holds the output, so the
+	// command returns as soon as the shell exits, leaving the child to
+	// SIGKILL.
+	child := 0
+	runLines(ctx, Command{Name: "sh", Args: []string{"-c", `(trap "" TERM; exec sleep 30) >/dev/null 2>&1 & echo $!; wait`}}, func(line string) {
+		if pid, err := strconv.Atoi(line); err == nil && child == 0 {
+			child = pid
+			cancel()
+		}
+	})
+	if child == 0 {
+		t.Fatal("the child's pid was not printed")
+	}
+
+	deadline := time.Now().Add(killGracePeriod + 2*time.Second)
+	for running(child) {
+		if time.Now().After(deadline) {
+			syscall.Kill(child, syscall.SIGKILL)
+			t.Fatalf("child %d ignoring SIGTERM still runs after the grace period", child)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,113 @@
+package nix
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+)
+
+// FakeResult is a canned response returned by a FakeExecutor.
+type FakeResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	// Err, when set, is returned instead of running the command at all.
+	Err error
+}
+
+// FakeCall records a command received by a FakeExecutor.
+type FakeCall struct {
+	Line  string
+	Dir   string
+	Stdin string
+}
+
+type fakeRule struct {
+	prefix string
+	result FakeResult
+}
+
+// FakeExecutor is a scripted Executor for tests. It records every command it
+// receives and answers with the first registered result whose prefix matches
+// the command line. Commands without a match succeed with no output.
+type FakeExecutor struct {
+	mu    sync.Mutex
+	rules []fakeRule
+	calls []FakeCall
+}
+
+// NewFakeExecutor returns an empty FakeExecutor.
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{}
+}
+
+// On registers result for every command line starting with prefix,
+// e.g. "nix store gc" or "sudo -S nixos-rebuild".
+func (f *FakeExecutor) On(prefix string, result FakeResult) *FakeExecutor {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, fakeRule{prefix: prefix, result: result})
+	return f
+}
+
+// Calls returns the commands received so far, in order.
+func (f *FakeExecutor) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeCall(nil), f.calls...)
+}
+
+// CommandLines returns the command lines received so far, in order.
+func (f *FakeExecutor) CommandLines() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lines := make([]string, len(f.calls))
+	for i, call := range f.calls {
+		lines[i] = call.Line
+	}
+	return lines
+}
+
+// Reset forgets all recorded calls while keeping the registered results.
+func (f *FakeExecutor) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = nil
+}
+
+func (f *FakeExecutor) Run(ctx context.Context, cmd Command) error {
+	call := FakeCall{Line: cmd.String(), Dir: cmd.Dir}
+	if cmd.Stdin != nil {
+		data, _ := io.ReadAll(cmd.Stdin)
+		call.Stdin = string(data)
+	}
+
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	var result FakeResult
+	for _, rule := range f.rules {
+		if strings.HasPrefix(call.Line, rule.prefix) {
+			result = rule.result
+			break
+		}
+	}
+	f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if result.Err != nil {
+		return result.Err
+	}
+	if cmd.Stdout != nil && result.Stdout != "" {
+		io.WriteString(cmd.Stdout, result.Stdout)
+	}
+	if cmd.Stderr != nil && result.Stderr != "" {
+		io.WriteString(cmd.Stderr, result.Stderr)
+	}
+	if result.ExitCode != 0 {
+		return &ExitError{Code: result.ExitCode}
+	}
+	return nil
+}
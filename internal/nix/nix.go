@@ -1,6 +1,8 @@
 package nix
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
@@ -50,56 +52,40 @@ func getNixExecutable() string {
 }
 
 func RunCommand(command string, args ...string) (string, error) {
-	if command == "nix" {
-		command = getNixExecutable()
-		if command == "" {
-			return "", fmt.Errorf("nix executable not found: please ensure Nix is installed and in your PATH")
-		}
-	}
-	if strings.HasSuffix(command, "nix") {
-		args = append([]string{"--extra-experimental-features", "nix-command", "--extra-experimental-features", "flakes"}, args...)
-	}
-	cmd := exec.Command(command, args...)
-	output, err := cmd.CombinedOutput()
+	var output bytes.Buffer
+	err := executor.Run(context.Background(), Command{Name: command, Args: args, Stdout: &output, Stderr: &output})
 	if err != nil {
-		return "", fmt.Errorf("error running command '%s %s': %s\n%s", command, strings.Join(args, " "), err, string(output))
+		return "", fmt.Errorf("error running command '%s %s': %s\n%s", command, strings.Join(args, " "), err, output.String())
 	}
-	return string(output), nil
+	return output.String(), nil
 }
 
 func RunInteractiveCommand(command string, args ...string) error {
-	if command == "nix" {
-		command = getNixExecutable()
-		if command == "" {
-			return fmt.Errorf("nix executable not found: please ensure Nix is installed and in your PATH")
-		}
-	}
-	cmd := exec.Command(command, args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	return executor.Run(context.Background(), Command{Name: command, Args: args, Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr})
 }
 
 func RunSudoCommand(password string, args ...string) (string, error) {
-	cmd := exec.Command("sudo", "-S")
-	cmd.Stdin = strings.NewReader(password)
-	cmd.Args = append(cmd.Args, args...)
-	output, err := cmd.CombinedOutput()
+	var output bytes.Buffer
+	err := executor.Run(context.Background(), Command{
+		Name:   "sudo",
+		Args:   append([]string{"-S"}, args...),
+		Stdin:  strings.NewReader(password),
+		Stdout: &output,
+		Stderr: &output,
+	})
 	if err != nil {
-		return "", fmt.Errorf("error running sudo command: %s\n%s", err, string(output))
+		return "", fmt.Errorf("error running sudo command: %s\n%s", err, output.String())
 	}
-	return string(output), nil
+	return output.String(), nil
 }
 
 func RunCommandInDir(dir, command string, args ...string) (string, error) {
-	cmd := exec.Command(command, args...)
-	cmd.Dir = dir
-	output, err := cmd.CombinedOutput()
+	var output bytes.Buffer
+	err := executor.Run(context.Background(), Command{Name: command, Args: args, Dir: dir, Stdout: &output, Stderr: &output})
 	if err != nil {
-		return "", fmt.Errorf("error running command in dir '%s': %s\n%s", dir, err, string(output))
+		return "", fmt.Errorf("error running command in dir '%s': %s\n%s", dir, err, output.String())
 	}
-	return string(output), nil
+	return output.String(), nil
 }
 
 func GetNixMode() NixMode {
@@ -128,19 +114,6 @@ func UpdateFlake(path string) error {
 	return err
 }
 
-func CommitAndPush(path, message string) error {
-	if _, err := RunCommandInDir(path, "git", "add", "."); err != nil {
-		return err
-	}
-	if _, err := RunCommandInDir(path, "git", "commit", "-m", message); err != nil {
-		return err
-	}
-	if _, err := RunCommandInDir(path, "git", "push"); err != nil {
-		return err
-	}
-	return nil
-}
-
 // IsNixInstalled checks if the 'nix' command is available in the system's PATH.
 func IsNixInstalled() bool {
 	// First, check if 'nix' is in the system's PATH
@@ -156,25 +129,5 @@ func IsNixInstalled() bool {
 }
 
 func RunCommandInNewTerminal(command string, args ...string) error {
-	if command == "nix" {
-		command = getNixExecutable()
-		if command == "" {
-			return fmt.Errorf("nix executable not found: please ensure Nix is installed and in your PATH")
-		}
-	}
-	cmd := exec.Command(command, args...)
-	cmd.Stdin = nil
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-	return cmd.Start()
-}
-
-func Commit(path, message string) error {
-	if _, err := RunCommandInDir(path, "git", "add", "."); err != nil {
-		return err
-	}
-	if _, err := RunCommandInDir(path, "git", "commit", "-m", message); err != nil {
-		return err
-	}
-	return nil
+	return executor.Run(context.Background(), Command{Name: command, Args: args, Detach: true})
 }
@@ -0,0 +1,358 @@
+package nix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// ActivityType identifies what a nix activity is doing, as reported in the
+// "type" field of --log-format internal-json start events.
+type ActivityType int
+
+const (
+	ActUnknown       ActivityType = 0
+	ActCopyPath      ActivityType = 100
+	ActFileTransfer  ActivityType = 101
+	ActRealise       ActivityType = 102
+	ActCopyPaths     ActivityType = 103
+	ActBuilds        ActivityType = 104
+	ActBuild         ActivityType = 105
+	ActOptimiseStore ActivityType = 106
+	ActVerifyPaths   ActivityType = 107
+	ActSubstitute    ActivityType = 108
+	ActQueryPathInfo ActivityType = 109
+	ActPostBuildHook ActivityType = 110
+	ActBuildWaiting  ActivityType = 111
+	ActFetchTree     ActivityType = 112
+)
+
+// ResultType identifies the payload of an internal-json result event.
+type ResultType int
+
+const (
+	ResFileLinked       ResultType = 100
+	ResBuildLogLine     ResultType = 101
+	ResUntrustedPath    ResultType = 102
+	ResCorruptedPath    ResultType = 103
+	ResSetPhase         ResultType = 104
+	ResProgress         ResultType = 105
+	ResSetExpected      ResultType = 106
+	ResPostBuildLogLine ResultType = 107
+	ResFetchStatus      ResultType = 108
+)
+
+// Verbosity levels used by nix log messages.
+const (
+	LvlError = iota
+	LvlWarn
+	LvlNotice
+	LvlInfo
+	LvlTalkative
+	LvlChatty
+	LvlDebug
+	LvlVomit
+)
+
+// internalJSONPrefix marks structured log lines on nix's stderr.
+const internalJSONPrefix = "@nix "
+
+// Event is a single structured log event emitted by nix with --log-format internal-json.
+type Event struct {
+	Action string        `json:"action"` // "start", "stop", "result" or "msg"
+	ID     uint64        `json:"id"`
+	Parent uint64        `json:"parent"`
+	Level  int           `json:"level"`
+	Type   int           `json:"type"`
+	Text   string        `json:"text"`
+	Msg    string        `json:"msg"`
+	Fields []interface{} `json:"fields"`
+}
+
+// ActivityType returns the activity type of a start event.
+func (e Event) ActivityType() ActivityType {
+	return ActivityType(e.Type)
+}
+
+// ResultType returns the result type of a result event.
+func (e Event) ResultType() ResultType {
+	return ResultType(e.Type)
+}
+
+// IntField returns the i-th field as an integer, or 0 if it is missing or not a number.
+func (e Event) IntField(i int) int64 {
+	if i >= len(e.Fields) {
+		return 0
+	}
+	if f, ok := e.Fields[i].(float64); ok {
+		return int64(f)
+	}
+	return 0
+}
+
+// StringField returns the i-th field as a string, or "" if it is missing or not a string.
+func (e Event) StringField(i int) string {
+	if i >= len(e.Fields) {
+		return ""
+	}
+	s, _ := e.Fields[i].(string)
+	return s
+}
+
+// ParseEvent decodes a line of nix stderr output. It reports false for lines
+// that are not internal-json events.
+func ParseEvent(line string) (Event, bool) {
+	if !strings.HasPrefix(line, internalJSONPrefix) {
+		return Event{}, false
+	}
+	var ev Event
+	if err := json.Unmarshal([]byte(line[len(internalJSONPrefix):]), &ev); err != nil {
+		return Event{}, false
+	}
+	return ev, true
+}
+
+// Progress is a snapshot of what a nix build is doing.
+type Progress struct {
+	BuildsDone     int
+	BuildsExpected int
+	BuildsRunning  int
+	BuildsFailed   int
+	CopiesDone     int
+	CopiesExpected int
+	BytesDone      int64
+	BytesExpected  int64
+	// Current is the name of the derivation that most recently started building.
+	Current string
+	// Phase is the build phase most recently entered, e.g. "buildPhase".
+	Phase string
+}
+
+// Fraction estimates overall completion between 0 and 1, or -1 if nothing
+// measurable has been reported yet.
+func (p Progress) Fraction() float64 {
+	var done, total float64
+	if p.BuildsExpected > 0 {
+		done += float64(p.BuildsDone)
+		total += float64(p.BuildsExpected)
+	}
+	if p.CopiesExpected > 0 {
+		done += float64(p.CopiesDone)
+		total += float64(p.CopiesExpected)
+	}
+	if total == 0 {
+		return -1
+	}
+	return done / total
+}
+
+// String summarises the progress on one line,
+// e.g. "building 3 of 12 derivations, downloading 45.2 of 120.0 MiB".
+func (p Progress) String() string {
+	var parts []string
+	if p.BuildsExpected > 0 {
+		parts = append(parts, fmt.Sprintf("building %d of %d derivations", p.BuildsDone, p.BuildsExpected))
+	}
+	if p.CopiesExpected > 0 {
+		parts = append(parts, fmt.Sprintf("fetching %d of %d paths", p.CopiesDone, p.CopiesExpected))
+	}
+	if p.BytesExpected > 0 {
+		parts = append(parts, fmt.Sprintf("downloading %.1f of %.1f MiB", mebibytes(p.BytesDone), mebibytes(p.BytesExpected)))
+	}
+	if p.BuildsFailed > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed", p.BuildsFailed))
+	}
+	if p.Current != "" {
+		current := p.Current
+		if p.Phase != "" {
+			current += " (" + p.Phase + ")"
+		}
+		parts = append(parts, current)
+	}
+	if len(parts) == 0 {
+		return "evaluating..."
+	}
+	return strings.Join(parts, ", ")
+}
+
+func mebibytes(n int64) float64 {
+	return float64(n) / (1024 * 1024)
+}
+
+type transfer struct {
+	done, expected int64
+}
+
+// ProgressTracker folds a stream of events into a Progress snapshot.
+type ProgressTracker struct {
+	progress   Progress
+	activities map[uint64]ActivityType
+	transfers  map[uint64]transfer
+}
+
+// NewProgressTracker returns an empty tracker.
+func NewProgressTracker() *ProgressTracker {
+	return &ProgressTracker{
+		activities: make(map[uint64]ActivityType),
+		transfers:  make(map[uint64]transfer),
+	}
+}
+
+// Progress returns the current snapshot.
+func (t *ProgressTracker) Progress() Progress {
+	return t.progress
+}
+
+// Handle applies ev and reports whether the snapshot changed.
+func (t *ProgressTracker) Handle(ev Event) bool {
+	switch ev.Action {
+	case "start":
+		t.activities[ev.ID] = ev.ActivityType()
+		if ev.ActivityType() == ActBuild {
+			t.progress.Current = derivationName(ev.StringField(0))
+			t.progress.Phase = ""
+			return true
+		}
+	case "stop":
+		delete(t.activities, ev.ID)
+	case "result":
+		return t.handleResult(ev)
+	}
+	return false
+}
+
+func (t *ProgressTracker) handleResult(ev Event) bool {
+	switch ev.ResultType() {
+	case ResSetPhase:
+		t.progress.Phase = ev.StringField(0)
+		return true
+	case ResProgress:
+		done, expected := ev.IntField(0), ev.IntField(1)
+		switch t.activities[ev.ID] {
+		case ActBuilds:
+			t.progress.BuildsDone = int(done)
+			t.progress.BuildsExpected = int(expected)
+			t.progress.BuildsRunning = int(ev.IntField(2))
+			t.progress.BuildsFailed = int(ev.IntField(3))
+		case ActCopyPaths:
+			t.progress.CopiesDone = int(done)
+			t.progress.CopiesExpected = int(expected)
+		case ActFileTransfer, ActCopyPath:
+			t.transfers[ev.ID] = transfer{done: done, expected: expected}
+			t.progress.BytesDone, t.progress.BytesExpected = 0, 0
+			for _, tr := range t.transfers {
+				t.progress.BytesDone += tr.done
+				t.progress.BytesExpected += tr.expected
+			}
+		default:
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// derivationName turns "/nix/store/<hash>-hello-2.12.drv" into "hello-2.12".
+func derivationName(drvPath string) string {
+	name := strings.TrimSuffix(path.Base(drvPath), ".drv")
+	if i := strings.IndexByte(name, '-'); i == 32 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// logText returns the human-readable text carried by ev, if any.
+func logText(ev Event) (string, bool) {
+	switch ev.Action {
+	case "msg":
+		return ev.Msg, ev.Level <= LvlInfo
+	case "start":
+		return ev.Text, ev.Text != "" && ev.Level <= LvlInfo
+	case "result":
+		if ev.ResultType() == ResBuildLogLine || ev.ResultType() == ResPostBuildLogLine {
+			return ev.StringField(0), true
+		}
+	}
+	return "", false
+}
+
+// ProgressFunc receives a new snapshot whenever the build progress changes.
+type ProgressFunc func(Progress)
+
+// progressArgs returns the flags that make command emit internal-json events,
+// or nil if the command cannot produce them. home-manager only forwards a fixed
+// set of flags to nix, so it is left out.
+func progressArgs(command string) []string {
+	switch path.Base(command) {
+	case "nix", "nixos-rebuild":
+		return []string{"--log-format", "internal-json", "-v"}
+	}
+	return nil
+}
+
+// progressHandler turns the raw output of a command run with internal-json
+// logging into readable lines for onLine and snapshots for onProgress.
+func progressHandler(onLine OutputFunc, onProgress ProgressFunc) OutputFunc {
+	var mu sync.Mutex
+	tracker := NewProgressTracker()
+	return func(line string) {
+		ev, ok := ParseEvent(line)
+		if !ok {
+			if onLine != nil {
+				onLine(line)
+			}
+			return
+		}
+		if text, ok := logText(ev); ok && onLine != nil {
+			onLine(text)
+		}
+		mu.Lock()
+		changed := tracker.Handle(ev)
+		snapshot := tracker.Progress()
+		mu.Unlock()
+		if changed && onProgress != nil {
+			onProgress(snapshot)
+		}
+	}
+}
+
+// RunCommandProgress runs command like RunCommandContext, asking nix for
+// structured logs and reporting build progress to onProgress. Only readable
+// log lines are passed to onLine and returned as output.
+func RunCommandProgress(ctx context.Context, onLine OutputFunc, onProgress ProgressFunc, command string, args ...string) (string, error) {
+	args = append(args, progressArgs(command)...)
+	return runProgress(ctx, Command{Name: command, Args: args}, onLine, onProgress)
+}
+
+// RunSudoCommandProgress is the sudo variant of RunCommandProgress.
+func RunSudoCommandProgress(ctx context.Context, password string, onLine OutputFunc, onProgress ProgressFunc, args ...string) (string, error) {
+	if len(args) > 0 {
+		args = append(args, progressArgs(args[0])...)
+	}
+	return runProgress(ctx, Command{
+		Name:  "sudo",
+		Args:  append([]string{"-S"}, args...),
+		Stdin: strings.NewReader(password),
+	}, onLine, onProgress)
+}
+
+func runProgress(ctx context.Context, c Command, onLine OutputFunc, onProgress ProgressFunc) (string, error) {
+	var text strings.Builder
+	handler := progressHandler(func(line string) {
+		text.WriteString(line + "\n")
+		if onLine != nil {
+			onLine(line)
+		}
+	}, onProgress)
+	_, err := runLines(ctx, c, handler)
+	if ctx.Err() != nil {
+		return text.String(), fmt.Errorf("command '%s' cancelled: %w", c, ctx.Err())
+	}
+	if err != nil {
+		return "", fmt.Errorf("error running command '%s': %s\n%s", c, err, text.String())
+	}
+	return text.String(), nil
+}
@@ -0,0 +1,79 @@
+package nix
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const rebuildLog = `@nix {"action":"start","id":1,"level":0,"parent":0,"text":"","type":104}
+@nix {"action":"start","id":2,"level":0,"parent":0,"text":"","type":103}
+@nix {"action":"result","fields":[0,12,0,0],"id":1,"type":105}
+@nix {"action":"msg","level":3,"msg":"these 12 derivations will be built:"}
+@nix {"action":"msg","level":5,"msg":"evaluating file '/nix/store/abc-source/flake.nix'"}
+@nix {"action":"start","fields":["/nix/store/0123456789abcdfghijklmnpqrsvwxyz-hello-2.12.drv","",1,1],"id":3,"level":3,"parent":0,"text":"building '/nix/store/0123456789abcdfghijklmnpqrsvwxyz-hello-2.12.drv'","type":105}
+@nix {"action":"result","fields":["buildPhase"],"id":3,"type":104}
+@nix {"action":"result","fields":["make: Nothing to be done."],"id":3,"type":101}
+@nix {"action":"start","id":4,"level":4,"parent":0,"text":"downloading 'https://cache.nixos.org/nar/x.nar.xz'","type":101}
+@nix {"action":"result","fields":[1048576,4194304,0,0],"id":4,"type":105}
+@nix {"action":"result","fields":[3,12,1,0],"id":1,"type":105}
+@nix {"action":"result","fields":[1,4,0,0],"id":2,"type":105}
+plain stderr line
+`
+
+func TestProgressTracker(t *testing.T) {
+	tracker := NewProgressTracker()
+	for _, line := range strings.Split(rebuildLog, "\n") {
+		if ev, ok := ParseEvent(line); ok {
+			tracker.Handle(ev)
+		}
+	}
+
+	p := tracker.Progress()
+	want := Progress{
+		BuildsDone:     3,
+		BuildsExpected: 12,
+		BuildsRunning:  1,
+		CopiesDone:     1,
+		CopiesExpected: 4,
+		BytesDone:      1048576,
+		BytesExpected:  4194304,
+		Current:        "hello-2.12",
+		Phase:          "buildPhase",
+	}
+	if p != want {
+		t.Fatalf("progress = %+v, want %+v", p, want)
+	}
+	if got := p.String(); got != "building 3 of 12 derivations, fetching 1 of 4 paths, downloading 1.0 of 4.0 MiB, hello-2.12 (buildPhase)" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := p.Fraction(); got != 0.25 {
+		t.Errorf("Fraction() = %v, want 0.25", got)
+	}
+}
+
+func TestRunCommandProgress(t *testing.T) {
+	fake := NewFakeExecutor().On("nix build", FakeResult{Stderr: rebuildLog})
+	SetExecutor(fake)
+	defer SetExecutor(nil)
+
+	var updates int
+	out, err := RunCommandProgress(context.Background(), nil, func(Progress) { updates++ }, "nix", "build", ".#foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := fake.CommandLines()[0]; got != "nix build .#foo --log-format internal-json -v" {
+		t.Errorf("command = %q", got)
+	}
+	wantOut := "these 12 derivations will be built:\n" +
+		"building '/nix/store/0123456789abcdfghijklmnpqrsvwxyz-hello-2.12.drv'\n" +
+		"make: Nothing to be done.\n" +
+		"plain stderr line\n"
+	if out != wantOut {
+		t.Errorf("output = %q, want %q", out, wantOut)
+	}
+	if updates == 0 {
+		t.Error("expected progress updates")
+	}
+}
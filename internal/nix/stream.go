@@ -0,0 +1,112 @@
+package nix
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// OutputFunc receives the output of a running command one line at a time.
+type OutputFunc func(line string)
+
+// lineWriter collects everything written to it and hands each complete line
+// to an OutputFunc as soon as it arrives.
+type lineWriter struct {
+	mu      sync.Mutex
+	onLine  OutputFunc
+	output  bytes.Buffer
+	pending []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.output.Write(p)
+	if w.onLine == nil {
+		return len(p), nil
+	}
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		w.onLine(strings.TrimRight(string(w.pending[:i]), "\r"))
+		w.pending = w.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush delivers a trailing line that was not terminated by a newline.
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.onLine != nil && len(w.pending) > 0 {
+		w.onLine(strings.TrimRight(string(w.pending), "\r"))
+	}
+	w.pending = nil
+}
+
+func (w *lineWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.output.String()
+}
+
+// runLines runs c with stdout and stderr combined, passing each line to onLine.
+// It returns everything the command printed.
+func runLines(ctx context.Context, c Command, onLine OutputFunc) (string, error) {
+	w := &lineWriter{onLine: onLine}
+	c.Stdout = w
+	c.Stderr = w
+	err := executor.Run(ctx, c)
+	w.flush()
+	return w.String(), err
+}
+
+// RunCommandContext runs a command like RunCommand, passing stdout and stderr
+// to onLine line by line while it runs. Cancelling ctx kills the command's
+// whole process group. onLine may be nil.
+func RunCommandContext(ctx context.Context, onLine OutputFunc, command string, args ...string) (string, error) {
+	output, err := runLines(ctx, Command{Name: command, Args: args}, onLine)
+	if ctx.Err() != nil {
+		return output, fmt.Errorf("command '%s %s' cancelled: %w", command, strings.Join(args, " "), ctx.Err())
+	}
+	if err != nil {
+		return "", fmt.Errorf("error running command '%s %s': %s\n%s", command, strings.Join(args, " "), err, output)
+	}
+	return output, nil
+}
+
+// RunCommandStdout runs a command whose output is parsed and returns only
+// what it printed on stdout; stderr is reported in the error if it fails. env
+// holds extra "KEY=value" variables for the command's environment.
+func RunCommandStdout(ctx context.Context, env []string, command string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := executor.Run(ctx, Command{Name: command, Args: args, Env: env, Stdout: &stdout, Stderr: &stderr})
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("command '%s %s' cancelled: %w", command, strings.Join(args, " "), ctx.Err())
+	}
+	if err != nil {
+		return "", fmt.Errorf("error running command '%s %s': %s\n%s", command, strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// RunSudoCommandContext is the streaming, cancellable variant of RunSudoCommand.
+func RunSudoCommandContext(ctx context.Context, password string, onLine OutputFunc, args ...string) (string, error) {
+	output, err := runLines(ctx, Command{
+		Name:  "sudo",
+		Args:  append([]string{"-S"}, args...),
+		Stdin: strings.NewReader(password),
+	}, onLine)
+	if ctx.Err() != nil {
+		return output, fmt.Errorf("sudo command cancelled: %w", ctx.Err())
+	}
+	if err != nil {
+		return "", fmt.Errorf("error running sudo command: %s\n%s", err, output)
+	}
+	return output, nil
+}
@@ -0,0 +1,46 @@
+package nix
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := &lineWriter{onLine: func(line string) { lines = append(lines, line) }}
+	for _, chunk := range []string{"building", " hello\r\n", "copying\ndone\n", "partial"} {
+		w.Write([]byte(chunk))
+	}
+	if want := []string{"building hello", "copying", "done"}; !reflect.DeepEqual(lines, want) {
+		t.Fatalf("lines before flush = %q, want %q", lines, want)
+	}
+	w.flush()
+	if want := []string{"building hello", "copying", "done", "partial"}; !reflect.DeepEqual(lines, want) {
+		t.Fatalf("lines after flush = %q, want %q", lines, want)
+	}
+	if want := "building hello\r\ncopying\ndone\npartial"; w.String() != want {
+		t.Errorf("output = %q, want %q", w.String(), want)
+	}
+}
+
+func TestRunLinesInterleaved(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	var lines []string
+	output, err := runLines(context.Background(), Command{
+		Name: "sh",
+		Args: []string{"-c", "echo out; echo err >&2; echo more; printf last >&2"},
+	}, func(line string) { lines = append(lines, line) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"out", "err", "more", "last"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+	if want := "out\nerr\nmore\nlast"; output != want {
+		t.Errorf("output = %q, want %q", output, want)
+	}
+}
@@ -0,0 +1,196 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// kind is the JSON type of a node.
+type kind int
+
+const (
+	kindNull kind = iota
+	kindBool
+	kindNumber
+	kindString
+	kindArray
+	kindObject
+)
+
+func (k kind) String() string {
+	return [...]string{"null", "boolean", "number", "string", "array", "object"}[k]
+}
+
+// node is a parsed JSON value that remembers where it starts in the input.
+type node struct {
+	kind   kind
+	offset int
+	// value holds the Go value of scalars: bool, json.Number or string.
+	value  interface{}
+	fields []field
+	items  []*node
+}
+
+// field is an object member in the order it appears.
+type field struct {
+	name   string
+	offset int
+	value  *node
+}
+
+// parser reads JSON that json.Valid has accepted, so it only has to track
+// positions and not report syntax errors precisely.
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func parse(data []byte) (*node, error) {
+	p := &parser{data: data}
+	n, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) value() (*node, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, fmt.Errorf("unexpected end of input")
+	}
+	start := p.pos
+	switch c := p.data[p.pos]; {
+	case c == '{':
+		return p.object()
+	case c == '[':
+		return p.array()
+	case c == '"':
+		s, err := p.string()
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: kindString, offset: start, value: s}, nil
+	case c == 't' || c == 'f':
+		b := c == 't'
+		if b {
+			p.pos += len("true")
+		} else {
+			p.pos += len("false")
+		}
+		return &node{kind: kindBool, offset: start, value: b}, nil
+	case c == 'n':
+		p.pos += len("null")
+		return &node{kind: kindNull, offset: start}, nil
+	default:
+		for p.pos < len(p.data) && isNumberByte(p.data[p.pos]) {
+			p.pos++
+		}
+		return &node{kind: kindNumber, offset: start, value: json.Number(p.data[start:p.pos])}, nil
+	}
+}
+
+func isNumberByte(c byte) bool {
+	return c == '-' || c == '+' || c == '.' || c == 'e' || c == 'E' || (c >= '0' && c <= '9')
+}
+
+func (p *parser) string() (string, error) {
+	start := p.pos
+	p.pos++ // opening quote
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case '\\':
+			p.pos += 2
+		case '"':
+			p.pos++
+			var s string
+			if err := json.Unmarshal(p.data[start:p.pos], &s); err != nil {
+				return "", err
+			}
+			return s, nil
+		default:
+			p.pos++
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+func (p *parser) object() (*node, error) {
+	n := &node{kind: kindObject, offset: p.pos}
+	p.pos++ // {
+	for {
+		p.skipSpace()
+		if p.pos < len(p.data) && p.data[p.pos] == '}' {
+			p.pos++
+			return n, nil
+		}
+		if p.pos < len(p.data) && p.data[p.pos] == ',' {
+			p.pos++
+			p.skipSpace()
+		}
+		keyOffset := p.pos
+		name, err := p.string()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		p.pos++ // :
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		n.fields = append(n.fields, field{name: name, offset: keyOffset, value: v})
+	}
+}
+
+func (p *parser) array() (*node, error) {
+	n := &node{kind: kindArray, offset: p.pos}
+	p.pos++ // [
+	for {
+		p.skipSpace()
+		if p.pos < len(p.data) && p.data[p.pos] == ']' {
+			p.pos++
+			return n, nil
+		}
+		if p.pos < len(p.data) && p.data[p.pos] == ',' {
+			p.pos++
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		n.items = append(n.items, v)
+	}
+}
+
+// lineColumn converts a byte offset into data to a 1-based line and column,
+// counting columns in characters.
+func lineColumn(data []byte, offset int) (int, int) {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	line, col := 1, 1
+	for i := 0; i < offset; {
+		r, size := utf8.DecodeRune(data[i:])
+		if r == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+		i += size
+	}
+	return line, col
+}
@@ -0,0 +1,320 @@
+// Package schema validates pilo's JSON files against the JSON Schemas
+// embedded with it and reports every problem with its line and column.
+//
+// Only the parts of JSON Schema the embedded schemas use are supported: type,
+// properties, required, additionalProperties, items, enum, minimum,
+// minLength and pattern.
+package schema
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed schemas/*.schema.json
+var schemaFS embed.FS
+
+// Files lists the pilo JSON files that have a schema, by file name.
+var Files = []string{"base-config.json", "packages.json", "aliases.json", "users.json"}
+
+// Schema is a JSON Schema.
+type Schema struct {
+	Title       string             `json:"title,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	// AdditionalProperties is false, a schema for the values of properties
+	// not listed in Properties, or nil to allow anything.
+	AdditionalProperties *Additional   `json:"additionalProperties,omitempty"`
+	Items                *Schema       `json:"items,omitempty"`
+	Enum                 []interface{} `json:"enum,omitempty"`
+	Minimum              *float64      `json:"minimum,omitempty"`
+	MinLength            *int          `json:"minLength,omitempty"`
+	Pattern              string        `json:"pattern,omitempty"`
+
+	patternOnce sync.Once
+	pattern     *regexp.Regexp
+}
+
+// Additional is the value of additionalProperties: either a boolean or a
+// schema.
+type Additional struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+func (a *Additional) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	return json.Unmarshal(data, &a.Schema)
+}
+
+// Problem is one way a file does not match its schema.
+type Problem struct {
+	File   string
+	Line   int
+	Column int
+	// Path locates the value, such as "system.type" or "packages[2].name".
+	// It is empty for the whole document.
+	Path    string
+	Message string
+}
+
+func (p Problem) Error() string {
+	loc := fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+	if p.Path == "" {
+		return loc + ": " + p.Message
+	}
+	return fmt.Sprintf("%s: %s: %s", loc, p.Path, p.Message)
+}
+
+// Error holds every problem found in a file.
+type Error struct {
+	Problems []Problem
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+var (
+	schemasMu sync.Mutex
+	schemas   = map[string]*Schema{}
+)
+
+// For returns the schema of the pilo file with the base name of path.
+func For(path string) (*Schema, error) {
+	name := strings.TrimSuffix(filepath.Base(path), ".json")
+	schemasMu.Lock()
+	defer schemasMu.Unlock()
+	if s, ok := schemas[name]; ok {
+		return s, nil
+	}
+	data, err := schemaFS.ReadFile("schemas/" + name + ".schema.json")
+	if err != nil {
+		return nil, fmt.Errorf("no schema for %s", filepath.Base(path))
+	}
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid schema for %s: %w", filepath.Base(path), err)
+	}
+	schemas[name] = &s
+	return &s, nil
+}
+
+// Source returns the JSON Schema document of the pilo file with the base
+// name of path.
+func Source(path string) ([]byte, error) {
+	name := strings.TrimSuffix(filepath.Base(path), ".json")
+	return schemaFS.ReadFile("schemas/" + name + ".schema.json")
+}
+
+// Validate checks data, the contents of the pilo file at path, against the
+// file's schema and returns every problem in the order they appear.
+func Validate(path string, data []byte) []Problem {
+	s, err := For(path)
+	if err != nil {
+		return []Problem{{File: path, Line: 1, Column: 1, Message: err.Error()}}
+	}
+	return s.Validate(path, data)
+}
+
+// Check is like Validate but returns an *Error, or nil if data is valid.
+func Check(path string, data []byte) error {
+	if problems := Validate(path, data); len(problems) > 0 {
+		return &Error{Problems: problems}
+	}
+	return nil
+}
+
+// Validate checks data against s. Problems are reported against file.
+func (s *Schema) Validate(file string, data []byte) []Problem {
+	if err := syntaxCheck(data); err != nil {
+		// The offset of a syntax error is just past the offending byte.
+		var syntax *json.SyntaxError
+		offset := 0
+		if errors.As(err, &syntax) && syntax.Offset > 0 {
+			offset = int(syntax.Offset) - 1
+		}
+		line, col := lineColumn(data, offset)
+		return []Problem{{File: file, Line: line, Column: col, Message: "invalid JSON: " + err.Error()}}
+	}
+	root, err := parse(data)
+	if err != nil {
+		return []Problem{{File: file, Line: 1, Column: 1, Message: "invalid JSON: " + err.Error()}}
+	}
+
+	v := &validator{file: file, data: data}
+	v.check(s, root, "")
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i], v.problems[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return v.problems
+}
+
+// syntaxCheck returns the syntax error of data, if any. An empty file is
+// reported as such rather than as an unexpected end of input.
+func syntaxCheck(data []byte) error {
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return errors.New("the file is empty")
+	}
+	var v interface{}
+	return json.Unmarshal(data, &v)
+}
+
+type validator struct {
+	file     string
+	data     []byte
+	problems []Problem
+}
+
+func (v *validator) report(offset int, path, format string, args ...interface{}) {
+	line, col := lineColumn(v.data, offset)
+	v.problems = append(v.problems, Problem{File: v.file, Line: line, Column: col, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) check(s *Schema, n *node, path string) {
+	if s.Type != "" && !typeMatches(s.Type, n) {
+		v.report(n.offset, path, "expected %s, found %s", s.Type, n.kind)
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, n) {
+		v.report(n.offset, path, "must be one of %s", enumList(s.Enum))
+	}
+
+	switch n.kind {
+	case kindNumber:
+		if s.Minimum != nil {
+			if f, err := n.value.(json.Number).Float64(); err == nil && f < *s.Minimum {
+				v.report(n.offset, path, "must be at least %s", strconv.FormatFloat(*s.Minimum, 'f', -1, 64))
+			}
+		}
+	case kindString:
+		str := n.value.(string)
+		if s.MinLength != nil && len([]rune(str)) < *s.MinLength {
+			if *s.MinLength == 1 {
+				v.report(n.offset, path, "must not be empty")
+			} else {
+				v.report(n.offset, path, "must be at least %d characters long", *s.MinLength)
+			}
+		}
+		if re := s.compiledPattern(); re != nil && !re.MatchString(str) {
+			v.report(n.offset, path, "%q does not match the pattern %s", str, s.Pattern)
+		}
+	case kindArray:
+		if s.Items != nil {
+			for i, item := range n.items {
+				v.check(s.Items, item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	case kindObject:
+		seen := make(map[string]bool)
+		for _, f := range n.fields {
+			fieldPath := joinPath(path, f.name)
+			if seen[f.name] {
+				v.report(f.offset, fieldPath, "duplicate field")
+				continue
+			}
+			seen[f.name] = true
+			if prop, ok := s.Properties[f.name]; ok {
+				v.check(prop, f.value, fieldPath)
+			} else if s.AdditionalProperties != nil {
+				if s.AdditionalProperties.Schema != nil {
+					v.check(s.AdditionalProperties.Schema, f.value, fieldPath)
+				} else if !s.AdditionalProperties.Allowed {
+					v.report(f.offset, fieldPath, "unknown field")
+				}
+			}
+		}
+		for _, name := range s.Required {
+			if !seen[name] {
+				v.report(n.offset, path, "missing required field %q", name)
+			}
+		}
+	}
+}
+
+func (s *Schema) compiledPattern() *regexp.Regexp {
+	s.patternOnce.Do(func() {
+		if s.Pattern != "" {
+			s.pattern = regexp.MustCompile(s.Pattern)
+		}
+	})
+	return s.pattern
+}
+
+func typeMatches(typ string, n *node) bool {
+	switch typ {
+	case "integer":
+		if n.kind != kindNumber {
+			return false
+		}
+		_, err := n.value.(json.Number).Int64()
+		return err == nil
+	case "number":
+		return n.kind == kindNumber
+	default:
+		return typ == n.kind.String()
+	}
+}
+
+func inEnum(enum []interface{}, n *node) bool {
+	for _, e := range enum {
+		switch e := e.(type) {
+		case string:
+			if n.kind == kindString && n.value.(string) == e {
+				return true
+			}
+		case bool:
+			if n.kind == kindBool && n.value.(bool) == e {
+				return true
+			}
+		case float64:
+			if n.kind == kindNumber {
+				if f, err := n.value.(json.Number).Float64(); err == nil && f == e {
+					return true
+				}
+			}
+		case nil:
+			if n.kind == kindNull {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func enumList(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, e := range enum {
+		b, _ := json.Marshal(e)
+		values[i] = string(b)
+	}
+	return strings.Join(values, ", ")
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
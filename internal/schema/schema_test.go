@@ -0,0 +1,87 @@
+package schema
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+		want []string
+	}{
+		{
+			name: "valid base config",
+			file: "base-config.json",
+			data: `{"schema_version": 1, "system": {"type": "aarch64-darwin", "groups": ["core"]}, "gc": {"keep_last": 3}}`,
+		},
+		{
+			name: "syntax error",
+			file: "base-config.json",
+			data: "{\n  \"push_on_commit\": true,\n  \"remote_url\": \"x\"\n  \"remote_branch\": \"main\"\n}",
+			want: []string{"base-config.json:4:3: invalid JSON: invalid character '\"' after object key:value pair"},
+		},
+		{
+			name: "empty file",
+			file: "users.json",
+			data: "\n",
+			want: []string{"users.json:1:1: invalid JSON: the file is empty"},
+		},
+		{
+			name: "every problem",
+			file: "base-config.json",
+			data: "{\n  \"push_on_commit\": \"yes\",\n  \"system\": {\"type\": \"x86_64-windows\", \"colour\": \"red\"},\n  \"gc\": {\"keep_last\": -1}\n}",
+			want: []string{
+				"base-config.json:2:21: push_on_commit: expected boolean, found string",
+				`base-config.json:3:22: system.type: must be one of "", "x86_64-linux", "aarch64-linux", "x86_64-darwin", "aarch64-darwin"`,
+				"base-config.json:3:40: system.colour: unknown field",
+				"base-config.json:4:23: gc.keep_last: must be at least 0",
+			},
+		},
+		{
+			name: "packages",
+			file: "/somewhere/flake/packages.json",
+			data: `{"groups": [{"name": "dev go", "enabled": true}], "packages": [{"name": "", "installed": true}, {"name": "git"}, {"name": "go", "installed": true, "name": "go"}]}`,
+			want: []string{
+				`/somewhere/flake/packages.json:1:22: groups[0].name: "dev go" does not match the pattern ^[A-Za-z0-9][A-Za-z0-9_.-]*$`,
+				"/somewhere/flake/packages.json:1:73: packages[0].name: must not be empty",
+				`/somewhere/flake/packages.json:1:97: packages[1]: missing required field "installed"`,
+				"/somewhere/flake/packages.json:1:148: packages[2].name: duplicate field",
+			},
+		},
+		{
+			name: "aliases",
+			file: "aliases.json",
+			data: `{"ll": "ls -l", "aliases": {"gs": "git status"}}`,
+			want: []string{"aliases.json:1:28: aliases: expected string, found object"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, p := range Validate(tt.file, []byte(tt.data)) {
+				got = append(got, p.Error())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+// TestFlakeTemplate checks that the files pilo installs match their schemas.
+func TestFlakeTemplate(t *testing.T) {
+	for _, name := range Files {
+		data, err := os.ReadFile(filepath.Join("..", "..", "flake", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Check(name, data); err != nil {
+			t.Errorf("%v", err)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+// Package search builds a local index of the packages in the pinned nixpkgs
+// and answers package searches from it.
+package search
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pilo/internal/nix"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a single package in the index.
+type Entry struct {
+	AttrPath    string   `json:"attr"`
+	Pname       string   `json:"pname"`
+	Version     string   `json:"version"`
+	Description string   `json:"description,omitempty"`
+	License     []string `json:"license,omitempty"`
+	Homepage    string   `json:"homepage,omitempty"`
+	MainProgram string   `json:"mainProgram,omitempty"`
+	Platforms   []string `json:"platforms,omitempty"`
+	Unfree      bool     `json:"unfree,omitempty"`
+	Broken      bool     `json:"broken,omitempty"`
+	Insecure    bool     `json:"insecure,omitempty"`
+}
+
+// Index is the set of packages of one nixpkgs revision.
+type Index struct {
+	// Revision identifies the nixpkgs the index was built from.
+	Revision string  `json:"revision"`
+	Entries  []Entry `json:"entries"`
+
+	lowerOnce sync.Once
+	lower     []lowered
+}
+
+// lockedInput is the locked reference of a flake input in flake.lock.
+type lockedInput struct {
+	Rev     string `json:"rev"`
+	NarHash string `json:"narHash"`
+}
+
+// LockedRevision returns the revision of the nixpkgs input pinned in the
+// flake.lock of flakePath, falling back to its NAR hash for inputs without one.
+func LockedRevision(flakePath string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(flakePath, "flake.lock"))
+	if err != nil {
+		return "", fmt.Errorf("could not read flake.lock: %w", err)
+	}
+	var lock struct {
+		Nodes map[string]struct {
+			Inputs map[string]json.RawMessage `json:"inputs"`
+			Locked lockedInput                `json:"locked"`
+		} `json:"nodes"`
+		Root string `json:"root"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return "", fmt.Errorf("could not parse flake.lock: %w", err)
+	}
+
+	// The root node maps input names to node names, which usually match.
+	node := "nixpkgs"
+	if root, ok := lock.Nodes[lock.Root]; ok {
+		var name string
+		if err := json.Unmarshal(root.Inputs["nixpkgs"], &name); err == nil {
+			node = name
+		}
+	}
+	locked := lock.Nodes[node].Locked
+	switch {
+	case locked.Rev != "":
+		return locked.Rev, nil
+	case locked.NarHash != "":
+		return locked.NarHash, nil
+	default:
+		return "", fmt.Errorf("flake.lock does not pin a nixpkgs input")
+	}
+}
+
+// Build evaluates the nixpkgs pinned by the flake at flakePath and indexes
+// every package in it. This takes a minute or more.
+func Build(ctx context.Context, flakePath string) (*Index, error) {
+	revision, err := LockedRevision(flakePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Archiving the flake fetches its inputs and reports their store paths.
+	out, err := nix.RunCommandStdout(ctx, nil, "nix", "flake", "archive", "--json", flakePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch flake inputs: %w", err)
+	}
+	var archive struct {
+		Inputs map[string]struct {
+			Path string `json:"path"`
+		} `json:"inputs"`
+	}
+	if err := json.Unmarshal([]byte(out), &archive); err != nil {
+		return nil, fmt.Errorf("could not parse flake archive output: %w", err)
+	}
+	source := archive.Inputs["nixpkgs"].Path
+	if source == "" {
+		return nil, fmt.Errorf("the flake has no nixpkgs input")
+	}
+
+	// Unfree, broken and insecure packages are only listed when allowed;
+	// they are flagged instead.
+	out, err = nix.RunCommandStdout(ctx, []string{"NIXPKGS_ALLOW_UNFREE=1", "NIXPKGS_ALLOW_BROKEN=1", "NIXPKGS_ALLOW_INSECURE=1"},
+		"nix-env", "-f", source, "-qaP", "--json", "--meta")
+	if err != nil {
+		return nil, fmt.Errorf("could not list nixpkgs packages: %w", err)
+	}
+	entries, err := parseNixEnv([]byte(out))
+	if err != nil {
+		return nil, err
+	}
+	return &Index{Revision: revision, Entries: entries}, nil
+}
+
+// parseNixEnv converts the output of `nix-env -qaP --json --meta` into entries
+// sorted by attribute path.
+func parseNixEnv(data []byte) ([]Entry, error) {
+	var raw map[string]struct {
+		Pname   string `json:"pname"`
+		Version string `json:"version"`
+		Meta    struct {
+			Description          string          `json:"description"`
+			License              json.RawMessage `json:"license"`
+			Homepage             json.RawMessage `json:"homepage"`
+			MainProgram          string          `json:"mainProgram"`
+			Platforms            json.RawMessage `json:"platforms"`
+			Unfree               bool            `json:"unfree"`
+			Broken               bool            `json:"broken"`
+			Insecure             bool            `json:"insecure"`
+			KnownVulnerabilities []string        `json:"knownVulnerabilities"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("could not parse package list: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(raw))
+	for attr, pkg := range raw {
+		entries = append(entries, Entry{
+			AttrPath:    attr,
+			Pname:       pkg.Pname,
+			Version:     pkg.Version,
+			Description: pkg.Meta.Description,
+			License:     parseLicenses(pkg.Meta.License),
+			Homepage:    parseHomepage(pkg.Meta.Homepage),
+			MainProgram: pkg.Meta.MainProgram,
+			Platforms:   parsePlatforms(pkg.Meta.Platforms),
+			Unfree:      pkg.Meta.Unfree,
+			Broken:      pkg.Meta.Broken,
+			Insecure:    pkg.Meta.Insecure || len(pkg.Meta.KnownVulnerabilities) > 0,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].AttrPath < entries[j].AttrPath })
+	return entries, nil
+}
+
+// parseLicenses accepts the shapes meta.license takes in nixpkgs: a license
+// attribute set, a list of them, or a plain string.
+func parseLicenses(raw json.RawMessage) []string {
+	type license struct {
+		SpdxID    string `json:"spdxId"`
+		ShortName string `json:"shortName"`
+	}
+	name := func(l license) string {
+		if l.SpdxID != "" {
+			return l.SpdxID
+		}
+		return l.ShortName
+	}
+
+	var one license
+	if err := json.Unmarshal(raw, &one); err == nil {
+		if n := name(one); n != "" {
+			return []string{n}
+		}
+		return nil
+	}
+	var many []json.RawMessage
+	if err := json.Unmarshal(raw, &many); err == nil {
+		var names []string
+		for _, item := range many {
+			names = append(names, parseLicenses(item)...)
+		}
+		return names
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil && s != "" {
+		return []string{s}
+	}
+	return nil
+}
+
+// parseHomepage returns meta.homepage, or the first of several homepages.
+func parseHomepage(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err == nil && len(many) > 0 {
+		return many[0]
+	}
+	return ""
+}
+
+// parsePlatforms keeps the plain system names of meta.platforms and drops
+// the pattern attribute sets some packages use.
+func parsePlatforms(raw json.RawMessage) []string {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil
+	}
+	var platforms []string
+	for _, item := range items {
+		var s string
+		if err := json.Unmarshal(item, &s); err == nil {
+			platforms = append(platforms, s)
+		}
+	}
+	return platforms
+}
+
+// cacheVersion changes whenever Entry does, so stale caches are rebuilt.
+const cacheVersion = "v3"
+
+// cacheFile is where the index for revision is stored.
+func cacheFile(revision string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	name := strings.NewReplacer("/", "_", "+", "-", "=", "").Replace(revision)
+	return filepath.Join(dir, "pilo", "search-"+cacheVersion+"-"+name+".json.gz"), nil
+}
+
+// staleBuild is how long a build marker is trusted. A build that was killed
+// leaves its marker behind.
+const staleBuild = time.Hour
+
+// buildMarker is the file that exists while the index for revision is being
+// built, so that other pilo processes do not build it too.
+func buildMarker(revision string) (string, error) {
+	path, err := cacheFile(revision)
+	if err != nil {
+		return "", err
+	}
+	return path + ".building", nil
+}
+
+// Building reports whether the index for flakePath is being built, by this
+// process or another one.
+func Building(flakePath string) bool {
+	revision, err := LockedRevision(flakePath)
+	if err != nil {
+		return false
+	}
+	marker, err := buildMarker(revision)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(marker)
+	return err == nil && time.Since(info.ModTime()) < staleBuild
+}
+
+// Save writes the index to the cache.
+func (idx *Index) Save() error {
+	path, err := cacheFile(idx.Revision)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw := gzip.NewWriter(f)
+	if err := json.NewEncoder(zw).Encode(idx); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// loadCached reads the cached index for revision.
+func loadCached(revision string) (*Index, error) {
+	path, err := cacheFile(revision)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var idx Index
+	if err := json.NewDecoder(zr).Decode(&idx); err != nil {
+		return nil, fmt.Errorf("could not read search index: %w", err)
+	}
+	return &idx, nil
+}
+
+var (
+	loadedMu sync.Mutex
+	loaded   *Index
+)
+
+// Cached returns the index for flakePath if it is in memory or in the cache,
+// without building it.
+func Cached(flakePath string) (*Index, error) {
+	revision, err := LockedRevision(flakePath)
+	if err != nil {
+		return nil, err
+	}
+
+	loadedMu.Lock()
+	defer loadedMu.Unlock()
+	if loaded != nil && loaded.Revision == revision {
+		return loaded, nil
+	}
+	idx, err := loadCached(revision)
+	if err != nil {
+		return nil, err
+	}
+	loaded = idx
+	return idx, nil
+}
+
+// Lookup returns the entry with the given attribute path.
+func (idx *Index) Lookup(attrPath string) (Entry, bool) {
+	i := sort.Search(len(idx.Entries), func(i int) bool { return idx.Entries[i].AttrPath >= attrPath })
+	if i < len(idx.Entries) && idx.Entries[i].AttrPath == attrPath {
+		return idx.Entries[i], true
+	}
+	return Entry{}, false
+}
+
+// Refresh rebuilds the index for flakePath even if a cached one exists.
+// Building reports true meanwhile.
+func Refresh(ctx context.Context, flakePath string) (*Index, error) {
+	revision, err := LockedRevision(flakePath)
+	if err != nil {
+		return nil, err
+	}
+	if marker, err := buildMarker(revision); err == nil {
+		if err := os.MkdirAll(filepath.Dir(marker), 0755); err == nil {
+			if err := os.WriteFile(marker, nil, 0644); err == nil {
+				defer os.Remove(marker)
+			}
+		}
+	}
+
+	idx, err := Build(ctx, flakePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := idx.Save(); err != nil {
+		return nil, fmt.Errorf("could not cache search index: %w", err)
+	}
+	loadedMu.Lock()
+	loaded = idx
+	loadedMu.Unlock()
+	return idx, nil
+}
@@ -0,0 +1,187 @@
+package search
+
+import (
+	"sort"
+	"strings"
+)
+
+// Options controls how Search filters and orders results.
+type Options struct {
+	// FreeOnly drops packages with an unfree license.
+	FreeOnly bool
+	// SortByPopularity orders results by popularity before match quality.
+	SortByPopularity bool
+	// Limit caps the number of results; zero means DefaultLimit.
+	Limit int
+}
+
+// DefaultLimit is the number of results returned when Options.Limit is zero.
+const DefaultLimit = 200
+
+// Result is a matching entry with its ranking.
+type Result struct {
+	Entry
+	Score      int
+	Popularity int
+}
+
+// lowered caches the lower-cased fields of an entry that queries match against.
+type lowered struct {
+	attr, pname, lastAttr, description string
+}
+
+func (idx *Index) lowered() []lowered {
+	idx.lowerOnce.Do(func() {
+		idx.lower = make([]lowered, len(idx.Entries))
+		for i, e := range idx.Entries {
+			attr := strings.ToLower(e.AttrPath)
+			last := attr
+			if dot := strings.LastIndexByte(attr, '.'); dot >= 0 {
+				last = attr[dot+1:]
+			}
+			idx.lower[i] = lowered{
+				attr:        attr,
+				pname:       strings.ToLower(e.Pname),
+				lastAttr:    last,
+				description: strings.ToLower(e.Description),
+			}
+		}
+	})
+	return idx.lower
+}
+
+// Search returns the entries matching every term of query, best matches first.
+func (idx *Index) Search(query []string, opts Options) []Result {
+	var terms []string
+	for _, q := range query {
+		for _, t := range strings.Fields(strings.ToLower(q)) {
+			terms = append(terms, t)
+		}
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var results []Result
+	for i, l := range idx.lowered() {
+		e := idx.Entries[i]
+		if opts.FreeOnly && e.Unfree {
+			continue
+		}
+		score := 0
+		for _, t := range terms {
+			s := matchScore(l, t)
+			if s == 0 {
+				score = 0
+				break
+			}
+			score += s
+		}
+		if score > 0 {
+			results = append(results, Result{Entry: e, Score: score, Popularity: popularity(e)})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if opts.SortByPopularity && a.Popularity != b.Popularity {
+			return a.Popularity > b.Popularity
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Popularity != b.Popularity {
+			return a.Popularity > b.Popularity
+		}
+		if len(a.AttrPath) != len(b.AttrPath) {
+			return len(a.AttrPath) < len(b.AttrPath)
+		}
+		return a.AttrPath < b.AttrPath
+	})
+
+	limit := opts.Limit
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// matchScore rates how well term matches an entry, or returns 0 if it does not.
+func matchScore(l lowered, term string) int {
+	switch {
+	case l.pname == term:
+		return 100
+	case l.lastAttr == term:
+		return 90
+	case strings.HasPrefix(l.pname, term):
+		return 70
+	case strings.Contains(l.pname, term):
+		return 50
+	case strings.Contains(l.attr, term):
+		return 35
+	case containsWord(l.description, term):
+		return 20
+	case strings.Contains(l.description, term):
+		return 10
+	}
+	return fuzzyScore(l.pname, term)
+}
+
+// containsWord reports whether term occurs in s as a whole word.
+func containsWord(s, term string) bool {
+	for _, w := range strings.FieldsFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '+')
+	}) {
+		if w == term {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzyScore matches term as a subsequence of s, so "ffx" finds "firefox".
+// Tighter matches score higher; terms shorter than three letters never match
+// fuzzily because they would match almost everything.
+func fuzzyScore(s, term string) int {
+	if len(term) < 3 {
+		return 0
+	}
+	pos, gaps := 0, 0
+	for i := 0; i < len(term); i++ {
+		j := strings.IndexByte(s[pos:], term[i])
+		if j < 0 {
+			return 0
+		}
+		if i > 0 {
+			gaps += j
+		}
+		pos += j + 1
+	}
+	if score := 8 - gaps; score > 1 {
+		return score
+	}
+	return 1
+}
+
+// popularity estimates how likely a package is to be what people look for.
+// nixpkgs has no download statistics, so this favours top-level packages over
+// those nested in language package sets and well-described, multi-platform
+// packages over the rest.
+func popularity(e Entry) int {
+	p := 3 - strings.Count(e.AttrPath, ".")*2
+	if e.Description != "" {
+		p++
+	}
+	linux, darwin := false, false
+	for _, platform := range e.Platforms {
+		linux = linux || strings.HasSuffix(platform, "-linux")
+		darwin = darwin || strings.HasSuffix(platform, "-darwin")
+	}
+	if linux && darwin {
+		p++
+	}
+	return p
+}
@@ -0,0 +1,202 @@
+package search
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"pilo/internal/nix"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestParseNixEnv(t *testing.T) {
+	out := `{
+		"hello": {"pname": "hello", "version": "2.12.1", "meta": {
+			"description": "A program that produces a familiar, friendly greeting",
+			"license": {"spdxId": "GPL-3.0-or-later", "shortName": "gpl3Plus"},
+			"platforms": ["x86_64-linux", "aarch64-darwin", {"kernel": {"name": "linux"}}],
+			"homepage": ["https://www.gnu.org/software/hello/", "https://example.org"],
+			"mainProgram": "hello"
+		}},
+		"vscode": {"pname": "vscode", "version": "1.90.0", "meta": {
+			"license": [{"shortName": "unfree"}, "custom"],
+			"unfree": true,
+			"broken": true,
+			"knownVulnerabilities": ["CVE-2024-0001"]
+		}}
+	}`
+	entries, err := parseNixEnv([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Entry{
+		{
+			AttrPath:    "hello",
+			Pname:       "hello",
+			Version:     "2.12.1",
+			Description: "A program that produces a familiar, friendly greeting",
+			License:     []string{"GPL-3.0-or-later"},
+			Homepage:    "https://www.gnu.org/software/hello/",
+			MainProgram: "hello",
+			Platforms:   []string{"x86_64-linux", "aarch64-darwin"},
+		},
+		{
+			AttrPath: "vscode",
+			Pname:    "vscode",
+			Version:  "1.90.0",
+			License:  []string{"unfree", "custom"},
+			Unfree:   true,
+			Broken:   true,
+			Insecure: true,
+		},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("got %+v, want %+v", entries, want)
+	}
+}
+
+func TestLockedRevision(t *testing.T) {
+	dir := t.TempDir()
+	lock := `{
+		"nodes": {
+			"nixpkgs_2": {"locked": {"rev": "abc123", "narHash": "sha256-x"}},
+			"root": {"inputs": {"nixpkgs": "nixpkgs_2"}}
+		},
+		"root": "root",
+		"version": 7
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "flake.lock"), []byte(lock), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rev, err := LockedRevision(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev != "abc123" {
+		t.Fatalf("got revision %q, want abc123", rev)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	linuxAndDarwin := []string{"x86_64-linux", "aarch64-darwin"}
+	idx := &Index{Entries: []Entry{
+		{AttrPath: "firefox", Pname: "firefox", Description: "Web browser built from Firefox source tree", Platforms: linuxAndDarwin},
+		{AttrPath: "firefox-esr", Pname: "firefox-esr", Description: "Web browser built from Firefox source tree"},
+		{AttrPath: "python3Packages.firefox-helper", Pname: "firefox-helper", Description: "Helper"},
+		{AttrPath: "chromium", Pname: "chromium", Description: "An open source web browser from Google"},
+		{AttrPath: "google-chrome", Pname: "google-chrome", Description: "A freeware web browser developed by Google", Unfree: true},
+	}}
+
+	names := func(results []Result) []string {
+		var out []string
+		for _, r := range results {
+			out = append(out, r.AttrPath)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name  string
+		query []string
+		opts  Options
+		want  []string
+	}{
+		{
+			name:  "exact name first",
+			query: []string{"firefox"},
+			want:  []string{"firefox", "firefox-esr", "python3Packages.firefox-helper"},
+		},
+		{
+			name:  "every term must match",
+			query: []string{"web browser", "google"},
+			want:  []string{"google-chrome", "chromium"},
+		},
+		{
+			name:  "free only",
+			query: []string{"google"},
+			opts:  Options{FreeOnly: true},
+			want:  []string{"chromium"},
+		},
+		{
+			name:  "fuzzy",
+			query: []string{"chrmium"},
+			want:  []string{"chromium"},
+		},
+		{
+			name:  "popularity before match quality",
+			query: []string{"browser"},
+			opts:  Options{SortByPopularity: true},
+			want:  []string{"firefox", "chromium", "firefox-esr", "google-chrome"},
+		},
+		{
+			name:  "limit",
+			query: []string{"firefox"},
+			opts:  Options{Limit: 1},
+			want:  []string{"firefox"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := names(idx.Search(tt.query, tt.opts))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// buildExecutor answers the commands Build runs and records whether the
+// index was marked as being built while nix-env ran.
+type buildExecutor struct {
+	flakePath string
+	building  bool
+	env       []string
+}
+
+func (e *buildExecutor) Run(ctx context.Context, cmd nix.Command) error {
+	switch cmd.Name {
+	case "nix":
+		io.WriteString(cmd.Stdout, `{"inputs": {"nixpkgs": {"path": "/nix/store/abc-source"}}}`)
+	case "nix-env":
+		e.building = Building(e.flakePath)
+		e.env = cmd.Env
+		io.WriteString(cmd.Stdout, `{"hello": {"pname": "hello", "version": "2.12.1", "meta": {"broken": true}}}`)
+	}
+	return nil
+}
+
+func TestRefresh(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	dir := t.TempDir()
+	lock := `{"nodes": {"nixpkgs": {"locked": {"rev": "abc123"}}, "root": {"inputs": {"nixpkgs": "nixpkgs"}}}, "root": "root"}`
+	if err := os.WriteFile(filepath.Join(dir, "flake.lock"), []byte(lock), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exec := &buildExecutor{flakePath: dir}
+	nix.SetExecutor(exec)
+	t.Cleanup(func() { nix.SetExecutor(nil) })
+
+	if _, err := Cached(dir); err == nil {
+		t.Fatal("expected no cached index before the first build")
+	}
+	if _, err := Refresh(context.Background(), dir); err != nil {
+		t.Fatal(err)
+	}
+	if !exec.building || Building(dir) {
+		t.Errorf("building = %v during the build and %v after, want true and false", exec.building, Building(dir))
+	}
+	for _, allow := range []string{"NIXPKGS_ALLOW_UNFREE=1", "NIXPKGS_ALLOW_BROKEN=1", "NIXPKGS_ALLOW_INSECURE=1"} {
+		if !slices.Contains(exec.env, allow) {
+			t.Errorf("nix-env ran without %s", allow)
+		}
+	}
+	idx, err := Cached(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := idx.Lookup("hello"); !ok || !entry.Broken {
+		t.Errorf("hello = %+v, %v, want a broken entry", entry, ok)
+	}
+}
@@ -0,0 +1,211 @@
+package vcs
+
+import (
+	"errors"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// gitBackend keeps repositories on disk with go-git.
+type gitBackend struct{}
+
+func (gitBackend) Open(path string) (Repository, error) {
+	repo, err := git.PlainOpen(path)
+	if err == git.ErrRepositoryNotExists {
+		return nil, ErrNotRepository
+	} else if err != nil {
+		return nil, err
+	}
+	return &gitRepository{repo: repo}, nil
+}
+
+func (gitBackend) Init(path string) (Repository, error) {
+	repo, err := git.PlainInitWithOptions(path, &git.PlainInitOptions{
+		InitOptions: git.InitOptions{DefaultBranch: plumbing.NewBranchReferenceName(DefaultBranch)},
+	})
+	if err == git.ErrRepositoryAlreadyExists {
+		return nil, ErrRepositoryExists
+	} else if err != nil {
+		return nil, err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Init.DefaultBranch = DefaultBranch
+	if err := repo.SetConfig(cfg); err != nil {
+		return nil, err
+	}
+	return &gitRepository{repo: repo}, nil
+}
+
+type gitRepository struct {
+	repo *git.Repository
+}
+
+func (r *gitRepository) AddAll() error {
+	w, err := r.repo.Worktree()
+	if err != nil {
+		return err
+	}
+	_, err = w.Add(".")
+	return err
+}
+
+func (r *gitRepository) Commit(message string, opts CommitOptions) (string, error) {
+	w, err := r.repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	author := &object.Signature{Name: opts.Author.Name, Email: opts.Author.Email, When: opts.Author.When}
+	var parents []plumbing.Hash
+	for _, p := range opts.Parents {
+		parents = append(parents, plumbing.NewHash(p))
+	}
+	commitOpts := &git.CommitOptions{
+		Author:            author,
+		Parents:           parents,
+		AllowEmptyCommits: opts.AllowEmpty,
+	}
+	if opts.Signer != nil {
+		commitOpts.Signer = opts.Signer
+	}
+	hash, err := w.Commit(message, commitOpts)
+	if errors.Is(err, git.ErrEmptyCommit) {
+		return "", ErrEmptyCommit
+	} else if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+func (r *gitRepository) Status() (Status, error) {
+	w, err := r.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	status, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+	var s Status
+	for path, f := range status {
+		if f.Staging == git.Unmodified && f.Worktree == git.Unmodified {
+			continue
+		}
+		s = append(s, FileStatus{Path: path, Staging: byte(f.Staging), Worktree: byte(f.Worktree)})
+	}
+	return sortStatus(s), nil
+}
+
+func (r *gitRepository) Head() (string, error) {
+	head, err := r.repo.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return "", ErrNoCommits
+	} else if err != nil {
+		return "", err
+	}
+	return head.Hash().String(), nil
+}
+
+func (r *gitRepository) Push(auth transport.AuthMethod) error {
+	err := r.repo.Push(&git.PushOptions{RemoteName: "origin", Auth: auth})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
+
+func (r *gitRepository) AheadBehind() (int, int, error) {
+	head, err := r.repo.Head()
+	if err != nil {
+		return 0, 0, err
+	}
+	upstream, err := r.upstream(head.Name())
+	if err != nil {
+		return 0, 0, err
+	}
+	ours, err := r.ancestors(head.Hash())
+	if err != nil {
+		return 0, 0, err
+	}
+	theirs, err := r.ancestors(upstream)
+	if err != nil {
+		return 0, 0, err
+	}
+	ahead, behind := 0, 0
+	for h := range ours {
+		if !theirs[h] {
+			ahead++
+		}
+	}
+	for h := range theirs {
+		if !ours[h] {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// upstream returns the commit of the remote branch that branch tracks, or
+// else of the branch of the same name on origin.
+func (r *gitRepository) upstream(branch plumbing.ReferenceName) (plumbing.Hash, error) {
+	if !branch.IsBranch() {
+		return plumbing.ZeroHash, ErrNoUpstream
+	}
+	remote, merge := "origin", branch
+	if cfg, err := r.repo.Config(); err == nil {
+		if b, ok := cfg.Branches[branch.Short()]; ok && b.Remote != "" && b.Merge != "" {
+			remote, merge = b.Remote, b.Merge
+		}
+	}
+	ref, err := r.repo.Reference(plumbing.NewRemoteReferenceName(remote, merge.Short()), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return plumbing.ZeroHash, ErrNoUpstream
+	} else if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return ref.Hash(), nil
+}
+
+// ancestors returns the hashes of from and every commit it descends from.
+func (r *gitRepository) ancestors(from plumbing.Hash) (map[plumbing.Hash]bool, error) {
+	commits, err := r.repo.Log(&git.LogOptions{From: from})
+	if err != nil {
+		return nil, err
+	}
+	seen := map[plumbing.Hash]bool{}
+	err = commits.ForEach(func(c *object.Commit) error {
+		seen[c.Hash] = true
+		return nil
+	})
+	return seen, err
+}
+
+func (r *gitRepository) RemoteURL(name string) (string, error) {
+	remote, err := r.repo.Remote(name)
+	if err == git.ErrRemoteNotFound {
+		return "", ErrRemoteNotFound
+	} else if err != nil {
+		return "", err
+	}
+	if urls := remote.Config().URLs; len(urls) > 0 {
+		return urls[0], nil
+	}
+	return "", ErrRemoteNotFound
+}
+
+func (r *gitRepository) Identity() (Signature, error) {
+	cfg, err := r.repo.ConfigScoped(config.SystemScope)
+	if err != nil {
+		return Signature{}, err
+	}
+	if cfg.User.Name == "" || cfg.User.Email == "" {
+		return Signature{}, ErrNoIdentity
+	}
+	return Signature{Name: cfg.User.Name, Email: cfg.User.Email}, nil
+}
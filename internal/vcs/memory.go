@@ -0,0 +1,327 @@
+package vcs
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// MemoryBackend keeps repositories in memory, by path. Tests install one
+// with SetBackend.
+type MemoryBackend struct {
+	mu    sync.Mutex
+	repos map[string]*Memory
+}
+
+// NewMemoryBackend returns a MemoryBackend without repositories.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{repos: map[string]*Memory{}}
+}
+
+func (b *MemoryBackend) Open(path string) (Repository, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r, ok := b.repos[path]; ok {
+		return r, nil
+	}
+	return nil, ErrNotRepository
+}
+
+func (b *MemoryBackend) Init(path string) (Repository, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.repos[path]; ok {
+		return nil, ErrRepositoryExists
+	}
+	r := NewMemory()
+	b.repos[path] = r
+	return r, nil
+}
+
+// Repository returns the repository at path, or nil if there is none.
+func (b *MemoryBackend) Repository(path string) *Memory {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.repos[path]
+}
+
+// MemoryCommit is a commit of a Memory repository.
+type MemoryCommit struct {
+	Hash    string
+	Message string
+	Author  Signature
+	Parents []string
+	Files   map[string][]byte
+	// Signature is what the commit's Signer returned, if it had one.
+	Signature []byte
+}
+
+// Memory is a Repository kept in memory. Its worktree is changed with
+// WriteFile and RemoveFile, and pushing copies the current commit to its
+// upstream branch.
+type Memory struct {
+	mu       sync.Mutex
+	worktree map[string][]byte
+	index    map[string][]byte
+	commits  map[string]*MemoryCommit
+	head     string
+	// upstream is the commit of the upstream branch, and hasUpstream
+	// whether there is one.
+	upstream    string
+	hasUpstream bool
+	remotes     map[string]string
+	identity    Signature
+}
+
+// NewMemory returns an empty Memory repository.
+func NewMemory() *Memory {
+	return &Memory{
+		worktree: map[string][]byte{},
+		index:    map[string][]byte{},
+		commits:  map[string]*MemoryCommit{},
+		remotes:  map[string]string{},
+	}
+}
+
+// WriteFile writes a file of the worktree.
+func (m *Memory) WriteFile(path string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.worktree[path] = append([]byte(nil), data...)
+}
+
+// RemoveFile removes a file from the worktree.
+func (m *Memory) RemoveFile(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.worktree, path)
+}
+
+// SetRemote adds the remote name with url.
+func (m *Memory) SetRemote(name, url string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remotes[name] = url
+}
+
+// SetIdentity sets the user.name and user.email that Identity returns.
+func (m *Memory) SetIdentity(name, email string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.identity = Signature{Name: name, Email: email}
+}
+
+// SetUpstream makes hash the commit of the upstream branch, as a fetch
+// would.
+func (m *Memory) SetUpstream(hash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.upstream, m.hasUpstream = hash, true
+}
+
+// Log returns the commits from the current one back along first parents.
+func (m *Memory) Log() []MemoryCommit {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var log []MemoryCommit
+	for h := m.head; h != ""; {
+		c := m.commits[h]
+		log = append(log, *c)
+		h = ""
+		if len(c.Parents) > 0 {
+			h = c.Parents[0]
+		}
+	}
+	return log
+}
+
+func (m *Memory) AddAll() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.index = copyFiles(m.worktree)
+	return nil
+}
+
+func (m *Memory) Commit(message string, opts CommitOptions) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !opts.AllowEmpty && sameFiles(m.index, m.headFiles()) {
+		return "", ErrEmptyCommit
+	}
+	parents := opts.Parents
+	if parents == nil && m.head != "" {
+		parents = []string{m.head}
+	}
+	for _, p := range parents {
+		if _, ok := m.commits[p]; !ok {
+			return "", fmt.Errorf("unknown parent commit %s", p)
+		}
+	}
+	var signature []byte
+	if opts.Signer != nil {
+		var err error
+		payload := fmt.Sprintf("author %s\n\n%s", opts.Author, message)
+		if signature, err = opts.Signer.Sign(strings.NewReader(payload)); err != nil {
+			return "", err
+		}
+	}
+	sum := sha1.Sum([]byte(fmt.Sprintf("%d\x00%s\x00%v\x00%s", len(m.commits), message, parents, opts.Author)))
+	c := &MemoryCommit{
+		Hash:      hex.EncodeToString(sum[:]),
+		Message:   message,
+		Author:    opts.Author,
+		Parents:   append([]string(nil), parents...),
+		Files:     copyFiles(m.index),
+		Signature: signature,
+	}
+	m.commits[c.Hash] = c
+	m.head = c.Hash
+	return c.Hash, nil
+}
+
+func (m *Memory) Status() (Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	head := m.headFiles()
+	paths := map[string]bool{}
+	for _, files := range []map[string][]byte{head, m.index, m.worktree} {
+		for p := range files {
+			paths[p] = true
+		}
+	}
+	var s Status
+	for p := range paths {
+		staging := change(head, m.index, p)
+		worktree := change(m.index, m.worktree, p)
+		if staging == ' ' && worktree == 'A' {
+			staging, worktree = '?', '?'
+		}
+		if staging != ' ' || worktree != ' ' {
+			s = append(s, FileStatus{Path: p, Staging: staging, Worktree: worktree})
+		}
+	}
+	return sortStatus(s), nil
+}
+
+func (m *Memory) Head() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.head == "" {
+		return "", ErrNoCommits
+	}
+	return m.head, nil
+}
+
+func (m *Memory) Push(auth transport.AuthMethod) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.remotes["origin"]; !ok {
+		return ErrRemoteNotFound
+	}
+	m.upstream, m.hasUpstream = m.head, true
+	return nil
+}
+
+func (m *Memory) AheadBehind() (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.hasUpstream {
+		return 0, 0, ErrNoUpstream
+	}
+	ours, theirs := m.ancestors(m.head), m.ancestors(m.upstream)
+	ahead, behind := 0, 0
+	for h := range ours {
+		if !theirs[h] {
+			ahead++
+		}
+	}
+	for h := range theirs {
+		if !ours[h] {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+func (m *Memory) RemoteURL(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if url, ok := m.remotes[name]; ok {
+		return url, nil
+	}
+	return "", ErrRemoteNotFound
+}
+
+func (m *Memory) Identity() (Signature, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.identity.Name == "" || m.identity.Email == "" {
+		return Signature{}, ErrNoIdentity
+	}
+	return m.identity, nil
+}
+
+func (m *Memory) headFiles() map[string][]byte {
+	if c, ok := m.commits[m.head]; ok {
+		return c.Files
+	}
+	return map[string][]byte{}
+}
+
+func (m *Memory) ancestors(hash string) map[string]bool {
+	seen := map[string]bool{}
+	queue := []string{hash}
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		c, ok := m.commits[h]
+		if !ok || seen[h] {
+			continue
+		}
+		seen[h] = true
+		queue = append(queue, c.Parents...)
+	}
+	return seen
+}
+
+// change returns the status letter of path going from files a to b.
+func change(a, b map[string][]byte, path string) byte {
+	before, inA := a[path]
+	after, inB := b[path]
+	switch {
+	case !inA && inB:
+		return 'A'
+	case inA && !inB:
+		return 'D'
+	case inA && !bytes.Equal(before, after):
+		return 'M'
+	default:
+		return ' '
+	}
+}
+
+func copyFiles(files map[string][]byte) map[string][]byte {
+	c := make(map[string][]byte, len(files))
+	for p, data := range files {
+		c[p] = data
+	}
+	return c
+}
+
+func sameFiles(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for p, data := range a {
+		other, ok := b[p]
+		if !ok || !bytes.Equal(data, other) {
+			return false
+		}
+	}
+	return true
+}
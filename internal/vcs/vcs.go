@@ -0,0 +1,169 @@
+// Package vcs is the version control of the pilo repository. Every commit,
+// push, status and ahead/behind query goes through a Repository, which is
+// backed by go-git, or kept in memory in tests.
+package vcs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+var (
+	// ErrNotRepository is returned by Open when path holds no repository.
+	ErrNotRepository = errors.New("not a git repository")
+	// ErrRepositoryExists is returned by Init when path already holds one.
+	ErrRepositoryExists = errors.New("repository already exists")
+	// ErrNoCommits is returned by Head before the first commit.
+	ErrNoCommits = errors.New("the repository has no commits")
+	// ErrEmptyCommit is returned by Commit when nothing is staged and the
+	// commit does not allow it.
+	ErrEmptyCommit = errors.New("nothing to commit")
+	// ErrNoUpstream is returned by AheadBehind when the current branch
+	// tracks no remote branch.
+	ErrNoUpstream = errors.New("the current branch has no upstream branch")
+	// ErrRemoteNotFound is returned by RemoteURL for an unknown remote.
+	ErrRemoteNotFound = errors.New("remote not found")
+	// ErrNoIdentity is returned by Identity when the git configuration
+	// lacks user.name or user.email.
+	ErrNoIdentity = errors.New("user.name and user.email are not set in the git configuration")
+)
+
+// DefaultBranch is the branch new repositories start on.
+const DefaultBranch = "main"
+
+// Repository is a git repository with a worktree.
+type Repository interface {
+	// AddAll stages every change in the worktree, including deletions.
+	AddAll() error
+	// Commit commits the staged changes and returns the hash of the new
+	// commit.
+	Commit(message string, opts CommitOptions) (string, error)
+	// Status returns the files that differ between the current commit, the
+	// index and the worktree.
+	Status() (Status, error)
+	// Head returns the hash of the current commit.
+	Head() (string, error)
+	// Push pushes the local branches to the origin remote.
+	Push(auth transport.AuthMethod) error
+	// AheadBehind counts the commits of the current branch that its
+	// upstream branch lacks, and those of the upstream that it lacks, as of
+	// the last fetch. The upstream is the tracked branch, or else the branch
+	// of the same name on origin.
+	AheadBehind() (ahead, behind int, err error)
+	// RemoteURL returns the URL of the named remote.
+	RemoteURL(name string) (string, error)
+	// Identity returns the user.name and user.email of the git
+	// configuration, the repository's own taking precedence over the
+	// user's and the system's.
+	Identity() (Signature, error)
+}
+
+// Signature identifies the author or committer of a commit.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// String formats s the way git does, as "Name <email>".
+func (s Signature) String() string {
+	return fmt.Sprintf("%s <%s>", s.Name, s.Email)
+}
+
+// CommitOptions describes a commit.
+type CommitOptions struct {
+	Author Signature
+	// Parents replaces the current commit as the parents of the new one.
+	// A merge commit lists the current commit and the merged one.
+	Parents []string
+	// AllowEmpty commits even if nothing is staged.
+	AllowEmpty bool
+	// Signer signs the commit; nil leaves it unsigned.
+	Signer Signer
+}
+
+// Signer signs commits. Sign returns the armored signature of message,
+// which is the commit as git encodes it without a signature.
+type Signer interface {
+	Sign(message io.Reader) ([]byte, error)
+}
+
+// FileStatus is the state of one changed file, in the letters of
+// "git status --short": ' ' unmodified, '?' untracked, 'A' added,
+// 'M' modified and 'D' deleted.
+type FileStatus struct {
+	Path     string
+	Staging  byte
+	Worktree byte
+}
+
+// Status lists the changed files of a repository, sorted by path.
+type Status []FileStatus
+
+// IsClean reports whether nothing changed.
+func (s Status) IsClean() bool {
+	return len(s) == 0
+}
+
+// String formats s like "git status --short".
+func (s Status) String() string {
+	var b strings.Builder
+	for _, f := range s {
+		fmt.Fprintf(&b, "%c%c %s\n", f.Staging, f.Worktree, f.Path)
+	}
+	return b.String()
+}
+
+func sortStatus(s Status) Status {
+	sort.Slice(s, func(i, j int) bool { return s[i].Path < s[j].Path })
+	return s
+}
+
+// Backend opens and creates repositories.
+type Backend interface {
+	// Open opens the repository at path.
+	Open(path string) (Repository, error)
+	// Init creates a repository at path on DefaultBranch.
+	Init(path string) (Repository, error)
+}
+
+var (
+	backendMu sync.Mutex
+	backend   Backend = gitBackend{}
+)
+
+// SetBackend makes Open and Init use b. Tests use it to keep repositories
+// in memory; passing nil restores go-git.
+func SetBackend(b Backend) {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	if b == nil {
+		b = gitBackend{}
+	}
+	backend = b
+}
+
+func currentBackend() Backend {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	return backend
+}
+
+// Open opens the repository at path. It returns ErrNotRepository if there
+// is none.
+func Open(path string) (Repository, error) {
+	return currentBackend().Open(path)
+}
+
+// Init creates a repository at path on DefaultBranch. It returns
+// ErrRepositoryExists if there already is one.
+func Init(path string) (Repository, error) {
+	return currentBackend().Init(path)
+}
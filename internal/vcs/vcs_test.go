@@ -0,0 +1,119 @@
+package vcs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestBackends runs the same flow against go-git and the memory backend, so
+// that tests using the latter see what pilo sees on disk.
+func TestBackends(t *testing.T) {
+	backends := []struct {
+		name    string
+		backend Backend
+		write   func(t *testing.T, repo Repository, path, name, content string)
+	}{
+		{
+			name:    "git",
+			backend: gitBackend{},
+			write: func(t *testing.T, _ Repository, path, name, content string) {
+				if err := os.WriteFile(filepath.Join(path, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:    "memory",
+			backend: NewMemoryBackend(),
+			write: func(t *testing.T, repo Repository, _, name, content string) {
+				repo.(*Memory).WriteFile(name, []byte(content))
+			},
+		},
+	}
+
+	author := Signature{Name: "pilo", Email: "pilo@localhost", When: time.Now()}
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			path := t.TempDir()
+			if _, err := b.backend.Open(path); err != ErrNotRepository {
+				t.Fatalf("Open before Init: err = %v, want ErrNotRepository", err)
+			}
+			repo, err := b.backend.Init(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := b.backend.Init(path); err != ErrRepositoryExists {
+				t.Fatalf("second Init: err = %v, want ErrRepositoryExists", err)
+			}
+			if _, err := repo.Head(); err != ErrNoCommits {
+				t.Fatalf("Head before commit: err = %v, want ErrNoCommits", err)
+			}
+
+			b.write(t, repo, path, "packages.json", `{"packages": []}`)
+			status, err := repo.Status()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := Status{{Path: "packages.json", Staging: '?', Worktree: '?'}}
+			if !reflect.DeepEqual(status, want) {
+				t.Fatalf("status = %q, want %q", status, want)
+			}
+
+			if err := repo.AddAll(); err != nil {
+				t.Fatal(err)
+			}
+			hash, err := repo.Commit("add packages", CommitOptions{Author: author})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if head, err := repo.Head(); err != nil || head != hash {
+				t.Fatalf("Head = %q, %v, want %q", head, err, hash)
+			}
+			if status, err := repo.Status(); err != nil || !status.IsClean() {
+				t.Fatalf("status after commit = %q, %v, want clean", status, err)
+			}
+			if _, err := repo.Commit("nothing", CommitOptions{Author: author}); err != ErrEmptyCommit {
+				t.Fatalf("empty commit: err = %v, want ErrEmptyCommit", err)
+			}
+			if _, err := repo.Commit("empty", CommitOptions{Author: author, AllowEmpty: true}); err != nil {
+				t.Fatalf("allowed empty commit: %v", err)
+			}
+
+			if _, _, err := repo.AheadBehind(); err != ErrNoUpstream {
+				t.Fatalf("AheadBehind: err = %v, want ErrNoUpstream", err)
+			}
+			if _, err := repo.RemoteURL("origin"); err != ErrRemoteNotFound {
+				t.Fatalf("RemoteURL: err = %v, want ErrRemoteNotFound", err)
+			}
+		})
+	}
+}
+
+func TestMemoryAheadBehind(t *testing.T) {
+	repo := NewMemory()
+	repo.SetRemote("origin", "git@example.com:me/config.git")
+	repo.WriteFile("packages.json", []byte(`{"packages": []}`))
+	repo.AddAll()
+	if _, err := repo.Commit("base", CommitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Push(nil); err != nil {
+		t.Fatal(err)
+	}
+	repo.WriteFile("packages.json", []byte(`{"packages": ["git"]}`))
+	repo.AddAll()
+	if _, err := repo.Commit("add git", CommitOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ahead, behind, err := repo.AheadBehind()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ahead != 1 || behind != 0 {
+		t.Errorf("ahead, behind = %d, %d, want 1, 0", ahead, behind)
+	}
+}